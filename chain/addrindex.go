@@ -0,0 +1,225 @@
+package chain
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"PublicChain/wallet"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// 地址历史索引，记录每个地址的每一笔收入和支出
+// key:   公钥hash + 区块高度(8) + 交易在区块中的位置(8) + 方向(1) + 输入或输出的序号(8)
+// value: 交易hash(32) + 金额(8，最小单位)
+const ADDRINDEX = "addrindex"
+
+// 同一笔交易中，先记支出再记收入，保证按key排序后的余额是连续的
+const (
+	HISTORYDEBIT  byte = 0 // 支出
+	HISTORYCREDIT byte = 1 // 收入
+)
+
+/**
+ * 地址的一条收支记录
+ */
+type AddressHistory struct {
+	TxId      [32]byte // 发生收支的交易
+	Index     int      // 收入时为交易输出的序号，支出时为交易输入的序号
+	Height    int64
+	Value     int64
+	Direction byte
+	Balance   int64 // 该条记录之后地址的余额
+}
+
+/**
+ * 拼接地址历史索引的key
+ */
+func addrIndexKey(pubHash []byte, height int64, position int, direction byte, index int) ([]byte, error) {
+	heightBytes, err := utils.IntToByte(height)
+	if err != nil {
+		return nil, err
+	}
+	positionBytes, err := utils.IntToByte(int64(position))
+	if err != nil {
+		return nil, err
+	}
+	indexBytes, err := utils.IntToByte(int64(index))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join([][]byte{pubHash, heightBytes, positionBytes, {direction}, indexBytes}, []byte{}), nil
+}
+
+func addrIndexValue(txid [32]byte, value int64) []byte {
+	valueBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(valueBytes, uint64(value))
+	return append(txid[:], valueBytes...)
+}
+
+/**
+ * 在地址历史索引中查找某个交易输出的收入记录，返回所在区块的高度以及交易在区块中的位置
+ */
+func findCreditInTx(tx *bolt.Tx, utxo transaction.UTXO) (int64, int64, error) {
+	bucket := tx.Bucket([]byte(ADDRINDEX))
+	if bucket == nil {
+		return 0, 0, errors.New("地址历史索引不存在")
+	}
+	cursor := bucket.Cursor()
+	for key, value := cursor.Seek(utxo.PubHash); key != nil && bytes.HasPrefix(key, utxo.PubHash); key, value = cursor.Next() {
+		entry := parseAddrIndex(key, value)
+		if entry.Direction == HISTORYCREDIT && entry.TxId == utxo.TxId && entry.Index == utxo.Vout {
+			position := int64(binary.BigEndian.Uint64(key[len(key)-17 : len(key)-9]))
+			return entry.Height, position, nil
+		}
+	}
+	return 0, 0, errors.New("地址历史索引中没有该交易输出的记录")
+}
+
+/**
+ * 将区块中所有交易的收入和支出写入地址历史索引，支出的金额取自区块的撤销数据中被花费的utxo
+ */
+func putAddrIndex(bucket *bolt.Bucket, block Block, undo BlockUndo) error {
+	spents := undo.SpentUTXOs
+	for position, tx := range block.Txs {
+		if len(spents) < len(tx.Inputs) {
+			return fmt.Errorf("区块%x的撤销数据与交易输入不一致", block.Hash)
+		}
+		for index, input := range tx.Inputs {
+			key, err := addrIndexKey(wallet.NewPubKHash(input.Pubk), block.Height, position, HISTORYDEBIT, index)
+			if err != nil {
+				return err
+			}
+			err = bucket.Put(key, addrIndexValue(tx.TxHash, spents[index].UTXO.Value))
+			if err != nil {
+				return err
+			}
+		}
+		for index, output := range tx.Outputs {
+			key, err := addrIndexKey(output.PubHash, block.Height, position, HISTORYCREDIT, index)
+			if err != nil {
+				return err
+			}
+			err = bucket.Put(key, addrIndexValue(tx.TxHash, output.Value))
+			if err != nil {
+				return err
+			}
+		}
+		spents = spents[len(tx.Inputs):]
+	}
+	return nil
+}
+
+/**
+ * 区块从主链断开时，删除该区块写入的地址历史索引
+ */
+func deleteAddrIndex(bucket *bolt.Bucket, block Block) error {
+	for position, tx := range block.Txs {
+		for index, input := range tx.Inputs {
+			key, err := addrIndexKey(wallet.NewPubKHash(input.Pubk), block.Height, position, HISTORYDEBIT, index)
+			if err != nil {
+				return err
+			}
+			err = bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		for index, output := range tx.Outputs {
+			key, err := addrIndexKey(output.PubHash, block.Height, position, HISTORYCREDIT, index)
+			if err != nil {
+				return err
+			}
+			err = bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/**
+ * 为已有的db文件按高度从创世区块开始补建地址历史索引，支出的金额取自各区块的撤销数据
+ */
+func buildAddrIndex(tx *bolt.Tx) error {
+	heightBucket := tx.Bucket([]byte(HEIGHTINDEX))
+	indexBucket := tx.Bucket([]byte(ADDRINDEX))
+	if heightBucket == nil || indexBucket == nil {
+		return errors.New("区块数据区操作失败")
+	}
+	return heightBucket.ForEach(func(heightBytes, hash []byte) error {
+		var blockHash [32]byte
+		copy(blockHash[:], hash)
+		block, err := getBlockInTx(tx, blockHash)
+		if err != nil {
+			return err
+		}
+		undo, err := requireBlockUndo(tx, block)
+		if err != nil {
+			return err
+		}
+		return putAddrIndex(indexBucket, block, *undo)
+	})
+}
+
+/**
+ * 将地址历史索引中的一条数据还原为收支记录
+ */
+func parseAddrIndex(key []byte, value []byte) AddressHistory {
+	// 跳过公钥hash
+	fields := key[len(key)-25:]
+	entry := AddressHistory{
+		Height:    int64(binary.BigEndian.Uint64(fields[:8])),
+		Direction: fields[16],
+		Index:     int(binary.BigEndian.Uint64(fields[17:])),
+		Value:     int64(binary.BigEndian.Uint64(value[32:])),
+	}
+	copy(entry.TxId[:], value[:32])
+	return entry
+}
+
+/**
+ * 查询某个地址在[from,to]高度区间内的收支记录，并计算每条记录之后的余额
+ * to小于0时表示查询到最新区块
+ */
+func (chain *BlockChain) GetAddressHistory(address string, from int64, to int64) ([]AddressHistory, error) {
+	if !wallet.IsAddressValid(address) {
+		return nil, errors.New("地址不合法，请重试")
+	}
+	if to < 0 {
+		to = chain.LastBlock.Height
+	}
+	if from > to {
+		return nil, errors.New("查询的高度区间有误")
+	}
+	pubHash := wallet.GetPubKHashWithAddress(address)
+	histories := make([]AddressHistory, 0)
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(ADDRINDEX))
+		if bucket == nil {
+			return errors.New("地址历史索引不存在")
+		}
+		var balance int64
+		cursor := bucket.Cursor()
+		for key, value := cursor.Seek(pubHash); key != nil && bytes.HasPrefix(key, pubHash); key, value = cursor.Next() {
+			entry := parseAddrIndex(key, value)
+			if entry.Height > to {
+				break
+			}
+			if entry.Direction == HISTORYCREDIT {
+				balance += entry.Value
+			} else {
+				balance -= entry.Value
+			}
+			entry.Balance = balance
+			if entry.Height >= from {
+				histories = append(histories, entry)
+			}
+		}
+		return nil
+	})
+	return histories, err
+}
@@ -0,0 +1,122 @@
+package chain
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 在newTestChain的基础上挖出a1，a2中把a1的coinbase输出转10个币给另一个地址to，返回a1、a2和to
+func spendTestChain(t *testing.T, chain *BlockChain, address string) (Block, Block, string) {
+	t.Helper()
+	to, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := mineTestBlock(t, chain.LastBlock, address)
+	acceptTestBlocks(t, chain, a1)
+	coinbase := transaction.NewUTXO(a1.Txs[0].TxHash, 0, a1.Txs[0].Outputs[0])
+	a2 := mineTestBlock(t, a1, address, spendTestUTXOs(t, chain, []transaction.UTXO{coinbase}, address, to, 10*utils.COIN))
+	acceptTestBlocks(t, chain, a2)
+	return a1, a2, to
+}
+
+func testAddressHistory(t *testing.T, chain *BlockChain, address string) []AddressHistory {
+	t.Helper()
+	histories, err := chain.GetAddressHistory(address, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return histories
+}
+
+// 支出记录的金额为被花费的utxo的金额，最后一条记录之后的余额与utxo集合中的余额一致；区块断开后记录随之删除
+func TestAddressHistory(t *testing.T) {
+	chain, address := newTestChain(t)
+	a1, a2, to := spendTestChain(t, chain, address)
+
+	histories := testAddressHistory(t, chain, address)
+	var debits []AddressHistory
+	for _, entry := range histories {
+		if entry.Direction == HISTORYDEBIT {
+			debits = append(debits, entry)
+		}
+	}
+	if len(debits) != 1 {
+		t.Fatalf("有%d条支出记录，应为1条", len(debits))
+	}
+	if debits[0].TxId != a2.Txs[1].TxHash || debits[0].Height != a2.Height || debits[0].Value != a1.Txs[0].Outputs[0].Value {
+		t.Errorf("支出记录为%+v，应为交易%x花费的%d", debits[0], a2.Txs[1].TxHash, a1.Txs[0].Outputs[0].Value)
+	}
+	if balance := histories[len(histories)-1].Balance; balance != chain.GetBalance(address) {
+		t.Errorf("地址历史中的余额为%d，utxo集合中为%d", balance, chain.GetBalance(address))
+	}
+	received := testAddressHistory(t, chain, to)
+	if len(received) != 1 || received[0].Direction != HISTORYCREDIT || received[0].Value != 10*utils.COIN || received[0].Balance != 10*utils.COIN {
+		t.Errorf("收款地址的记录为%+v", received)
+	}
+
+	err := chain.InvalidateBlock(a2.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received := testAddressHistory(t, chain, to); len(received) != 0 {
+		t.Errorf("a2断开后收款地址仍有%d条记录", len(received))
+	}
+	for _, entry := range testAddressHistory(t, chain, address) {
+		if entry.Height >= a2.Height {
+			t.Errorf("a2断开后仍有高度%d的记录", entry.Height)
+		}
+	}
+}
+
+// 旧的db文件没有地址历史索引时，打开时依据各区块的撤销数据补建，与连接区块时写入的相同；撤销数据缺失时打开报错
+func TestBuildAddrIndex(t *testing.T) {
+	chain, address := newTestChain(t)
+	_, a2, to := spendTestChain(t, chain, address)
+	expected := map[string][]AddressHistory{
+		address: testAddressHistory(t, chain, address),
+		to:      testAddressHistory(t, chain, to),
+	}
+	path := chain.DB.Path()
+	err := chain.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperTestDB(t, path, func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(ADDRINDEX))
+	})
+	reopened, err := OpenBlockChain(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, histories := range expected {
+		if rebuilt := testAddressHistory(t, &reopened, addr); !reflect.DeepEqual(rebuilt, histories) {
+			t.Errorf("补建的记录为%+v，应为%+v", rebuilt, histories)
+		}
+	}
+	err = reopened.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamperTestDB(t, path, func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(ADDRINDEX))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(UNDOBUCKET)).Delete(a2.Hash[:])
+	})
+	reopened, err = OpenBlockChain(path)
+	if err == nil {
+		_ = reopened.Close()
+		t.Fatal("撤销数据缺失时补建地址历史索引应报错")
+	}
+	if !strings.Contains(err.Error(), "没有撤销数据") {
+		t.Errorf("错误信息没有指出撤销数据缺失：%v", err)
+	}
+}
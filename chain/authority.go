@@ -0,0 +1,356 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"PublicChain/wallet"
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// 权威证明当前的验证者集合：验证者公钥 -> 1，随主链上的治理交易变化
+const VALIDATORSET = "validators"
+
+/**
+ * 在事务中取出当前的验证者公钥，按公钥的字节序排列，即轮流出块的顺序
+ */
+func getValidatorsInTx(tx *bolt.Tx) ([][]byte, error) {
+	validators := make([][]byte, 0)
+	bucket := tx.Bucket([]byte(VALIDATORSET))
+	if bucket == nil {
+		return validators, nil
+	}
+	err := bucket.ForEach(func(key, value []byte) error {
+		validators = append(validators, append([]byte{}, key...))
+		return nil
+	})
+	return validators, err
+}
+
+/**
+ * 创建创世区块时写入初始的验证者集合
+ */
+func putValidatorsInTx(tx *bolt.Tx, validators [][]byte) error {
+	if len(validators) == 0 {
+		return errors.New("权威证明的链至少需要一个验证者")
+	}
+	bucket, err := tx.CreateBucketIfNotExists([]byte(VALIDATORSET))
+	if err != nil {
+		return err
+	}
+	for _, validator := range validators {
+		if !isPublicKey(validator) {
+			return fmt.Errorf("验证者公钥%x格式有误", validator)
+		}
+		err = bucket.Put(validator, []byte{1})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 轮到产出某个高度区块的验证者：按当前验证者集合的顺序，高度对验证者数量取余
+ */
+func expectedValidatorInTx(tx *bolt.Tx, height int64) ([]byte, error) {
+	validators, err := getValidatorsInTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(validators) == 0 {
+		return nil, errors.New("验证者集合为空")
+	}
+	return validators[height%int64(len(validators))], nil
+}
+
+/**
+ * 权威证明的区块连接到主链时检查出块者：必须是轮到出块的验证者。调用时验证者集合必须处于父区块的状态
+ */
+func checkBlockAuthority(tx *bolt.Tx, block Block) error {
+	expected, err := expectedValidatorInTx(tx, block.Height)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(block.Producer, expected) {
+		return nil
+	}
+	producer, _ := wallet.NewAddress(block.Producer)
+	if isValidatorInTx(tx, block.Producer) {
+		return fmt.Errorf("还没有轮到验证者%s出块", producer)
+	}
+	return fmt.Errorf("%s不是验证者，无权出块", producer)
+}
+
+func isValidatorInTx(tx *bolt.Tx, pub []byte) bool {
+	bucket := tx.Bucket([]byte(VALIDATORSET))
+	return bucket != nil && bucket.Get(pub) != nil
+}
+
+/**
+ * 检查治理交易能否作用于验证者集合：批准者都必须是验证者，并且超过验证者总数的一半，
+ * 添加的验证者不能已存在，移除的验证者必须存在，并且不能移除最后一个验证者
+ */
+func checkGovernance(set map[string]bool, gov *transaction.Governance) error {
+	approved, err := countApprovals(set, gov)
+	if err != nil {
+		return err
+	}
+	if approved*2 <= len(set) {
+		return fmt.Errorf("治理交易需要超过半数的验证者批准，当前有%d个验证者，只有%d个批准", len(set), approved)
+	}
+	return checkGovernanceAction(set, gov)
+}
+
+// 治理操作能否作用于验证者集合，不检查批准
+func checkGovernanceAction(set map[string]bool, gov *transaction.Governance) error {
+	switch gov.Action {
+	case transaction.GOVADDVALIDATOR:
+		if set[string(gov.Validator)] {
+			return errors.New("要添加的验证者已存在")
+		}
+	case transaction.GOVREMOVEVALIDATOR:
+		if !set[string(gov.Validator)] {
+			return errors.New("要移除的验证者不存在")
+		}
+		if len(set) == 1 {
+			return errors.New("不能移除最后一个验证者")
+		}
+	default:
+		return fmt.Errorf("不支持的治理操作%d", gov.Action)
+	}
+	return nil
+}
+
+// 批准治理交易的验证者个数，批准者不是验证者时报错，同一个验证者只计一次
+func countApprovals(set map[string]bool, gov *transaction.Governance) (int, error) {
+	approvers := make(map[string]bool)
+	for _, approval := range gov.Approvals {
+		if !set[string(approval.Signer)] {
+			address, _ := wallet.NewAddress(approval.Signer)
+			return 0, fmt.Errorf("治理交易的批准者%s不是验证者", address)
+		}
+		approvers[string(approval.Signer)] = true
+	}
+	return len(approvers), nil
+}
+
+// 把治理交易作用于内存中的验证者集合
+func applyGovernance(set map[string]bool, gov *transaction.Governance) {
+	if gov.Action == transaction.GOVADDVALIDATOR {
+		set[string(gov.Validator)] = true
+	} else {
+		delete(set, string(gov.Validator))
+	}
+}
+
+/**
+ * 在事务中取出当前的验证者集合，用于检查治理交易
+ */
+func getValidatorSetInTx(tx *bolt.Tx) (map[string]bool, error) {
+	validators, err := getValidatorsInTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	for _, validator := range validators {
+		set[string(validator)] = true
+	}
+	return set, nil
+}
+
+/**
+ * 区块连接到主链时，按顺序执行区块中的治理交易，更新验证者集合。只有权威证明的链可以有治理交易
+ */
+func connectGovernanceInTx(tx *bolt.Tx, params ChainParams, block Block) error {
+	var set map[string]bool
+	for _, transac := range block.Txs {
+		if !transac.IsGovernanceTransaction() {
+			continue
+		}
+		if params.Consensus != consensus.POA {
+			return errors.New("只有权威证明的链可以使用治理交易")
+		}
+		if set == nil {
+			var err error
+			set, err = getValidatorSetInTx(tx)
+			if err != nil {
+				return err
+			}
+		}
+		gov := transac.Governance
+		err := checkGovernance(set, gov)
+		if err != nil {
+			return fmt.Errorf("治理交易%x无效，%s", transac.TxHash, err.Error())
+		}
+		applyGovernance(set, gov)
+		bucket := tx.Bucket([]byte(VALIDATORSET))
+		if gov.Action == transaction.GOVADDVALIDATOR {
+			err = bucket.Put(gov.Validator, []byte{1})
+		} else {
+			err = bucket.Delete(gov.Validator)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 区块从主链断开时，倒序撤销区块中的治理交易，验证者集合回到父区块的状态
+ */
+func disconnectGovernanceInTx(tx *bolt.Tx, block Block) error {
+	for i := len(block.Txs) - 1; i >= 0; i-- {
+		gov := block.Txs[i].Governance
+		if gov == nil {
+			continue
+		}
+		bucket := tx.Bucket([]byte(VALIDATORSET))
+		if bucket == nil {
+			return errors.New("验证者集合不存在")
+		}
+		var err error
+		if gov.Action == transaction.GOVADDVALIDATOR {
+			err = bucket.Delete(gov.Validator)
+		} else {
+			err = bucket.Put(gov.Validator, []byte{1})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 查询当前的验证者公钥，按轮流出块的顺序排列，同时返回轮到产出下一个区块的验证者
+ */
+func (chain *BlockChain) GetValidators() ([][]byte, []byte, error) {
+	if chain.Params.Consensus != consensus.POA {
+		return nil, nil, errors.New("当前链不是权威证明，没有验证者")
+	}
+	var validators [][]byte
+	var next []byte
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		validators, err = getValidatorsInTx(tx)
+		if err != nil {
+			return err
+		}
+		next, err = expectedValidatorInTx(tx, chain.LastBlock.Height+1)
+		return err
+	})
+	return validators, next, err
+}
+
+/**
+ * 由钱包中的验证者from发起一笔治理交易，添加或移除验证者validator。
+ * 批准已超过半数时(例如只有一个验证者)交易放入交易池等待打包，否则返回的交易需要交给其他验证者用ApproveGovernance批准
+ */
+func (chain *BlockChain) SendGovernance(action uint8, validator string, from string) (*transaction.Transaction, bool, error) {
+	if chain.Params.Consensus != consensus.POA {
+		return nil, false, errors.New("只有权威证明的链可以使用治理交易")
+	}
+	pub, err := chain.ResolveValidator(validator)
+	if err != nil {
+		return nil, false, err
+	}
+	transac, err := transaction.NewGovernanceTx(action, pub)
+	if err != nil {
+		return nil, false, err
+	}
+	return chain.ApproveGovernance(*transac, from)
+}
+
+/**
+ * 由钱包中的验证者from批准一笔治理交易。加上该批准后超过半数的验证者批准时，交易放入交易池等待打包，返回true；
+ * 否则返回加上了该批准的交易，需要继续交给其他验证者批准
+ */
+func (chain *BlockChain) ApproveGovernance(transac transaction.Transaction, from string) (*transaction.Transaction, bool, error) {
+	if chain.Params.Consensus != consensus.POA {
+		return nil, false, errors.New("只有权威证明的链可以使用治理交易")
+	}
+	if !transac.IsGovernanceTransaction() {
+		return nil, false, errors.New("不是治理交易")
+	}
+	keyPair := chain.Wallet.GetKeyPairByAddress(from)
+	if keyPair == nil {
+		return nil, false, errors.New("钱包中没有批准者地址的私钥")
+	}
+	//复制治理内容，不修改调用方的交易
+	gov := *transac.Governance
+	gov.Approvals = append([]transaction.Approval{}, gov.Approvals...)
+	transac.Governance = &gov
+	err := transac.SignGovernance(keyPair.Pri, keyPair.Pub)
+	if err != nil {
+		return nil, false, err
+	}
+	_, err = transac.VertifySign(nil)
+	if err != nil {
+		return nil, false, err
+	}
+	var submit bool
+	err = chain.DB.View(func(tx *bolt.Tx) error {
+		set, err := getValidatorSetInTx(tx)
+		if err != nil {
+			return err
+		}
+		approved, err := countApprovals(set, &gov)
+		if err != nil {
+			return err
+		}
+		submit = approved*2 > len(set)
+		return checkGovernanceAction(set, &gov)
+	})
+	if err != nil || !submit {
+		return &transac, false, err
+	}
+	err = chain.Mempool.AcceptTxs([]transaction.Transaction{transac}, chain.LastBlock.Height)
+	return &transac, err == nil, err
+}
+
+/**
+ * 把钱包中的地址或者十六进制格式的公钥解析为验证者公钥
+ */
+func (chain *BlockChain) ResolveValidator(validator string) ([]byte, error) {
+	if keyPair := chain.Wallet.GetKeyPairByAddress(validator); keyPair != nil {
+		return keyPair.Pub, nil
+	}
+	pub, err := hex.DecodeString(validator)
+	if err != nil || !isPublicKey(pub) {
+		return nil, errors.New("验证者应为钱包中的地址或者十六进制格式的公钥")
+	}
+	return pub, nil
+}
+
+func isPublicKey(pub []byte) bool {
+	x, _ := elliptic.Unmarshal(elliptic.P256(), pub)
+	return x != nil
+}
+
+/**
+ * 权威证明出块时，从钱包中找出轮到出块的验证者的密钥对
+ */
+func (chain *BlockChain) getAuthorityKey() (*wallet.KeyPair, error) {
+	var expected []byte
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		expected, err = expectedValidatorInTx(tx, chain.LastBlock.Height+1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, keyPair := range chain.Wallet.Address {
+		if bytes.Equal(keyPair.Pub, expected) {
+			return keyPair, nil
+		}
+	}
+	address, _ := wallet.NewAddress(expected)
+	return nil, fmt.Errorf("轮到验证者%s出块，钱包中没有该验证者的私钥", address)
+}
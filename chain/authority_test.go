@@ -0,0 +1,179 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"PublicChain/wallet"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+ * 在临时目录中创建一条回归测试网的权威证明链，钱包中的count个地址作为初始验证者，返回区块链实例和这些地址
+ */
+func newTestAuthorityChain(t *testing.T, count int) (*BlockChain, []string) {
+	t.Helper()
+	err := chainparams.Select(chainparams.REGTEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := OpenBlockChain(filepath.Join(t.TempDir(), chainparams.BOLTFILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = chain.Close() })
+	addresses := make([]string, 0, count)
+	params := ChainParams{Consensus: consensus.POA}
+	for i := 0; i < count; i++ {
+		address, err := chain.GetNewAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, address)
+		params.Validators = append(params.Validators, chain.Wallet.GetKeyPairByAddress(address).Pub)
+	}
+	_, err = chain.CreateCoinbase(addresses[0], params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &chain, addresses
+}
+
+// 钱包中公钥为pub的密钥对
+func testKeyPairOf(t *testing.T, chain *BlockChain, pub []byte) *wallet.KeyPair {
+	t.Helper()
+	for _, keyPair := range chain.Wallet.Address {
+		if bytes.Equal(keyPair.Pub, pub) {
+			return keyPair
+		}
+	}
+	t.Fatalf("钱包中没有公钥%x", pub)
+	return nil
+}
+
+// 由轮到的验证者签名，在主链末端连接一个区块
+func authorTestBlock(t *testing.T, chain *BlockChain, txs ...transaction.Transaction) Block {
+	t.Helper()
+	_, next, err := chain.GetValidators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, _ := wallet.NewAddress(next)
+	return signTestBlock(t, chain.LastBlock, address, testKeyPairOf(t, chain, next), txs...)
+}
+
+// 验证者按公钥的顺序轮流出块，没轮到的验证者和不是验证者的地址产出的区块被拒绝
+func TestCheckBlockAuthority(t *testing.T) {
+	chain, _ := newTestAuthorityChain(t, 3)
+	validators, _, err := chain.GetValidators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(validators); i++ {
+		if bytes.Compare(validators[i-1], validators[i]) >= 0 {
+			t.Fatal("验证者应按公钥的字节序排列")
+		}
+	}
+	outsider, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for height := int64(1); height <= 4; height++ {
+		parent := chain.LastBlock
+		expected := validators[height%int64(len(validators))]
+		waiting := validators[(height+1)%int64(len(validators))]
+		address, _ := wallet.NewAddress(waiting)
+		err := chain.ConnectBlock(signTestBlock(t, parent, address, testKeyPairOf(t, chain, waiting)))
+		if err == nil || !strings.Contains(err.Error(), "还没有轮到") {
+			t.Errorf("高度%d：没轮到的验证者出块应被拒绝，结果为%v", height, err)
+		}
+		err = chain.ConnectBlock(signTestBlock(t, parent, outsider, chain.Wallet.GetKeyPairByAddress(outsider)))
+		if err == nil || !strings.Contains(err.Error(), "不是验证者") {
+			t.Errorf("高度%d：不是验证者的地址出块应被拒绝，结果为%v", height, err)
+		}
+		address, _ = wallet.NewAddress(expected)
+		block := signTestBlock(t, parent, address, testKeyPairOf(t, chain, expected))
+		acceptTestBlocks(t, chain, block)
+		if chain.LastBlock.Hash != block.Hash {
+			t.Fatalf("高度%d：轮到的验证者产出的区块应成为最新区块", height)
+		}
+	}
+}
+
+// 治理交易需要超过半数的当前验证者批准才能放入交易池和打包进区块
+func TestGovernanceMajority(t *testing.T) {
+	chain, addresses := newTestAuthorityChain(t, 3)
+	newcomer, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposal, submitted, err := chain.SendGovernance(transaction.GOVADDVALIDATOR, newcomer, addresses[0])
+	if err != nil || submitted {
+		t.Fatalf("3个验证者中只有1个批准时不应放入交易池：%v，%v", submitted, err)
+	}
+	if len(poolTestTxids(t, chain)) != 0 {
+		t.Fatal("交易池中不应有未获得多数批准的治理交易")
+	}
+	if _, _, err := chain.ApproveGovernance(*proposal, newcomer); err == nil {
+		t.Error("不是验证者的地址不能批准治理交易")
+	}
+	if _, _, err := chain.ApproveGovernance(*proposal, addresses[0]); err == nil {
+		t.Error("同一个验证者不能重复批准")
+	}
+
+	//只有一个批准的治理交易打包进区块时，区块被拒绝
+	err = chain.ConnectBlock(authorTestBlock(t, chain, *proposal))
+	if err == nil || !strings.Contains(err.Error(), "超过半数") {
+		t.Errorf("包含未获得多数批准的治理交易的区块应被拒绝，结果为%v", err)
+	}
+
+	approved, submitted, err := chain.ApproveGovernance(*proposal, addresses[1])
+	if err != nil || !submitted {
+		t.Fatalf("3个验证者中有2个批准时应放入交易池：%v，%v", submitted, err)
+	}
+	if len(proposal.Governance.Approvals) != 1 {
+		t.Error("批准不应修改传入的交易")
+	}
+	if txids := poolTestTxids(t, chain); len(txids) != 1 || txids[0] != approved.TxHash {
+		t.Fatalf("交易池中的交易为%x", txids)
+	}
+	acceptTestBlocks(t, chain, authorTestBlock(t, chain, *approved))
+	validators, _, err := chain.GetValidators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(validators) != 4 {
+		t.Fatalf("打包后有%d个验证者，应为4个", len(validators))
+	}
+
+	//4个验证者时，2个批准不超过半数
+	removal, submitted, err := chain.SendGovernance(transaction.GOVREMOVEVALIDATOR, addresses[2], addresses[0])
+	if err != nil || submitted {
+		t.Fatalf("4个验证者中只有1个批准时不应放入交易池：%v，%v", submitted, err)
+	}
+	removal, submitted, err = chain.ApproveGovernance(*removal, addresses[1])
+	if err != nil || submitted {
+		t.Fatalf("4个验证者中只有2个批准时不应放入交易池：%v，%v", submitted, err)
+	}
+	_, submitted, err = chain.ApproveGovernance(*removal, newcomer)
+	if err != nil || !submitted {
+		t.Fatalf("4个验证者中有3个批准时应放入交易池：%v，%v", submitted, err)
+	}
+}
+
+func poolTestTxids(t *testing.T, chain *BlockChain) [][32]byte {
+	t.Helper()
+	entries, err := chain.Mempool.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txids := make([][32]byte, 0)
+	for _, entry := range entries {
+		txids = append(txids, entry.Tx.TxHash)
+	}
+	return txids
+}
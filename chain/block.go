@@ -1,92 +1,241 @@
 package chain
 
 import (
+	"PublicChain/chainparams"
 	"PublicChain/consensus"
 	"PublicChain/merkle"
 	"PublicChain/transaction"
+	"PublicChain/utils"
 	"bytes"
-	"encoding/gob"
+	"context"
+	"errors"
+	"fmt"
 	"time"
 )
 
 type Block struct{
-	Height  int64   //4
-	Version int64    //4
-	PreHash [32]byte   //32
+	consensus.BlockHeader //区块头，区块hash只对区块头计算
+	Height  int64
 	Hash    [32]byte
-	// 默克尔根
-	MerkleRoot []byte    //32
-	Timestamp int64  // 4
-	//Difficulty int64
-	Nonce int64  // 4
-	//Data  []byte //区块体
 	Txs []transaction.Transaction
+	Producer  []byte // 出块者的公钥，只有权益证明和权威证明的区块有，计入区块hash
+	Signature []byte // 出块者对区块hash的签名，不计入区块hash
 
 }
 
 
 /**
-   区块的序列化，序列化为 []byte数据类型
+   区块的序列化，序列化为二进制格式的[]byte：区块头 + 区块体，区块体的格式见serializeBody
  */
 func(block *Block)Serialize()([]byte ,error){
-	buffer :=new(bytes.Buffer)
-	encoder :=gob.NewEncoder(buffer)
-	err :=encoder.Encode(&block)
-	return buffer.Bytes() , err
-
+	data := block.BlockHeader.Serialize()
+	return append(data, block.serializeBody()...), nil
 }
 
 /**
-   反序列化操作，传入[]byte，返回Block结构体
+   反序列化操作，传入[]byte，返回Block结构体。区块hash根据区块头和出块者计算
  */
 func UnSerialize(data []byte)(Block ,error){
-	var block Block
-	encoder :=gob.NewDecoder(bytes.NewReader(data))
-	err :=encoder.Decode(&block)
-	return block , err
+	if len(data) < consensus.HEADERSIZE {
+		return Block{}, errors.New("区块数据长度有误")
+	}
+	header, err := consensus.DeserializeHeader(data[:consensus.HEADERSIZE])
+	if err != nil {
+		return Block{}, err
+	}
+	return unserializeBody(header, data[consensus.HEADERSIZE:])
+}
+
+/*
+区块体的二进制格式，整数均为小端序，变长整数为compact size：
+
+	高度         int64
+	交易个数     varint
+	交易         每笔交易的二进制格式，见transaction.Encode
+	出块者公钥   varint长度 + 字节，只有带签名的区块有
+	签名         varint长度 + 字节，只有带签名的区块有
+*/
+func (block *Block) serializeBody() []byte {
+	buff := new(bytes.Buffer)
+	utils.WriteInt64(buff, block.Height)
+	utils.WriteVarInt(buff, uint64(len(block.Txs)))
+	for _, tx := range block.Txs {
+		tx.Encode(buff)
+	}
+	if len(block.Signature) > 0 {
+		utils.WriteVarBytes(buff, block.Producer)
+		utils.WriteVarBytes(buff, block.Signature)
+	}
+	return buff.Bytes()
+}
+
+/**
+   根据区块头和区块体还原区块
+ */
+func unserializeBody(header consensus.BlockHeader, data []byte) (Block, error) {
+	return decodeBody(header, data, transaction.DecodeTransaction)
+}
+
+// 还原区块体，交易由decodeTx读取，转换旧版本的db文件时使用旧格式的交易解码
+func decodeBody(header consensus.BlockHeader, data []byte, decodeTx func(reader *bytes.Reader) (transaction.Transaction, error)) (Block, error) {
+	block := Block{BlockHeader: header}
+	var err error
+	reader :=bytes.NewReader(data)
+	if block.Height, err = utils.ReadInt64(reader); err != nil {
+		return block, err
+	}
+	txCount, err := utils.ReadVarInt(reader)
+	if err != nil {
+		return block, err
+	}
+	if txCount > uint64(reader.Len()) {
+		return block, errors.New("区块中的交易个数超出范围")
+	}
+	block.Txs = make([]transaction.Transaction, 0, txCount)
+	for i := uint64(0); i < txCount; i++ {
+		tx, err := decodeTx(reader)
+		if err != nil {
+			return block, err
+		}
+		block.Txs = append(block.Txs, tx)
+	}
+	//工作量证明的区块在交易之后就结束了
+	if reader.Len() > 0 {
+		if block.Producer, err = utils.ReadVarBytes(reader); err != nil {
+			return block, err
+		}
+		if block.Signature, err = utils.ReadVarBytes(reader); err != nil {
+			return block, err
+		}
+		if len(block.Signature) == 0 {
+			return block, errors.New("区块的签名为空")
+		}
+	}
+	if reader.Len() != 0 {
+		return block, errors.New("区块数据末尾有多余的字节")
+	}
+	block.Hash = block.CalculateHash()
+	return block , nil
 }
 
+/**
+   计算区块hash：带签名的区块对区块头和出块者公钥计算，工作量证明的区块只对区块头计算
+ */
+func (block *Block) CalculateHash() [32]byte {
+	if len(block.Producer) > 0 {
+		return block.SignedBlockHash(block.Producer)
+	}
+	return block.BlockHash()
+}
+
+/**
+   计算交易的默克尔根
+ */
+func calculateMerkleRoot(txs []transaction.Transaction) ([32]byte, error) {
+	var root [32]byte
+	tree, err := merkle.GenerateTreeByTransactions(txs)
+	if err != nil {
+		return root, err
+	}
+	if tree.RootNode == nil || len(tree.RootNode.Value) != len(root) {
+		return root, errors.New("默克尔根计算有误")
+	}
+	copy(root[:], tree.RootNode.Value)
+	return root, nil
+}
 
-func CreateBlock(height int64 ,prevHash [32]byte ,txs []transaction.Transaction)(*Block,error){
+
+/**
+   打包交易生成新区块，并用newEngine创建的共识引擎产出区块，ctx取消时停止
+ */
+func CreateBlock(ctx context.Context ,height int64 ,prevHash [32]byte ,bits uint32 ,timestamp int64 ,txs []transaction.Transaction ,newEngine engineFunc)(*Block,*MiningStats,error){
+	block, err := newBlock(height, prevHash, bits, timestamp, txs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats, err := mineBlock(ctx, block, newEngine)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	return block,stats,nil
+}
+
+/**
+   打包交易生成还没有产出的新区块，height为父区块的高度，timestamp为区块的初始时间戳
+ */
+func newBlock(height int64 ,prevHash [32]byte ,bits uint32 ,timestamp int64 ,txs []transaction.Transaction)(*Block,error){
 	block :=Block{}
 	block.Height =height + 1
 	block.PreHash = prevHash
 	block.Version = 0X00
-	block.Timestamp = time.Now().Unix()
+	block.Timestamp = timestamp
+	block.Bits = bits
 	block.Txs = txs
 
 	//调用生成merkle树
-	tree,err:=merkle.GenerateTreeByTransactions(txs)
+	root,err:=calculateMerkleRoot(txs)
 	if err !=nil{
 		return nil,err
 	}
-	block.MerkleRoot = tree.RootNode.Value
-
-
-	proof := consensus.NewProofWork(block)
-	hash, nonce := proof.SearchNonce()
-	block.Nonce = nonce
-
-	block.Hash = hash
-
+	block.MerkleRoot = root
 	return &block,nil
 }
 
 
-func CreateGenesisBlock(txs []transaction.Transaction)Block{
+/**
+   生成权益证明或权威证明的创世区块：版本号和初始难度由当前网络决定，之前还没有出块者，不需要签名。
+   工作量证明的链使用当前网络固定的创世区块，见NetworkGenesisBlock
+ */
+func CreateGenesisBlock(txs []transaction.Transaction ,params ChainParams)Block{
 	genesis :=Block{}
 	genesis.Height =0
 	genesis.PreHash =[32]byte{0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0}
-	genesis.Version = 0X01
+	genesis.Version = chainparams.Active.GenesisVersion
 	genesis.Timestamp = time.Now().Unix()
+	genesis.Bits = params.InitialBits()
 	genesis.Txs =txs
+	root,err:=calculateMerkleRoot(txs)
+	if err ==nil{
+		genesis.MerkleRoot = root
+	}
+	genesis.Hash = genesis.BlockHash()
+	return genesis
+}
 
-	proof := consensus.NewProofWork(genesis)
-	hash, nonce := proof.SearchNonce()
-	genesis.Hash = hash
-	genesis.Nonce = nonce
+/**
+   当前网络固定的工作量证明创世区块：时间戳、nonce和coinbase输出都来自网络参数，同一网络上的节点生成的创世区块完全相同。
+   生成的区块hash与网络参数中记录的不一致时报错
+ */
+func NetworkGenesisBlock() (Block, error) {
+	params := chainparams.Active
+	coinbase := transaction.Transaction{
+		Inputs:     []transaction.TxInput{},
+		Outputs:    []transaction.TxOutput{{Value: consensus.BlockSubsidy(0), PubHash: params.GenesisPubHash}},
+		LockedTime: params.GenesisTimestamp,
+	}
+	txid, err := coinbase.CalculateTxId()
+	if err != nil {
+		return Block{}, err
+	}
+	coinbase.TxHash = txid
 
-	return genesis
+	genesis := Block{}
+	genesis.Version = params.GenesisVersion
+	genesis.Timestamp = params.GenesisTimestamp
+	genesis.Bits = DefaultChainParams().InitialBits()
+	genesis.Nonce = params.GenesisNonce
+	genesis.Txs = []transaction.Transaction{coinbase}
+	genesis.MerkleRoot, err = calculateMerkleRoot(genesis.Txs)
+	if err != nil {
+		return Block{}, err
+	}
+	genesis.Hash = genesis.BlockHash()
+	if genesis.Hash != params.GenesisHash {
+		return Block{}, fmt.Errorf("%s的创世区块hash为%x，与网络参数中的%x不一致", params.Name, genesis.Hash, params.GenesisHash)
+	}
+	return genesis, nil
 }
 
 /**
@@ -100,7 +249,7 @@ func (block Block) GetHeight() int64 {
  * 该方法是实现BlockInterface的GetVersion方法
  */
 func (block Block) GetVersion() int64 {
-	return block.Version
+	return int64(block.Version)
 }
 
 func (block Block) GetTimeStamp() int64 {
@@ -116,5 +265,9 @@ func (block Block) GetTxs()  []transaction.Transaction {
 }
 
 func(block Block)GetMerkleRoot()[]byte{
-	return block.MerkleRoot
+	return block.MerkleRoot[:]
+}
+
+func (block Block) GetHeader() consensus.BlockHeader {
+	return block.BlockHeader
 }
\ No newline at end of file
@@ -0,0 +1,92 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"bytes"
+	"testing"
+)
+
+func testBlock(t *testing.T, signed bool) Block {
+	t.Helper()
+	coinbase := transaction.Transaction{
+		Inputs:     []transaction.TxInput{},
+		Outputs:    []transaction.TxOutput{{Value: 5000000000, PubHash: bytes.Repeat([]byte{0x01}, 21)}},
+		LockedTime: 7,
+	}
+	coinbase.TxHash, _ = coinbase.CalculateTxId()
+	spend := transaction.Transaction{
+		Inputs:     []transaction.TxInput{{Txid: coinbase.TxHash, Vout: 0, Sig: bytes.Repeat([]byte{0x02}, 64), Pubk: bytes.Repeat([]byte{0x03}, 65)}},
+		Outputs:    []transaction.TxOutput{{Value: 100, PubHash: bytes.Repeat([]byte{0x04}, 21)}},
+		LockedTime: 8,
+	}
+	spend.TxHash, _ = spend.CalculateTxId()
+	block, err := newBlock(41, [32]byte{0xaa}, 0x207fffff, 1792281601, []transaction.Transaction{coinbase, spend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Nonce = 12345
+	if signed {
+		block.Producer = bytes.Repeat([]byte{0x05}, 65)
+		block.Signature = bytes.Repeat([]byte{0x06}, 64)
+	}
+	block.Hash = block.CalculateHash()
+	return *block
+}
+
+// 工作量证明和带签名的区块序列化后再还原，区块头、交易和签名都与原来的一致
+func TestBlockRoundTrip(t *testing.T) {
+	for _, signed := range []bool{false, true} {
+		block := testBlock(t, signed)
+		data, err := block.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := UnSerialize(data)
+		if err != nil {
+			t.Errorf("带签名%v：反序列化出错：%v", signed, err)
+			continue
+		}
+		if decoded.BlockHeader != block.BlockHeader || decoded.Height != block.Height || decoded.Hash != block.Hash {
+			t.Errorf("带签名%v：区块头或高度没有正确还原", signed)
+		}
+		if len(decoded.Txs) != len(block.Txs) {
+			t.Fatalf("带签名%v：交易个数为%d，应为%d", signed, len(decoded.Txs), len(block.Txs))
+		}
+		for i := range block.Txs {
+			if decoded.Txs[i].TxHash != block.Txs[i].TxHash {
+				t.Errorf("带签名%v：第%d笔交易的hash不一致", signed, i)
+			}
+		}
+		if !bytes.Equal(decoded.Producer, block.Producer) || !bytes.Equal(decoded.Signature, block.Signature) {
+			t.Errorf("带签名%v：出块者或签名没有正确还原", signed)
+		}
+		again, _ := decoded.Serialize()
+		if !bytes.Equal(again, data) {
+			t.Errorf("带签名%v：重新序列化的结果与原来的不一致", signed)
+		}
+	}
+}
+
+func TestUnSerializeBlockInvalid(t *testing.T) {
+	block := testBlock(t, false)
+	data, _ := block.Serialize()
+	signed := testBlock(t, true)
+	signedData, _ := signed.Serialize()
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"不足一个区块头", data[:50]},
+		{"只有区块头", data[:consensus.HEADERSIZE]},
+		{"交易被截断", data[:len(data)-3]},
+		{"签名被截断", signedData[:len(signedData)-1]},
+		{"签名为空", append(append([]byte{}, data...), 0x01, 0x05, 0x00)},
+	}
+	for _, c := range cases {
+		_, err := UnSerialize(c.data)
+		if err == nil {
+			t.Errorf("%s：应反序列化失败", c.name)
+		}
+	}
+}
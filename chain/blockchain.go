@@ -1,11 +1,14 @@
 package chain
 
 import (
+	"PublicChain/consensus"
+	"PublicChain/mempool"
 	"PublicChain/transaction"
 	"PublicChain/utils"
 	"PublicChain/utxoset"
 	"PublicChain/wallet"
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
@@ -16,6 +19,9 @@ import (
 const BUCKERNAME = "blocks"
 const LASTHASH = "lasthash"
 
+// 区块头单独存储：区块hash -> 区块头，BUCKERNAME中只存区块体，只查询区块头时不需要读取交易
+const HEADERBUCKET = "headers"
+
 /**
  * 定义区块链这个结构体，用于存储产生的区块（内存中)
  */
@@ -27,31 +33,107 @@ type BlockChain struct {
 	IteratorBloockHash [32]byte        //迭代到的区块
 	Wallet             *wallet.Wallet  // 钱包
 	UTXOSet            utxoset.UTXOSet // utxo管理即操作
+	Mempool            mempool.Mempool // 等待打包的交易
+	Params             ChainParams     // 链参数
 }
 
 func NewBlockChain(db *bolt.DB) (BlockChain, error) {
 	//为lastblock赋值
 	var lastBlock Block
-	db.Update(func(tx *bolt.Tx) error {
+	params := DefaultChainParams()
+	var reindex bool
+	//旧的db文件在同一个事务中补建各项索引和统计信息，任何一步失败都整体回滚，并且不再继续打开
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		params, err = getChainParamsInTx(tx)
+		if err != nil {
+			return err
+		}
 		bucket := tx.Bucket([]byte(BUCKERNAME))
 		if bucket == nil {
-			bucket, _ = tx.CreateBucket([]byte(BUCKERNAME))
+			bucket, err = tx.CreateBucket([]byte(BUCKERNAME))
+			if err != nil {
+				return err
+			}
 		}
 		lastHash := bucket.Get([]byte(LASTHASH))
 		if len(lastHash) == 0 {
 			return nil
 		}
-		lastBlockBytes := bucket.Get(lastHash)
-		lastBlock, _ = UnSerialize(lastBlockBytes)
+		var hash [32]byte
+		copy(hash[:], lastHash)
+		lastBlock, err = getBlockInTx(tx, hash)
+		if err != nil {
+			return fmt.Errorf("无法读取最新区块%x，%s", hash, err.Error())
+		}
 
+		//旧的db文件没有高度索引，补建一次
+		if tx.Bucket([]byte(HEIGHTINDEX)) == nil {
+			_, err := tx.CreateBucket([]byte(HEIGHTINDEX))
+			if err != nil {
+				return err
+			}
+			err = buildHeightIndex(tx, lastBlock)
+			if err != nil {
+				return err
+			}
+		}
+		//旧的db文件没有区块元数据索引，补建一次
+		if tx.Bucket([]byte(BLOCKINDEX)) == nil {
+			err := buildBlockIndex(tx)
+			if err != nil {
+				return err
+			}
+		}
+		//旧的db文件没有地址历史索引，补建一次
+		if tx.Bucket([]byte(ADDRINDEX)) == nil {
+			_, err := tx.CreateBucket([]byte(ADDRINDEX))
+			if err != nil {
+				return err
+			}
+			err = buildAddrIndex(tx)
+			if err != nil {
+				return err
+			}
+		}
+		//旧的db文件没有utxo集合的统计信息，补建一次
+		err = utxoset.BuildSetStatsInTx(tx)
+		if err != nil {
+			return err
+		}
+		//旧的db文件中utxo按地址存储，迁移为按outpoint存储
+		skipped, err := utxoset.MigrateLegacyUTXOSet(tx)
+		if err != nil {
+			return err
+		}
+		if skipped > 0 {
+			//旧的utxo集合保留到重建成功为止
+			fmt.Printf("有%d个地址的utxo数据无法解析，保留旧的utxo数据\n", skipped)
+			reindex = true
+			return nil
+		}
+		//旧的db文件没有记录utxo集合对应的最新区块，utxo集合是直接写入db的，与最新区块一致
+		if _, ok := utxoset.GetBestBlockInTx(tx); !ok {
+			var hash [32]byte
+			copy(hash[:], lastHash)
+			return utxoset.SetBestBlockInTx(tx, hash)
+		}
 		return nil
 	})
 	blockChain := BlockChain{
 		DB:                 db,
 		LastBlock:          lastBlock,
 		IteratorBloockHash: lastBlock.Hash,
+		Params:             params,
+	}
+	if err != nil {
+		return blockChain, err
 	}
 
+	set := utxoset.LoadUTXOSetFromDB(db)
+	blockChain.UTXOSet = set
+	blockChain.Mempool = mempool.LoadMempoolFromDB(db, set.Cache)
+
 	wlt, err := wallet.LoadWalletFromDB(db)
 	if err != nil {
 		return blockChain, err
@@ -59,76 +141,172 @@ func NewBlockChain(db *bolt.DB) (BlockChain, error) {
 	//把构建的wallet对象赋值个 blockChain大的wallet属性
 	blockChain.Wallet = &wlt
 
-	set := utxoset.LoadUTXOSetFromDB(db)
-	blockChain.UTXOSet = set
+	//上次退出前utxo缓存没有写回db，utxo集合与最新区块不一致，或者旧的utxo集合无法迁移，需要重建
+	if reindex || set.Cache.GetBestBlock() != lastBlock.Hash {
+		fmt.Println("utxo集合需要从区块重建，开始重建utxo集合")
+		_, err = blockChain.ReindexChainstate()
+		if err != nil {
+			return blockChain, err
+		}
+	}
 
 	return blockChain, err
 }
 
 /**
- * 创建一个区块链实例，该实例携带一个创世区块
+ * 打开db文件并创建区块链实例：db文件必须属于当前网络并使用该网络的创世区块，旧版本的db文件先转换为当前的格式。db文件被其他进程打开时等待其关闭。
+ * 加载区块链时的错误(例如钱包数据无法解析)与区块链实例一起返回，此时db文件保持打开，其余功能仍可使用
+ */
+func OpenBlockChain(file string) (BlockChain, error) {
+	db, err := bolt.Open(file, 0600, nil)
+	if err != nil {
+		return BlockChain{}, err
+	}
+	err = db.View(checkNetworkInTx)
+	if err != nil {
+		_ = db.Close()
+		return BlockChain{}, err
+	}
+	err = db.View(checkGenesisInTx)
+	if err != nil {
+		_ = db.Close()
+		return BlockChain{}, err
+	}
+	err = UpgradeDB(db)
+	if err != nil {
+		_ = db.Close()
+		return BlockChain{}, errors.New("db文件升级失败：" + err.Error())
+	}
+	chain, err := NewBlockChain(db)
+	if err != nil {
+		_ = db.Close()
+		return BlockChain{}, err
+	}
+	return chain, nil
+}
+
+/**
+ * 把utxo缓存中的改动写回db，然后关闭db文件，其他进程可以打开该db文件
+ */
+func (chain *BlockChain) Close() error {
+	err := chain.FlushCoins()
+	if err != nil {
+		_ = chain.DB.Close()
+		return err
+	}
+	return chain.DB.Close()
+}
+
+/**
+ * 开启一个会修改utxo缓存的读写事务：事务失败时把缓存恢复到事务开始之前的状态，
+ * 避免缓存中留下db中并不存在的改动
+ */
+func (chain *BlockChain) updateCoins(fn func(tx *bolt.Tx) error) error {
+	cache := chain.UTXOSet.Cache
+	cache.Begin()
+	err := chain.DB.Update(fn)
+	if err != nil {
+		cache.Rollback()
+		return err
+	}
+	cache.Commit()
+	return nil
+}
+
+/**
+ * 把utxo缓存中还未写回的改动写入db，程序退出前调用
  */
-func (chain *BlockChain) CreateChainWithGenesis(coinbase []transaction.Transaction) {
+func (chain *BlockChain) FlushCoins() error {
+	return chain.UTXOSet.Flush()
+}
+
+/**
+ * 创建一个区块链实例，该实例携带创世区块genesis，并把miner设置为默认矿工地址。params为这条链的链参数
+ */
+func (chain *BlockChain) CreateChainWithGenesis(genesis Block, miner string, params ChainParams) error {
 	//先看chain.LastBlock是否为空
 	hashBig := new(big.Int)
 	hashBig.SetBytes(chain.LastBlock.Hash[:])
 	if hashBig.Cmp(big.NewInt(0)) == 1 {
-		return
+		return errors.New("创世区块已存在")
 	}
-	db := chain.DB
-
-	db.Update(func(tx *bolt.Tx) error {
-
+	// 创世区块、链参数、utxo、各项索引以及矿工地址在同一个事务中写入
+	err := chain.updateCoins(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(BUCKERNAME))
 		if bucket == nil {
-			bucket, _ = tx.CreateBucket([]byte(BUCKERNAME))
-		}
-		if bucket != nil {
-			lastHash := bucket.Get([]byte(LASTHASH))
-			if len(lastHash) == 0 {
-
-				genesis := CreateGenesisBlock(coinbase)
-				genesisBytes, _ := genesis.Serialize()
-				//存创世区块
-				bucket.Put(genesis.Hash[:], genesisBytes)
-				// 更新最新区块
-				bucket.Put([]byte(LASTHASH), genesis.Hash[:])
-				chain.LastBlock = genesis
-				chain.IteratorBloockHash = genesis.Hash
+			var err error
+			bucket, err = tx.CreateBucket([]byte(BUCKERNAME))
+			if err != nil {
+				return err
 			}
 		}
-		return nil
+		if len(bucket.Get([]byte(LASTHASH))) != 0 {
+			return errors.New("创世区块已存在")
+		}
+		err := setChainParamsInTx(tx, params)
+		if err != nil {
+			return err
+		}
+		if params.Consensus == consensus.POA {
+			err = putValidatorsInTx(tx, params.Validators)
+			if err != nil {
+				return err
+			}
+		}
+		//存创世区块
+		_, err = storeBlock(tx, genesis)
+		if err != nil {
+			return err
+		}
+		//更新utxo集合、各项索引和最新区块标记
+		err = connectBlock(tx, chain.UTXOSet.Cache, genesis)
+		if err != nil {
+			return err
+		}
+		//把miner设置为默认矿工地址
+		return wallet.SetCoinbaseInTx(tx, miner)
 	})
-
+	if err != nil {
+		return err
+	}
+	chain.LastBlock = genesis
+	chain.IteratorBloockHash = genesis.Hash
+	chain.Params = params
+	return nil
 }
 
-func (chain *BlockChain) CreateCoinbase(addr string) ([]byte, error) {
+/**
+ * 创建创世区块，返回创世区块中coinbase交易的hash。工作量证明的链使用当前网络固定的创世区块，addr只作为默认矿工地址；
+ * 权益证明和权威证明的链由本节点生成创世区块，出块奖励归addr所有
+ */
+func (chain *BlockChain) CreateCoinbase(addr string, params ChainParams) ([]byte, error) {
 	//1.判断地址有效性
 	isValid := wallet.IsAddressValid(addr)
 	if !isValid {
 		return nil, errors.New("输入地址有误，请重新参试")
 	}
 
-	coinbase, err := transaction.NewCoinbaseTx(addr)
-	if err != nil {
-		fmt.Println(err.Error())
-		return nil, err
+	var genesis Block
+	if params.Consensus == consensus.POW {
+		var err error
+		genesis, err = NetworkGenesisBlock()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		coinbase, err := transaction.NewCoinbaseTx(addr, consensus.BlockSubsidy(0))
+		if err != nil {
+			fmt.Println(err.Error())
+			return nil, err
+		}
+		genesis = CreateGenesisBlock([]transaction.Transaction{*coinbase}, params)
 	}
 
-	chain.CreateChainWithGenesis([]transaction.Transaction{*coinbase})
-
-	// 把coinbase交易产生的utxo存到utxoset中
-	utxos := make([]transaction.UTXO, 0)
-	for index, output := range coinbase.Outputs {
-		utxo := transaction.NewUTXO(coinbase.TxHash, index, output)
-		utxos = append(utxos, utxo)
-	}
-	success := chain.UTXOSet.AddUTXOWithAddress(addr, utxos)
-	if !success {
-		return nil, errors.New("保存utxo添加失败")
+	err := chain.CreateChainWithGenesis(genesis, addr, params)
+	if err != nil {
+		return nil, err
 	}
-	//把address设置为默认矿工地址
-	return coinbase.TxHash[:], chain.SetCoinbase(addr)
+	return genesis.Txs[0].TxHash[:], nil
 }
 
 /*
@@ -136,7 +314,7 @@ func (chain *BlockChain) CreateCoinbase(addr string) ([]byte, error) {
 
 	获取某个地址的余额
 */
-func (chain *BlockChain) GetBalance(address string) float64 {
+func (chain *BlockChain) GetBalance(address string) int64 {
 	_, totalBalance := chain.GetUtxoWithBalance(address, []transaction.Transaction{})
 	return totalBalance
 }
@@ -144,9 +322,9 @@ func (chain *BlockChain) GetBalance(address string) float64 {
 /*
 *
 
-	获取某个特定的地址余额和 所能花费的utxoSet
+	获取某个特定的地址余额和 所能花费的utxoSet，txs为还未打包进区块的交易
 */
-func (chain *BlockChain) GetUtxoWithBalance(address string, txs []transaction.Transaction) ([]transaction.UTXO, float64) {
+func (chain *BlockChain) GetUtxoWithBalance(address string, txs []transaction.Transaction) ([]transaction.UTXO, int64) {
 	//文件中遍历区块，找出区块已经存在交易中可花费utxo
 	//dbUtxos:=chain.SearchUTXO(address)
 	dbUtxos, err := chain.UTXOSet.QuerryUTXOByAddress(address)
@@ -158,14 +336,12 @@ func (chain *BlockChain) GetUtxoWithBalance(address string, txs []transaction.Tr
 	memSpends := make([]transaction.TxInput, 0)
 	memearns := make([]transaction.UTXO, 0)
 
-	kerPair := chain.Wallet.GetKeyPairByAddress(address)
-	if kerPair == nil {
-		return nil, 0
-	}
+	//按公钥hash判断交易输入是否由该地址发起，地址不必在钱包中
+	pubHash := wallet.GetPubKHashWithAddress(address)
 	for _, tx := range txs {
 
 		for _, input := range tx.Inputs {
-			if input.CheckPubKeyWithpubKey(kerPair.Pub) {
+			if bytes.Equal(wallet.NewPubKHash(input.Pubk), pubHash) {
 				memSpends = append(memSpends, input)
 			}
 		}
@@ -177,10 +353,10 @@ func (chain *BlockChain) GetUtxoWithBalance(address string, txs []transaction.Tr
 		}
 	}
 
-	// 将内存中以花的utxo从dbutxo删掉，将内存中产生的收入加入到可花费收入中
+	// 将内存中产生的收入加入到可花费收入中，再把内存中已花的utxo删掉(内存中的收入也可能已被之后的交易花掉)
 	utxos := make([]transaction.UTXO, 0)
 	var isSpent bool
-	for _, dbUtxo := range dbUtxos {
+	for _, dbUtxo := range append(dbUtxos, memearns...) {
 		isSpent = false
 		for _, memUtxo := range memSpends {
 			//判断某个UTXO 是否已经被消费掉
@@ -194,23 +370,31 @@ func (chain *BlockChain) GetUtxoWithBalance(address string, txs []transaction.Tr
 		}
 	}
 
-	utxos = append(utxos, memearns...)
-
 	fmt.Printf("地址%s一共找到%d笔金额\n", address, len(utxos))
-	var totalBalance float64
+	var totalBalance int64
 	for _, utxo := range utxos {
 		totalBalance += utxo.Value
 	}
-	fmt.Printf("获取%s的utxo和余额，余额是：%f\n", address, totalBalance)
+	fmt.Printf("获取%s的utxo和余额，余额是：%s\n", address, utils.FormatAmount(totalBalance))
 	return utxos, totalBalance
 }
 
-func (chain *BlockChain) SendTransaction(from string, to string, value string) error {
+/**
+ * 发送一批转账交易到交易池。每笔交易支付手续费fee；或者按费率feeRate(每千字节的金额)和交易序列化后的大小计算手续费，
+ * 两者只能指定一个。金额均为最小单位，手续费由打包交易的区块的coinbase交易获得
+ */
+func (chain *BlockChain) SendTransaction(from string, to string, value string, fee int64, feeRate int64) ([][32]byte, error) {
 	fromSlice, err := utils.JsonStringToSlince(from)
 	toSlice, err := utils.JsonStringToSlince(to)
-	valueSlice, err := utils.JsonFloatToSlice(value)
+	valueSlice, err := utils.JsonAmountToSlice(value)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if fee < 0 || feeRate < 0 {
+		return nil, errors.New("手续费和手续费率不能为负数")
+	}
+	if fee > 0 && feeRate > 0 {
+		return nil, errors.New("手续费和手续费率只能指定一个")
 	}
 
 	//判断参数的长度，筛选参数不匹配的情况
@@ -218,7 +402,7 @@ func (chain *BlockChain) SendTransaction(from string, to string, value string) e
 	lenTo := len(toSlice)
 	lenValue := len(valueSlice)
 	if !(lenFrom == lenTo && lenFrom == lenValue) {
-		return errors.New("发起交易的参数不匹配，请检查后重试")
+		return nil, errors.New("发起交易的参数不匹配，请检查后重试")
 	}
 
 	//地址有效性的判断
@@ -230,201 +414,177 @@ func (chain *BlockChain) SendTransaction(from string, to string, value string) e
 		//from: 合法   合法
 		//to:   不合法  不合法
 		if !isFromValid || !isToValid {
-			return errors.New("交易的参数地址不合法，请检查后重试")
+			return nil, errors.New("交易的参数地址不合法，请检查后重试")
 		}
 	}
 
+	//交易池中还未打包的交易已经花掉的钱不能再花，找零可以继续花
+	memTxs, err := chain.Mempool.GetTxs()
+	if err != nil {
+		return nil, err
+	}
 	//遍历参数的切片，创建交易
 	txs := make([]transaction.Transaction, 0)
 	for index := 0; index < lenFrom; index++ {
-		utxos, totalBalance := chain.GetUtxoWithBalance(fromSlice[index], txs)
-		//fmt.Printf("转账发起人%s,当前余额：%f,接收者:%s,转账数额：%f\n", fromSlice[index], totalBalance, toSlice[index], valueSlice[index])
-		if totalBalance < valueSlice[index] {
-			return errors.New("抱歉，" + fromSlice[index] + "余额不足，请充值！")
+		utxos, _ := chain.GetUtxoWithBalance(fromSlice[index], memTxs)
+		keyPair := chain.Wallet.GetKeyPairByAddress(fromSlice[index])
+		if keyPair == nil {
+			return nil, errors.New("钱包中没有" + fromSlice[index] + "的私钥")
 		}
 
-		var inputAmount float64 //总的花费的钱数
-
-		utxoNum := 0
-		for num, utxo := range utxos {
-			inputAmount += utxo.Value
-			if inputAmount >= valueSlice[index] {
-				//够花了
-				utxoNum = num
+		//1、创建交易并使用from对应的私钥签名。按费率计算手续费时，交易的大小取决于用到几个utxo，
+		//而用到几个utxo又取决于手续费，所以手续费不足时按新的手续费重新创建，直到手续费足够为止
+		txFee := fee
+		var tx *transaction.Transaction
+		for {
+			tx, err = newSignedTransaction(utxos, fromSlice[index], toSlice[index], valueSlice[index], txFee, keyPair)
+			//如果任何一笔交易创建失败，则全部交易结束，返回错误信息
+			if err != nil {
+				return nil, err
+			}
+			if feeRate == 0 {
 				break
 			}
+			data, err := tx.Serialize()
+			if err != nil {
+				return nil, err
+			}
+			required := feeForSize(len(data), feeRate)
+			if txFee >= required {
+				break
+			}
+			txFee = required
 		}
-		keyPair := chain.Wallet.GetKeyPairByAddress(fromSlice[index])
-		//1、创建交易
-
-		tx, err := transaction.NewTransaction(
-			utxos[:utxoNum+1],
-			fromSlice[index],
-			keyPair.Pub,
-			toSlice[index],
-			valueSlice[index])
-		if err != nil {
-			return errors.New("抱歉，创建交易失败，请检查后重试")
-		}
-
-		//2、使用from对应的私钥对tx进行交易签名
-		err = tx.Sign(keyPair.Pri, utxos[:utxoNum+1])
-		//如果任何一笔交易签名失败，则全部交易结束，返回错误信息
-		if err != nil {
-			return err
-		}
-		//3、把已经构建好并且签好名的交易放入到txs内存切片容器中
+		//2、把已经构建好并且签好名的交易放入到txs内存切片容器中
 		txs = append(txs, *tx)
+		memTxs = append(memTxs, *tx)
 	}
 
-	address := chain.GetCoinbase()
-	if len(address) == 0 {
-		return errors.New("未设置coinbase矿工地址，请先设置")
-	}
-	coninbase, err := transaction.NewCoinbaseTx(address)
+	//交易放入交易池，等待挖矿时打包进区块
+	err = chain.Mempool.AcceptTxs(txs, chain.LastBlock.Height)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-
-	sumTxs := make([]transaction.Transaction, 0)
-	sumTxs = append(sumTxs, *coninbase)
-	sumTxs = append(sumTxs, txs...)
-	//对即将要打包到区块中的交易进行签名验证，确保交易的正确性。
-	//如果发现有非法的交易（即签名验证失败），则终止打包，返回错误。
-
-	//该段验证签名的代码由矿工节点执行，对每一笔交易依次进行签名
+	txids := make([][32]byte, 0)
 	for _, tx := range txs {
-
-		//首先判断交易是否是coinbase交易，如果是，则不需要验签
-		if tx.IsCoinbaseTranaction() {
-			continue
-		}
-		//1、先找出当前的交易tx消费的是哪些utxo
-		spendUTXOs, err := chain.FindSpentUTXOsByTrabsaction(tx, txs)
-		if err != nil {
-			return err
-		}
-
-		//2、把找到的该笔tx所消费的utxo传入到签名验证方法中，供进行使用
-		verify, err := tx.VertifySign(spendUTXOs)
-		if err != nil {
-			return err
-		}
-
-		if !verify {
-			return errors.New("交易失败。请重试！")
-		}
+		txids = append(txids, tx.TxHash)
 	}
+	return txids, nil
+}
 
-	//把构建好的交易存入到区块中
-	err = chain.AddNewBlock(txs)
+/**
+ * 从utxos中依次选取足够支付转账金额和手续费的utxo，创建交易并签名，多出的部分找零给转账发起人
+ */
+func newSignedTransaction(utxos []transaction.UTXO, from string, to string, value int64, fee int64, keyPair *wallet.KeyPair) (*transaction.Transaction, error) {
+	if value <= 0 {
+		return nil, errors.New("转账金额必须大于0")
+	}
+	need := value + fee
+	var inputAmount int64 //总的花费的钱数
+	utxoNum := 0
+	for utxoNum < len(utxos) && inputAmount < need {
+		inputAmount += utxos[utxoNum].Value
+		utxoNum++
+	}
+	if inputAmount < need {
+		return nil, errors.New("抱歉，" + from + "余额不足以支付转账金额和手续费，请充值！")
+	}
+	tx, err := transaction.NewTransaction(utxos[:utxoNum], from, keyPair.Pub, to, value, fee)
 	if err != nil {
-		return err
+		return nil, errors.New("抱歉，创建交易失败，请检查后重试")
 	}
-	// txs: coinbase + 用户自定义交易
+	err = tx.Sign(keyPair.Pri, utxos[:utxoNum])
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
 
-	//遍历txs，统计哪些地址，产生了哪些utxo 将结果保存
-	usxoSet := make(map[string][]transaction.UTXO)
-	for txindex, tx := range txs {
-		for index, output := range tx.Outputs {
-			utxo := transaction.NewUTXO(tx.TxHash, index, output)
-			isSpent := false
-			for i := txindex + 1; i < len(txs); i++ {
-				for _, input := range txs[i].Inputs {
-					if utxo.IsSpent(input) {
-						isSpent = true
-					}
-				}
-			}
-			if !isSpent {
-				//根据utxo.pubhash得到地址
-				address := chain.Wallet.GetAddressByPubKHash(utxo.PubHash)
+// 按每千字节feeRate的费率，size字节的交易需要支付的手续费，不足一个最小单位的部分向上取整
+func feeForSize(size int, feeRate int64) int64 {
+	return (feeRate*int64(size) + 999) / 1000
+}
 
-				utxos := usxoSet[address]
-				if len(utxos) == 0 {
-					utxos = make([]transaction.UTXO, 0)
-				}
-				utxos = append(utxos, utxo)
-				usxoSet[address] = utxos
-			}
-		}
+/**
+ * 用交易打包出一个新区块，并连接到主链末端。区块按链参数选用的共识机制产出，
+ * threads只对工作量证明有效。返回挖矿的统计信息
+ */
+func (chain *BlockChain) AddNewBlock(ctx context.Context, txs []transaction.Transaction, threads int) (*MiningStats, error) {
+	lastBlock := chain.LastBlock
+	bits, err := chain.GetNextBits()
+	if err != nil {
+		return nil, err
 	}
-	for pubkHash, utxos := range usxoSet {
-		success := chain.UTXOSet.AddUTXOWithAddress(pubkHash, utxos)
-		if !success {
-			return errors.New("保存失败")
-		}
+	timestamp, err := chain.nextBlockTime()
+	if err != nil {
+		return nil, err
 	}
-
-	//从UTXOSet中把已消费掉的utxo删除掉
-	// target ：统计当前内存中交易哪些地址花了哪些utxo，统计即可
-	spendRecords := make(map[string][]utxoset.SpendRecord, 0)
-
-	for _, tx := range txs {
-		for _, input := range tx.Inputs {
-			//只需要记录每个input消费的txid 和 vout
-			spendRecord := utxoset.NewSpendRecord(input.Txid, input.Vout)
-			address, err := wallet.NewAddress(input.Pubk)
-			if err != nil {
-				return err
-			}
-			records := spendRecords[address]
-			if len(records) == 0 {
-				records = make([]utxoset.SpendRecord, 0)
-			}
-			records = append(records, spendRecord)
-
-			spendRecords[address] = records
-		}
+	newEngine, err := chain.newEngine(threads)
+	if err != nil {
+		return nil, err
 	}
-
-	// 对每个地址消费统计的spendrecord进行删除
-	for address, record := range spendRecords {
-		success := chain.UTXOSet.Change(address, record)
-		if !success {
-			return errors.New("更新utxo数据失败")
-		}
+	newBlock, stats, err := CreateBlock(ctx, lastBlock.Height, lastBlock.Hash, bits, timestamp, txs, newEngine)
+	if err != nil {
+		return stats, err
 	}
+	return stats, chain.ConnectBlock(*newBlock)
+}
 
+/**
+ * 区块写入db时，在同一个事务中更新高度索引、地址历史索引，以及开启了的交易索引。undo为区块的撤销数据，地址历史索引从中取得支出的金额
+ */
+func indexBlock(tx *bolt.Tx, block Block, undo BlockUndo) error {
+	heightBucket := tx.Bucket([]byte(HEIGHTINDEX))
+	if heightBucket == nil {
+		heightBucket, _ = tx.CreateBucket([]byte(HEIGHTINDEX))
+	}
+	err := putHeightIndex(heightBucket, block.Height, block.Hash)
+	if err != nil {
+		return err
+	}
+	addrBucket := tx.Bucket([]byte(ADDRINDEX))
+	if addrBucket == nil {
+		addrBucket, _ = tx.CreateBucket([]byte(ADDRINDEX))
+	}
+	err = putAddrIndex(addrBucket, block, undo)
+	if err != nil {
+		return err
+	}
+	txBucket := tx.Bucket([]byte(TXINDEX))
+	if txBucket != nil {
+		return putTxIndex(txBucket, block)
+	}
 	return nil
 }
 
-func (chain *BlockChain) AddNewBlock(txs []transaction.Transaction) error {
-	//1.从db中找到最后一个区块数据
-	db := chain.DB
-	//2. 获取到最新区块
-	lastBlock := chain.LastBlock
-
-	//3. 得到区块属性
-	newBlock, err := CreateBlock(lastBlock.Height, lastBlock.Hash, txs)
+/**
+ * 区块从主链断开时，在同一个事务中删除该区块的高度索引、地址历史索引和交易索引
+ */
+func unindexBlock(tx *bolt.Tx, block Block) error {
+	heightBucket := tx.Bucket([]byte(HEIGHTINDEX))
+	if heightBucket == nil {
+		return errors.New("区块高度索引不存在")
+	}
+	heightBytes, err := utils.IntToByte(block.Height)
 	if err != nil {
 		return err
 	}
-	newBlockBytes, err := newBlock.Serialize()
+	err = heightBucket.Delete(heightBytes)
 	if err != nil {
 		return err
 	}
-	//4. 更新db文件，将新生成的区块写入文件中
-	db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(BUCKERNAME))
-		if bucket == nil {
-			err = errors.New("上一步有错")
+	addrBucket := tx.Bucket([]byte(ADDRINDEX))
+	if addrBucket != nil {
+		err = deleteAddrIndex(addrBucket, block)
+		if err != nil {
 			return err
 		}
-		//更新区块数据
-		bucket.Put(newBlock.Hash[:], newBlockBytes)
-		//更新最新区块指向标记
-		bucket.Put([]byte(LASTHASH), newBlock.Hash[:])
-		return nil
-
-		//更新blockChain对象的lastBlock结构体
-		chain.LastBlock = *newBlock
-		chain.IteratorBloockHash = lastBlock.Hash
-
-		return nil
-	})
+	}
+	txBucket := tx.Bucket([]byte(TXINDEX))
+	if txBucket != nil {
+		return deleteTxIndex(txBucket, block)
+	}
 	return nil
-
 }
 
 func (chain BlockChain) GetLastBlock() Block {
@@ -454,8 +614,9 @@ func (chain BlockChain) GetAllBlocks() ([]Block, error) {
 		currentHash = chain.LastBlock.PreHash[:]
 		for {
 			//倒数第二个区块开始遍历
-			currentBlockBytes := bucket.Get(currentHash)
-			currentBlock, _ := UnSerialize(currentBlockBytes)
+			var blockHash [32]byte
+			copy(blockHash[:], currentHash)
+			currentBlock, _ := getBlockInTx(tx, blockHash)
 
 			blocks = append(blocks, currentBlock)
 
@@ -479,22 +640,11 @@ func (chain *BlockChain) IsNext() bool {
 	//是否还有前一个区块
 	engine := chain.DB
 	var isNext bool
-	genesishash := [32]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 
 	engine.View(func(tx *bolt.Tx) error {
-		currentBlockHash := chain.IteratorBloockHash[:]
-
-		bucket := tx.Bucket([]byte(BUCKERNAME))
-		if bucket == nil {
-			return errors.New("区块数据文件失败")
-		}
-		currentBlockByte := bucket.Get(currentBlockHash[:])
-		currentBlock, _ := UnSerialize(currentBlockByte)
-		if bytes.Compare(currentBlock.Hash[:], genesishash[:]) == 0 {
-			isNext = false
-		} else {
-			isNext = true
-		}
+		//只需判断迭代到的区块是否存在，读取区块头即可
+		_, err := getHeaderInTx(tx, chain.IteratorBloockHash)
+		isNext = err == nil
 
 		//isNext = len(preBlockBytes) !=0
 		return nil
@@ -512,12 +662,7 @@ func (chain *BlockChain) Next() Block {
 	var currentBlock Block
 	engine.View(func(tx *bolt.Tx) error {
 
-		bucket := tx.Bucket([]byte(BUCKERNAME))
-		if bucket == nil {
-			return errors.New("区块数据文件失败")
-		}
-		currentBlockBytes := bucket.Get(chain.IteratorBloockHash[:])
-		currentBlock, _ = UnSerialize(currentBlockBytes)
+		currentBlock, _ = getBlockInTx(tx, chain.IteratorBloockHash)
 		chain.IteratorBloockHash = currentBlock.PreHash
 		return nil
 	})
@@ -539,12 +684,14 @@ func (chain BlockChain) SearchUTXO(from string) []transaction.UTXO {
 	if keyPair == nil {
 		return nil
 	}
+	//从最新区块开始遍历
+	chain.IteratorBloockHash = chain.LastBlock.Hash
 	for chain.IsNext() { //遍历区块
 		block := chain.Next()
 		for _, tx := range block.Txs { //遍历交易
 			//a.遍历交易输入
 			for _, input := range tx.Inputs {
-				if input.CheckPubKeyWithpubKey(keyPair.Pub) {
+				if !input.CheckPubKeyWithpubKey(keyPair.Pub) {
 					continue
 				} //from花费了
 				spents = append(spents, input)
@@ -656,19 +803,23 @@ func (chain BlockChain) FindSpentUTXOsByTrabsaction(transac transaction.Transact
 	//	})
 	//}
 
-	// 去utxo Set中寻找 该交易花费的utxo
+	// 去utxo Set中按outpoint寻找 该交易花费的utxo，花费内存中交易输出的除外
 	records := make([]utxoset.SpendRecord, 0)
 
 	for _, input := range transac.Inputs {
+		inMemory := false
+		for _, memTx := range memTxs {
+			if memTx.TxHash == input.Txid {
+				inMemory = true
+			}
+		}
+		if inMemory {
+			continue
+		}
 		spendRecord := utxoset.NewSpendRecord(input.Txid, input.Vout)
 		records = append(records, spendRecord)
 	}
-	// 不是coinbase  transac.Inputs[0]不会报错 可以不用判断input长度
-	address, err := wallet.NewAddress(transac.Inputs[0].Pubk)
-	if err != nil {
-		return nil, err
-	}
-	spentUTXOs, err = chain.UTXOSet.QuerrySpendUTXOs(address, records)
+	spentUTXOs, err = chain.UTXOSet.QuerrySpendUTXOs(records)
 	if err != nil {
 		return nil, err
 	}
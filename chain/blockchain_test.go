@@ -0,0 +1,199 @@
+package chain
+
+import (
+	"PublicChain/utxoset"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+/**
+ * 在临时目录中创建一条有count个区块(不含创世区块)的链，关闭后返回db文件路径和主链上的区块
+ */
+func closedTestChain(t *testing.T, count int) (string, []Block) {
+	t.Helper()
+	chain, address := newTestChain(t)
+	blocks := []Block{chain.LastBlock}
+	for i := 0; i < count; i++ {
+		block := mineTestBlock(t, chain.LastBlock, address)
+		acceptTestBlocks(t, chain, block)
+		blocks = append(blocks, block)
+	}
+	path := chain.DB.Path()
+	err := chain.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, blocks
+}
+
+// 直接修改db文件，模拟旧版本或损坏的db文件
+func tamperTestDB(t *testing.T, path string, fn func(tx *bolt.Tx) error) {
+	t.Helper()
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Update(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hasTestBucket(t *testing.T, path string, name string) bool {
+	t.Helper()
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var exists bool
+	err = db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket([]byte(name)) != nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+// 打开旧的db文件时补建索引或统计信息失败，打开报错，已补建的部分整体回滚
+func TestOpenFailsWhenUpgradeFails(t *testing.T) {
+	cases := []struct {
+		name   string
+		bucket string // 模拟旧版本db文件时删除的数据区，打开失败后应仍不存在
+		tamper func(tx *bolt.Tx, blocks []Block) error
+	}{
+		{"补建高度索引失败", HEIGHTINDEX, func(tx *bolt.Tx, blocks []Block) error {
+			return tx.Bucket([]byte(BUCKERNAME)).Delete(blocks[1].Hash[:])
+		}},
+		{"补建区块元数据索引失败", BLOCKINDEX, func(tx *bolt.Tx, blocks []Block) error {
+			return tx.Bucket([]byte(BUCKERNAME)).Delete(blocks[1].Hash[:])
+		}},
+		{"补建地址历史索引失败", ADDRINDEX, func(tx *bolt.Tx, blocks []Block) error {
+			return tx.Bucket([]byte(BUCKERNAME)).Delete(blocks[1].Hash[:])
+		}},
+		{"补建utxo统计信息失败", utxoset.UTXOMETA, func(tx *bolt.Tx, blocks []Block) error {
+			return tx.Bucket([]byte(utxoset.UTXOSET)).Put([]byte("无效的key"), []byte{0x01})
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path, blocks := closedTestChain(t, 2)
+			tamperTestDB(t, path, func(tx *bolt.Tx) error {
+				err := tx.DeleteBucket([]byte(c.bucket))
+				if err != nil {
+					return err
+				}
+				return c.tamper(tx, blocks)
+			})
+			chain, err := OpenBlockChain(path)
+			if err == nil {
+				_ = chain.Close()
+				t.Fatal("补建失败时打开db文件应报错")
+			}
+			if hasTestBucket(t, path, c.bucket) {
+				t.Errorf("打开失败后%s不应被写入db", c.bucket)
+			}
+		})
+	}
+}
+
+// 最新区块的区块头或区块体缺失、无法解析时打开报错，不能以空的区块作为最新区块
+func TestOpenFailsWhenLastBlockUnreadable(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func(tx *bolt.Tx, tip Block) error
+	}{
+		{"区块体缺失", func(tx *bolt.Tx, tip Block) error {
+			return tx.Bucket([]byte(BUCKERNAME)).Delete(tip.Hash[:])
+		}},
+		{"区块体无法解析", func(tx *bolt.Tx, tip Block) error {
+			return tx.Bucket([]byte(BUCKERNAME)).Put(tip.Hash[:], []byte{0x01, 0x02})
+		}},
+		{"区块头缺失", func(tx *bolt.Tx, tip Block) error {
+			return tx.Bucket([]byte(HEADERBUCKET)).Delete(tip.Hash[:])
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path, blocks := closedTestChain(t, 1)
+			tamperTestDB(t, path, func(tx *bolt.Tx) error {
+				return c.tamper(tx, blocks[len(blocks)-1])
+			})
+			chain, err := OpenBlockChain(path)
+			if err == nil {
+				_ = chain.Close()
+				t.Fatal("无法读取最新区块时打开db文件应报错")
+			}
+			if !strings.Contains(err.Error(), "无法读取最新区块") {
+				t.Errorf("错误信息没有指出最新区块无法读取：%v", err)
+			}
+		})
+	}
+}
+
+// 旧版本按地址存储的utxo集合中有无法解析的数据时，不迁移也不删除旧的集合，从区块重建utxo集合，重建成功后才删除旧的集合
+func TestOpenRebuildsUndecodableLegacyUTXOSet(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func(tx *bolt.Tx, blocks []Block) error
+		valid  bool
+	}{
+		{"重建成功", func(tx *bolt.Tx, blocks []Block) error { return nil }, true},
+		{"区块缺失导致重建失败", func(tx *bolt.Tx, blocks []Block) error {
+			return tx.Bucket([]byte(BUCKERNAME)).Delete(blocks[1].Hash[:])
+		}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path, blocks := closedTestChain(t, 2)
+			tamperTestDB(t, path, func(tx *bolt.Tx) error {
+				for _, name := range []string{utxoset.UTXOSET, utxoset.UTXOADDRINDEX} {
+					err := tx.DeleteBucket([]byte(name))
+					if err != nil {
+						return err
+					}
+				}
+				legacy, err := tx.CreateBucket([]byte(utxoset.LEGACYUTXOSET))
+				if err != nil {
+					return err
+				}
+				err = legacy.Put([]byte("无法解析的地址"), []byte{0x01, 0x02})
+				if err != nil {
+					return err
+				}
+				return c.tamper(tx, blocks)
+			})
+			chain, err := OpenBlockChain(path)
+			if !c.valid {
+				if err == nil {
+					_ = chain.Close()
+					t.Fatal("重建失败时打开db文件应报错")
+				}
+				if !hasTestBucket(t, path, utxoset.LEGACYUTXOSET) {
+					t.Error("重建失败时旧的utxo集合不应被删除")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, block := range blocks[1:] {
+				if !hasUTXO(t, &chain, block.Txs[0].TxHash, 0) {
+					t.Errorf("高度%d的coinbase输出没有重建", block.Height)
+				}
+			}
+			err = chain.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasTestBucket(t, path, utxoset.LEGACYUTXOSET) {
+				t.Error("重建成功后旧的utxo集合应被删除")
+			}
+		})
+	}
+}
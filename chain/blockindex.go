@@ -0,0 +1,290 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/utils"
+	"bytes"
+	"errors"
+	"github.com/boltdb/bolt"
+	"math/big"
+)
+
+// 区块元数据索引：区块hash -> BlockIndex，主链和分叉上的区块都会记录
+const BLOCKINDEX = "blockindex"
+
+// 区块的状态
+const (
+	BLOCKVALID   = 0 // 区块数据有效，可能在主链上，也可能在分叉上
+	BLOCKINVALID = 1 // 区块连接到主链时验证失败，或者被手动标记为无效
+)
+
+// 链的末端(tip)的状态
+const (
+	TIPACTIVE    = "active"     // 当前主链的最新区块
+	TIPVALIDFORK = "valid-fork" // 有效的分叉，累计工作量不如主链
+	TIPINVALID   = "invalid"    // 分叉上存在无效区块
+)
+
+/**
+ * 区块的元数据，用于在不反序列化整个区块的情况下进行链的选择
+ */
+type BlockIndex struct {
+	Hash      [32]byte
+	PreHash   [32]byte
+	Height    int64
+	ChainWork []byte // 从创世区块到该区块累计的工作量，big.Int的字节表示
+	Status    int
+}
+
+/**
+ * 链的末端，即没有子区块的区块
+ */
+type ChainTip struct {
+	Hash      [32]byte
+	Height    int64
+	BranchLen int64 // 与主链分叉点之间的距离，主链为0
+	Status    string
+}
+
+func (index BlockIndex) GetChainWork() *big.Int {
+	return new(big.Int).SetBytes(index.ChainWork)
+}
+
+/**
+ * 在事务中读取某个区块的元数据
+ */
+func getBlockIndex(tx *bolt.Tx, hash [32]byte) (*BlockIndex, error) {
+	bucket := tx.Bucket([]byte(BLOCKINDEX))
+	if bucket == nil {
+		return nil, errors.New("区块索引不存在")
+	}
+	indexBytes := bucket.Get(hash[:])
+	if len(indexBytes) == 0 {
+		return nil, errors.New("未找到该区块的索引")
+	}
+	var index BlockIndex
+	_, err := utils.GodDecode(indexBytes, &index)
+	return &index, err
+}
+
+func putBlockIndex(tx *bolt.Tx, index BlockIndex) error {
+	bucket := tx.Bucket([]byte(BLOCKINDEX))
+	if bucket == nil {
+		var err error
+		bucket, err = tx.CreateBucket([]byte(BLOCKINDEX))
+		if err != nil {
+			return err
+		}
+	}
+	indexBytes, err := utils.GobEncode(index)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(index.Hash[:], indexBytes)
+}
+
+/**
+ * 保存区块数据，并根据父区块的累计工作量记录该区块的元数据，不改变主链
+ */
+func storeBlock(tx *bolt.Tx, block Block) (*BlockIndex, error) {
+	bucket := tx.Bucket([]byte(BUCKERNAME))
+	if bucket == nil {
+		return nil, errors.New("区块数据区操作失败")
+	}
+	headerBucket, err := tx.CreateBucketIfNotExists([]byte(HEADERBUCKET))
+	if err != nil {
+		return nil, err
+	}
+	err = headerBucket.Put(block.Hash[:], block.BlockHeader.Serialize())
+	if err != nil {
+		return nil, err
+	}
+	err = bucket.Put(block.Hash[:], block.serializeBody())
+	if err != nil {
+		return nil, err
+	}
+
+	chainWork := consensus.CalculateWork(consensus.CompactToTarget(block.Bits))
+	if block.Height > 0 {
+		parent, err := getBlockIndex(tx, block.PreHash)
+		if err != nil {
+			return nil, errors.New("未找到父区块")
+		}
+		chainWork.Add(chainWork, parent.GetChainWork())
+	}
+	index := BlockIndex{
+		Hash:      block.Hash,
+		PreHash:   block.PreHash,
+		Height:    block.Height,
+		ChainWork: chainWork.Bytes(),
+		Status:    BLOCKVALID,
+	}
+	return &index, putBlockIndex(tx, index)
+}
+
+/**
+ * 为已有的db文件按高度补建区块元数据索引，旧文件中只有主链
+ */
+func buildBlockIndex(tx *bolt.Tx) error {
+	heightBucket := tx.Bucket([]byte(HEIGHTINDEX))
+	if heightBucket == nil {
+		return errors.New("区块高度索引不存在")
+	}
+	chainWork := big.NewInt(0)
+	work := consensus.CalculateWork(consensus.NewTarget())
+	return heightBucket.ForEach(func(heightBytes, hashBytes []byte) error {
+		var hash [32]byte
+		copy(hash[:], hashBytes)
+		block, err := getBlockInTx(tx, hash)
+		if err != nil {
+			return err
+		}
+		chainWork.Add(chainWork, work)
+		return putBlockIndex(tx, BlockIndex{
+			Hash:      block.Hash,
+			PreHash:   block.PreHash,
+			Height:    block.Height,
+			ChainWork: chainWork.Bytes(),
+			Status:    BLOCKVALID,
+		})
+	})
+}
+
+/**
+ * 在事务中根据hash取出区块
+ */
+func getBlockInTx(tx *bolt.Tx, hash [32]byte) (Block, error) {
+	header, err := getHeaderInTx(tx, hash)
+	if err != nil {
+		return Block{}, err
+	}
+	bucket := tx.Bucket([]byte(BUCKERNAME))
+	if bucket == nil {
+		return Block{}, errors.New("区块数据区操作失败")
+	}
+	bodyBytes := bucket.Get(hash[:])
+	if len(bodyBytes) == 0 {
+		return Block{}, errors.New("未找到该hash对应的区块")
+	}
+	block, err := unserializeBody(*header, bodyBytes)
+	if err != nil {
+		return Block{}, err
+	}
+	if block.Hash != hash {
+		return Block{}, errors.New("区块头的hash与存储的key不一致")
+	}
+	return block, nil
+}
+
+/**
+ * 在事务中根据hash只取出区块头，不读取区块中的交易
+ */
+func getHeaderInTx(tx *bolt.Tx, hash [32]byte) (*consensus.BlockHeader, error) {
+	bucket := tx.Bucket([]byte(HEADERBUCKET))
+	if bucket == nil {
+		return nil, errors.New("区块头数据区操作失败")
+	}
+	headerBytes := bucket.Get(hash[:])
+	if len(headerBytes) == 0 {
+		return nil, errors.New("未找到该hash对应的区块")
+	}
+	header, err := consensus.DeserializeHeader(headerBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+/**
+ * 判断某个区块是否在当前主链上：高度索引中该高度对应的正是这个区块
+ */
+func isOnActiveChain(tx *bolt.Tx, index BlockIndex) bool {
+	bucket := tx.Bucket([]byte(HEIGHTINDEX))
+	if bucket == nil {
+		return false
+	}
+	heightBytes, err := utils.IntToByte(index.Height)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(bucket.Get(heightBytes), index.Hash[:])
+}
+
+/**
+ * 从某个区块沿着父区块向前走，直到主链上的分叉点。
+ * 返回分叉上的区块(从新到旧)，以及分支上是否存在无效区块
+ */
+func getBranch(tx *bolt.Tx, hash [32]byte) ([]BlockIndex, bool, error) {
+	branch := make([]BlockIndex, 0)
+	valid := true
+	for {
+		index, err := getBlockIndex(tx, hash)
+		if err != nil {
+			return nil, false, err
+		}
+		if isOnActiveChain(tx, *index) {
+			return branch, valid, nil
+		}
+		if index.Status == BLOCKINVALID {
+			valid = false
+		}
+		branch = append(branch, *index)
+		if index.Height == 0 {
+			// 与主链没有共同的创世区块
+			return branch, valid, nil
+		}
+		hash = index.PreHash
+	}
+}
+
+/**
+ * 列出所有链的末端：主链、有效分叉以及包含无效区块的分叉
+ */
+func (chain *BlockChain) GetChainTips() ([]ChainTip, error) {
+	tips := make([]ChainTip, 0)
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(BLOCKINDEX))
+		if bucket == nil {
+			return errors.New("区块索引不存在")
+		}
+		indexes := make([]BlockIndex, 0)
+		hasChild := make(map[[32]byte]bool)
+		err := bucket.ForEach(func(key, value []byte) error {
+			var index BlockIndex
+			_, err := utils.GodDecode(value, &index)
+			if err != nil {
+				return err
+			}
+			indexes = append(indexes, index)
+			hasChild[index.PreHash] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			// 回退主链之后，主链的最新区块可能还有(无效的)子区块，主链末端总是要列出
+			if hasChild[index.Hash] && index.Hash != chain.LastBlock.Hash {
+				continue
+			}
+			branch, valid, err := getBranch(tx, index.Hash)
+			if err != nil {
+				return err
+			}
+			tip := ChainTip{
+				Hash:      index.Hash,
+				Height:    index.Height,
+				BranchLen: int64(len(branch)),
+				Status:    TIPVALIDFORK,
+			}
+			if index.Hash == chain.LastBlock.Hash {
+				tip.Status = TIPACTIVE
+			} else if !valid {
+				tip.Status = TIPINVALID
+			}
+			tips = append(tips, tip)
+		}
+		return nil
+	})
+	return tips, err
+}
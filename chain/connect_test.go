@@ -0,0 +1,120 @@
+package chain
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 区块在db各个数据区中留下的记录：区块头、区块体、高度索引、撤销数据和交易索引
+func blockRecords(t *testing.T, chain *BlockChain, block Block) []string {
+	t.Helper()
+	records := make([]string, 0)
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		if len(tx.Bucket([]byte(HEADERBUCKET)).Get(block.Hash[:])) != 0 {
+			records = append(records, HEADERBUCKET)
+		}
+		if len(tx.Bucket([]byte(BUCKERNAME)).Get(block.Hash[:])) != 0 {
+			records = append(records, BUCKERNAME)
+		}
+		heightBytes, err := utils.IntToByte(block.Height)
+		if err != nil {
+			return err
+		}
+		if string(tx.Bucket([]byte(HEIGHTINDEX)).Get(heightBytes)) == string(block.Hash[:]) {
+			records = append(records, HEIGHTINDEX)
+		}
+		if undo := tx.Bucket([]byte(UNDOBUCKET)); undo != nil && len(undo.Get(block.Hash[:])) != 0 {
+			records = append(records, UNDOBUCKET)
+		}
+		txIndex := tx.Bucket([]byte(TXINDEX))
+		for _, transac := range block.Txs {
+			if txIndex != nil && len(txIndex.Get(transac.TxHash[:])) != 0 {
+				records = append(records, TXINDEX)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// 连接成功时区块、utxo和各项索引一起写入；交易签名无效时全部不写入，最新区块标记和地址历史保持不变
+func TestConnectBlockAtomic(t *testing.T) {
+	chain, address := newTestChain(t)
+	to, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chain.BuildTxIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := mineTestBlock(t, chain.LastBlock, address)
+	err = chain.ConnectBlock(a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := blockRecords(t, chain, a1); len(records) != 5 {
+		t.Fatalf("连接a1后只写入了%v", records)
+	}
+	history := testAddressHistory(t, chain, address)
+
+	coinbase := transaction.NewUTXO(a1.Txs[0].TxHash, 0, a1.Txs[0].Outputs[0])
+	spend := spendTestUTXOs(t, chain, []transaction.UTXO{coinbase}, address, to, 10*utils.COIN)
+	spend.Inputs[0].Sig = append([]byte{}, spend.Inputs[0].Sig...)
+	spend.Inputs[0].Sig[len(spend.Inputs[0].Sig)-1] ^= 0xff
+	bad := mineTestBlock(t, a1, address, spend)
+	err = chain.ConnectBlock(bad)
+	if err == nil {
+		t.Fatal("交易签名无效的区块应连接失败")
+	}
+	if records := blockRecords(t, chain, bad); len(records) != 0 {
+		t.Errorf("连接失败后db中留下了%v", records)
+	}
+	err = chain.DB.View(func(tx *bolt.Tx) error {
+		if string(tx.Bucket([]byte(BUCKERNAME)).Get([]byte(LASTHASH))) != string(a1.Hash[:]) {
+			t.Error("最新区块标记应仍为a1")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.LastBlock.Hash != a1.Hash || chain.IteratorBloockHash != a1.Hash {
+		t.Error("内存中的最新区块应仍为a1")
+	}
+	if after := testAddressHistory(t, chain, address); len(after) != len(history) {
+		t.Errorf("地址历史有%d条记录，连接之前为%d条", len(after), len(history))
+	}
+	if len(testAddressHistory(t, chain, to)) != 0 {
+		t.Error("连接失败的区块不应留下收款记录")
+	}
+	if !hasUTXO(t, chain, coinbase.TxId, coinbase.Vout) {
+		t.Error("a1的coinbase交易的utxo应仍未花费")
+	}
+}
+
+// 只能连接父区块为主链最新区块、高度加1的区块
+func TestConnectBlockRequiresTip(t *testing.T) {
+	chain, address := newTestChain(t)
+	genesis := chain.LastBlock
+	a1 := mineTestBlock(t, genesis, address)
+	err := chain.ConnectBlock(a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1 := mineTestBlock(t, genesis, address)
+	err = chain.ConnectBlock(b1)
+	if err == nil {
+		t.Error("父区块不是最新区块时应连接失败")
+	}
+	if records := blockRecords(t, chain, b1); len(records) != 0 {
+		t.Errorf("连接失败后db中留下了%v", records)
+	}
+}
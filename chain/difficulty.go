@@ -0,0 +1,183 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"sort"
+	"time"
+)
+
+// 区块的时间戳必须大于前MEDIANTIMESPAN个区块时间戳的中位数
+const MEDIANTIMESPAN = 11
+
+// 区块的时间戳最多比当前时间晚多少秒
+const MAXFUTUREBLOCKTIME = 2 * 60 * 60
+
+/**
+ * getdifficulty的返回结果
+ */
+type DifficultyInfo struct {
+	Height     int64   // 最新区块高度
+	Bits       uint32  // 最新区块的难度目标
+	Difficulty float64 // 最新区块的难度
+	NextBits   uint32  // 下一个区块应使用的难度目标
+	Remaining  int64   // 再挖出多少个区块后调整难度，最后一个区块使用新的难度目标；当前网络不调整难度时为0
+}
+
+/**
+ * 计算父区块之后的下一个区块应使用的难度目标：不在调整高度时沿用父区块的难度目标，
+ * 否则沿区块头向前找到本周期的第一个区块，根据周期内实际花费的时间重新计算。权威证明不调整难度
+ */
+func nextBitsInTx(tx *bolt.Tx, parentHash [32]byte, parentHeight int64) (uint32, error) {
+	parent, err := getHeaderInTx(tx, parentHash)
+	if err != nil {
+		return 0, err
+	}
+	params, err := getChainParamsInTx(tx)
+	if err != nil {
+		return 0, err
+	}
+	if params.Consensus == consensus.POA || !consensus.IsRetargetHeight(parentHeight+1) {
+		return parent.Bits, nil
+	}
+	first := parent
+	for i := int64(1); i < chainparams.Active.RetargetInterval; i++ {
+		first, err = getHeaderInTx(tx, first.PreHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return consensus.CalculateNextBits(parent.Bits, first.Timestamp, parent.Timestamp), nil
+}
+
+/**
+ * 检查区块的难度目标是否符合调整规则，创世区块使用链参数对应的初始难度
+ */
+func checkBlockBits(tx *bolt.Tx, block Block) error {
+	params, err := getChainParamsInTx(tx)
+	if err != nil {
+		return err
+	}
+	expected := params.InitialBits()
+	if block.Height > 0 {
+		expected, err = nextBitsInTx(tx, block.PreHash, block.Height-1)
+		if err != nil {
+			return err
+		}
+	}
+	if block.Bits != expected {
+		return fmt.Errorf("区块的难度目标为%08x，应为%08x", block.Bits, expected)
+	}
+	return nil
+}
+
+/**
+ * 从hash对应的区块开始沿区块头向前，取最多MEDIANTIMESPAN个区块时间戳的中位数。
+ * 矿工可以随意填写时间戳，用中位数作为下限，单个区块无法把时间往回拨
+ */
+func medianTimePastInTx(tx *bolt.Tx, hash [32]byte) (int64, error) {
+	header, err := getHeaderInTx(tx, hash)
+	if err != nil {
+		return 0, err
+	}
+	timestamps := []int64{header.Timestamp}
+	for i := int64(1); i < MEDIANTIMESPAN && header.PreHash != [32]byte{}; i++ {
+		header, err = getHeaderInTx(tx, header.PreHash)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, header.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+/**
+ * 检查区块的时间戳：必须大于前MEDIANTIMESPAN个区块时间戳的中位数，且不能超前当前时间MAXFUTUREBLOCKTIME秒以上。
+ * 难度调整按实际的时间戳计算，不检查时矿工可以把时间戳往后推，每个周期都把难度降到调整的下限
+ */
+func checkBlockTime(tx *bolt.Tx, block Block) error {
+	if block.Height == 0 {
+		return nil
+	}
+	median, err := medianTimePastInTx(tx, block.PreHash)
+	if err != nil {
+		return err
+	}
+	if block.Timestamp <= median {
+		return fmt.Errorf("区块的时间戳%d必须大于前%d个区块时间戳的中位数%d", block.Timestamp, MEDIANTIMESPAN, median)
+	}
+	if block.Timestamp > time.Now().Unix()+MAXFUTUREBLOCKTIME {
+		return fmt.Errorf("区块的时间戳%d超前当前时间%d秒以上", block.Timestamp, MAXFUTUREBLOCKTIME)
+	}
+	return nil
+}
+
+/**
+ * 主链最新区块及之前共MEDIANTIMESPAN个区块时间戳的中位数，下一个区块的时间戳必须大于该值
+ */
+func (chain *BlockChain) medianTimePast() (int64, error) {
+	var median int64
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		median, err = medianTimePastInTx(tx, chain.LastBlock.Hash)
+		return err
+	})
+	return median, err
+}
+
+/**
+ * 主链最新区块之后的下一个区块可以使用的时间戳：取当前时间，不大于中位数时取中位数加一
+ */
+func (chain *BlockChain) nextBlockTime() (int64, error) {
+	median, err := chain.medianTimePast()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	if now <= median {
+		return median + 1, nil
+	}
+	return now, nil
+}
+
+/**
+ * 计算主链最新区块之后的下一个区块应使用的难度目标
+ */
+func (chain *BlockChain) GetNextBits() (uint32, error) {
+	var bits uint32
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		bits, err = nextBitsInTx(tx, chain.LastBlock.Hash, chain.LastBlock.Height)
+		return err
+	})
+	return bits, err
+}
+
+/**
+ * 查询主链最新区块的难度，以及下一个区块应使用的难度目标
+ */
+func (chain *BlockChain) GetDifficulty() (*DifficultyInfo, error) {
+	lastBlock := chain.LastBlock
+	if lastBlock.Hash == [32]byte{} {
+		return nil, errors.New("还没有区块")
+	}
+	nextBits, err := chain.GetNextBits()
+	if err != nil {
+		return nil, err
+	}
+	var remaining int64
+	if interval := chainparams.Active.RetargetInterval; !chainparams.Active.NoRetargeting {
+		remaining = (lastBlock.Height/interval+1)*interval - lastBlock.Height
+	}
+	return &DifficultyInfo{
+		Height:     lastBlock.Height,
+		Bits:       lastBlock.Bits,
+		Difficulty: consensus.GetDifficulty(lastBlock.Bits),
+		NextBits:   nextBits,
+		Remaining:  remaining,
+	}, nil
+}
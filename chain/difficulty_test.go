@@ -0,0 +1,183 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 按指定的难度目标和时间戳在parent之后挖出一个区块，只生成区块，不写入db
+func mineTestBlockAt(t *testing.T, parent Block, bits uint32, timestamp int64, address string, txs ...transaction.Transaction) Block {
+	t.Helper()
+	coinbase, err := transaction.NewCoinbaseTx(address, consensus.BlockSubsidy(parent.Height+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := newBlock(parent.Height, parent.Hash, bits, timestamp, append([]transaction.Transaction{*coinbase}, txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mineBlock(context.Background(), block, func(block Block) consensus.Consensus {
+		return consensus.NewProofWork(block, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *block
+}
+
+// 在主链最新区块之后按timestamps依次挖出并连接区块
+func connectTestBlocks(t *testing.T, chain *BlockChain, address string, timestamps ...int64) {
+	t.Helper()
+	for _, timestamp := range timestamps {
+		block := mineTestBlockAt(t, chain.LastBlock, chain.LastBlock.Bits, timestamp, address)
+		err := chain.ConnectBlock(block)
+		if err != nil {
+			t.Fatalf("连接区块%d出错：%v", block.Height, err)
+		}
+	}
+}
+
+func testMedianTimePast(t *testing.T, chain *BlockChain) int64 {
+	t.Helper()
+	median, err := chain.medianTimePast()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return median
+}
+
+// 不足MEDIANTIMESPAN个区块时取已有区块的中位数，之后只取最近的MEDIANTIMESPAN个
+func TestMedianTimePast(t *testing.T) {
+	chain, address := newTestChain(t)
+	genesis := chain.LastBlock.Timestamp
+	if median := testMedianTimePast(t, chain); median != genesis {
+		t.Errorf("只有创世区块时中位数为%d，应为%d", median, genesis)
+	}
+
+	// 时间戳可以早于父区块，只要大于中位数；个数为偶数时取较大的一个
+	connectTestBlocks(t, chain, address, genesis+20, genesis+30, genesis+25)
+	if median := testMedianTimePast(t, chain); median != genesis+25 {
+		t.Errorf("中位数为%d，应为%d", median, genesis+25)
+	}
+
+	var timestamps []int64
+	for i := int64(1); i <= 12; i++ {
+		timestamps = append(timestamps, genesis+30+i)
+	}
+	connectTestBlocks(t, chain, address, timestamps...)
+	// 最近的11个区块时间戳为genesis+32到genesis+42
+	if median := testMedianTimePast(t, chain); median != genesis+37 {
+		t.Errorf("中位数为%d，应为%d", median, genesis+37)
+	}
+}
+
+func TestCheckBlockTime(t *testing.T) {
+	chain, address := newTestChain(t)
+	genesis := chain.LastBlock.Timestamp
+	connectTestBlocks(t, chain, address, genesis+1, genesis+2, genesis+3, genesis+4)
+	median := testMedianTimePast(t, chain)
+	now := time.Now().Unix()
+	cases := []struct {
+		name      string
+		timestamp int64
+		valid     bool
+	}{
+		{"早于中位数", median - 1, false},
+		{"等于中位数", median, false},
+		{"中位数加一", median + 1, true},
+		{"早于父区块但大于中位数", chain.LastBlock.Timestamp - 1, true},
+		{"当前时间", now, true},
+		{"超前当前时间不到2小时", now + MAXFUTUREBLOCKTIME - 60, true},
+		{"超前当前时间2小时以上", now + MAXFUTUREBLOCKTIME + 60, false},
+	}
+	for _, c := range cases {
+		block := Block{Height: chain.LastBlock.Height + 1}
+		block.PreHash = chain.LastBlock.Hash
+		block.Timestamp = c.timestamp
+		err := chain.DB.View(func(tx *bolt.Tx) error {
+			return checkBlockTime(tx, block)
+		})
+		if (err == nil) != c.valid {
+			t.Errorf("%s：检查结果为%v，应为%v", c.name, err, c.valid)
+		}
+	}
+}
+
+// 时间戳不大于中位数的区块不能连接到主链，主链保持不变
+func TestConnectBlockRejectsOldTimestamp(t *testing.T) {
+	chain, address := newTestChain(t)
+	genesis := chain.LastBlock.Timestamp
+	connectTestBlocks(t, chain, address, genesis+1, genesis+2)
+	tip := chain.LastBlock
+	block := mineTestBlockAt(t, tip, tip.Bits, testMedianTimePast(t, chain), address)
+	if err := chain.ConnectBlock(block); err == nil {
+		t.Fatal("时间戳等于中位数的区块应被拒绝")
+	}
+	if chain.LastBlock.Hash != tip.Hash {
+		t.Error("被拒绝的区块成为了主链的最新区块")
+	}
+}
+
+// 最近的区块时间戳超前于当前时间时，下一个区块的时间戳取中位数加一
+func TestNextBlockTime(t *testing.T) {
+	chain, address := newTestChain(t)
+	before := time.Now().Unix()
+	next, err := chain.nextBlockTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next < before || next <= testMedianTimePast(t, chain) {
+		t.Errorf("下一个区块的时间戳%d应不早于当前时间且大于中位数", next)
+	}
+
+	future := time.Now().Unix() + MAXFUTUREBLOCKTIME/2
+	connectTestBlocks(t, chain, address, future, future+1, future+2)
+	next, err = chain.nextBlockTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if median := testMedianTimePast(t, chain); next != median+1 {
+		t.Errorf("下一个区块的时间戳为%d，应为中位数加一%d", next, median+1)
+	}
+}
+
+// 在调整高度按上一周期实际花费的时间重新计算难度目标，沿用旧难度目标的区块被拒绝
+func TestCheckBlockBitsAtRetarget(t *testing.T) {
+	chain, address := newTestChain(t)
+	chainparams.Active.NoRetargeting = false
+	t.Cleanup(func() { chainparams.Active.NoRetargeting = true })
+
+	genesis := chain.LastBlock
+	var timestamps []int64
+	for i := int64(1); i < chainparams.Active.RetargetInterval; i++ {
+		timestamps = append(timestamps, genesis.Timestamp+i)
+	}
+	connectTestBlocks(t, chain, address, timestamps...)
+
+	tip := chain.LastBlock
+	expected := consensus.CalculateNextBits(tip.Bits, genesis.Timestamp, tip.Timestamp)
+	if expected == tip.Bits {
+		t.Fatal("出块过快时难度目标应调整")
+	}
+	bits, err := chain.GetNextBits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bits != expected {
+		t.Fatalf("下一个区块的难度目标为%08x，应为%08x", bits, expected)
+	}
+	stale := mineTestBlockAt(t, tip, tip.Bits, tip.Timestamp+1, address)
+	if err := chain.ConnectBlock(stale); err == nil {
+		t.Error("沿用旧难度目标的区块应被拒绝")
+	}
+	retarget := mineTestBlockAt(t, tip, expected, tip.Timestamp+1, address)
+	if err := chain.ConnectBlock(retarget); err != nil {
+		t.Errorf("使用新难度目标的区块应被接受：%v", err)
+	}
+}
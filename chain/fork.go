@@ -0,0 +1,472 @@
+package chain
+
+import (
+	"PublicChain/mempool"
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"PublicChain/utxoset"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+/**
+ * 区块连接到主链时验证失败，记录失败的区块，以便将其标记为无效
+ */
+type badBlockError struct {
+	Hash [32]byte
+	Err  error
+}
+
+func (err *badBlockError) Error() string {
+	return fmt.Sprintf("区块%x验证失败：%s", err.Hash, err.Err.Error())
+}
+
+/**
+ * 接收一个已经挖好的区块(可能在分叉上)：校验后保存，然后切换到累计工作量最大的链
+ */
+func (chain *BlockChain) AcceptBlock(block Block) error {
+	err := checkBlock(chain.Params, block)
+	if err != nil {
+		return err
+	}
+	err = chain.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := getBlockIndex(tx, block.Hash); err == nil {
+			return errors.New("区块已存在")
+		}
+		parent, err := getBlockIndex(tx, block.PreHash)
+		if err != nil {
+			return errors.New("未找到父区块")
+		}
+		if parent.Status == BLOCKINVALID {
+			return errors.New("父区块无效")
+		}
+		if block.Height != parent.Height+1 {
+			return errors.New("区块高度有误")
+		}
+		err = checkBlockBits(tx, block)
+		if err != nil {
+			return err
+		}
+		err = checkBlockTime(tx, block)
+		if err != nil {
+			return err
+		}
+		_, err = storeBlock(tx, block)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return chain.ActivateBestChain()
+}
+
+/**
+ * 在所有有效的区块中选出累计工作量最大的一个，如果比当前主链大，则切换主链。
+ * 切换过程中验证失败的区块会被标记为无效，然后重新选择
+ */
+func (chain *BlockChain) ActivateBestChain() error {
+	for {
+		err := chain.updateCoins(func(tx *bolt.Tx) error {
+			best, err := findBestTip(tx, chain.LastBlock.Hash)
+			if err != nil {
+				return err
+			}
+			if best.Hash == chain.LastBlock.Hash {
+				return nil
+			}
+			return reorganize(tx, chain.UTXOSet.Cache, *best)
+		})
+		badBlock, isBad := err.(*badBlockError)
+		if !isBad {
+			if err != nil {
+				return err
+			}
+			return chain.loadLastBlock()
+		}
+		fmt.Println(badBlock.Error())
+		err = chain.DB.Update(func(tx *bolt.Tx) error {
+			index, err := getBlockIndex(tx, badBlock.Hash)
+			if err != nil {
+				return err
+			}
+			index.Status = BLOCKINVALID
+			return putBlockIndex(tx, *index)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/**
+ * 不依赖链上状态的区块检查：出块证明、默克尔根和coinbase交易的结构
+ */
+func checkBlock(params ChainParams, block Block) error {
+	err := checkBlockProof(params, block)
+	if err != nil {
+		return err
+	}
+	if len(block.Txs) > 0 {
+		root, err := calculateMerkleRoot(block.Txs)
+		if err != nil {
+			return err
+		}
+		if root != block.MerkleRoot {
+			return errors.New("区块的默克尔根有误")
+		}
+	}
+	return checkBlockCoinbase(block)
+}
+
+/**
+ * 区块必须以coinbase交易开头，只能有一笔coinbase交易，coinbase交易的金额必须为正数且不超过utils.MAXMONEY。
+ * coinbase交易的金额不超过出块奖励加手续费，在连接到主链时检查
+ */
+func checkBlockCoinbase(block Block) error {
+	if len(block.Txs) == 0 || !block.Txs[0].IsCoinbaseTranaction() {
+		return errors.New("区块的第一笔交易必须是coinbase交易")
+	}
+	for _, transac := range block.Txs[1:] {
+		if transac.IsCoinbaseTranaction() {
+			return errors.New("区块中存在多笔coinbase交易")
+		}
+	}
+	_, err := block.Txs[0].OutputAmount()
+	return err
+}
+
+/**
+ * 将一个新区块连接到主链末端：校验区块后，在同一个事务中保存区块、验证交易签名、
+ * 更新utxo集合、撤销数据和各项索引。事务提交成功之后才更新内存中的最新区块，
+ * 任何一步失败都不会在db中留下只写了一半的数据
+ */
+func (chain *BlockChain) ConnectBlock(block Block) error {
+	err := checkBlock(chain.Params, block)
+	if err != nil {
+		return err
+	}
+	err = chain.updateCoins(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(BUCKERNAME))
+		if bucket == nil {
+			return errors.New("区块数据区操作失败")
+		}
+		if !bytes.Equal(bucket.Get([]byte(LASTHASH)), block.PreHash[:]) {
+			return errors.New("区块的父区块不是主链的最新区块")
+		}
+		parent, err := getBlockIndex(tx, block.PreHash)
+		if err != nil {
+			return err
+		}
+		if block.Height != parent.Height+1 {
+			return errors.New("区块高度有误")
+		}
+		err = checkBlockBits(tx, block)
+		if err != nil {
+			return err
+		}
+		err = checkBlockTime(tx, block)
+		if err != nil {
+			return err
+		}
+		_, err = storeBlock(tx, block)
+		if err != nil {
+			return err
+		}
+		return connectBlock(tx, chain.UTXOSet.Cache, block)
+	})
+	if err != nil {
+		return err
+	}
+	chain.LastBlock = block
+	chain.IteratorBloockHash = block.Hash
+	return nil
+}
+
+/**
+ * 找出累计工作量最大、且分支上没有无效区块的区块；工作量相同时保留当前主链
+ */
+func findBestTip(tx *bolt.Tx, tipHash [32]byte) (*BlockIndex, error) {
+	best, err := getBlockIndex(tx, tipHash)
+	if err != nil {
+		return nil, err
+	}
+	bucket := tx.Bucket([]byte(BLOCKINDEX))
+	candidates := make([]BlockIndex, 0)
+	err = bucket.ForEach(func(key, value []byte) error {
+		var index BlockIndex
+		_, err := utils.GodDecode(value, &index)
+		if err != nil {
+			return err
+		}
+		if index.Status == BLOCKVALID && index.GetChainWork().Cmp(best.GetChainWork()) > 0 {
+			candidates = append(candidates, index)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if candidate.GetChainWork().Cmp(best.GetChainWork()) <= 0 {
+			continue
+		}
+		_, valid, err := getBranch(tx, candidate.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if valid {
+			best = &BlockIndex{}
+			*best = candidate
+		}
+	}
+	return best, nil
+}
+
+/**
+ * 将主链切换到newTip所在的分支：先从当前最新区块断开到分叉点，再依次连接分支上的区块
+ */
+func reorganize(tx *bolt.Tx, coins *utxoset.CoinsCache, newTip BlockIndex) error {
+	branch, _, err := getBranch(tx, newTip.Hash)
+	if err != nil {
+		return err
+	}
+	forkHeight := branch[len(branch)-1].Height - 1
+
+	blocksBucket := tx.Bucket([]byte(BUCKERNAME))
+	for {
+		lastHash := blocksBucket.Get([]byte(LASTHASH))
+		var hash [32]byte
+		copy(hash[:], lastHash)
+		block, err := getBlockInTx(tx, hash)
+		if err != nil {
+			return err
+		}
+		if block.Height <= forkHeight {
+			break
+		}
+		err = disconnectBlock(tx, coins, block)
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		block, err := getBlockInTx(tx, branch[i].Hash)
+		if err != nil {
+			return err
+		}
+		err = connectBlock(tx, coins, block)
+		if err != nil {
+			return &badBlockError{Hash: block.Hash, Err: err}
+		}
+	}
+	return nil
+}
+
+/**
+ * 将区块连接到主链末端：检查出块者的资格，验证并更新utxo集合和验证者集合，保存撤销数据，更新各项索引和最新区块标记。
+ * utxo的改动先记录在缓存中，区块处理完之后缓存超过内存上限时再写回db
+ */
+func connectBlock(tx *bolt.Tx, coins *utxoset.CoinsCache, block Block) error {
+	params, err := getChainParamsInTx(tx)
+	if err != nil {
+		return err
+	}
+	err = checkBlockProducer(tx, coins, params, block)
+	if err != nil {
+		return err
+	}
+	undo, err := connectBlockUTXO(tx, coins, block)
+	if err != nil {
+		return err
+	}
+	err = connectGovernanceInTx(tx, params, block)
+	if err != nil {
+		return err
+	}
+	err = putBlockUndo(tx, block.Hash, *undo)
+	if err != nil {
+		return err
+	}
+	err = indexBlock(tx, block, *undo)
+	if err != nil {
+		return err
+	}
+	//区块中的交易已打包，从交易池中删除，与之冲突的交易也一并删除
+	err = mempool.RemoveBlockTxsInTx(tx, block.Txs)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket([]byte(BUCKERNAME)).Put([]byte(LASTHASH), block.Hash[:])
+	if err != nil {
+		return err
+	}
+	coins.SetBestBlock(block.Hash)
+	return coins.FlushIfNeeded(tx)
+}
+
+/**
+ * 将主链的最新区块断开：依据撤销数据还原utxo集合，撤销治理交易，删除各项索引，最新区块标记回退到父区块
+ */
+func disconnectBlock(tx *bolt.Tx, coins *utxoset.CoinsCache, block Block) error {
+	err := disconnectBlockUTXO(tx, coins, block)
+	if err != nil {
+		return err
+	}
+	err = disconnectGovernanceInTx(tx, block)
+	if err != nil {
+		return err
+	}
+	err = deleteBlockUndo(tx, block.Hash)
+	if err != nil {
+		return err
+	}
+	err = unindexBlock(tx, block)
+	if err != nil {
+		return err
+	}
+	//区块中的交易放回交易池，等待重新打包，无法放回的交易直接丢弃
+	for _, transac := range block.Txs {
+		if transac.IsCoinbaseTranaction() {
+			continue
+		}
+		_ = mempool.AcceptTxInTx(tx, coins, transac, block.Height-1)
+	}
+	err = tx.Bucket([]byte(BUCKERNAME)).Put([]byte(LASTHASH), block.PreHash[:])
+	if err != nil {
+		return err
+	}
+	coins.SetBestBlock(block.PreHash)
+	return coins.FlushIfNeeded(tx)
+}
+
+/**
+ * 依次处理区块中的每一笔交易：coinbase交易只能排在第一位且金额为正数；其他交易验证其花费的utxo存在、签名正确，输出金额为正数且之和不大于输入金额，
+ * 各项金额及其和都不超过utils.MAXMONEY，然后删除花费的utxo，加入新产生的utxo。
+ * 输入金额减去输出金额即交易的手续费，coinbase交易的金额不能超过出块奖励加上区块中所有交易的手续费。返回区块的撤销数据
+ */
+func connectBlockUTXO(tx *bolt.Tx, coins *utxoset.CoinsCache, block Block) (*BlockUndo, error) {
+	undo := BlockUndo{SpentUTXOs: make([]SpentUTXO, 0)}
+	var claimed, fees int64
+	for position, transac := range block.Txs {
+		spents := make([]SpentUTXO, 0)
+		if transac.IsCoinbaseTranaction() {
+			if position != 0 {
+				return nil, errors.New("coinbase交易必须是区块中的第一笔交易")
+			}
+			amount, err := transac.OutputAmount()
+			if err != nil {
+				return nil, err
+			}
+			claimed = amount
+		} else {
+			spentUTXOs := make([]transaction.UTXO, 0)
+			for _, input := range transac.Inputs {
+				spent, err := lookupSpentUTXO(tx, coins, block.Txs[:position], input)
+				if err != nil {
+					return nil, err
+				}
+				spents = append(spents, *spent)
+				spentUTXOs = append(spentUTXOs, spent.UTXO)
+			}
+			fee, err := txFee(transac, spentUTXOs)
+			if err != nil {
+				return nil, err
+			}
+			fees, err = addFee(fees, fee)
+			if err != nil {
+				return nil, err
+			}
+			verify, err := transac.VertifySign(spentUTXOs)
+			if err != nil {
+				return nil, err
+			}
+			if !verify {
+				return nil, fmt.Errorf("交易%x签名验证失败", transac.TxHash)
+			}
+		}
+		err := applyTxUTXO(tx, coins, transac, spents)
+		if err != nil {
+			return nil, err
+		}
+		undo.SpentUTXOs = append(undo.SpentUTXOs, spents...)
+	}
+	err := checkCoinbaseValue(block.Height, claimed, fees)
+	if err != nil {
+		return nil, err
+	}
+	return &undo, nil
+}
+
+/**
+ * 把一笔交易的结果写入utxo集合：删除交易花费掉的utxo，加入交易产生的utxo
+ */
+func applyTxUTXO(tx *bolt.Tx, coins *utxoset.CoinsCache, transac transaction.Transaction, spents []SpentUTXO) error {
+	for _, spent := range spents {
+		_, err := coins.SpendUTXO(tx, spent.UTXO.TxId, spent.UTXO.Vout)
+		if err != nil {
+			return err
+		}
+	}
+	for index, output := range transac.Outputs {
+		err := coins.AddUTXO(transaction.NewUTXO(transac.TxHash, index, output))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 倒序处理区块中的交易：删除交易产生的utxo，并依据撤销数据把交易花费的utxo加回去
+ */
+func disconnectBlockUTXO(tx *bolt.Tx, coins *utxoset.CoinsCache, block Block) error {
+	undo, err := requireBlockUndo(tx, block)
+	if err != nil {
+		return err
+	}
+	spents := undo.SpentUTXOs
+	for i := len(block.Txs) - 1; i >= 0; i-- {
+		transac := block.Txs[i]
+		for index := range transac.Outputs {
+			_, err := coins.SpendUTXO(tx, transac.TxHash, index)
+			if err != nil {
+				return err
+			}
+		}
+		// 撤销数据按花费顺序排列，倒序取出本交易花费的utxo
+		if len(spents) < len(transac.Inputs) {
+			return errors.New("区块的撤销数据不完整")
+		}
+		for _, spent := range spents[len(spents)-len(transac.Inputs):] {
+			err := coins.AddUTXO(spent.UTXO)
+			if err != nil {
+				return err
+			}
+		}
+		spents = spents[:len(spents)-len(transac.Inputs)]
+	}
+	return nil
+}
+
+/**
+ * 从db中重新读取最新区块，更新内存中的状态
+ */
+func (chain *BlockChain) loadLastBlock() error {
+	return chain.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(BUCKERNAME))
+		if bucket == nil {
+			return errors.New("区块数据区操作失败")
+		}
+		var hash [32]byte
+		copy(hash[:], bucket.Get([]byte(LASTHASH)))
+		block, err := getBlockInTx(tx, hash)
+		if err != nil {
+			return err
+		}
+		chain.LastBlock = block
+		chain.IteratorBloockHash = block.Hash
+		return nil
+	})
+}
@@ -0,0 +1,333 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+/**
+ * 在临时目录中创建一条回归测试网的工作量证明链，返回区块链实例和钱包中的一个地址
+ */
+func newTestChain(t *testing.T) (*BlockChain, string) {
+	t.Helper()
+	err := chainparams.Select(chainparams.REGTEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := OpenBlockChain(filepath.Join(t.TempDir(), chainparams.BOLTFILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = chain.Close() })
+	address, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chain.CreateCoinbase(address, DefaultChainParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &chain, address
+}
+
+/**
+ * 在parent之后挖出一个区块：coinbase交易把出块奖励给address，时间戳比父区块晚1秒。
+ * 只生成区块，不写入db
+ */
+func mineTestBlock(t *testing.T, parent Block, address string, txs ...transaction.Transaction) Block {
+	t.Helper()
+	coinbase, err := transaction.NewCoinbaseTx(address, consensus.BlockSubsidy(parent.Height+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := newBlock(parent.Height, parent.Hash, parent.Bits, parent.Timestamp+1, append([]transaction.Transaction{*coinbase}, txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mineBlock(context.Background(), block, func(block Block) consensus.Consensus {
+		return consensus.NewProofWork(block, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *block
+}
+
+// 用钱包中address的私钥花费utxos，转给to
+func spendTestUTXOs(t *testing.T, chain *BlockChain, utxos []transaction.UTXO, address string, to string, value int64) transaction.Transaction {
+	t.Helper()
+	tx, err := newSignedTransaction(utxos, address, to, value, 0, chain.Wallet.GetKeyPairByAddress(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *tx
+}
+
+// 该outpoint是否在utxo集合(包括缓存中还未写回的改动)中
+func hasUTXO(t *testing.T, chain *BlockChain, txid [32]byte, vout int) bool {
+	t.Helper()
+	var found bool
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		utxo, err := chain.UTXOSet.Cache.GetUTXO(tx, txid, vout)
+		found = utxo != nil
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+// 地址所有utxo的outpoint，排序后便于比较
+func utxoKeys(t *testing.T, chain *BlockChain, address string) []string {
+	t.Helper()
+	utxos, balance := chain.GetUtxoWithBalance(address, nil)
+	if balance < 0 {
+		t.Fatal("查询utxo失败")
+	}
+	keys := make([]string, 0, len(utxos))
+	for _, utxo := range utxos {
+		keys = append(keys, testOutpoint(utxo.TxId, utxo.Vout))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func testOutpoint(txid [32]byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txid, vout)
+}
+
+func equalKeys(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func acceptTestBlocks(t *testing.T, chain *BlockChain, blocks ...Block) {
+	t.Helper()
+	for _, block := range blocks {
+		err := chain.AcceptBlock(block)
+		if err != nil {
+			t.Fatalf("接收区块%d出错：%v", block.Height, err)
+		}
+	}
+}
+
+// 分叉的累计工作量超过主链时切换过去，再次超过时切换回来，utxo集合随主链变化
+func TestReorganizeToMostWork(t *testing.T) {
+	chain, address := newTestChain(t)
+	genesis := chain.LastBlock
+
+	a1 := mineTestBlock(t, genesis, address)
+	acceptTestBlocks(t, chain, a1)
+	b1 := mineTestBlock(t, genesis, address)
+	acceptTestBlocks(t, chain, b1)
+	if chain.LastBlock.Hash != a1.Hash {
+		t.Fatal("工作量相同时应保留当前主链")
+	}
+
+	b2 := mineTestBlock(t, b1, address)
+	acceptTestBlocks(t, chain, b2)
+	if chain.LastBlock.Hash != b2.Hash {
+		t.Fatal("分叉的工作量更大时应切换主链")
+	}
+	if hasUTXO(t, chain, a1.Txs[0].TxHash, 0) {
+		t.Error("断开的区块中coinbase交易的utxo应被删除")
+	}
+	for _, block := range []Block{b1, b2} {
+		if !hasUTXO(t, chain, block.Txs[0].TxHash, 0) {
+			t.Errorf("新主链上区块%d的coinbase交易的utxo不存在", block.Height)
+		}
+	}
+
+	a2 := mineTestBlock(t, a1, address)
+	a3 := mineTestBlock(t, a2, address)
+	acceptTestBlocks(t, chain, a2, a3)
+	if chain.LastBlock.Hash != a3.Hash {
+		t.Fatal("原来的链工作量更大时应切换回来")
+	}
+	for _, block := range []Block{b1, b2} {
+		if hasUTXO(t, chain, block.Txs[0].TxHash, 0) {
+			t.Errorf("断开的区块%d中coinbase交易的utxo应被删除", block.Height)
+		}
+	}
+	for _, block := range []Block{a1, a2, a3} {
+		if !hasUTXO(t, chain, block.Txs[0].TxHash, 0) {
+			t.Errorf("主链上区块%d的coinbase交易的utxo不存在", block.Height)
+		}
+	}
+	hash, err := chain.GetBlockHashByHeight(1)
+	if err != nil || hash != a1.Hash {
+		t.Errorf("高度1的区块hash为%x，应为%x", hash, a1.Hash)
+	}
+}
+
+// 断开花费了utxo的区块时，依据撤销数据把花费掉的utxo加回来，重新连接后再次花费
+func TestReorganizeRestoresSpentUTXOs(t *testing.T) {
+	chain, address := newTestChain(t)
+	other, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := chain.LastBlock
+
+	a1 := mineTestBlock(t, genesis, address)
+	acceptTestBlocks(t, chain, a1)
+	coinbase := transaction.NewUTXO(a1.Txs[0].TxHash, 0, a1.Txs[0].Outputs[0])
+	spend := spendTestUTXOs(t, chain, []transaction.UTXO{coinbase}, address, other, coinbase.Value/2)
+	a2 := mineTestBlock(t, a1, address, spend)
+	acceptTestBlocks(t, chain, a2)
+	spentKeys := utxoKeys(t, chain, address)
+	if hasUTXO(t, chain, coinbase.TxId, coinbase.Vout) {
+		t.Fatal("被花费的utxo应从utxo集合中删除")
+	}
+
+	b1 := mineTestBlock(t, genesis, other)
+	b2 := mineTestBlock(t, b1, other)
+	b3 := mineTestBlock(t, b2, other)
+	acceptTestBlocks(t, chain, b1, b2, b3)
+	if chain.LastBlock.Hash != b3.Hash {
+		t.Fatal("分叉的工作量更大时应切换主链")
+	}
+	if hasUTXO(t, chain, coinbase.TxId, coinbase.Vout) {
+		t.Error("区块a1断开后，它的coinbase交易的utxo也应被删除")
+	}
+	if hasUTXO(t, chain, spend.TxHash, 0) || hasUTXO(t, chain, spend.TxHash, 1) {
+		t.Error("断开的区块中交易产生的utxo应被删除")
+	}
+	if keys := utxoKeys(t, chain, address); len(keys) != 0 {
+		t.Errorf("切换主链后address还有%d个utxo", len(keys))
+	}
+
+	a3 := mineTestBlock(t, a2, address)
+	a4 := mineTestBlock(t, a3, address)
+	acceptTestBlocks(t, chain, a3, a4)
+	if chain.LastBlock.Hash != a4.Hash {
+		t.Fatal("原来的链工作量更大时应切换回来")
+	}
+	if hasUTXO(t, chain, coinbase.TxId, coinbase.Vout) {
+		t.Error("重新连接区块a2后，被花费的utxo应再次删除")
+	}
+	expected := append(spentKeys, testOutpoint(a3.Txs[0].TxHash, 0), testOutpoint(a4.Txs[0].TxHash, 0))
+	sort.Strings(expected)
+	if keys := utxoKeys(t, chain, address); !equalKeys(keys, expected) {
+		t.Errorf("切换回来后address的utxo为%v，应为%v", keys, expected)
+	}
+	if !hasUTXO(t, chain, spend.TxHash, 0) {
+		t.Error("重新连接的交易产生的utxo不存在")
+	}
+}
+
+// 连接区块在事务中途失败时，db和utxo缓存都恢复到连接之前的状态，之后仍可以正常连接区块
+func TestRollbackAfterFailedConnectBlock(t *testing.T) {
+	cases := []struct {
+		name  string
+		build func(t *testing.T, chain *BlockChain, parent Block, address string, other string) Block
+	}{
+		{
+			name: "同一区块中重复花费",
+			build: func(t *testing.T, chain *BlockChain, parent Block, address string, other string) Block {
+				coinbase := transaction.NewUTXO(parent.Txs[0].TxHash, 0, parent.Txs[0].Outputs[0])
+				first := spendTestUTXOs(t, chain, []transaction.UTXO{coinbase}, address, other, 1)
+				second := spendTestUTXOs(t, chain, []transaction.UTXO{coinbase}, address, other, 2)
+				return mineTestBlock(t, parent, address, first, second)
+			},
+		},
+		{
+			name: "coinbase交易的金额超过出块奖励",
+			build: func(t *testing.T, chain *BlockChain, parent Block, address string, other string) Block {
+				coinbase, err := transaction.NewCoinbaseTx(address, consensus.BlockSubsidy(parent.Height+1)+1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				block, err := newBlock(parent.Height, parent.Hash, parent.Bits, parent.Timestamp+1, []transaction.Transaction{*coinbase})
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = mineBlock(context.Background(), block, func(block Block) consensus.Consensus {
+					return consensus.NewProofWork(block, 1)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return *block
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chain, address := newTestChain(t)
+			other, err := chain.GetNewAddress()
+			if err != nil {
+				t.Fatal(err)
+			}
+			a1 := mineTestBlock(t, chain.LastBlock, address)
+			err = chain.ConnectBlock(a1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cache := chain.UTXOSet.Cache
+			before := cache.Stats()
+			beforeBest := cache.GetBestBlock()
+			beforeKeys := utxoKeys(t, chain, address)
+			beforeOther := utxoKeys(t, chain, other)
+
+			bad := c.build(t, chain, a1, address, other)
+			err = chain.ConnectBlock(bad)
+			if err == nil {
+				t.Fatal("无效的区块应连接失败")
+			}
+
+			after := cache.Stats()
+			if after.Entries != before.Entries || after.Dirty != before.Dirty || after.Usage != before.Usage {
+				t.Errorf("utxo缓存没有恢复：之前%+v，之后%+v", before, after)
+			}
+			if cache.GetBestBlock() != beforeBest {
+				t.Error("utxo缓存对应的最新区块没有恢复")
+			}
+			if !equalKeys(utxoKeys(t, chain, address), beforeKeys) || !equalKeys(utxoKeys(t, chain, other), beforeOther) {
+				t.Error("utxo集合与连接之前不一致")
+			}
+			if !hasUTXO(t, chain, a1.Txs[0].TxHash, 0) {
+				t.Error("区块a1的coinbase交易的utxo应仍未花费")
+			}
+			if hasUTXO(t, chain, bad.Txs[0].TxHash, 0) {
+				t.Error("无效区块中coinbase交易的utxo不应留在utxo集合中")
+			}
+			if chain.LastBlock.Hash != a1.Hash {
+				t.Error("最新区块应仍为a1")
+			}
+			err = chain.DB.View(func(tx *bolt.Tx) error {
+				_, err := getBlockIndex(tx, bad.Hash)
+				return err
+			})
+			if err == nil {
+				t.Error("无效的区块不应写入db")
+			}
+
+			a2 := mineTestBlock(t, a1, address)
+			err = chain.ConnectBlock(a2)
+			if err != nil {
+				t.Fatalf("失败之后连接有效的区块出错：%v", err)
+			}
+			if !hasUTXO(t, chain, a1.Txs[0].TxHash, 0) || !hasUTXO(t, chain, a2.Txs[0].TxHash, 0) {
+				t.Error("连接有效区块后utxo集合有误")
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/mempool"
+	"PublicChain/transaction"
+	"PublicChain/utxoset"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+/**
+ * 连续挖出blocks个区块，每个区块打包交易池中当前可以打包的交易，出块奖励给矿工地址。
+ * 每个区块用threads个goroutine搜索nonce，ctx取消时停止，已挖出的区块保留。返回挖出的区块hash和挖矿的统计信息
+ */
+func (chain *BlockChain) Generate(ctx context.Context, blocks int, threads int) ([][32]byte, MiningStats, error) {
+	var total MiningStats
+	if blocks <= 0 {
+		return nil, total, errors.New("区块数量必须大于0")
+	}
+	if chain.LastBlock.Hash == [32]byte{} {
+		return nil, total, errors.New("还没有创世区块，请先执行generategenesis")
+	}
+	address := chain.GetCoinbase()
+	if len(address) == 0 {
+		return nil, total, errors.New("未设置coinbase矿工地址，请先设置")
+	}
+	hashes := make([][32]byte, 0)
+	for i := 0; i < blocks; i++ {
+		sumTxs, err := chain.collectBlockTxs(address)
+		if err != nil {
+			return hashes, total, err
+		}
+		stats, err := chain.AddNewBlock(ctx, sumTxs, threads)
+		if stats != nil {
+			total.add(*stats)
+		}
+		if err != nil {
+			return hashes, total, err
+		}
+		hashes = append(hashes, chain.LastBlock.Hash)
+	}
+	return hashes, total, nil
+}
+
+/**
+ * 下一个区块要打包的交易：交易池中当前可以打包的交易，以及给address的coinbase交易。
+ * coinbase交易的金额为按下一个区块的高度计算的出块奖励加上打包的交易的手续费
+ */
+func (chain *BlockChain) collectBlockTxs(address string) ([]transaction.Transaction, error) {
+	txs, fees, err := chain.selectMempoolTxs()
+	if err != nil {
+		return nil, err
+	}
+	coinbase, err := transaction.NewCoinbaseTx(address, consensus.BlockSubsidy(chain.LastBlock.Height+1)+fees)
+	if err != nil {
+		return nil, err
+	}
+	sumTxs := make([]transaction.Transaction, 0)
+	sumTxs = append(sumTxs, *coinbase)
+	sumTxs = append(sumTxs, txs...)
+	return sumTxs, nil
+}
+
+/**
+ * 从交易池中选出可以打包进下一个区块的交易：按进入交易池的顺序，交易花费的utxo必须在utxo集合或者已选出的交易中，
+ * 治理交易必须能作用于已选出的治理交易执行之后的验证者集合。
+ * 主链回退时放回交易池的交易可能排在依赖它的交易之后，所以反复遍历，直到选不出新的交易为止。
+ * 剩下的交易花费的utxo已经不存在(例如花费的是被断开区块的coinbase)，或者治理交易已经失效，从交易池中删除。
+ * 同时返回选出的交易的手续费之和
+ */
+func (chain *BlockChain) selectMempoolTxs() ([]transaction.Transaction, int64, error) {
+	selected := make([]transaction.Transaction, 0)
+	stale := make([][32]byte, 0)
+	var fees int64
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		pending, err := mempool.GetEntriesInTx(tx)
+		if err != nil {
+			return err
+		}
+		validators, err := getValidatorSetInTx(tx)
+		if err != nil {
+			return err
+		}
+		for len(pending) > 0 {
+			remain := make([]mempool.TxEntry, 0)
+			for _, entry := range pending {
+				ok := canSpendInputs(tx, chain.UTXOSet.Cache, selected, entry.Tx)
+				if gov := entry.Tx.Governance; ok && gov != nil {
+					ok = chain.Params.Consensus == consensus.POA && checkGovernance(validators, gov) == nil
+					if ok {
+						applyGovernance(validators, gov)
+					}
+				}
+				if ok {
+					selected = append(selected, entry.Tx)
+				} else {
+					remain = append(remain, entry)
+				}
+			}
+			if len(remain) == len(pending) {
+				for _, entry := range remain {
+					stale = append(stale, entry.Tx.TxHash)
+				}
+				break
+			}
+			pending = remain
+		}
+		fees, err = sumTxFeesInTx(tx, chain.UTXOSet.Cache, selected)
+		return err
+	})
+	if err != nil || len(stale) == 0 {
+		return selected, fees, err
+	}
+	err = chain.DB.Update(func(tx *bolt.Tx) error {
+		for _, txid := range stale {
+			fmt.Printf("交易%x已无法打包，从交易池中删除\n", txid)
+			err := mempool.RemoveTxInTx(tx, txid)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return selected, fees, err
+}
+
+/**
+ * 判断交易的每一个输入花费的utxo是否都能在utxo集合或者已选出的交易中找到
+ */
+func canSpendInputs(tx *bolt.Tx, coins *utxoset.CoinsCache, selected []transaction.Transaction, transac transaction.Transaction) bool {
+	for _, input := range transac.Inputs {
+		_, err := lookupSpentUTXO(tx, coins, selected, input)
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
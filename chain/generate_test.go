@@ -0,0 +1,91 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"context"
+	"testing"
+)
+
+// 挖出的区块打包交易池中的交易(包括依赖池中交易的交易)，coinbase交易领取出块奖励加上所有交易的手续费
+func TestGenerateCollectsFees(t *testing.T) {
+	chain, address := newTestChain(t)
+	to, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := mineTestBlock(t, chain.LastBlock, address)
+	acceptTestBlocks(t, chain, a1)
+	coinbase := transaction.NewUTXO(a1.Txs[0].TxHash, 0, a1.Txs[0].Outputs[0])
+	parent, err := newSignedTransaction([]transaction.UTXO{coinbase}, address, to, 10*utils.COIN, 3*utils.COIN, chain.Wallet.GetKeyPairByAddress(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := transaction.NewUTXO(parent.TxHash, 0, parent.Outputs[0])
+	child, err := newSignedTransaction([]transaction.UTXO{output}, to, address, 8*utils.COIN, 2*utils.COIN, chain.Wallet.GetKeyPairByAddress(to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = chain.Mempool.AcceptTxs([]transaction.Transaction{*parent, *child}, chain.LastBlock.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes, _, err := chain.Generate(context.Background(), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 || chain.LastBlock.Hash != hashes[0] {
+		t.Fatalf("挖出了%d个区块", len(hashes))
+	}
+	block := chain.LastBlock
+	if len(block.Txs) != 3 || block.Txs[1].TxHash != parent.TxHash || block.Txs[2].TxHash != child.TxHash {
+		t.Fatalf("区块中有%d笔交易", len(block.Txs))
+	}
+	expected := consensus.BlockSubsidy(2) + 5*utils.COIN
+	if value, err := block.Txs[0].OutputAmount(); err != nil || value != expected {
+		t.Errorf("coinbase交易的金额为%d，应为%d", value, expected)
+	}
+	if txids := poolTestTxids(t, chain); len(txids) != 0 {
+		t.Errorf("打包后交易池中还有%d笔交易", len(txids))
+	}
+}
+
+// 花费的utxo已不存在的交易无法打包，从交易池中删除，也不计入手续费
+func TestSelectMempoolTxsDropsStale(t *testing.T) {
+	chain, address := newTestChain(t)
+	to, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	extendTestChain(t, chain, address, 1)
+	a2 := mineTestBlock(t, chain.LastBlock, address)
+	acceptTestBlocks(t, chain, a2)
+	coinbase := transaction.NewUTXO(a2.Txs[0].TxHash, 0, a2.Txs[0].Outputs[0])
+	spend, err := newSignedTransaction([]transaction.UTXO{coinbase}, address, to, 10*utils.COIN, utils.COIN, chain.Wallet.GetKeyPairByAddress(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = chain.Mempool.AcceptTxs([]transaction.Transaction{*spend}, chain.LastBlock.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, fees, err := chain.selectMempoolTxs()
+	if err != nil || len(txs) != 1 || fees != utils.COIN {
+		t.Fatalf("选出%d笔交易，手续费%d：%v", len(txs), fees, err)
+	}
+
+	// 断开a2之后它的coinbase输出不再存在
+	err = chain.InvalidateBlock(a2.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, fees, err = chain.selectMempoolTxs()
+	if err != nil || len(txs) != 0 || fees != 0 {
+		t.Fatalf("选出%d笔交易，手续费%d：%v", len(txs), fees, err)
+	}
+	if txids := poolTestTxids(t, chain); len(txids) != 0 {
+		t.Errorf("无法打包的交易应从交易池中删除，还剩%d笔", len(txids))
+	}
+}
@@ -0,0 +1,119 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/utils"
+	"errors"
+	"github.com/boltdb/bolt"
+)
+
+// 区块高度索引：高度 -> 区块hash
+const HEIGHTINDEX = "heightindex"
+
+/**
+ * 将区块的高度和hash写入高度索引中，高度使用大端序8字节，保证key按高度有序
+ */
+func putHeightIndex(bucket *bolt.Bucket, height int64, hash [32]byte) error {
+	heightBytes, err := utils.IntToByte(height)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(heightBytes, hash[:])
+}
+
+/**
+ * 从最新区块沿着PreHash向前遍历一次，为已有的db文件补建高度索引
+ */
+func buildHeightIndex(tx *bolt.Tx, lastBlock Block) error {
+	indexBucket := tx.Bucket([]byte(HEIGHTINDEX))
+	if indexBucket == nil {
+		return errors.New("区块数据区操作失败")
+	}
+	current := lastBlock
+	for {
+		err := putHeightIndex(indexBucket, current.Height, current.Hash)
+		if err != nil {
+			return err
+		}
+		if current.Height == 0 {
+			return nil
+		}
+		current, err = getBlockInTx(tx, current.PreHash)
+		if err != nil {
+			return errors.New("区块数据不完整，无法建立高度索引")
+		}
+	}
+}
+
+/**
+ * 根据区块高度，通过高度索引查询区块的hash
+ */
+func (chain *BlockChain) GetBlockHashByHeight(height int64) ([32]byte, error) {
+	var hash [32]byte
+	if height < 0 || height > chain.LastBlock.Height {
+		return hash, errors.New("区块高度超出范围")
+	}
+	heightBytes, err := utils.IntToByte(height)
+	if err != nil {
+		return hash, err
+	}
+	err = chain.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(HEIGHTINDEX))
+		if bucket == nil {
+			return errors.New("区块高度索引不存在")
+		}
+		hashBytes := bucket.Get(heightBytes)
+		if len(hashBytes) != 32 {
+			return errors.New("未找到该高度的区块")
+		}
+		copy(hash[:], hashBytes)
+		return nil
+	})
+	return hash, err
+}
+
+/**
+ * 根据区块hash，直接从db中取出该区块
+ */
+func (chain *BlockChain) GetBlockByHash(hash [32]byte) (Block, error) {
+	var block Block
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		block, err = getBlockInTx(tx, hash)
+		return err
+	})
+	return block, err
+}
+
+/**
+ * 根据区块hash只取出区块头及区块高度，不读取区块中的交易
+ */
+func (chain *BlockChain) GetBlockHeader(hash [32]byte) (*consensus.BlockHeader, int64, error) {
+	var header *consensus.BlockHeader
+	var height int64
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		header, err = getHeaderInTx(tx, hash)
+		if err != nil {
+			return err
+		}
+		index, err := getBlockIndex(tx, hash)
+		if err != nil {
+			return err
+		}
+		height = index.Height
+		return nil
+	})
+	return header, height, err
+}
+
+/**
+ * 根据区块高度取出区块
+ */
+func (chain *BlockChain) GetBlockByHeight(height int64) (Block, error) {
+	hash, err := chain.GetBlockHashByHeight(height)
+	if err != nil {
+		return Block{}, err
+	}
+	return chain.GetBlockByHash(hash)
+}
@@ -0,0 +1,59 @@
+package chain
+
+import (
+	"PublicChain/transaction"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 区块hash只由区块头计算：改动交易不改变区块hash，但默克尔根与交易不一致的区块不能通过检查
+func TestBlockHashCoversHeader(t *testing.T) {
+	chain, address := newTestChain(t)
+	a1 := mineTestBlock(t, chain.LastBlock, address)
+	if a1.Hash != a1.BlockHeader.BlockHash() {
+		t.Fatal("区块hash应为区块头的hash")
+	}
+	other := mineTestBlock(t, chain.LastBlock, address)
+	tampered := a1
+	tampered.Txs = []transaction.Transaction{other.Txs[0]}
+	if tampered.CalculateHash() != a1.Hash {
+		t.Error("只改动交易时区块hash不应变化")
+	}
+	err := chain.ConnectBlock(tampered)
+	if err == nil {
+		t.Fatal("默克尔根与交易不一致的区块不应连接")
+	}
+	err = chain.ConnectBlock(a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 区块头与区块体分开存储，区块体缺失时仍可以只查询区块头
+func TestGetBlockHeader(t *testing.T) {
+	chain, address := newTestChain(t)
+	a1 := mineTestBlock(t, chain.LastBlock, address)
+	acceptTestBlocks(t, chain, a1)
+	err := chain.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BUCKERNAME)).Delete(a1.Hash[:])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, height, err := chain.GetBlockHeader(a1.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *header != a1.BlockHeader || height != 1 {
+		t.Errorf("区块头为%+v，高度%d", header, height)
+	}
+	_, err = chain.GetBlockByHash(a1.Hash)
+	if err == nil {
+		t.Error("区块体缺失时读取完整区块应返回错误")
+	}
+	_, _, err = chain.GetBlockHeader([32]byte{1})
+	if err == nil {
+		t.Error("不存在的区块头应返回错误")
+	}
+}
@@ -0,0 +1,479 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"PublicChain/mempool"
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"PublicChain/utxoset"
+	"PublicChain/wallet"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// db文件的元数据
+const CHAINMETA = "chainmeta"
+
+// db文件格式的版本号
+const DBVERSION = "dbversion"
+
+/*
+db文件格式的版本：
+
+	1.区块和交易使用gob编码，没有记录版本号
+	2.区块和交易使用确定性的二进制格式，交易hash和区块hash基于该格式计算
+	3.区块头与区块体分开存储，区块hash为区块头的两次sha256
+	4.区块的难度目标按实际出块时间定期调整
+	5.金额由float64改为int64的最小单位，交易格式的版本号随之升级，交易hash和签名都发生变化
+	6.带签名的区块(权益证明和权威证明)的区块hash包含出块者公钥，工作量证明的区块不变
+*/
+const CURRENTDBVERSION = 6
+
+/**
+ * 打开db文件后、创建区块链实例之前调用：旧版本的db文件转换为当前的格式。
+ * 转换是一次性的，只在本地进行：交易用本地钱包的私钥重新签名，每个区块重新挖出，
+ * 转换后的链与其他节点上的同一条链不再相同；分叉上的区块和交易池中的交易直接丢弃。
+ * 交易输入的公钥不在本地钱包中时无法重新签名，在修改db文件之前报错
+ */
+func UpgradeDB(db *bolt.DB) error {
+	var version int64
+	var legacy []Block
+	var sideBlocks, mempoolTxs int
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		version, err = getDBVersionInTx(tx)
+		if err != nil || version >= CURRENTDBVERSION {
+			return err
+		}
+		bucket := tx.Bucket([]byte(BUCKERNAME))
+		if bucket == nil || len(bucket.Get([]byte(LASTHASH))) == 0 {
+			return nil
+		}
+		//转换后需要重新计算区块头，只有工作量证明的区块可以由本节点重新挖出
+		params, err := getChainParamsInTx(tx)
+		if err != nil {
+			return err
+		}
+		if params.Consensus != consensus.POW {
+			return fmt.Errorf("db文件版本%d过旧，%s共识的链无法自动转换，请删除db文件后重新创建创世区块", version, params.Consensus)
+		}
+		//版本6只改变了带签名的区块的hash，工作量证明的链不需要转换
+		if version == 5 {
+			return nil
+		}
+		legacy, err = loadLegacyChain(tx, version)
+		if err != nil {
+			return err
+		}
+		//不在主链上的区块和交易池中的交易不做转换，直接丢弃
+		sideBlocks = bucket.Stats().KeyN - 1 - len(legacy)
+		if entries := tx.Bucket([]byte(mempool.MEMPOOL)); entries != nil {
+			mempoolTxs = entries.Stats().KeyN
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if version > CURRENTDBVERSION {
+		return fmt.Errorf("db文件版本%d高于当前程序支持的版本%d", version, CURRENTDBVERSION)
+	}
+	if version == CURRENTDBVERSION {
+		return nil
+	}
+	if len(legacy) == 0 {
+		//还没有区块或者不需要转换区块的db文件，直接记录为当前版本
+		return db.Update(setDBVersionInTx)
+	}
+
+	wlt, err := checkLegacySigners(db, legacy)
+	if err != nil {
+		return fmt.Errorf("db文件版本%d过旧，%s。db文件没有被修改，请删除db文件后重新创建创世区块", version, err.Error())
+	}
+	fmt.Printf("db文件版本过旧，开始转换%d个区块\n", len(legacy))
+	fmt.Println("转换只在本地进行：交易用本地钱包重新签名，区块重新挖出，转换后的链与其他节点不再相同")
+	if sideBlocks > 0 || mempoolTxs > 0 {
+		fmt.Printf("%d个分叉上的区块和交易池中的%d笔交易不做转换，将被丢弃\n", sideBlocks, mempoolTxs)
+	}
+	//旧版本的交易hash和签名原文基于gob编码或者float64的金额，需要先转换交易
+	err = convertLegacyTxs(wlt, legacy)
+	if err != nil {
+		return err
+	}
+	blocks, err := rebuildHeaders(legacy)
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		//删除旧格式的区块、索引、utxo集合和交易池，钱包保持不变
+		buckets := []string{BUCKERNAME, HEADERBUCKET, HEIGHTINDEX, BLOCKINDEX, ADDRINDEX, UNDOBUCKET,
+			utxoset.UTXOSET, utxoset.UTXOADDRINDEX, utxoset.LEGACYUTXOSET, utxoset.UTXOMETA,
+			mempool.MEMPOOL, mempool.MEMPOOLSPENT}
+		for _, name := range buckets {
+			if tx.Bucket([]byte(name)) == nil {
+				continue
+			}
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		//开启了交易索引的，转换后继续保留交易索引
+		if tx.Bucket([]byte(TXINDEX)) != nil {
+			err := tx.DeleteBucket([]byte(TXINDEX))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(TXINDEX))
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.CreateBucket([]byte(BUCKERNAME))
+		if err != nil {
+			return err
+		}
+		coins := utxoset.NewCoinsCache(utxoset.DEFAULTCACHESIZE)
+		for _, block := range blocks {
+			_, err = storeBlock(tx, block)
+			if err != nil {
+				return err
+			}
+			err = connectBlock(tx, coins, block)
+			if err != nil {
+				return fmt.Errorf("区块%d(%x)：%s", block.Height, block.Hash, err.Error())
+			}
+		}
+		err = coins.Flush(tx)
+		if err != nil {
+			return err
+		}
+		return setDBVersionInTx(tx)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("db文件转换完成，共%d个区块，最新区块hash：%x\n", len(blocks), blocks[len(blocks)-1].Hash)
+	return nil
+}
+
+/**
+ * 从最新区块沿父区块hash读取旧格式的主链，按高度从小到大返回
+ */
+func loadLegacyChain(tx *bolt.Tx, version int64) ([]Block, error) {
+	bucket := tx.Bucket([]byte(BUCKERNAME))
+	blocks := make([]Block, 0)
+	hash := bucket.Get([]byte(LASTHASH))
+	for {
+		block, err := loadLegacyBlock(tx, version, hash)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取区块%x：%s", hash, err.Error())
+		}
+		blocks = append(blocks, block)
+		if block.Height == 0 {
+			break
+		}
+		hash = block.PreHash[:]
+	}
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return blocks, nil
+}
+
+/**
+ * 按db文件的版本读取一个区块，版本3和4的区块格式与当前一致，只有交易中的金额是float64
+ */
+func loadLegacyBlock(tx *bolt.Tx, version int64, hash []byte) (Block, error) {
+	data := tx.Bucket([]byte(BUCKERNAME)).Get(hash)
+	switch version {
+	case 0:
+		return unserializeGobBlock(data)
+	case 2:
+		return unserializeV2Block(data)
+	default:
+		var blockHash [32]byte
+		copy(blockHash[:], hash)
+		return unserializeV4Block(tx, blockHash, data)
+	}
+}
+
+/**
+ * 版本1的db文件中的区块是gob编码的，gob按字段名解码，用与当时相同的字段解码后再转换
+ */
+func unserializeGobBlock(data []byte) (Block, error) {
+	var legacy struct {
+		Height    int64
+		Version   int64
+		PreHash   [32]byte
+		Timestamp int64
+		Txs       []struct {
+			TxHash  [32]byte
+			Inputs  []transaction.TxInput
+			Outputs []struct {
+				Value   float64
+				PubHash []byte
+			}
+			LockedTime int64
+		}
+	}
+	if len(data) == 0 {
+		return Block{}, errors.New("区块数据不存在")
+	}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy)
+	block := Block{Height: legacy.Height}
+	block.Version = int32(legacy.Version)
+	block.PreHash = legacy.PreHash
+	block.Timestamp = legacy.Timestamp
+	for _, legacyTx := range legacy.Txs {
+		tx := transaction.Transaction{TxHash: legacyTx.TxHash, Inputs: legacyTx.Inputs, LockedTime: legacyTx.LockedTime}
+		for _, output := range legacyTx.Outputs {
+			tx.Outputs = append(tx.Outputs, transaction.TxOutput{
+				Value:   transaction.LegacyValueToAmount(output.Value),
+				PubHash: output.PubHash,
+			})
+		}
+		block.Txs = append(block.Txs, tx)
+	}
+	return block, err
+}
+
+/**
+ * 版本2的db文件中区块整体使用二进制格式：
+ * 版本号int64、高度int64、前一个区块hash、默克尔根(varint长度+字节)、时间戳int64、nonce int64、交易
+ */
+func unserializeV2Block(data []byte) (Block, error) {
+	var block Block
+	if len(data) == 0 {
+		return block, errors.New("区块数据不存在")
+	}
+	reader := bytes.NewReader(data)
+	version, err := utils.ReadInt64(reader)
+	if err != nil {
+		return block, err
+	}
+	block.Version = int32(version)
+	if block.Height, err = utils.ReadInt64(reader); err != nil {
+		return block, err
+	}
+	if block.PreHash, err = utils.ReadHash(reader); err != nil {
+		return block, err
+	}
+	if _, err = utils.ReadVarBytes(reader); err != nil {
+		return block, err
+	}
+	if block.Timestamp, err = utils.ReadInt64(reader); err != nil {
+		return block, err
+	}
+	if _, err = utils.ReadInt64(reader); err != nil {
+		return block, err
+	}
+	txCount, err := utils.ReadVarInt(reader)
+	if err != nil {
+		return block, err
+	}
+	if txCount > uint64(reader.Len()) {
+		return block, errors.New("区块中的交易个数超出范围")
+	}
+	for i := uint64(0); i < txCount; i++ {
+		tx, err := transaction.DecodeLegacyTransaction(reader)
+		if err != nil {
+			return block, err
+		}
+		block.Txs = append(block.Txs, tx)
+	}
+	return block, nil
+}
+
+/**
+ * 版本3和4的db文件中区块头与区块体分开存储，区块体中的交易金额为float64
+ */
+func unserializeV4Block(tx *bolt.Tx, hash [32]byte, data []byte) (Block, error) {
+	header, err := getHeaderInTx(tx, hash)
+	if err != nil {
+		return Block{}, err
+	}
+	if len(data) == 0 {
+		return Block{}, errors.New("区块数据不存在")
+	}
+	return decodeBody(*header, data, transaction.DecodeLegacyTransaction)
+}
+
+/**
+ * 转换前检查主链上每笔交易输入的公钥是否都在本地钱包中，返回加载的钱包。
+ * 有交易输入的链才需要重新签名，没有时不加载钱包
+ */
+func checkLegacySigners(db *bolt.DB, legacy []Block) (*wallet.Wallet, error) {
+	var wlt *wallet.Wallet
+	for _, block := range legacy {
+		for _, transac := range block.Txs {
+			for _, input := range transac.Inputs {
+				if wlt == nil {
+					loaded, err := wallet.LoadWalletFromDB(db)
+					if err != nil {
+						return nil, err
+					}
+					wlt = &loaded
+				}
+				if findKeyPair(wlt, input.Pubk) == nil {
+					return nil, fmt.Errorf("区块%d中交易%x的输入公钥%x不在本地钱包中，转换需要用本地钱包重新签名所有交易，只能转换本节点钱包产生的链",
+						block.Height, transac.TxHash, input.Pubk)
+				}
+			}
+		}
+	}
+	return wlt, nil
+}
+
+/**
+ * 按新的格式重新计算每笔交易的hash：交易输入引用的交易hash随之替换，
+ * 签名的原文发生了变化，需要用钱包wlt中的私钥重新签名
+ */
+func convertLegacyTxs(wlt *wallet.Wallet, legacy []Block) error {
+	//旧交易hash -> 新交易hash
+	txids := make(map[[32]byte][32]byte)
+	//新交易hash -> 交易输出，用于重新签名
+	outputs := make(map[[32]byte][]transaction.TxOutput)
+	for _, block := range legacy {
+		for i := range block.Txs {
+			transac := &block.Txs[i]
+			spents := make([]transaction.UTXO, 0, len(transac.Inputs))
+			for j := range transac.Inputs {
+				input := &transac.Inputs[j]
+				txid, ok := txids[input.Txid]
+				if !ok {
+					return fmt.Errorf("区块%d：交易%x引用的交易%x不存在", block.Height, transac.TxHash, input.Txid)
+				}
+				input.Txid = txid
+				if input.Vout < 0 || input.Vout >= len(outputs[txid]) {
+					return fmt.Errorf("区块%d：交易%x引用的交易输出不存在", block.Height, transac.TxHash)
+				}
+				spents = append(spents, transaction.UTXO{TxId: txid, Vout: input.Vout, TxOutput: outputs[txid][input.Vout]})
+			}
+			oldTxid := transac.TxHash
+			txid, err := transac.CalculateTxId()
+			if err != nil {
+				return err
+			}
+			transac.TxHash = txid
+			if len(spents) > 0 {
+				err = resignTransaction(wlt, transac, spents)
+				if err != nil {
+					return fmt.Errorf("区块%d：交易%x：%s", block.Height, oldTxid, err.Error())
+				}
+			}
+			txids[oldTxid] = txid
+			outputs[txid] = transac.Outputs
+		}
+	}
+	return nil
+}
+
+/**
+ * 按当前的规则重新计算每个区块的区块头：默克尔根、父区块hash和难度目标。
+ * 区块头发生变化的区块需要重新计算工作量证明，之后的区块的父区块hash也随之变化
+ */
+func rebuildHeaders(legacy []Block) ([]Block, error) {
+	var preHash [32]byte
+	blocks := make([]Block, 0, len(legacy))
+	for height, block := range legacy {
+		root, err := calculateMerkleRoot(block.Txs)
+		if err != nil {
+			return nil, err
+		}
+		block.MerkleRoot = root
+		block.PreHash = preHash
+		switch {
+		case height == 0:
+			block.Bits = consensus.NewBits()
+		case consensus.IsRetargetHeight(int64(height)):
+			first := blocks[int64(height)-chainparams.Active.RetargetInterval]
+			last := blocks[height-1]
+			block.Bits = consensus.CalculateNextBits(last.Bits, first.Timestamp, last.Timestamp)
+		default:
+			block.Bits = blocks[height-1].Bits
+		}
+		//区块中的交易不能修改，nonce用完时调整时间戳后继续搜索
+		for !consensus.CheckProofOfWork(block.BlockHeader, block.Hash) {
+			result, err := consensus.NewProofWork(block, 0).SearchNonce(context.Background())
+			if err == consensus.ErrNonceExhausted {
+				block.Timestamp++
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			block.Hash, block.Nonce = result.Hash, result.Nonce
+		}
+		preHash = block.Hash
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+/**
+ * 用钱包中与交易输入公钥对应的私钥重新签名，一笔交易的输入可能来自多个地址，逐个输入签名
+ */
+func resignTransaction(wlt *wallet.Wallet, transac *transaction.Transaction, spents []transaction.UTXO) error {
+	signed := make([][]byte, len(transac.Inputs))
+	for i, input := range transac.Inputs {
+		keyPair := findKeyPair(wlt, input.Pubk)
+		if keyPair == nil {
+			return errors.New("钱包中没有交易输入对应的私钥，无法重新签名")
+		}
+		err := transac.Sign(keyPair.Pri, spents)
+		if err != nil {
+			return err
+		}
+		signed[i] = transac.Inputs[i].Sig
+	}
+	for i := range transac.Inputs {
+		transac.Inputs[i].Sig = signed[i]
+	}
+	return nil
+}
+
+// 在钱包中查找公钥对应的密钥对，没有时返回nil
+func findKeyPair(wlt *wallet.Wallet, pub []byte) *wallet.KeyPair {
+	for _, pair := range wlt.Address {
+		if bytes.Equal(pair.Pub, pub) {
+			return pair
+		}
+	}
+	return nil
+}
+
+// 读取db文件的版本号，没有记录时返回0
+func getDBVersionInTx(tx *bolt.Tx) (int64, error) {
+	bucket := tx.Bucket([]byte(CHAINMETA))
+	if bucket == nil {
+		return 0, nil
+	}
+	versionBytes := bucket.Get([]byte(DBVERSION))
+	if len(versionBytes) == 0 {
+		return 0, nil
+	}
+	if len(versionBytes) != 8 {
+		return 0, errors.New("db文件版本号格式错误")
+	}
+	return int64(binary.BigEndian.Uint64(versionBytes)), nil
+}
+
+// 把db文件记录为当前版本
+func setDBVersionInTx(tx *bolt.Tx) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(CHAINMETA))
+	if err != nil {
+		return err
+	}
+	versionBytes, err := utils.IntToByte(CURRENTDBVERSION)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(DBVERSION), versionBytes)
+}
@@ -0,0 +1,252 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// 挖矿守护进程的统计信息在CHAINMETA中的key
+const MININGINFO = "mininginfo"
+
+// 挖矿守护进程每一轮搜索nonce的最长时间，单位秒
+const MININGROUNDTIME = 2
+
+/**
+ * 挖矿守护进程每一轮最多搜索MININGROUNDTIME秒，且不超过一个出块间隔，之后重新打开db，
+ * 提交其他进程收到的区块和交易池中新的交易，避免长时间在过期的链尾上挖矿
+ */
+func miningRoundTime() time.Duration {
+	seconds := int64(MININGROUNDTIME)
+	if chainparams.Active.TargetSpacing < seconds {
+		seconds = chainparams.Active.TargetSpacing
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+/**
+ * 挖矿守护进程的状态和统计信息：每一轮结束时写入db，getmininginfo和stopmining从db中读取
+ */
+type MiningInfo struct {
+	Pid        int         // 挖矿进程的进程号
+	Running    bool        // 挖矿进程是否在运行
+	Threads    int         // 同时搜索nonce的goroutine数量
+	Address    string      // 出块奖励的地址
+	StartTime  int64       // 开始挖矿的时间
+	UpdateTime int64       // 最近一次写入统计信息的时间
+	Blocks     int64       // 挖出并连接到主链的区块数量
+	Stale      int64       // 挖出后不在主链上的区块数量
+	Stats      MiningStats // 累计的hash次数和搜索时间
+}
+
+/**
+ * 挖矿进程运行了多长时间，已停止时为开始到最后一次写入统计信息的时间
+ */
+func (info *MiningInfo) Uptime() time.Duration {
+	end := info.UpdateTime
+	if info.Running {
+		end = time.Now().Unix()
+	}
+	return time.Duration(end-info.StartTime) * time.Second
+}
+
+/**
+ * 记录的挖矿进程是否仍然存在。挖矿进程异常退出时来不及把Running改为false
+ */
+func (info *MiningInfo) IsAlive() bool {
+	if !info.Running {
+		return false
+	}
+	process, err := os.FindProcess(info.Pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+func getMiningInfoInTx(tx *bolt.Tx) (*MiningInfo, error) {
+	bucket := tx.Bucket([]byte(CHAINMETA))
+	if bucket == nil {
+		return nil, nil
+	}
+	infoBytes := bucket.Get([]byte(MININGINFO))
+	if len(infoBytes) == 0 {
+		return nil, nil
+	}
+	var info MiningInfo
+	_, err := utils.GodDecode(infoBytes, &info)
+	return &info, err
+}
+
+func putMiningInfoInTx(tx *bolt.Tx, info MiningInfo) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(CHAINMETA))
+	if err != nil {
+		return err
+	}
+	infoBytes, err := utils.GobEncode(info)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(MININGINFO), infoBytes)
+}
+
+/**
+ * 查询最近一次启动的挖矿守护进程的状态，从未启动过时返回nil
+ */
+func (chain *BlockChain) GetMiningInfo() (*MiningInfo, error) {
+	var info *MiningInfo
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		info, err = getMiningInfoInTx(tx)
+		return err
+	})
+	return info, err
+}
+
+func (chain *BlockChain) putMiningInfo(info MiningInfo) error {
+	info.UpdateTime = time.Now().Unix()
+	return chain.DB.Update(func(tx *bolt.Tx) error {
+		return putMiningInfoInTx(tx, info)
+	})
+}
+
+/**
+ * 记录挖矿进程已停止。重新打开db失败时chain中的db已经关闭，直接打开db文件写入，
+ * 否则getmininginfo会一直把已退出的进程显示为正在运行
+ */
+func (chain *BlockChain) putMinerStopped(path string, info MiningInfo) error {
+	info.Running = false
+	err := chain.putMiningInfo(info)
+	if !errors.Is(err, bolt.ErrDatabaseNotOpen) {
+		return err
+	}
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	info.UpdateTime = time.Now().Unix()
+	return db.Update(func(tx *bolt.Tx) error {
+		return putMiningInfoInTx(tx, info)
+	})
+}
+
+/**
+ * 挖矿守护进程：持续挖出区块，出块奖励给矿工地址，直到ctx取消。
+ * 每一轮在db打开时打包交易池中的交易、准备共识引擎，然后关闭db搜索nonce，其他进程可以在此期间使用db；
+ * 找到区块或者搜索超过miningRoundTime后重新打开db，按接收区块的流程提交挖出的区块，并更新统计信息。
+ * 每挖出一个区块调用一次found，isTip表示区块是否连接到了主链。
+ * 开始挖矿之后无论因为ctx取消还是出错退出，都会把挖矿进程记录为已停止
+ */
+func (chain *BlockChain) RunMiner(ctx context.Context, threads int, found func(block Block, isTip bool)) error {
+	if chain.LastBlock.Hash == [32]byte{} {
+		return errors.New("还没有创世区块，请先执行generategenesis")
+	}
+	address := chain.GetCoinbase()
+	if len(address) == 0 {
+		return errors.New("未设置coinbase矿工地址，请先设置")
+	}
+	last, err := chain.GetMiningInfo()
+	if err != nil {
+		return err
+	}
+	if last != nil && last.IsAlive() {
+		return fmt.Errorf("挖矿进程%d正在运行，请先执行stopmining", last.Pid)
+	}
+	info := MiningInfo{
+		Pid:       os.Getpid(),
+		Running:   true,
+		Threads:   threads,
+		Address:   address,
+		StartTime: time.Now().Unix(),
+	}
+	err = chain.putMiningInfo(info)
+	if err != nil {
+		return err
+	}
+	//关闭db之后无法再取得db文件的路径
+	path := chain.DB.Path()
+	var runErr error
+	for ctx.Err() == nil {
+		block, newEngine, err := chain.prepareMiningRound(address, threads)
+		if err != nil {
+			runErr = err
+			break
+		}
+		err = chain.Close()
+		if err != nil {
+			runErr = err
+			break
+		}
+		roundCtx, cancel := context.WithTimeout(ctx, miningRoundTime())
+		stats, mineErr := mineBlock(roundCtx, block, newEngine)
+		cancel()
+		reopened, err := OpenBlockChain(path)
+		if err != nil {
+			runErr = err
+			break
+		}
+		*chain = reopened
+		info.Stats.add(*stats)
+		if mineErr == nil {
+			isTip, err := chain.SubmitBlock(*block)
+			if err != nil {
+				runErr = err
+				break
+			}
+			if isTip {
+				info.Blocks++
+			} else {
+				info.Stale++
+			}
+			found(*block, isTip)
+		} else if !errors.Is(mineErr, context.DeadlineExceeded) && ctx.Err() == nil {
+			runErr = mineErr
+			break
+		}
+		err = chain.putMiningInfo(info)
+		if err != nil {
+			runErr = err
+			break
+		}
+	}
+	err = chain.putMinerStopped(path, info)
+	if err != nil {
+		if runErr != nil {
+			return fmt.Errorf("%s，并且无法记录挖矿进程已停止：%s", runErr.Error(), err.Error())
+		}
+		return err
+	}
+	return runErr
+}
+
+// 在db打开时准备一轮挖矿：打包交易生成新区块，并创建共识引擎
+func (chain *BlockChain) prepareMiningRound(address string, threads int) (*Block, engineFunc, error) {
+	txs, err := chain.collectBlockTxs(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	bits, err := chain.GetNextBits()
+	if err != nil {
+		return nil, nil, err
+	}
+	timestamp, err := chain.nextBlockTime()
+	if err != nil {
+		return nil, nil, err
+	}
+	newEngine, err := chain.newEngine(threads)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := newBlock(chain.LastBlock.Height, chain.LastBlock.Hash, bits, timestamp, txs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, newEngine, nil
+}
@@ -0,0 +1,97 @@
+package chain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+/**
+ * 挖矿守护进程挖出的区块连接到主链，ctx取消后把挖矿进程记录为已停止
+ */
+func TestRunMinerRecordsStop(t *testing.T) {
+	chain, address := newTestChain(t)
+	err := chain.SetCoinbase(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mined []Block
+	err = chain.RunMiner(ctx, 2, func(block Block, isTip bool) {
+		if !isTip {
+			t.Errorf("区块%x没有连接到主链", block.Hash)
+		}
+		mined = append(mined, block)
+		if len(mined) == 2 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mined) != 2 || chain.LastBlock.Height != 2 || chain.LastBlock.Hash != mined[1].Hash {
+		t.Fatalf("挖出%d个区块，主链高度%d", len(mined), chain.LastBlock.Height)
+	}
+	info, err := chain.GetMiningInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.Running || info.Blocks != 2 || info.Stale != 0 || info.Address != address {
+		t.Fatalf("挖矿统计信息不正确：%+v", info)
+	}
+}
+
+/**
+ * 重新打开db失败时挖矿进程返回错误，并且仍然把挖矿进程记录为已停止
+ */
+func TestRunMinerRecordsStopOnError(t *testing.T) {
+	chain, address := newTestChain(t)
+	err := chain.SetCoinbase(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := chain.DB.Path()
+	var mined int
+	err = chain.RunMiner(context.Background(), 1, func(block Block, isTip bool) {
+		mined++
+		//删除刚挖出的区块，下一轮重新打开db时无法读取最新区块
+		err := chain.DB.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(BUCKERNAME)).Delete(block.Hash[:])
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err == nil {
+		t.Fatal("无法读取最新区块时挖矿进程没有返回错误")
+	}
+	if mined != 1 {
+		t.Fatalf("挖出%d个区块，应为1个", mined)
+	}
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var info *MiningInfo
+	err = db.View(func(tx *bolt.Tx) error {
+		var err error
+		info, err = getMiningInfoInTx(tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.Running || info.Blocks != 1 {
+		t.Fatalf("出错退出后挖矿统计信息不正确：%+v", info)
+	}
+}
+
+func TestMiningRoundTime(t *testing.T) {
+	newTestChain(t)
+	if miningRoundTime() <= 0 || miningRoundTime().Seconds() > MININGROUNDTIME {
+		t.Fatalf("每一轮挖矿时间为%s", miningRoundTime())
+	}
+}
@@ -0,0 +1,91 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"context"
+	"errors"
+	"time"
+)
+
+/**
+ * 挖矿的统计信息
+ */
+type MiningStats struct {
+	Hashes      uint64        // 一共计算的hash次数
+	Elapsed     time.Duration // 搜索nonce花费的时间
+	ExtraNonces int           // nonce用完后修改coinbase交易的次数
+}
+
+/**
+ * 挖矿期间的算力，每秒计算的hash次数
+ */
+func (stats *MiningStats) HashRate() float64 {
+	if stats.Elapsed <= 0 {
+		return 0
+	}
+	return float64(stats.Hashes) / stats.Elapsed.Seconds()
+}
+
+func (stats *MiningStats) add(other MiningStats) {
+	stats.Hashes += other.Hashes
+	stats.Elapsed += other.Elapsed
+	stats.ExtraNonces += other.ExtraNonces
+}
+
+/**
+ * 根据待打包的区块创建共识引擎，区块内容修改后会重新创建
+ */
+type engineFunc func(block Block) consensus.Consensus
+
+/**
+ * 用共识引擎产出区块，找到后写入区块的nonce、时间戳、hash和签名。
+ * nonce的取值范围用完时修改coinbase交易的extra nonce，重新计算默克尔根后继续搜索
+ */
+func mineBlock(ctx context.Context, block *Block, newEngine engineFunc) (*MiningStats, error) {
+	stats := &MiningStats{}
+	for {
+		result, err := newEngine(*block).SearchNonce(ctx)
+		stats.Hashes += result.Hashes
+		stats.Elapsed += result.Elapsed
+		if err == consensus.ErrNonceExhausted {
+			err = incrementExtraNonce(block)
+			if err != nil {
+				return stats, err
+			}
+			stats.ExtraNonces++
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		block.Nonce = result.Nonce
+		block.Timestamp = result.Timestamp
+		block.Hash = result.Hash
+		block.Producer = result.Producer
+		block.Signature = result.Signature
+		return stats, nil
+	}
+}
+
+/**
+ * coinbase交易没有输入，LockedTime只用来区分不同的coinbase交易，挖矿时把它当作extra nonce：
+ * 加一后coinbase交易的hash随之变化，默克尔根和区块头也随之变化，nonce可以从头开始搜索
+ */
+func incrementExtraNonce(block *Block) error {
+	if len(block.Txs) == 0 || !block.Txs[0].IsCoinbaseTranaction() {
+		return errors.New("nonce已用完，区块中没有可以修改的coinbase交易")
+	}
+	coinbase := &block.Txs[0]
+	coinbase.LockedTime++
+	txid, err := coinbase.CalculateTxId()
+	if err != nil {
+		return err
+	}
+	coinbase.TxHash = txid
+	root, err := calculateMerkleRoot(block.Txs)
+	if err != nil {
+		return err
+	}
+	block.MerkleRoot = root
+	return nil
+}
@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"context"
+	"testing"
+)
+
+// 第一次搜索时nonce用完，之后找到区块的共识引擎
+type exhaustedEngine struct {
+	block    Block
+	searches *int
+}
+
+func (engine exhaustedEngine) SearchNonce(ctx context.Context) (*consensus.SearchResult, error) {
+	*engine.searches++
+	result := &consensus.SearchResult{Hashes: 10, Timestamp: engine.block.Timestamp}
+	if *engine.searches == 1 {
+		return result, consensus.ErrNonceExhausted
+	}
+	result.Nonce = 7
+	result.Hash = engine.block.BlockHeader.BlockHash()
+	return result, nil
+}
+
+// nonce用完时修改coinbase交易的extra nonce，重新计算默克尔根后继续搜索
+func TestMineBlockExtraNonce(t *testing.T) {
+	chain, address := newTestChain(t)
+	block := mineTestBlock(t, chain.LastBlock, address)
+	coinbase := block.Txs[0]
+	root := block.MerkleRoot
+	searches := 0
+	stats, err := mineBlock(context.Background(), &block, func(block Block) consensus.Consensus {
+		return exhaustedEngine{block: block, searches: &searches}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if searches != 2 || stats.ExtraNonces != 1 || stats.Hashes != 20 {
+		t.Fatalf("搜索了%d次，统计信息为%+v", searches, stats)
+	}
+	if block.Txs[0].LockedTime != coinbase.LockedTime+1 || block.Txs[0].TxHash == coinbase.TxHash {
+		t.Error("coinbase交易的extra nonce没有加一")
+	}
+	if block.MerkleRoot == root {
+		t.Error("修改coinbase交易后默克尔根没有重新计算")
+	}
+	if block.Nonce != 7 {
+		t.Errorf("区块的nonce为%d，应为7", block.Nonce)
+	}
+	err = checkBlockCoinbase(block)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIncrementExtraNonceWithoutCoinbase(t *testing.T) {
+	block := Block{}
+	err := incrementExtraNonce(&block)
+	if err == nil {
+		t.Error("区块中没有coinbase交易时应返回错误")
+	}
+}
+
+// 挖矿可以通过ctx取消
+func TestMineBlockCancel(t *testing.T) {
+	chain, address := newTestChain(t)
+	block := mineTestBlock(t, chain.LastBlock, address)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := mineBlock(ctx, &block, func(block Block) consensus.Consensus {
+		return consensus.ProofWork{Block: block, Target: consensus.CompactToTarget(0x01003456), Threads: 2}
+	})
+	if err != context.Canceled {
+		t.Errorf("取消后返回%v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"PublicChain/utils"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// 链参数在CHAINMETA中的key：创建创世区块时写入，之后不再修改
+const CHAINPARAMS = "chainparams"
+
+// 网络标识在CHAINMETA中的key：创建创世区块时写入当前网络的Magic
+const NETWORKMAGIC = "networkmagic"
+
+/**
+ * 链参数：决定区块如何产出和验证，同一条链上的所有区块使用相同的参数
+ */
+type ChainParams struct {
+	Consensus  string   // 共识机制，consensus.POW、consensus.POS或consensus.POA
+	Validators [][]byte // 权威证明的初始验证者公钥，只在创建创世区块时使用，之后的变化记录在验证者集合中
+}
+
+/**
+ * 默认的链参数，没有记录链参数的db文件(包括旧版本生成的)使用工作量证明
+ */
+func DefaultChainParams() ChainParams {
+	return ChainParams{Consensus: consensus.POW}
+}
+
+/**
+ * 根据共识机制的名称生成链参数
+ */
+func NewChainParams(name string) (ChainParams, error) {
+	params := ChainParams{Consensus: name}
+	return params, params.check()
+}
+
+func (params ChainParams) check() error {
+	switch params.Consensus {
+	case consensus.POW, consensus.POS, consensus.POA:
+		return nil
+	}
+	return fmt.Errorf("不支持的共识机制：%s，可选pow、pos或poa", params.Consensus)
+}
+
+/**
+ * 该共识机制下创世区块使用的难度目标
+ */
+func (params ChainParams) InitialBits() uint32 {
+	switch params.Consensus {
+	case consensus.POS:
+		return consensus.NewStakeBits()
+	case consensus.POA:
+		return consensus.NewAuthorityBits()
+	}
+	return consensus.NewBits()
+}
+
+// 读取db文件中记录的链参数，没有记录时使用默认参数
+func getChainParamsInTx(tx *bolt.Tx) (ChainParams, error) {
+	bucket := tx.Bucket([]byte(CHAINMETA))
+	if bucket == nil {
+		return DefaultChainParams(), nil
+	}
+	name := bucket.Get([]byte(CHAINPARAMS))
+	if len(name) == 0 {
+		return DefaultChainParams(), nil
+	}
+	params := ChainParams{Consensus: string(name)}
+	if err := params.check(); err != nil {
+		return params, errors.New("db文件中的链参数有误，" + err.Error())
+	}
+	return params, nil
+}
+
+// 把链参数和当前网络的标识写入db文件
+func setChainParamsInTx(tx *bolt.Tx, params ChainParams) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(CHAINMETA))
+	if err != nil {
+		return err
+	}
+	magic := make([]byte, 4)
+	binary.LittleEndian.PutUint32(magic, chainparams.Active.Magic)
+	err = bucket.Put([]byte(NETWORKMAGIC), magic)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(CHAINPARAMS), []byte(params.Consensus))
+}
+
+/**
+ * 检查db文件是否属于当前网络。旧版本生成的db文件没有记录网络标识，属于主网；还没有创世区块的db文件不检查
+ */
+func checkNetworkInTx(tx *bolt.Tx) error {
+	var stored []byte
+	if meta := tx.Bucket([]byte(CHAINMETA)); meta != nil {
+		stored = meta.Get([]byte(NETWORKMAGIC))
+	}
+	magic := chainparams.MainNetParams.Magic
+	if len(stored) == 4 {
+		magic = binary.LittleEndian.Uint32(stored)
+	} else if blocks := tx.Bucket([]byte(BUCKERNAME)); blocks == nil || len(blocks.Get([]byte(LASTHASH))) == 0 {
+		return nil
+	}
+	if magic != chainparams.Active.Magic {
+		return fmt.Errorf("db文件属于其他网络(标识%08x)，当前网络为%s", magic, chainparams.Active.Name)
+	}
+	return nil
+}
+
+/**
+ * 检查工作量证明的链是否使用了当前网络固定的创世区块。权益证明和权威证明的创世区块由创建链的节点生成，
+ * 旧版本生成的db文件没有记录网络标识，创建时还没有固定的创世区块，这两种情况都不检查
+ */
+func checkGenesisInTx(tx *bolt.Tx) error {
+	meta := tx.Bucket([]byte(CHAINMETA))
+	if meta == nil || len(meta.Get([]byte(NETWORKMAGIC))) == 0 {
+		return nil
+	}
+	params, err := getChainParamsInTx(tx)
+	if err != nil || params.Consensus != consensus.POW {
+		return err
+	}
+	heights := tx.Bucket([]byte(HEIGHTINDEX))
+	if heights == nil {
+		return nil
+	}
+	heightBytes, err := utils.IntToByte(0)
+	if err != nil {
+		return err
+	}
+	stored := heights.Get(heightBytes)
+	if len(stored) == 0 {
+		return nil
+	}
+	if !bytes.Equal(stored, chainparams.Active.GenesisHash[:]) {
+		return fmt.Errorf("db文件的创世区块%x与%s的创世区块%x不一致，请删除db文件后重新创建创世区块", stored, chainparams.Active.Name, chainparams.Active.GenesisHash)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"PublicChain/utils"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 每个网络固定的创世区块与网络参数中的hash一致，并满足初始难度的工作量证明
+func TestNetworkGenesisBlock(t *testing.T) {
+	t.Cleanup(func() { _ = chainparams.Select(chainparams.REGTEST) })
+	for _, name := range []string{chainparams.MAINNET, chainparams.TESTNET, chainparams.REGTEST} {
+		err := chainparams.Select(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		genesis, err := NetworkGenesisBlock()
+		if err != nil {
+			t.Fatalf("%s：%v", name, err)
+		}
+		if genesis.Hash != chainparams.Active.GenesisHash || genesis.Timestamp != chainparams.Active.GenesisTimestamp {
+			t.Errorf("%s的创世区块为%x", name, genesis.Hash)
+		}
+		if new(big.Int).SetBytes(genesis.Hash[:]).Cmp(consensus.CompactToTarget(genesis.Bits)) >= 0 {
+			t.Errorf("%s的创世区块不满足工作量证明", name)
+		}
+	}
+}
+
+// 打开其他网络的db文件、或者创世区块与当前网络不一致的db文件时报错
+func TestOpenChecksNetwork(t *testing.T) {
+	path, _ := closedTestChain(t, 1)
+	err := chainparams.Select(chainparams.TESTNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = chainparams.Select(chainparams.REGTEST) })
+	_, err = OpenBlockChain(path)
+	if err == nil || !strings.Contains(err.Error(), "其他网络") {
+		t.Fatalf("打开回归测试网的db文件时返回%v", err)
+	}
+
+	err = chainparams.Select(chainparams.REGTEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperTestDB(t, path, func(tx *bolt.Tx) error {
+		heightBytes, err := utils.IntToByte(0)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(HEIGHTINDEX)).Put(heightBytes, make([]byte, 32))
+	})
+	_, err = OpenBlockChain(path)
+	if err == nil || !strings.Contains(err.Error(), "创世区块") {
+		t.Fatalf("创世区块不一致时返回%v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"PublicChain/utxoset"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// 重建utxo集合时，每重放多少个区块输出一次进度
+const REINDEXLOGINTERVAL = 100
+
+/**
+ * 删除utxo集合，按高度从创世区块到最新区块重新执行每个区块中的交易，重建utxo集合，
+ * 区块的撤销数据也一并重新生成。所有改动在同一个事务中提交，中途失败不会留下一半的utxo集合。
+ * 重放时不再检查交易签名，区块在连接到主链时已经验证过。utxo的改动经过缓存，
+ * 超过内存上限时分批写回，最后一次性写回剩余的改动。返回重放的区块数量
+ */
+func (chain *BlockChain) ReindexChainstate() (int64, error) {
+	if chain.LastBlock.Hash == [32]byte{} {
+		return 0, errors.New("还没有区块，无需重建")
+	}
+	tip := chain.LastBlock.Height
+	var count int64
+	coins := chain.UTXOSet.Cache
+	err := chain.updateCoins(func(tx *bolt.Tx) error {
+		coins.Clear()
+		err := utxoset.ResetUTXOSetInTx(tx)
+		if err != nil {
+			return err
+		}
+		for height := int64(0); height <= tip; height++ {
+			hash, err := getHeightHash(tx, height)
+			if err != nil {
+				return fmt.Errorf("区块%d：%s", height, err.Error())
+			}
+			block, err := getBlockInTx(tx, hash)
+			if err != nil {
+				return fmt.Errorf("区块%d(%x)：无法读取区块数据，%s", height, hash, err.Error())
+			}
+			undo := BlockUndo{SpentUTXOs: make([]SpentUTXO, 0)}
+			for position, transac := range block.Txs {
+				spents := make([]SpentUTXO, 0)
+				for _, input := range transac.Inputs {
+					spent, err := lookupSpentUTXO(tx, coins, block.Txs[:position], input)
+					if err != nil {
+						return fmt.Errorf("区块%d(%x)：%s", height, hash, err.Error())
+					}
+					spents = append(spents, *spent)
+				}
+				err = applyTxUTXO(tx, coins, transac, spents)
+				if err != nil {
+					return err
+				}
+				undo.SpentUTXOs = append(undo.SpentUTXOs, spents...)
+			}
+			err = putBlockUndo(tx, block.Hash, undo)
+			if err != nil {
+				return err
+			}
+			coins.SetBestBlock(block.Hash)
+			err = coins.FlushIfNeeded(tx)
+			if err != nil {
+				return err
+			}
+			count++
+			if count%REINDEXLOGINTERVAL == 0 || height == tip {
+				fmt.Printf("已重放到区块高度%d/%d\n", height, tip)
+			}
+		}
+		return coins.Flush(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
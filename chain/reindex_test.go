@@ -0,0 +1,84 @@
+package chain
+
+import (
+	"PublicChain/utxoset"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 删除utxo集合和撤销数据后，从区块重建得到与之前相同的utxo集合、统计信息和撤销数据
+func TestReindexChainstate(t *testing.T) {
+	chain, address := newTestChain(t)
+	_, a2, to := spendTestChain(t, chain, address)
+	before, err := chain.UTXOSet.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := utxoKeys(t, chain, address)
+	toKeys := utxoKeys(t, chain, to)
+	err = chain.DB.Update(func(tx *bolt.Tx) error {
+		err := utxoset.ResetUTXOSetInTx(tx)
+		if err != nil {
+			return err
+		}
+		return deleteBlockUndo(tx, a2.Hash)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.UTXOSet.Cache.Clear()
+
+	count, err := chain.ReindexChainstate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("重放了%d个区块，应为3个", count)
+	}
+	after, err := chain.UTXOSet.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *after != *before {
+		t.Errorf("重建后utxo集合的统计信息为%+v，应为%+v", after, before)
+	}
+	if !equalKeys(utxoKeys(t, chain, address), keys) || !equalKeys(utxoKeys(t, chain, to), toKeys) {
+		t.Error("重建后地址的utxo与之前不同")
+	}
+	undo := testBlockUndo(t, chain, a2.Hash)
+	if undo == nil || len(undo.SpentUTXOs) != 1 {
+		t.Errorf("重建后a2的撤销数据为%+v", undo)
+	}
+	_, err = chain.VerifyChain(0, VERIFYVALUE)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// 重放中途失败时，utxo集合保持重建之前的状态
+func TestReindexChainstateRollback(t *testing.T) {
+	chain, address := newTestChain(t)
+	a1, _, _ := spendTestChain(t, chain, address)
+	before, err := chain.UTXOSet.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = chain.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BUCKERNAME)).Delete(a1.Hash[:])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chain.ReindexChainstate()
+	if err == nil {
+		t.Fatal("区块数据缺失时重建应返回错误")
+	}
+	after, err := chain.UTXOSet.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *after != *before {
+		t.Errorf("重建失败后utxo集合的统计信息为%+v，应为%+v", after, before)
+	}
+}
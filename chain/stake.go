@@ -0,0 +1,175 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"PublicChain/utxoset"
+	"PublicChain/wallet"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"sort"
+	"time"
+)
+
+// 带签名的区块时间戳最多比当前时间晚几个出块间隔
+const MAXFUTURESPACINGS = 2
+
+/**
+ * 按链参数创建产出下一个区块的共识引擎：工作量证明用threads个goroutine搜索nonce，
+ * 权益证明由钱包中在当前最新区块时有已成熟余额的地址参与质押，权威证明由轮到的验证者签名
+ */
+func (chain *BlockChain) newEngine(threads int) (engineFunc, error) {
+	parentTime := chain.LastBlock.Timestamp
+	switch chain.Params.Consensus {
+	case consensus.POS:
+		stakers, err := chain.getStakers()
+		if err != nil {
+			return nil, err
+		}
+		if len(stakers) == 0 {
+			return nil, consensus.ErrNoStake
+		}
+		return func(block Block) consensus.Consensus {
+			return consensus.NewProofStake(block, parentTime, stakers)
+		}, nil
+	case consensus.POA:
+		key, err := chain.getAuthorityKey()
+		if err != nil {
+			return nil, err
+		}
+		return func(block Block) consensus.Consensus {
+			return consensus.NewProofAuthority(block, parentTime, key)
+		}, nil
+	}
+	return func(block Block) consensus.Consensus {
+		return consensus.NewProofWork(block, threads)
+	}, nil
+}
+
+/**
+ * 找出钱包中可质押余额大于0的地址作为质押者，按地址排序，可质押余额为产出下一个区块时已成熟的utxo的金额之和
+ */
+func (chain *BlockChain) getStakers() ([]consensus.Staker, error) {
+	addresses := make([]string, 0, len(chain.Wallet.Address))
+	for address := range chain.Wallet.Address {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	stakers := make([]consensus.Staker, 0)
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		for _, address := range addresses {
+			stake, err := matureStakeInTx(tx, chain.UTXOSet.Cache, address, chain.LastBlock.Height+1)
+			if err != nil {
+				return err
+			}
+			if stake > 0 {
+				stakers = append(stakers, consensus.Staker{Key: chain.Wallet.Address[address], Stake: stake})
+			}
+		}
+		return nil
+	})
+	return stakers, err
+}
+
+/**
+ * 地址在产出高度为height的区块时的质押权重：该地址的utxo中，产生于height-StakeMinDepth及之前的utxo的金额之和，创世区块的输出不受限制。
+ * utxo产生的高度从地址历史索引中查找，索引按高度排序，只需遍历到限制的高度为止。调用时utxo集合和地址历史索引必须处于父区块的状态
+ */
+func matureStakeInTx(tx *bolt.Tx, coins *utxoset.CoinsCache, address string, height int64) (int64, error) {
+	utxos, err := coins.QuerryUTXOsByAddress(tx, address)
+	if err != nil || len(utxos) == 0 {
+		return 0, err
+	}
+	bucket := tx.Bucket([]byte(ADDRINDEX))
+	if bucket == nil {
+		return 0, errors.New("地址历史索引不存在")
+	}
+	limit := height - chainparams.Active.StakeMinDepth
+	mature := make(map[string]bool)
+	pubHash := wallet.GetPubKHashWithAddress(address)
+	cursor := bucket.Cursor()
+	for key, value := cursor.Seek(pubHash); key != nil && bytes.HasPrefix(key, pubHash); key, value = cursor.Next() {
+		entry := parseAddrIndex(key, value)
+		if entry.Height > 0 && entry.Height > limit {
+			break
+		}
+		if entry.Direction == HISTORYCREDIT {
+			mature[fmt.Sprintf("%x:%d", entry.TxId, entry.Index)] = true
+		}
+	}
+	var stake int64
+	for _, utxo := range utxos {
+		if mature[fmt.Sprintf("%x:%d", utxo.TxId, utxo.Vout)] {
+			stake += utxo.Value
+		}
+	}
+	return stake, nil
+}
+
+/**
+ * 不依赖链上状态的出块证明检查：工作量证明的区块检查hash是否满足难度目标，不能带有签名；
+ * 权益证明和权威证明的区块检查出块者的签名，创世区块没有出块者，只检查hash
+ */
+func checkBlockProof(params ChainParams, block Block) error {
+	if params.Consensus == consensus.POW {
+		if len(block.Producer) > 0 || len(block.Signature) > 0 {
+			return errors.New("工作量证明的区块不能带有签名")
+		}
+		if !consensus.CheckProofOfWork(block.BlockHeader, block.Hash) {
+			return errors.New("区块的工作量证明无效")
+		}
+		return nil
+	}
+	if block.Height == 0 {
+		if len(block.Producer) > 0 || len(block.Signature) > 0 {
+			return errors.New("创世区块不能带有签名")
+		}
+		if block.BlockHash() != block.Hash {
+			return errors.New("重新计算的区块hash不一致")
+		}
+		return nil
+	}
+	if !consensus.CheckBlockSignature(block.BlockHeader, block.Hash, block.Producer, block.Signature) {
+		return errors.New("区块的签名无效")
+	}
+	return nil
+}
+
+/**
+ * 带签名的区块连接到主链时检查出块资格：时间戳大于父区块且不超过当前时间太多，
+ * 权益证明的出块者在父区块时已成熟的余额满足kernel hash的要求，权威证明的出块者是轮到出块的验证者。
+ * 调用时utxo集合和验证者集合必须处于父区块的状态
+ */
+func checkBlockProducer(tx *bolt.Tx, coins *utxoset.CoinsCache, params ChainParams, block Block) error {
+	if params.Consensus == consensus.POW || block.Height == 0 {
+		return nil
+	}
+	parent, err := getHeaderInTx(tx, block.PreHash)
+	if err != nil {
+		return err
+	}
+	if block.Timestamp <= parent.Timestamp {
+		return errors.New("区块的时间戳必须大于父区块")
+	}
+	if block.Timestamp > time.Now().Unix()+MAXFUTURESPACINGS*chainparams.Active.TargetSpacing {
+		return errors.New("区块的时间戳超前当前时间太多")
+	}
+	if params.Consensus == consensus.POA {
+		return checkBlockAuthority(tx, block)
+	}
+	producer, err := wallet.NewAddress(block.Producer)
+	if err != nil {
+		return err
+	}
+	stake, err := matureStakeInTx(tx, coins, producer, block.Height)
+	if err != nil {
+		return err
+	}
+	kernel := consensus.StakeKernel(block.PreHash, block.Producer, block.Timestamp)
+	if !consensus.CheckStakeKernel(kernel, block.Bits, stake) {
+		return fmt.Errorf("出块者%s已成熟的余额不足以产出该区块", producer)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package chain
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"PublicChain/wallet"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 在主链末端连接count个出块奖励给address的区块
+func extendTestChain(t *testing.T, chain *BlockChain, address string, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		acceptTestBlocks(t, chain, mineTestBlock(t, chain.LastBlock, address))
+	}
+}
+
+func testMatureStake(t *testing.T, chain *BlockChain, address string, height int64) int64 {
+	t.Helper()
+	var stake int64
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		stake, err = matureStakeInTx(tx, chain.UTXOSet.Cache, address, height)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stake
+}
+
+// 在parent之后生成一个由keyPair签名的区块，难度为最低难度，即权威证明使用的难度
+func signTestBlock(t *testing.T, parent Block, address string, keyPair *wallet.KeyPair, txs ...transaction.Transaction) Block {
+	t.Helper()
+	coinbase, err := transaction.NewCoinbaseTx(address, consensus.BlockSubsidy(parent.Height+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := newBlock(parent.Height, parent.Hash, consensus.TargetToCompact(consensus.PowLimit()), parent.Timestamp+1, append([]transaction.Transaction{*coinbase}, txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Producer = keyPair.Pub
+	block.Hash = block.CalculateHash()
+	block.Signature, err = consensus.SignBlockHash(keyPair.Pri, block.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *block
+}
+
+// 只有达到成熟深度的utxo计入质押权重，已花费的不计入，创世区块的输出不受限制
+func TestMatureStake(t *testing.T) {
+	chain, address := newTestChain(t)
+	to, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	depth := chainparams.Active.StakeMinDepth
+	a1 := mineTestBlock(t, chain.LastBlock, address)
+	acceptTestBlocks(t, chain, a1)
+	extendTestChain(t, chain, address, int(depth)-1)
+	//a1的coinbase输出转10个币给to，找零在该区块中产生，尚未成熟
+	coinbase := transaction.NewUTXO(a1.Txs[0].TxHash, 0, a1.Txs[0].Outputs[0])
+	acceptTestBlocks(t, chain, mineTestBlock(t, chain.LastBlock, address, spendTestUTXOs(t, chain, []transaction.UTXO{coinbase}, address, to, 10*utils.COIN)))
+	tip := chain.LastBlock.Height
+
+	var expected int64
+	for height := int64(2); height <= tip+1-depth; height++ {
+		expected += consensus.BlockSubsidy(height)
+	}
+	if stake := testMatureStake(t, chain, address, tip+1); stake != expected {
+		t.Errorf("质押权重为%d，应为%d", stake, expected)
+	}
+	if stake := testMatureStake(t, chain, to, tip+1); stake != 0 {
+		t.Errorf("刚收到的币不应计入质押权重，质押权重为%d", stake)
+	}
+	if stake := testMatureStake(t, chain, to, tip+depth); stake != 10*utils.COIN {
+		t.Errorf("成熟后的质押权重为%d，应为%d", stake, 10*utils.COIN)
+	}
+	if stake := testMatureStake(t, chain, address, tip+depth); stake != chain.GetBalance(address) {
+		t.Errorf("全部成熟后的质押权重为%d，应为余额%d", stake, chain.GetBalance(address))
+	}
+	genesis := wallet.GetAddressWithPubKHash(chainparams.Active.GenesisPubHash)
+	if stake := testMatureStake(t, chain, genesis, 1); stake != chain.GetBalance(genesis) || stake == 0 {
+		t.Errorf("创世区块的输出应直接计入质押权重，质押权重为%d", stake)
+	}
+}
+
+// 权益证明的出块者在父区块时必须有已成熟的余额
+func TestCheckBlockProducerStake(t *testing.T) {
+	chain, address := newTestChain(t)
+	to, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	depth := chainparams.Active.StakeMinDepth
+	a1 := mineTestBlock(t, chain.LastBlock, address)
+	acceptTestBlocks(t, chain, a1)
+	coinbase := transaction.NewUTXO(a1.Txs[0].TxHash, 0, a1.Txs[0].Outputs[0])
+	acceptTestBlocks(t, chain, mineTestBlock(t, chain.LastBlock, address, spendTestUTXOs(t, chain, []transaction.UTXO{coinbase}, address, to, 10*utils.COIN)))
+	params := ChainParams{Consensus: consensus.POS}
+	check := func(producer string) error {
+		block := signTestBlock(t, chain.LastBlock, producer, chain.Wallet.GetKeyPairByAddress(producer))
+		return chain.DB.View(func(tx *bolt.Tx) error {
+			return checkBlockProducer(tx, chain.UTXOSet.Cache, params, block)
+		})
+	}
+
+	if err := check(to); err == nil || !strings.Contains(err.Error(), "已成熟的余额不足") {
+		t.Errorf("只有未成熟utxo的出块者应被拒绝，结果为%v", err)
+	}
+	extendTestChain(t, chain, address, int(depth)-2)
+	if err := check(to); err == nil {
+		t.Error("差一个区块成熟时出块者应被拒绝")
+	}
+	extendTestChain(t, chain, address, 1)
+	if err := check(to); err != nil {
+		t.Errorf("utxo成熟后出块者应被接受：%v", err)
+	}
+}
+
+// 出块者公钥计入区块hash：更换出块者后原来的区块hash和签名都不再有效
+func TestSignedBlockCommitsProducer(t *testing.T) {
+	chain, address := newTestChain(t)
+	other, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := ChainParams{Consensus: consensus.POS}
+	block := signTestBlock(t, chain.LastBlock, address, chain.Wallet.GetKeyPairByAddress(address))
+	if err := checkBlockProof(params, block); err != nil {
+		t.Fatal(err)
+	}
+	data, err := block.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := UnSerialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hash != block.Hash || decoded.Hash == block.BlockHash() {
+		t.Error("反序列化得到的区块hash应包含出块者公钥")
+	}
+
+	swapped := block
+	swapped.Producer = chain.Wallet.GetKeyPairByAddress(other).Pub
+	if err := checkBlockProof(params, swapped); err == nil {
+		t.Error("更换出块者后原区块hash应校验失败")
+	}
+	swapped.Hash = swapped.CalculateHash()
+	if swapped.Hash == block.Hash {
+		t.Error("更换出块者后区块hash应改变")
+	}
+	if err := checkBlockProof(params, swapped); err == nil {
+		t.Error("更换出块者后原签名应校验失败")
+	}
+}
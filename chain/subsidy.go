@@ -0,0 +1,79 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"PublicChain/utxoset"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+/**
+ * 区块中coinbase交易的金额之和不能超过该高度的出块奖励加上区块中交易的手续费
+ */
+func checkCoinbaseValue(height int64, claimed int64, fees int64) error {
+	allowed := consensus.BlockSubsidy(height) + fees
+	if claimed > allowed {
+		return fmt.Errorf("coinbase交易的金额%s超过了出块奖励和手续费之和%s",
+			utils.FormatAmount(claimed), utils.FormatAmount(allowed))
+	}
+	return nil
+}
+
+/**
+ * 交易的手续费：输入金额减去输出金额。输入和输出的金额及其和都不能超出utils.MAXMONEY，输出之和不能大于输入之和
+ */
+func txFee(transac transaction.Transaction, spentUTXOs []transaction.UTXO) (int64, error) {
+	inputAmount, err := transaction.InputAmount(spentUTXOs)
+	if err != nil {
+		return 0, err
+	}
+	outputAmount, err := transac.OutputAmount()
+	if err != nil {
+		return 0, err
+	}
+	if outputAmount > inputAmount {
+		return 0, fmt.Errorf("交易%x的输出金额%s大于输入金额%s", transac.TxHash,
+			utils.FormatAmount(outputAmount), utils.FormatAmount(inputAmount))
+	}
+	return inputAmount - outputAmount, nil
+}
+
+/**
+ * 把一笔交易的手续费累加到区块的手续费之和上，之和不能超出utils.MAXMONEY
+ */
+func addFee(fees int64, fee int64) (int64, error) {
+	fees += fee
+	if !utils.MoneyRange(fees) {
+		return 0, errors.New("区块中交易的手续费之和超出范围")
+	}
+	return fees, nil
+}
+
+/**
+ * 一组待打包交易的手续费之和，交易可以花费排在它前面的交易的输出
+ */
+func sumTxFeesInTx(tx *bolt.Tx, coins *utxoset.CoinsCache, txs []transaction.Transaction) (int64, error) {
+	var fees int64
+	for position, transac := range txs {
+		spentUTXOs := make([]transaction.UTXO, 0)
+		for _, input := range transac.Inputs {
+			spent, err := lookupSpentUTXO(tx, coins, txs[:position], input)
+			if err != nil {
+				return 0, err
+			}
+			spentUTXOs = append(spentUTXOs, spent.UTXO)
+		}
+		fee, err := txFee(transac, spentUTXOs)
+		if err != nil {
+			return 0, err
+		}
+		fees, err = addFee(fees, fee)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return fees, nil
+}
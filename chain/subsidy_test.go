@@ -0,0 +1,128 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"bytes"
+	"context"
+	"testing"
+)
+
+// 在parent之后挖出一个coinbase交易金额为value的区块，只生成区块，不写入db
+func mineTestBlockWithCoinbase(t *testing.T, parent Block, value int64, address string, txs ...transaction.Transaction) Block {
+	t.Helper()
+	coinbase, err := transaction.NewCoinbaseTx(address, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := newBlock(parent.Height, parent.Hash, parent.Bits, parent.Timestamp+1, append([]transaction.Transaction{*coinbase}, txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mineBlock(context.Background(), block, func(block Block) consensus.Consensus {
+		return consensus.NewProofWork(block, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *block
+}
+
+// 用钱包中address的私钥花费utxo，输出金额为values，不检查金额
+func signTestOutputs(t *testing.T, chain *BlockChain, utxo transaction.UTXO, address string, values ...int64) transaction.Transaction {
+	t.Helper()
+	keyPair := chain.Wallet.GetKeyPairByAddress(address)
+	transac := transaction.Transaction{
+		Inputs:     []transaction.TxInput{transaction.NewTxInput(utxo.TxId, utxo.Vout, keyPair.Pub)},
+		Outputs:    []transaction.TxOutput{},
+		LockedTime: 1,
+	}
+	for _, value := range values {
+		transac.Outputs = append(transac.Outputs, transaction.Lock2Address(value, address))
+	}
+	var err error
+	transac.TxHash, err = transac.CalculateTxId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transac.Sign(keyPair.Pri, []transaction.UTXO{utxo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transac
+}
+
+// 输出金额之和溢出为负数时，输入减输出得到的手续费是一个很大的正数，coinbase交易不能借此领取
+func TestConnectBlockRejectsOverflowingOutputs(t *testing.T) {
+	cases := []struct {
+		name   string
+		values []int64
+	}{
+		{"输出之和溢出为负数", []int64{1<<63 - 1, 1<<62 + 1}},
+		{"两个输出之和溢出", []int64{1 << 62, 1 << 62}},
+		{"单个输出超过上限", []int64{utils.MAXMONEY + 1}},
+		{"输出金额为负数", []int64{-utils.COIN, utils.COIN}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chain, address := newTestChain(t)
+			a1 := mineTestBlock(t, chain.LastBlock, address)
+			acceptTestBlocks(t, chain, a1)
+			coinbase := transaction.NewUTXO(a1.Txs[0].TxHash, 0, a1.Txs[0].Outputs[0])
+
+			spend := signTestOutputs(t, chain, coinbase, address, c.values...)
+			claim := consensus.BlockSubsidy(a1.Height+1) + 1000*utils.COIN
+			bad := mineTestBlockWithCoinbase(t, a1, claim, address, spend)
+			if err := chain.ConnectBlock(bad); err == nil {
+				t.Fatal("输出金额超出范围的区块应连接失败")
+			}
+			if chain.LastBlock.Hash != a1.Hash {
+				t.Error("最新区块应仍为a1")
+			}
+			if !hasUTXO(t, chain, coinbase.TxId, coinbase.Vout) {
+				t.Error("被拒绝的交易花费的utxo应仍未花费")
+			}
+		})
+	}
+}
+
+func TestTxFee(t *testing.T) {
+	utxo := func(value int64) transaction.UTXO {
+		return transaction.NewUTXO([32]byte{1}, 0, transaction.TxOutput{Value: value, PubHash: bytes.Repeat([]byte{1}, 21)})
+	}
+	output := func(value int64) transaction.TxOutput {
+		return transaction.TxOutput{Value: value, PubHash: bytes.Repeat([]byte{2}, 21)}
+	}
+	cases := []struct {
+		name    string
+		inputs  []transaction.UTXO
+		outputs []transaction.TxOutput
+		fee     int64
+		valid   bool
+	}{
+		{"没有手续费", []transaction.UTXO{utxo(10)}, []transaction.TxOutput{output(10)}, 0, true},
+		{"有手续费", []transaction.UTXO{utxo(10), utxo(5)}, []transaction.TxOutput{output(12)}, 3, true},
+		{"输出大于输入", []transaction.UTXO{utxo(10)}, []transaction.TxOutput{output(11)}, 0, false},
+		{"输出之和溢出", []transaction.UTXO{utxo(10)}, []transaction.TxOutput{output(1<<63 - 1), output(1<<62 + 1)}, 0, false},
+		{"输入超过上限", []transaction.UTXO{utxo(utils.MAXMONEY + 1)}, []transaction.TxOutput{output(1)}, 0, false},
+	}
+	for _, c := range cases {
+		transac := transaction.Transaction{Outputs: c.outputs}
+		fee, err := txFee(transac, c.inputs)
+		if (err == nil) != c.valid {
+			t.Errorf("%s：结果为%v，应为%v", c.name, err, c.valid)
+			continue
+		}
+		if c.valid && fee != c.fee {
+			t.Errorf("%s：手续费为%d，应为%d", c.name, fee, c.fee)
+		}
+	}
+
+	if _, err := addFee(utils.MAXMONEY, 1); err == nil {
+		t.Error("手续费之和超过上限应报错")
+	}
+	if fees, err := addFee(utils.MAXMONEY-1, 1); err != nil || fees != utils.MAXMONEY {
+		t.Errorf("手续费之和为%d：%v，应为%d", fees, err, utils.MAXMONEY)
+	}
+}
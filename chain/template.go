@@ -0,0 +1,98 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"errors"
+	"github.com/boltdb/bolt"
+	"math/big"
+)
+
+// 外部矿工挖出的区块使用的版本号，与本节点挖出的区块一致
+const TEMPLATEVERSION int32 = 0x00
+
+/**
+ * getblocktemplate的返回结果：外部矿工据此自行构建coinbase交易，计算默克尔根后搜索nonce
+ */
+type BlockTemplate struct {
+	Version       int32                     // 区块头的版本号
+	Height        int64                     // 新区块的高度
+	PreHash       [32]byte                  // 父区块hash，即主链最新区块的hash
+	Bits          uint32                    // 新区块应使用的难度目标
+	Target        *big.Int                  // 难度目标展开后的目标值，区块hash必须不大于该值
+	CurTime       int64                     // 生成模板时的时间，可以作为区块的时间戳
+	MinTime       int64                     // 区块时间戳的最小值，即前11个区块时间戳的中位数加一
+	CoinbaseValue int64                     // coinbase交易最多可以获得的金额，即出块奖励加上手续费
+	Fees          int64                     // 模板中交易的手续费之和
+	Txs           []transaction.Transaction // 从交易池中选出的交易，排在coinbase交易之后
+}
+
+/**
+ * 生成下一个区块的模板，供外部矿工挖矿。只有工作量证明的链可以由外部矿工挖矿
+ */
+func (chain *BlockChain) GetBlockTemplate() (*BlockTemplate, error) {
+	if chain.LastBlock.Hash == [32]byte{} {
+		return nil, errors.New("还没有创世区块，请先执行generategenesis")
+	}
+	if chain.Params.Consensus != consensus.POW {
+		return nil, errors.New("只有工作量证明的链可以由外部矿工挖矿")
+	}
+	bits, err := chain.GetNextBits()
+	if err != nil {
+		return nil, err
+	}
+	txs, fees, err := chain.selectMempoolTxs()
+	if err != nil {
+		return nil, err
+	}
+	median, err := chain.medianTimePast()
+	if err != nil {
+		return nil, err
+	}
+	curTime, err := chain.nextBlockTime()
+	if err != nil {
+		return nil, err
+	}
+	return &BlockTemplate{
+		Version:       TEMPLATEVERSION,
+		Height:        chain.LastBlock.Height + 1,
+		PreHash:       chain.LastBlock.Hash,
+		Bits:          bits,
+		Target:        consensus.CompactToTarget(bits),
+		CurTime:       curTime,
+		MinTime:       median + 1,
+		CoinbaseValue: consensus.BlockSubsidy(chain.LastBlock.Height+1) + fees,
+		Fees:          fees,
+		Txs:           txs,
+	}, nil
+}
+
+/**
+ * 接收外部矿工挖出的区块：按接收区块的流程检查和保存，并切换到累计工作量最大的链。
+ * 返回区块是否成为了主链的最新区块，区块在分叉上时也会保存下来
+ */
+func (chain *BlockChain) SubmitBlock(block Block) (bool, error) {
+	if chain.LastBlock.Hash == [32]byte{} {
+		return false, errors.New("还没有创世区块，请先执行generategenesis")
+	}
+	err := chain.AcceptBlock(block)
+	if err != nil {
+		return false, err
+	}
+	var status int
+	err = chain.DB.View(func(tx *bolt.Tx) error {
+		index, err := getBlockIndex(tx, block.Hash)
+		if err != nil {
+			return err
+		}
+		status = index.Status
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if status == BLOCKINVALID {
+		return false, errors.New("区块连接到主链时验证失败，已标记为无效")
+	}
+	return chain.LastBlock.Hash == block.Hash, nil
+}
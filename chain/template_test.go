@@ -0,0 +1,120 @@
+package chain
+
+import (
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"context"
+	"testing"
+)
+
+// 按区块模板构建并挖出区块：coinbase交易领取模板中的金额，交易按模板的顺序排在coinbase之后
+func mineTemplateBlock(t *testing.T, template *BlockTemplate, address string, value int64) Block {
+	t.Helper()
+	coinbase, err := transaction.NewCoinbaseTx(address, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := newBlock(template.Height-1, template.PreHash, template.Bits, template.CurTime, append([]transaction.Transaction{*coinbase}, template.Txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mineBlock(context.Background(), block, func(block Block) consensus.Consensus {
+		return consensus.NewProofWork(block, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *block
+}
+
+// 模板包含交易池中的交易，coinbase金额为出块奖励加手续费；按模板挖出的区块提交后成为主链的最新区块
+func TestBlockTemplateAndSubmit(t *testing.T) {
+	chain, address := newTestChain(t)
+	to, err := chain.GetNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := mineTestBlock(t, chain.LastBlock, address)
+	acceptTestBlocks(t, chain, a1)
+	coinbase := transaction.NewUTXO(a1.Txs[0].TxHash, 0, a1.Txs[0].Outputs[0])
+	fee := utils.COIN / 10
+	spend, err := newSignedTransaction([]transaction.UTXO{coinbase}, address, to, 10*utils.COIN, fee, chain.Wallet.GetKeyPairByAddress(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = chain.Mempool.AcceptTxs([]transaction.Transaction{*spend}, chain.LastBlock.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := chain.GetBlockTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, err := chain.GetNextBits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	median := testMedianTimePast(t, chain)
+	if template.Height != 2 || template.PreHash != a1.Hash || template.Bits != bits || template.MinTime != median+1 || template.CurTime < template.MinTime {
+		t.Errorf("区块模板为%+v", template)
+	}
+	if template.Fees != fee || template.CoinbaseValue != consensus.BlockSubsidy(2)+fee {
+		t.Errorf("手续费为%d，coinbase金额为%d", template.Fees, template.CoinbaseValue)
+	}
+	if len(template.Txs) != 1 || template.Txs[0].TxHash != spend.TxHash {
+		t.Fatalf("模板中的交易为%d笔", len(template.Txs))
+	}
+
+	// coinbase金额超过模板中的金额
+	greedy := mineTemplateBlock(t, template, address, template.CoinbaseValue+1)
+	isTip, err := chain.SubmitBlock(greedy)
+	if err == nil || isTip {
+		t.Fatal("coinbase金额超过出块奖励加手续费的区块不应被接收")
+	}
+	if chain.LastBlock.Hash != a1.Hash {
+		t.Fatal("无效的区块不应成为最新区块")
+	}
+
+	block := mineTemplateBlock(t, template, address, template.CoinbaseValue)
+	isTip, err = chain.SubmitBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isTip || chain.LastBlock.Hash != block.Hash {
+		t.Fatal("按模板挖出的区块应成为最新区块")
+	}
+	if txids := poolTestTxids(t, chain); len(txids) != 0 {
+		t.Errorf("已打包的交易应从交易池中删除，还剩%d笔", len(txids))
+	}
+}
+
+// 提交分叉上的区块时保存下来，但不成为最新区块
+func TestSubmitForkBlock(t *testing.T) {
+	chain, address := newTestChain(t)
+	genesis := chain.LastBlock
+	extendTestChain(t, chain, address, 2)
+	tip := chain.LastBlock
+	fork := mineTestBlock(t, genesis, address)
+	isTip, err := chain.SubmitBlock(fork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isTip || chain.LastBlock.Hash != tip.Hash {
+		t.Error("工作量较小的分叉上的区块不应成为最新区块")
+	}
+	_, err = chain.GetBlockByHash(fork.Hash)
+	if err != nil {
+		t.Errorf("分叉上的区块应保存下来：%v", err)
+	}
+}
+
+// 只有工作量证明的链可以由外部矿工挖矿
+func TestBlockTemplateRequiresProofWork(t *testing.T) {
+	chain, _ := newTestAuthorityChain(t, 1)
+	_, err := chain.GetBlockTemplate()
+	if err == nil {
+		t.Error("权威证明的链不应生成区块模板")
+	}
+}
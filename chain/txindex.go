@@ -0,0 +1,139 @@
+package chain
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"encoding/binary"
+	"errors"
+	"github.com/boltdb/bolt"
+)
+
+// 交易索引：交易hash -> 区块hash(32字节) + 交易在区块中的位置(8字节)
+// 该索引是可选的，只有当bucket存在(执行过buildtxindex)时才会在连接区块时写入
+const TXINDEX = "txindex"
+
+/**
+ * 交易查询结果，包含交易本身以及所在区块的信息
+ */
+type TxInfo struct {
+	Tx            transaction.Transaction
+	BlockHash     [32]byte
+	Height        int64
+	Position      int64 // 交易在区块中的序号
+	Confirmations int64 // 确认数，最新区块中的交易确认数为1
+}
+
+/**
+ * 将区块中的每一笔交易写入交易索引
+ */
+func putTxIndex(bucket *bolt.Bucket, block Block) error {
+	for index, tx := range block.Txs {
+		positionBytes, err := utils.IntToByte(int64(index))
+		if err != nil {
+			return err
+		}
+		value := append(block.Hash[:], positionBytes...)
+		err = bucket.Put(tx.TxHash[:], value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 区块从主链断开时，删除区块中交易的索引
+ */
+func deleteTxIndex(bucket *bolt.Bucket, block Block) error {
+	for _, tx := range block.Txs {
+		err := bucket.Delete(tx.TxHash[:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 判断交易索引是否已开启
+ */
+func (chain *BlockChain) IsTxIndexEnabled() bool {
+	enabled := false
+	chain.DB.View(func(tx *bolt.Tx) error {
+		enabled = tx.Bucket([]byte(TXINDEX)) != nil
+		return nil
+	})
+	return enabled
+}
+
+/**
+ * 开启交易索引，并按高度从创世区块到最新区块为已有的区块补建索引，返回建立索引的交易数量
+ */
+func (chain *BlockChain) BuildTxIndex() (int, error) {
+	count := 0
+	err := chain.DB.Update(func(tx *bolt.Tx) error {
+		blocksBucket := tx.Bucket([]byte(BUCKERNAME))
+		heightBucket := tx.Bucket([]byte(HEIGHTINDEX))
+		if blocksBucket == nil || heightBucket == nil {
+			return errors.New("区块数据区操作失败")
+		}
+		//重新建立，避免残留旧的数据
+		if tx.Bucket([]byte(TXINDEX)) != nil {
+			err := tx.DeleteBucket([]byte(TXINDEX))
+			if err != nil {
+				return err
+			}
+		}
+		indexBucket, err := tx.CreateBucket([]byte(TXINDEX))
+		if err != nil {
+			return err
+		}
+		return heightBucket.ForEach(func(heightBytes, hash []byte) error {
+			var blockHash [32]byte
+			copy(blockHash[:], hash)
+			block, err := getBlockInTx(tx, blockHash)
+			if err != nil {
+				return errors.New("区块数据不完整，无法建立交易索引")
+			}
+			count += len(block.Txs)
+			return putTxIndex(indexBucket, block)
+		})
+	})
+	return count, err
+}
+
+/**
+ * 根据交易hash，通过交易索引查询交易及其所在区块、高度和确认数
+ */
+func (chain *BlockChain) GetTransaction(txid [32]byte) (*TxInfo, error) {
+	var info *TxInfo
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		indexBucket := tx.Bucket([]byte(TXINDEX))
+		if indexBucket == nil {
+			return errors.New("交易索引未开启，请先执行buildtxindex")
+		}
+		location := indexBucket.Get(txid[:])
+		if len(location) != 40 {
+			return errors.New("未找到该交易")
+		}
+		var blockHash [32]byte
+		copy(blockHash[:], location[:32])
+		block, err := getBlockInTx(tx, blockHash)
+		if err != nil {
+			return err
+		}
+		position := int64(binary.BigEndian.Uint64(location[32:]))
+		if position >= int64(len(block.Txs)) {
+			return errors.New("交易索引数据有误")
+		}
+		info = &TxInfo{
+			Tx:            block.Txs[position],
+			BlockHash:     block.Hash,
+			Height:        block.Height,
+			Position:      position,
+			Confirmations: chain.LastBlock.Height - block.Height + 1,
+		}
+		return nil
+	})
+	return info, err
+}
@@ -0,0 +1,59 @@
+package chain
+
+import "testing"
+
+// 开启交易索引时为已有区块补建索引，之后连接的区块自动写入索引，断开的区块中的交易从索引中删除
+func TestTxIndex(t *testing.T) {
+	chain, address := newTestChain(t)
+	if chain.IsTxIndexEnabled() {
+		t.Fatal("新建的链不应开启交易索引")
+	}
+	_, err := chain.GetTransaction(chain.LastBlock.Txs[0].TxHash)
+	if err == nil {
+		t.Fatal("交易索引未开启时查询交易应返回错误")
+	}
+	a1 := mineTestBlock(t, chain.LastBlock, address)
+	acceptTestBlocks(t, chain, a1)
+
+	count, err := chain.BuildTxIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || !chain.IsTxIndexEnabled() {
+		t.Fatalf("为%d笔交易建立了索引，应为2笔", count)
+	}
+	info, err := chain.GetTransaction(a1.Txs[0].TxHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Tx.TxHash != a1.Txs[0].TxHash || info.BlockHash != a1.Hash || info.Height != 1 || info.Position != 0 || info.Confirmations != 1 {
+		t.Errorf("交易信息为%+v", info)
+	}
+
+	a2 := mineTestBlock(t, a1, address)
+	acceptTestBlocks(t, chain, a2)
+	info, err = chain.GetTransaction(a2.Txs[0].TxHash)
+	if err != nil {
+		t.Fatalf("新连接的区块中的交易没有写入索引：%v", err)
+	}
+	if info.BlockHash != a2.Hash || info.Height != 2 {
+		t.Errorf("交易信息为%+v", info)
+	}
+	info, err = chain.GetTransaction(a1.Txs[0].TxHash)
+	if err != nil || info.Confirmations != 2 {
+		t.Errorf("a1中交易的确认数为%+v，应为2", info)
+	}
+
+	err = chain.InvalidateBlock(a2.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chain.GetTransaction(a2.Txs[0].TxHash)
+	if err == nil {
+		t.Error("断开的区块中的交易应从索引中删除")
+	}
+	_, err = chain.GetTransaction(a1.Txs[0].TxHash)
+	if err != nil {
+		t.Error(err)
+	}
+}
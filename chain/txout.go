@@ -0,0 +1,71 @@
+package chain
+
+import (
+	"PublicChain/mempool"
+	"PublicChain/transaction"
+	"PublicChain/wallet"
+	"errors"
+	"github.com/boltdb/bolt"
+)
+
+/**
+ * 单个未花费交易输出的查询结果
+ */
+type TxOutInfo struct {
+	UTXO          transaction.UTXO
+	Owner         string // 输出所属的地址
+	Height        int64  // 所在区块的高度，在交易池中时为-1
+	Confirmations int64  // 确认数，在交易池中时为0
+	Coinbase      bool   // 是否为coinbase交易的输出
+}
+
+/**
+ * 查询一个未花费的交易输出，已被花费或者不存在时返回nil。
+ * includeMempool为true时，被池中交易花费的输出视为已花费，池中交易的输出也可以查到
+ */
+func (chain *BlockChain) GetTxOut(txid [32]byte, vout int, includeMempool bool) (*TxOutInfo, error) {
+	if vout < 0 {
+		return nil, errors.New("输出序号不能为负数")
+	}
+	var info *TxOutInfo
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		if includeMempool {
+			_, spent, err := mempool.GetSpenderInTx(tx, txid, vout)
+			if err != nil || spent {
+				return err
+			}
+			utxo, err := mempool.GetOutputInTx(tx, txid, vout)
+			if err != nil {
+				return err
+			}
+			if utxo != nil {
+				info = &TxOutInfo{
+					UTXO:   *utxo,
+					Owner:  wallet.GetAddressWithPubKHash(utxo.PubHash),
+					Height: -1,
+				}
+				return nil
+			}
+		}
+		utxo, err := chain.UTXOSet.Cache.GetUTXO(tx, txid, vout)
+		if err != nil || utxo == nil {
+			return err
+		}
+		height, position, err := findCreditInTx(tx, *utxo)
+		if err != nil {
+			return err
+		}
+		info = &TxOutInfo{
+			UTXO:          *utxo,
+			Owner:         wallet.GetAddressWithPubKHash(utxo.PubHash),
+			Height:        height,
+			Confirmations: chain.LastBlock.Height - height + 1,
+			Coinbase:      position == 0,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
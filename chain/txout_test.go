@@ -0,0 +1,62 @@
+package chain
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"testing"
+)
+
+// 查询未花费的交易输出：已花费的查不到，includeMempool为true时池中交易花费的输出视为已花费，池中交易的输出可以查到
+func TestGetTxOut(t *testing.T) {
+	chain, address := newTestChain(t)
+	a1, a2, to := spendTestChain(t, chain, address)
+	_, err := chain.GetTxOut(a2.Txs[1].TxHash, -1, false)
+	if err == nil {
+		t.Error("输出序号为负数时应返回错误")
+	}
+	info, err := chain.GetTxOut(a1.Txs[0].TxHash, 0, false)
+	if err != nil || info != nil {
+		t.Errorf("已花费的输出查询结果为%+v，%v", info, err)
+	}
+	info, err = chain.GetTxOut(a2.Txs[1].TxHash, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.Owner != to || info.UTXO.Value != 10*utils.COIN || info.Height != 2 || info.Confirmations != 1 || info.Coinbase {
+		t.Fatalf("转账输出的查询结果为%+v", info)
+	}
+	extendTestChain(t, chain, address, 1)
+	info, err = chain.GetTxOut(a2.Txs[0].TxHash, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.Owner != address || info.Height != 2 || info.Confirmations != 2 || !info.Coinbase {
+		t.Fatalf("coinbase输出的查询结果为%+v", info)
+	}
+
+	output := transaction.NewUTXO(a2.Txs[1].TxHash, 0, a2.Txs[1].Outputs[0])
+	pending := spendTestUTXOs(t, chain, []transaction.UTXO{output}, to, address, 5*utils.COIN)
+	err = chain.Mempool.AcceptTxs([]transaction.Transaction{pending}, chain.LastBlock.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = chain.GetTxOut(output.TxId, output.Vout, false)
+	if err != nil || info == nil {
+		t.Errorf("不考虑交易池时，被池中交易花费的输出应可以查到：%+v，%v", info, err)
+	}
+	info, err = chain.GetTxOut(output.TxId, output.Vout, true)
+	if err != nil || info != nil {
+		t.Errorf("被池中交易花费的输出应视为已花费：%+v，%v", info, err)
+	}
+	info, err = chain.GetTxOut(pending.TxHash, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.Height != -1 || info.Confirmations != 0 || info.Owner != address {
+		t.Errorf("池中交易的输出查询结果为%+v", info)
+	}
+	info, err = chain.GetTxOut(pending.TxHash, 0, false)
+	if err != nil || info != nil {
+		t.Errorf("不考虑交易池时，池中交易的输出不应查到：%+v，%v", info, err)
+	}
+}
@@ -0,0 +1,222 @@
+package chain
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"PublicChain/utxoset"
+	"PublicChain/wallet"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// 区块的撤销数据：区块hash -> BlockUndo
+const UNDOBUCKET = "undo"
+
+/**
+ * 被花费掉的utxo以及它的所有者地址
+ */
+type SpentUTXO struct {
+	Owner string
+	UTXO  transaction.UTXO
+}
+
+/**
+ * 区块的撤销数据：区块中所有交易花费掉的utxo，按交易和交易输入的顺序排列。
+ * 区块从主链断开时，依据撤销数据把花费掉的utxo原样加回utxo集合
+ */
+type BlockUndo struct {
+	SpentUTXOs []SpentUTXO
+}
+
+/**
+ * 查找交易输入所花费的utxo：先在同一区块中该交易之前的交易里找，再到utxo集合中找
+ */
+func lookupSpentUTXO(tx *bolt.Tx, coins *utxoset.CoinsCache, prevTxs []transaction.Transaction, input transaction.TxInput) (*SpentUTXO, error) {
+	address, err := wallet.NewAddress(input.Pubk)
+	if err != nil {
+		return nil, err
+	}
+	for _, prevTx := range prevTxs {
+		if prevTx.TxHash != input.Txid || input.Vout >= len(prevTx.Outputs) {
+			continue
+		}
+		utxo := transaction.NewUTXO(prevTx.TxHash, input.Vout, prevTx.Outputs[input.Vout])
+		if utxo.IsSpent(input) {
+			return &SpentUTXO{Owner: address, UTXO: utxo}, nil
+		}
+	}
+	utxo, err := coins.GetUTXO(tx, input.Txid, input.Vout)
+	if err != nil {
+		return nil, err
+	}
+	if utxo != nil && utxo.IsSpent(input) {
+		return &SpentUTXO{Owner: address, UTXO: *utxo}, nil
+	}
+	return nil, fmt.Errorf("交易输入花费的utxo%x:%d不存在", input.Txid, input.Vout)
+}
+
+func putBlockUndo(tx *bolt.Tx, hash [32]byte, undo BlockUndo) error {
+	bucket := tx.Bucket([]byte(UNDOBUCKET))
+	if bucket == nil {
+		var err error
+		bucket, err = tx.CreateBucket([]byte(UNDOBUCKET))
+		if err != nil {
+			return err
+		}
+	}
+	undoBytes, err := utils.GobEncode(undo)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(hash[:], undoBytes)
+}
+
+/**
+ * 读取区块的撤销数据，旧版本连接的区块没有撤销数据时返回nil
+ */
+func getBlockUndo(tx *bolt.Tx, hash [32]byte) (*BlockUndo, error) {
+	bucket := tx.Bucket([]byte(UNDOBUCKET))
+	if bucket == nil {
+		return nil, nil
+	}
+	undoBytes := bucket.Get(hash[:])
+	if len(undoBytes) == 0 {
+		return nil, nil
+	}
+	var undo BlockUndo
+	_, err := utils.GodDecode(undoBytes, &undo)
+	return &undo, err
+}
+
+func deleteBlockUndo(tx *bolt.Tx, hash [32]byte) error {
+	bucket := tx.Bucket([]byte(UNDOBUCKET))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Delete(hash[:])
+}
+
+/**
+ * 读取区块的撤销数据。没有撤销数据时，只有不花费任何utxo的区块(例如创世区块)可以得到空的撤销数据，
+ * 其他区块返回错误，需要执行reindex-chainstate从区块重建
+ */
+func requireBlockUndo(tx *bolt.Tx, block Block) (*BlockUndo, error) {
+	undo, err := getBlockUndo(tx, block.Hash)
+	if err != nil || undo != nil {
+		return undo, err
+	}
+	for _, transac := range block.Txs {
+		if len(transac.Inputs) > 0 {
+			return nil, fmt.Errorf("区块%x没有撤销数据，请执行reindex-chainstate重建", block.Hash)
+		}
+	}
+	return &BlockUndo{SpentUTXOs: make([]SpentUTXO, 0)}, nil
+}
+
+/**
+ * 将某个区块标记为无效：如果它在主链上，则把主链回退到它的父区块，然后重新选择累计工作量最大的有效链
+ */
+func (chain *BlockChain) InvalidateBlock(hash [32]byte) error {
+	err := chain.updateCoins(func(tx *bolt.Tx) error {
+		index, err := getBlockIndex(tx, hash)
+		if err != nil {
+			return err
+		}
+		if index.Height == 0 {
+			return errors.New("不能将创世区块标记为无效")
+		}
+		onActiveChain := isOnActiveChain(tx, *index)
+		index.Status = BLOCKINVALID
+		err = putBlockIndex(tx, *index)
+		if err != nil {
+			return err
+		}
+		if !onActiveChain {
+			return nil
+		}
+		tip := chain.LastBlock
+		for tip.Height >= index.Height {
+			err = disconnectBlock(tx, chain.UTXOSet.Cache, tip)
+			if err != nil {
+				return err
+			}
+			tip, err = getBlockInTx(tx, tip.PreHash)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = chain.loadLastBlock()
+	if err != nil {
+		return err
+	}
+	return chain.ActivateBestChain()
+}
+
+/**
+ * 撤销对某个区块的无效标记，它的后代区块以及其祖先区块上的无效标记也一并撤销，然后重新选择最优链
+ */
+func (chain *BlockChain) ReconsiderBlock(hash [32]byte) error {
+	err := chain.DB.Update(func(tx *bolt.Tx) error {
+		target, err := getBlockIndex(tx, hash)
+		if err != nil {
+			return err
+		}
+		invalids := make([]BlockIndex, 0)
+		err = tx.Bucket([]byte(BLOCKINDEX)).ForEach(func(key, value []byte) error {
+			var index BlockIndex
+			_, err := utils.GodDecode(value, &index)
+			if err != nil {
+				return err
+			}
+			if index.Status == BLOCKINVALID {
+				invalids = append(invalids, index)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, index := range invalids {
+			related, err := isRelatedBlock(tx, *target, index)
+			if err != nil {
+				return err
+			}
+			if !related {
+				continue
+			}
+			index.Status = BLOCKVALID
+			err = putBlockIndex(tx, index)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return chain.ActivateBestChain()
+}
+
+/**
+ * 判断两个区块是否在同一条链上，即一个是另一个的祖先(或者是同一个区块)
+ */
+func isRelatedBlock(tx *bolt.Tx, a BlockIndex, b BlockIndex) (bool, error) {
+	if a.Height > b.Height {
+		a, b = b, a
+	}
+	for b.Height > a.Height {
+		parent, err := getBlockIndex(tx, b.PreHash)
+		if err != nil {
+			return false, err
+		}
+		b = *parent
+	}
+	return a.Hash == b.Hash, nil
+}
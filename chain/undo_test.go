@@ -0,0 +1,114 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func testBlockUndo(t *testing.T, chain *BlockChain, hash [32]byte) *BlockUndo {
+	t.Helper()
+	var undo *BlockUndo
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		undo, err = getBlockUndo(tx, hash)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return undo
+}
+
+// 连接区块时记录被花费的utxo，区块断开后撤销数据随之删除
+func TestBlockUndo(t *testing.T) {
+	chain, address := newTestChain(t)
+	a1, a2, _ := spendTestChain(t, chain, address)
+
+	undo := testBlockUndo(t, chain, a1.Hash)
+	if undo == nil || len(undo.SpentUTXOs) != 0 {
+		t.Errorf("a1的撤销数据为%+v，应为空", undo)
+	}
+	undo = testBlockUndo(t, chain, a2.Hash)
+	if undo == nil || len(undo.SpentUTXOs) != 1 {
+		t.Fatalf("a2的撤销数据为%+v，应有1个被花费的utxo", undo)
+	}
+	spent := undo.SpentUTXOs[0]
+	if spent.Owner != address || spent.UTXO.TxId != a1.Txs[0].TxHash || spent.UTXO.Vout != 0 || spent.UTXO.Value != a1.Txs[0].Outputs[0].Value {
+		t.Errorf("被花费的utxo为%+v", spent)
+	}
+
+	err := chain.InvalidateBlock(a2.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if undo := testBlockUndo(t, chain, a2.Hash); undo != nil {
+		t.Error("a2断开后撤销数据应被删除")
+	}
+}
+
+// 无效标记让主链回退并拒绝无效区块的子区块，撤销标记后重新切换到累计工作量最大的链
+func TestInvalidateAndReconsiderBlock(t *testing.T) {
+	chain, address := newTestChain(t)
+	genesis := chain.LastBlock
+	err := chain.InvalidateBlock(genesis.Hash)
+	if err == nil {
+		t.Fatal("不能将创世区块标记为无效")
+	}
+	a1, a2, _ := spendTestChain(t, chain, address)
+	spend := a2.Txs[1]
+
+	err = chain.InvalidateBlock(a2.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.LastBlock.Hash != a1.Hash {
+		t.Fatalf("主链应回退到a1，实际为高度%d", chain.LastBlock.Height)
+	}
+	if !hasUTXO(t, chain, a1.Txs[0].TxHash, 0) {
+		t.Error("a2断开后它花费的utxo应被加回")
+	}
+	if hasUTXO(t, chain, spend.TxHash, 0) || hasUTXO(t, chain, a2.Txs[0].TxHash, 0) {
+		t.Error("a2断开后它产生的utxo应被删除")
+	}
+	txids := poolTestTxids(t, chain)
+	if len(txids) != 1 || txids[0] != spend.TxHash {
+		t.Errorf("a2中的交易应放回交易池，交易池中为%x", txids)
+	}
+
+	// 无效区块的子区块不能接收
+	a3 := mineTestBlock(t, a2, address)
+	err = chain.AcceptBlock(a3)
+	if err == nil || chain.LastBlock.Hash != a1.Hash {
+		t.Fatal("父区块无效的区块不应被接收")
+	}
+	tips, err := chain.GetChainTips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[[32]byte]string)
+	for _, tip := range tips {
+		statuses[tip.Hash] = tip.Status
+	}
+	if statuses[a1.Hash] != TIPACTIVE || statuses[a2.Hash] != TIPINVALID {
+		t.Errorf("链末端的状态为%v", statuses)
+	}
+
+	err = chain.ReconsiderBlock(a2.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.LastBlock.Hash != a2.Hash {
+		t.Fatalf("撤销无效标记后应切换回a2，实际为高度%d", chain.LastBlock.Height)
+	}
+	if hasUTXO(t, chain, a1.Txs[0].TxHash, 0) || !hasUTXO(t, chain, spend.TxHash, 0) {
+		t.Error("重新连接a2后utxo集合不正确")
+	}
+	if txids := poolTestTxids(t, chain); len(txids) != 0 {
+		t.Errorf("重新连接a2后交易池中还有%d笔交易", len(txids))
+	}
+	acceptTestBlocks(t, chain, a3)
+	if chain.LastBlock.Hash != a3.Hash {
+		t.Error("撤销无效标记后应可以接收a2的子区块")
+	}
+}
@@ -0,0 +1,194 @@
+package chain
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// verifychain的检查级别，级别越高检查越多，每一级都包含之前级别的检查
+const (
+	VERIFYLINK      = 0 // 区块能正常读取，高度连续，PreHash与前一个区块相连
+	VERIFYPOW       = 1 // 重新计算区块hash，检查工作量证明或出块者的签名
+	VERIFYMERKLE    = 2 // 重新计算默克尔根，检查coinbase交易的位置和金额
+	VERIFYSIGNATURE = 3 // 依据撤销数据找到花费的utxo，检查每一笔交易的签名
+	VERIFYVALUE     = 4 // 检查交易的输入金额不小于输出金额，coinbase不超过出块奖励加上手续费
+)
+
+/**
+ * 重新验证db中主链最新的depth个区块(depth为0时验证整条链)，返回检查的区块数量。
+ * 按高度从低到高检查，遇到第一个有问题的区块即返回错误，错误中包含区块高度、hash和原因
+ */
+func (chain *BlockChain) VerifyChain(depth int64, level int) (int64, error) {
+	if level < VERIFYLINK || level > VERIFYVALUE {
+		return 0, errors.New("检查级别应为0到4")
+	}
+	if depth < 0 {
+		return 0, errors.New("检查的区块数量不能为负数")
+	}
+	tip := chain.LastBlock.Height
+	start := int64(0)
+	if depth > 0 && tip-depth+1 > 0 {
+		start = tip - depth + 1
+	}
+	var checked int64
+	err := chain.DB.View(func(tx *bolt.Tx) error {
+		blocksBucket := tx.Bucket([]byte(BUCKERNAME))
+		if blocksBucket == nil {
+			return errors.New("区块数据区操作失败")
+		}
+		params, err := getChainParamsInTx(tx)
+		if err != nil {
+			return err
+		}
+		var preHash [32]byte
+		if start > 0 {
+			hash, err := getHeightHash(tx, start-1)
+			if err != nil {
+				return err
+			}
+			preHash = hash
+		}
+		for height := start; height <= tip; height++ {
+			hash, err := getHeightHash(tx, height)
+			if err != nil {
+				return fmt.Errorf("区块%d：%s", height, err.Error())
+			}
+			block, err := getBlockInTx(tx, hash)
+			if err != nil {
+				return fmt.Errorf("区块%d(%x)：无法读取区块数据，%s", height, hash, err.Error())
+			}
+			err = verifyBlock(tx, params, block, hash, height, preHash, level)
+			if err != nil {
+				return fmt.Errorf("区块%d(%x)：%s", height, hash, err.Error())
+			}
+			preHash = block.Hash
+			checked++
+		}
+		if !bytes.Equal(blocksBucket.Get([]byte(LASTHASH)), preHash[:]) {
+			return errors.New("最新区块标记与主链末端不一致")
+		}
+		return nil
+	})
+	return checked, err
+}
+
+/**
+ * 根据高度从高度索引中取出区块hash
+ */
+func getHeightHash(tx *bolt.Tx, height int64) ([32]byte, error) {
+	var hash [32]byte
+	bucket := tx.Bucket([]byte(HEIGHTINDEX))
+	if bucket == nil {
+		return hash, errors.New("区块高度索引不存在")
+	}
+	heightBytes, err := utils.IntToByte(height)
+	if err != nil {
+		return hash, err
+	}
+	hashBytes := bucket.Get(heightBytes)
+	if len(hashBytes) != 32 {
+		return hash, errors.New("高度索引中缺少该高度的区块")
+	}
+	copy(hash[:], hashBytes)
+	return hash, nil
+}
+
+/**
+ * 按检查级别验证单个区块。权益证明的出块资格和权威证明的出块顺序与当时的链上状态有关，这里只检查签名
+ */
+func verifyBlock(tx *bolt.Tx, params ChainParams, block Block, hash [32]byte, height int64, preHash [32]byte, level int) error {
+	if block.Hash != hash {
+		return errors.New("区块中记录的hash与存储的key不一致")
+	}
+	if block.Height != height {
+		return fmt.Errorf("区块中记录的高度为%d，与高度索引不一致", block.Height)
+	}
+	if block.PreHash != preHash {
+		return fmt.Errorf("PreHash为%x，与前一个区块不相连", block.PreHash)
+	}
+	if level < VERIFYPOW {
+		return nil
+	}
+	err := checkBlockProof(params, block)
+	if err != nil {
+		return err
+	}
+	err = checkBlockBits(tx, block)
+	if err != nil {
+		return err
+	}
+	if level < VERIFYMERKLE {
+		return nil
+	}
+	if len(block.Txs) == 0 {
+		return errors.New("区块中没有交易")
+	}
+	// 旧版本生成的创世区块没有记录默克尔根，跳过该项检查
+	if height > 0 || block.MerkleRoot != [32]byte{} {
+		root, err := calculateMerkleRoot(block.Txs)
+		if err != nil {
+			return err
+		}
+		if root != block.MerkleRoot {
+			return fmt.Errorf("默克尔根为%x，重新计算得到%x", block.MerkleRoot, root)
+		}
+	}
+	err = checkBlockCoinbase(block)
+	if err != nil {
+		return err
+	}
+	if level < VERIFYSIGNATURE {
+		return nil
+	}
+	undo, err := requireBlockUndo(tx, block)
+	if err != nil {
+		return err
+	}
+	spents := undo.SpentUTXOs
+	var claimed, fees int64
+	for _, transac := range block.Txs {
+		if transac.IsCoinbaseTranaction() {
+			amount, err := transac.OutputAmount()
+			if err != nil {
+				return err
+			}
+			claimed = amount
+			continue
+		}
+		if len(spents) < len(transac.Inputs) {
+			return errors.New("撤销数据与区块中的交易输入不一致")
+		}
+		spentUTXOs := make([]transaction.UTXO, 0)
+		for index, input := range transac.Inputs {
+			utxo := spents[index].UTXO
+			if !utxo.IsSpent(input) {
+				return fmt.Errorf("交易%x的第%d个输入与撤销数据不一致", transac.TxHash, index)
+			}
+			spentUTXOs = append(spentUTXOs, utxo)
+		}
+		spents = spents[len(transac.Inputs):]
+		verify, err := transac.VertifySign(spentUTXOs)
+		if err != nil || !verify {
+			return fmt.Errorf("交易%x签名验证失败", transac.TxHash)
+		}
+		if level < VERIFYVALUE {
+			continue
+		}
+		fee, err := txFee(transac, spentUTXOs)
+		if err != nil {
+			return err
+		}
+		fees, err = addFee(fees, fee)
+		if err != nil {
+			return err
+		}
+	}
+	if level >= VERIFYVALUE {
+		return checkCoinbaseValue(height, claimed, fees)
+	}
+	return nil
+}
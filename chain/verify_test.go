@@ -0,0 +1,105 @@
+package chain
+
+import (
+	"PublicChain/utils"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 完整的链在各个检查级别下都能通过验证，depth限制检查的区块数量
+func TestVerifyChain(t *testing.T) {
+	chain, address := newTestChain(t)
+	spendTestChain(t, chain, address)
+	for level := VERIFYLINK; level <= VERIFYVALUE; level++ {
+		checked, err := chain.VerifyChain(0, level)
+		if err != nil {
+			t.Fatalf("级别%d：%v", level, err)
+		}
+		if checked != 3 {
+			t.Errorf("级别%d检查了%d个区块，应为3个", level, checked)
+		}
+	}
+	checked, err := chain.VerifyChain(1, VERIFYVALUE)
+	if err != nil || checked != 1 {
+		t.Errorf("depth为1时检查了%d个区块：%v", checked, err)
+	}
+	_, err = chain.VerifyChain(0, VERIFYVALUE+1)
+	if err == nil {
+		t.Error("检查级别超出范围时应返回错误")
+	}
+	_, err = chain.VerifyChain(-1, VERIFYLINK)
+	if err == nil {
+		t.Error("depth为负数时应返回错误")
+	}
+}
+
+// 被改动的区块在对应的检查级别上被发现，更低的级别检查不到
+func TestVerifyChainDetectsDamage(t *testing.T) {
+	cases := []struct {
+		name   string
+		level  int // 能发现问题的最低检查级别
+		reason string
+		tamper func(tx *bolt.Tx, a1 Block, a2 Block) error
+	}{
+		{
+			name:   "高度索引指向其他区块",
+			level:  VERIFYLINK,
+			reason: "高度",
+			tamper: func(tx *bolt.Tx, a1 Block, a2 Block) error {
+				heightBytes, err := utils.IntToByte(1)
+				if err != nil {
+					return err
+				}
+				return tx.Bucket([]byte(HEIGHTINDEX)).Put(heightBytes, a2.Hash[:])
+			},
+		},
+		{
+			name:   "交易被改动",
+			level:  VERIFYMERKLE,
+			reason: "默克尔根",
+			tamper: func(tx *bolt.Tx, a1 Block, a2 Block) error {
+				block, err := getBlockInTx(tx, a1.Hash)
+				if err != nil {
+					return err
+				}
+				block.Txs[0].Outputs[0].Value--
+				return tx.Bucket([]byte(BUCKERNAME)).Put(a1.Hash[:], block.serializeBody())
+			},
+		},
+		{
+			name:   "撤销数据缺失",
+			level:  VERIFYSIGNATURE,
+			reason: "撤销数据",
+			tamper: func(tx *bolt.Tx, a1 Block, a2 Block) error {
+				return deleteBlockUndo(tx, a2.Hash)
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chain, address := newTestChain(t)
+			a1, a2, _ := spendTestChain(t, chain, address)
+			err := chain.DB.Update(func(tx *bolt.Tx) error {
+				return c.tamper(tx, a1, a2)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.level > VERIFYLINK {
+				_, err = chain.VerifyChain(0, c.level-1)
+				if err != nil {
+					t.Errorf("级别%d不应发现问题：%v", c.level-1, err)
+				}
+			}
+			_, err = chain.VerifyChain(0, c.level)
+			if err == nil {
+				t.Fatalf("级别%d应发现问题", c.level)
+			}
+			if !strings.Contains(err.Error(), c.reason) {
+				t.Errorf("错误信息没有指出原因：%v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package chainparams
+
+import (
+	"PublicChain/utils"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"path/filepath"
+)
+
+// 网络的名称，通过-network参数选择
+const (
+	MAINNET = "mainnet" // 主网
+	TESTNET = "testnet" // 测试网，地址使用不同的版本号，难度更低
+	REGTEST = "regtest" // 回归测试网，难度极低且不调整，用于本地测试
+)
+
+// 数据目录中db文件的名称
+const BOLTFILE = "pubchain.db"
+
+/**
+ * 网络参数：同一个网络上的所有节点使用相同的参数，不同网络的数据和地址互不相通
+ */
+type Params struct {
+	Name           string // 网络名称
+	Magic          uint32 // 网络标识，创建创世区块时写入db文件，打开其他网络的db文件时报错
+	AddressVersion byte   // 地址的版本号，即公钥hash前的1个字节
+	DataDir        string // 数据目录，db文件存放在该目录下
+
+	GenesisVersion         int32    // 创世区块的版本号
+	GenesisTimestamp       int64    // 创世区块的时间戳，也是创世区块coinbase交易的LockedTime
+	GenesisNonce           uint32   // 创世区块的nonce，按InitialDifficulty对应的难度目标预先挖出
+	GenesisPubHash         []byte   // 创世区块coinbase输出的公钥hash(带地址版本号)，没有对应的私钥，出块奖励无法花费
+	GenesisHash            [32]byte // 创世区块的hash，打开db文件时检查工作量证明的链是否使用了该创世区块
+	InitialDifficulty      uint     // 工作量证明的初始难度，创世区块的目标值为 InitialDifficulty << (255-InitialDifficulty)
+	Reward                 int64    // 创世区块的出块奖励，单位为1/utils.COIN个币
+	SubsidyHalvingInterval int64    // 每隔多少个区块出块奖励减半
+
+	PowLimit         *big.Int // 目标值允许的最大值，即最低难度
+	RetargetInterval int64    // 每隔多少个区块调整一次难度
+	TargetSpacing    int64    // 期望的出块间隔(秒)
+	NoRetargeting    bool     // 不调整难度，一直使用创世区块的难度目标
+
+	StakeMinDepth int64 // 权益证明中utxo至少经过多少个区块才计入质押权重，创世区块的输出不受限制
+}
+
+/**
+ * 一个调整周期期望花费的时间(秒)
+ */
+func (params *Params) TargetTimespan() int64 {
+	return params.RetargetInterval * params.TargetSpacing
+}
+
+/**
+ * 该网络的db文件路径
+ */
+func (params *Params) DBFile() string {
+	return filepath.Join(params.DataDir, BOLTFILE)
+}
+
+// 创世区块coinbase输出锁定的公钥hash：ripemd160(sha256("PublicChain genesis block"))，没有人持有对应的私钥
+const genesisPubKeyHash = "2fd3255c9d389077376bd9b0c47d88d1b3f40348"
+
+// 在公钥hash前加上地址版本号，与交易输出中的公钥hash格式一致
+func newGenesisPubHash(version byte) []byte {
+	pubHash, _ := hex.DecodeString(genesisPubKeyHash)
+	return append([]byte{version}, pubHash...)
+}
+
+// 把十六进制的区块hash转换为[32]byte
+func newHash(text string) [32]byte {
+	var hash [32]byte
+	data, _ := hex.DecodeString(text)
+	copy(hash[:], data)
+	return hash
+}
+
+// 2^bits - 1
+func newPowLimit(bits uint) *big.Int {
+	return new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits), big.NewInt(1))
+}
+
+/**
+ * 主网：数据目录为当前目录，与没有网络参数的旧版本兼容
+ */
+var MainNetParams = Params{
+	Name:                   MAINNET,
+	Magic:                  0xd9b4bef9,
+	AddressVersion:         0x00,
+	DataDir:                ".",
+	GenesisVersion:         0x01,
+	GenesisTimestamp:       1792281600,
+	GenesisNonce:           133657,
+	GenesisPubHash:         newGenesisPubHash(0x00),
+	GenesisHash:            newHash("0000212bd1aadaf73793219095e652ffce2677aef3c064d96aa940a28cafb544"),
+	InitialDifficulty:      20,
+	Reward:                 50 * utils.COIN,
+	SubsidyHalvingInterval: 210000,
+	PowLimit:               newPowLimit(244),
+	RetargetInterval:       10,
+	TargetSpacing:          10,
+	StakeMinDepth:          100,
+}
+
+/**
+ * 测试网：地址版本号为0x6f，初始难度和最低难度都比主网低
+ */
+var TestNetParams = Params{
+	Name:                   TESTNET,
+	Magic:                  0x0709110b,
+	AddressVersion:         0x6f,
+	DataDir:                TESTNET,
+	GenesisVersion:         0x01,
+	GenesisTimestamp:       1792281600,
+	GenesisNonce:           14007,
+	GenesisPubHash:         newGenesisPubHash(0x6f),
+	GenesisHash:            newHash("000682539a22a1843f177a3807cd1877c7b5ba09b0917ec883e627ae84332769"),
+	InitialDifficulty:      16,
+	Reward:                 50 * utils.COIN,
+	SubsidyHalvingInterval: 210000,
+	PowLimit:               newPowLimit(248),
+	RetargetInterval:       10,
+	TargetSpacing:          10,
+	StakeMinDepth:          100,
+}
+
+/**
+ * 回归测试网：几次hash就能挖出一个区块，难度不调整，出块奖励每150个区块减半，地址版本号与测试网相同
+ */
+var RegTestParams = Params{
+	Name:                   REGTEST,
+	Magic:                  0xdab5bffa,
+	AddressVersion:         0x6f,
+	DataDir:                REGTEST,
+	GenesisVersion:         0x01,
+	GenesisTimestamp:       1792281600,
+	GenesisNonce:           0,
+	GenesisPubHash:         newGenesisPubHash(0x6f),
+	GenesisHash:            newHash("1a16ac46a10a0a3cb35acab40a7f0babbfd6411968932d9d8d75d9e7a5120a2a"),
+	InitialDifficulty:      1,
+	Reward:                 50 * utils.COIN,
+	SubsidyHalvingInterval: 150,
+	PowLimit:               newPowLimit(255),
+	RetargetInterval:       10,
+	TargetSpacing:          10,
+	NoRetargeting:          true,
+	StakeMinDepth:          10,
+}
+
+// 当前使用的网络参数，程序启动时由-network参数选择，默认为主网
+var Active = &MainNetParams
+
+/**
+ * 按名称选择当前使用的网络，需要在打开db文件之前调用
+ */
+func Select(name string) error {
+	switch name {
+	case MAINNET:
+		Active = &MainNetParams
+	case TESTNET:
+		Active = &TestNetParams
+	case REGTEST:
+		Active = &RegTestParams
+	default:
+		return fmt.Errorf("不支持的网络：%s，可选mainnet、testnet或regtest", name)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package chainparams
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// 按名称选择网络，不支持的名称返回错误且不改变当前网络
+func TestSelect(t *testing.T) {
+	t.Cleanup(func() { Active = &MainNetParams })
+	for _, name := range []string{MAINNET, TESTNET, REGTEST} {
+		err := Select(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Active.Name != name {
+			t.Errorf("选择%s后当前网络为%s", name, Active.Name)
+		}
+	}
+	err := Select("simnet")
+	if err == nil {
+		t.Fatal("不支持的网络应返回错误")
+	}
+	if Active != &RegTestParams {
+		t.Error("选择失败时不应改变当前网络")
+	}
+}
+
+// 不同网络的网络标识、创世区块和数据目录互不相同，测试网和回归测试网的地址版本号与主网不同
+func TestNetworksDiffer(t *testing.T) {
+	networks := []*Params{&MainNetParams, &TestNetParams, &RegTestParams}
+	for i, a := range networks {
+		for _, b := range networks[i+1:] {
+			if a.Magic == b.Magic || a.GenesisHash == b.GenesisHash || a.DBFile() == b.DBFile() {
+				t.Errorf("%s与%s的参数相同", a.Name, b.Name)
+			}
+		}
+		if a.PowLimit.Sign() <= 0 || a.TargetTimespan() != a.RetargetInterval*a.TargetSpacing {
+			t.Errorf("%s的难度参数有误", a.Name)
+		}
+	}
+	if TestNetParams.AddressVersion == MainNetParams.AddressVersion || RegTestParams.AddressVersion == MainNetParams.AddressVersion {
+		t.Error("测试网络的地址版本号应与主网不同")
+	}
+	if MainNetParams.DBFile() != filepath.Join(".", BOLTFILE) {
+		t.Errorf("主网的db文件为%s，应在当前目录下", MainNetParams.DBFile())
+	}
+	if !RegTestParams.NoRetargeting || MainNetParams.NoRetargeting || TestNetParams.NoRetargeting {
+		t.Error("只有回归测试网不调整难度")
+	}
+}
@@ -2,11 +2,22 @@ package client
 
 import (
 	"PublicChain/chain"
+	"PublicChain/chainparams"
+	"PublicChain/consensus"
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"PublicChain/utxoset"
+	"PublicChain/wallet"
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"math/big"
 	"math/rand"
 	"os"
+	"os/signal"
+	"runtime"
 	"time"
 )
 
@@ -60,6 +71,62 @@ func (client *Client) Run() {
 		client.SetCoinBase()
 	case GETCOINBASE: //得到coinbase地址
 		client.GetCoinBase()
+	case GETBLOCKHASH: // 根据高度得到区块hash
+		client.GetBlockHash()
+	case GETBLOCK: // 根据hash得到区块详情
+		client.GetBlock()
+	case GETBLOCKHEADER: // 根据hash只查询区块头
+		client.GetBlockHeader()
+	case BUILDTXINDEX: // 开启并补建交易索引
+		client.BuildTxIndex()
+	case GETTRANSACTION: // 根据交易hash查询交易
+		client.GetTransaction()
+	case GETADDRESSHISTORY: // 查询地址的收支记录
+		client.GetAddressHistory()
+	case GETCHAINTIPS: // 查询所有链的末端
+		client.GetChainTips()
+	case INVALIDATEBLOCK: // 将区块标记为无效并回退主链
+		client.InvalidateBlock()
+	case RECONSIDERBLOCK: // 撤销区块的无效标记
+		client.ReconsiderBlock()
+	case VERIFYCHAIN: // 重新验证主链上的区块
+		client.VerifyChain()
+	case REINDEXCHAINSTATE: // 从区块重建utxo集合
+		client.ReindexChainstate()
+	case GETRAWMEMPOOL: // 查询交易池中的交易
+		client.GetRawMempool()
+	case GETMEMPOOLENTRY: // 查询交易池中的某笔交易
+		client.GetMempoolEntry()
+	case GENERATE: // 挖出区块，打包交易池中的交易
+		client.Generate()
+	case GETTXOUTSETINFO: // 查询utxo集合的统计信息
+		client.GetTxOutSetInfo()
+	case GETTXOUT: // 查询单个未花费的交易输出
+		client.GetTxOut()
+	case SCANTXOUTSET: // 按地址或公钥hash扫描utxo集合
+		client.ScanTxOutSet()
+	case GETDIFFICULTY: // 查询当前难度
+		client.GetDifficulty()
+	case ADDVALIDATOR: // 发起添加验证者的治理交易
+		client.SendGovernance(ADDVALIDATOR, transaction.GOVADDVALIDATOR)
+	case REMOVEVALIDATOR: // 发起移除验证者的治理交易
+		client.SendGovernance(REMOVEVALIDATOR, transaction.GOVREMOVEVALIDATOR)
+	case APPROVEGOVERNANCE: // 批准其他验证者发起的治理交易
+		client.ApproveGovernance()
+	case GETVALIDATORS: // 查询当前的验证者
+		client.GetValidators()
+	case GETBLOCKTEMPLATE: // 生成供外部矿工挖矿的区块模板
+		client.GetBlockTemplate()
+	case SUBMITBLOCK: // 提交外部矿工挖出的区块
+		client.SubmitBlock()
+	case STARTMINING: // 持续挖矿，直到stopmining
+		client.StartMining()
+	case STOPMINING: // 停止正在运行的挖矿进程
+		client.StopMining()
+	case GETMININGINFO: // 查询挖矿进程的状态
+		client.GetMiningInfo()
+	case GETBLOCKSUBSIDY: // 查询出块奖励和减半计划
+		client.GetBlockSubsidy()
 	default:
 		client.Default()
 	}
@@ -162,7 +229,35 @@ func (client *Client) GetNewAddress() {
 func (client *Client) GenerateGenesis() {
 	generateGensis := flag.NewFlagSet(GENERATEGENESIS, flag.ExitOnError)
 	address := generateGensis.String("address", "", "用户指定地址")
+	consensusName := generateGensis.String("consensus", consensus.POW, "这条链使用的共识机制，pow、pos或poa")
+	validators := generateGensis.String("validators", "", "poa的初始验证者，钱包中的地址或十六进制公钥组成的json数组，默认为-address")
 	_ = generateGensis.Parse(os.Args[2:])
+	params, err := chain.NewChainParams(*consensusName)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if params.Consensus == consensus.POA {
+		list := []string{*address}
+		if len(*validators) > 0 {
+			list, err = utils.JsonStringToSlince(*validators)
+			if err != nil {
+				fmt.Println("验证者参数格式有误，应为json数组")
+				return
+			}
+		}
+		for _, validator := range list {
+			pub, err := client.Chain.ResolveValidator(validator)
+			if err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+			params.Validators = append(params.Validators, pub)
+		}
+	} else if len(*validators) > 0 {
+		fmt.Println("只有poa需要指定验证者")
+		return
+	}
 	// 先判断是否已存在创世区块
 	hashBig := new(big.Int)
 	hashBig.SetBytes(client.Chain.LastBlock.Hash[:])
@@ -172,13 +267,15 @@ func (client *Client) GenerateGenesis() {
 	}
 
 	//解析
-	coinbaseHash, err := client.Chain.CreateCoinbase(*address)
+	coinbaseHash, err := client.Chain.CreateCoinbase(*address, params)
 	if err != nil {
-		fmt.Println("coinbase交易出现错误，请重试。")
+		fmt.Println("创建创世区块出现错误：", err.Error())
 		return
 	}
 
 	fmt.Printf("交易hash是:%x\n", coinbaseHash)
+	fmt.Printf("创世区块hash:%x\n", client.Chain.LastBlock.Hash)
+	fmt.Printf("共识机制:%s\n", params.Consensus)
 
 }
 
@@ -194,7 +291,7 @@ func (client *Client) GetBalance() {
 		return
 	}
 	totalBalance := client.Chain.GetBalance(address)
-	fmt.Printf("用户%s的余额是%f\n", address, totalBalance)
+	fmt.Printf("用户%s的余额是%s\n", address, utils.FormatAmount(totalBalance))
 }
 
 func (client *Client) SendTransaction() {
@@ -202,18 +299,38 @@ func (client *Client) SendTransaction() {
 	from := addnewblock.String("from", "", "发起者地址")
 	to := addnewblock.String("to", "", "接收者地址")
 	value := addnewblock.String("value", "", "数值")
+	feeStr := addnewblock.String("fee", "0", "每笔交易支付的手续费")
+	feeRateStr := addnewblock.String("feerate", "0", "按交易大小计算手续费的费率，每千字节的金额")
 	// setcoinbase :=addnewblock.String("setcoinbase","","矿工地址")
 
 	//labol :=addnewblock.String("labol","","数值")
 	_ = addnewblock.Parse(os.Args[2:])
 	//1.从参数中取出以 —开头的参数
 	//2.准备一个当前命令支持的所有的参数切片
-	err := client.Chain.SendTransaction(*from, *to, *value)
+	//金额在命令行的边界由十进制文本转换为最小单位
+	fee, err := utils.ParseAmount(*feeStr)
+	if err != nil {
+		fmt.Println("手续费格式有误：", err.Error())
+		return
+	}
+	feeRate, err := utils.ParseAmount(*feeRateStr)
+	if err != nil {
+		fmt.Println("手续费率格式有误：", err.Error())
+		return
+	}
+	txids, err := client.Chain.SendTransaction(*from, *to, *value, fee, feeRate)
 	if err != nil {
 		fmt.Println(err.Error())
 		return
 	}
-	//	fmt.Println("交易成功")
+	for _, txid := range txids {
+		entry, _, err := client.Chain.Mempool.GetEntry(txid)
+		if err != nil {
+			fmt.Printf("交易%x已放入交易池，等待打包\n", txid)
+			continue
+		}
+		fmt.Printf("交易%x已放入交易池，手续费%s，等待打包\n", txid, utils.FormatAmount(entry.Fee))
+	}
 
 }
 
@@ -267,12 +384,751 @@ func (client *Client) GetAllBlocks() {
 			}
 			fmt.Println("\t该笔交易的交易输出")
 			for indexoutput, output := range tx.Outputs { //交易输出
-				fmt.Printf("\t\t第%d个交易输出，转给%x面额为%f的金额\n", indexoutput, output.PubHash, output.Value)
+				fmt.Printf("\t\t第%d个交易输出，转给%x面额为%s的金额\n", indexoutput, output.PubHash, utils.FormatAmount(output.Value))
 			}
 		}
 	}
 }
 
+// 根据区块高度，通过高度索引查询区块hash
+func (client *Client) GetBlockHash() {
+	getBlockHash := flag.NewFlagSet(GETBLOCKHASH, flag.ExitOnError)
+	height := getBlockHash.Int64("height", -1, "要查询的区块高度")
+	_ = getBlockHash.Parse(os.Args[2:])
+	if *height < 0 {
+		fmt.Println("请输入正确的区块高度")
+		return
+	}
+	hash, err := client.Chain.GetBlockHashByHeight(*height)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("%x\n", hash)
+}
+
+// 根据区块hash只查询区块头，不读取区块中的交易
+func (client *Client) GetBlockHeader() {
+	getHeader := flag.NewFlagSet(GETBLOCKHEADER, flag.ExitOnError)
+	hashStr := getHeader.String("hash", "", "要查询的区块hash")
+	_ = getHeader.Parse(os.Args[2:])
+	hash, err := utils.HexToHash(*hashStr)
+	if err != nil {
+		fmt.Println("区块hash格式有误，请重试")
+		return
+	}
+	header, height, err := client.Chain.GetBlockHeader(hash)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("区块hash：%x\n", hash)
+	fmt.Printf("区块高度：%d\n", height)
+	fmt.Printf("版本号：%d\n", header.Version)
+	fmt.Printf("前一个区块hash：%x\n", header.PreHash)
+	fmt.Printf("默克尔根：%x\n", header.MerkleRoot)
+	fmt.Printf("时间戳：%s\n", time.Unix(header.Timestamp, 0).Format("2006-01-02 15:04:05"))
+	fmt.Printf("难度目标：%08x\n", header.Bits)
+	fmt.Printf("随机数：%d\n", header.Nonce)
+}
+
+// 根据区块hash查询区块，打印区块头、默克尔根以及区块中的交易
+func (client *Client) GetBlock() {
+	getBlock := flag.NewFlagSet(GETBLOCK, flag.ExitOnError)
+	hashStr := getBlock.String("hash", "", "要查询的区块hash")
+	verbose := getBlock.Bool("verbose", false, "是否打印交易的输入和输出")
+	_ = getBlock.Parse(os.Args[2:])
+	hash, err := utils.HexToHash(*hashStr)
+	if err != nil {
+		fmt.Println("区块hash格式有误，请重试")
+		return
+	}
+	block, err := client.Chain.GetBlockByHash(hash)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("区块hash：%x\n", block.Hash)
+	fmt.Printf("区块高度：%d\n", block.Height)
+	fmt.Printf("版本号：%d\n", block.Version)
+	fmt.Printf("前一个区块hash：%x\n", block.PreHash)
+	fmt.Printf("默克尔根：%x\n", block.MerkleRoot)
+	fmt.Printf("时间戳：%s\n", time.Unix(block.Timestamp, 0).Format("2006-01-02 15:04:05"))
+	fmt.Printf("难度目标：%08x\n", block.Bits)
+	fmt.Printf("随机数：%d\n", block.Nonce)
+	if len(block.Producer) > 0 {
+		producer, _ := wallet.NewAddress(block.Producer)
+		fmt.Printf("出块者：%s\n", producer)
+		fmt.Printf("出块者签名：%x\n", block.Signature)
+	}
+	fmt.Printf("交易数量：%d\n", len(block.Txs))
+	for index, tx := range block.Txs {
+		fmt.Printf("第%d笔交易,交易hash是：%x\n", index, tx.TxHash)
+		if !*verbose {
+			continue
+		}
+		printTransaction(tx)
+	}
+}
+
+// 开启交易索引，并为已有的区块补建索引
+func (client *Client) BuildTxIndex() {
+	if len(os.Args[2:]) > 0 {
+		fmt.Println("buildtxindex不接收参数")
+		return
+	}
+	count, err := client.Chain.BuildTxIndex()
+	if err != nil {
+		fmt.Println("建立交易索引失败：", err.Error())
+		return
+	}
+	fmt.Printf("交易索引已开启，共为%d笔交易建立索引\n", count)
+}
+
+// 根据交易hash，通过交易索引查询交易及其所在区块
+func (client *Client) GetTransaction() {
+	getTransaction := flag.NewFlagSet(GETTRANSACTION, flag.ExitOnError)
+	txidStr := getTransaction.String("txid", "", "要查询的交易hash")
+	_ = getTransaction.Parse(os.Args[2:])
+	txid, err := utils.HexToHash(*txidStr)
+	if err != nil {
+		fmt.Println("交易hash格式有误，请重试")
+		return
+	}
+	info, err := client.Chain.GetTransaction(txid)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("交易hash：%x\n", info.Tx.TxHash)
+	fmt.Printf("所在区块hash：%x\n", info.BlockHash)
+	fmt.Printf("所在区块高度：%d\n", info.Height)
+	fmt.Printf("在区块中的位置：%d\n", info.Position)
+	fmt.Printf("确认数：%d\n", info.Confirmations)
+	printTransaction(info.Tx)
+}
+
+// 查询某个地址在一段高度区间内的收入和支出记录，以及每条记录之后的余额
+func (client *Client) GetAddressHistory() {
+	getAddressHistory := flag.NewFlagSet(GETADDRESSHISTORY, flag.ExitOnError)
+	address := getAddressHistory.String("address", "", "要查询的地址")
+	from := getAddressHistory.Int64("from", 0, "起始区块高度")
+	to := getAddressHistory.Int64("to", -1, "结束区块高度，默认为最新区块")
+	_ = getAddressHistory.Parse(os.Args[2:])
+	if len(*address) == 0 {
+		fmt.Println("请输入要查询的地址")
+		return
+	}
+	histories, err := client.Chain.GetAddressHistory(*address, *from, *to)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(histories) == 0 {
+		fmt.Println("该地址在此区间内没有收支记录")
+		return
+	}
+	for _, history := range histories {
+		direction := "收入"
+		if history.Direction == chain.HISTORYDEBIT {
+			direction = "支出"
+		}
+		fmt.Printf("高度%d\t交易%x的第%d个\t%s%s\t余额%s\n", history.Height, history.TxId, history.Index, direction,
+			utils.FormatAmount(history.Value), utils.FormatAmount(history.Balance))
+	}
+}
+
+// 列出主链以及所有分叉的末端区块
+func (client *Client) GetChainTips() {
+	if len(os.Args[2:]) > 0 {
+		fmt.Println("getchaintips不接收参数")
+		return
+	}
+	tips, err := client.Chain.GetChainTips()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, tip := range tips {
+		fmt.Printf("高度%d\t区块hash：%x\t分叉长度%d\t状态：%s\n", tip.Height, tip.Hash, tip.BranchLen, tip.Status)
+	}
+}
+
+// 将区块标记为无效，如果区块在主链上，主链回退到该区块的父区块
+func (client *Client) InvalidateBlock() {
+	invalidateBlock := flag.NewFlagSet(INVALIDATEBLOCK, flag.ExitOnError)
+	hashStr := invalidateBlock.String("hash", "", "要标记为无效的区块hash")
+	_ = invalidateBlock.Parse(os.Args[2:])
+	hash, err := utils.HexToHash(*hashStr)
+	if err != nil {
+		fmt.Println("区块hash格式有误，请重试")
+		return
+	}
+	err = client.Chain.InvalidateBlock(hash)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("区块已标记为无效，当前最新区块高度:%d\n", client.Chain.LastBlock.Height)
+}
+
+// 撤销区块的无效标记，重新选择累计工作量最大的链
+func (client *Client) ReconsiderBlock() {
+	reconsiderBlock := flag.NewFlagSet(RECONSIDERBLOCK, flag.ExitOnError)
+	hashStr := reconsiderBlock.String("hash", "", "要撤销无效标记的区块hash")
+	_ = reconsiderBlock.Parse(os.Args[2:])
+	hash, err := utils.HexToHash(*hashStr)
+	if err != nil {
+		fmt.Println("区块hash格式有误，请重试")
+		return
+	}
+	err = client.Chain.ReconsiderBlock(hash)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("已撤销区块的无效标记，当前最新区块高度:%d\n", client.Chain.LastBlock.Height)
+}
+
+// 重新验证主链上最新的若干个区块，报告第一个有问题的区块
+func (client *Client) VerifyChain() {
+	verifyChain := flag.NewFlagSet(VERIFYCHAIN, flag.ExitOnError)
+	depth := verifyChain.Int64("depth", 6, "要验证的区块数量，0表示整条链")
+	level := verifyChain.Int("level", chain.VERIFYSIGNATURE, "检查级别0-4：0区块连接，1工作量证明，2默克尔根，3交易签名，4交易金额")
+	_ = verifyChain.Parse(os.Args[2:])
+	checked, err := client.Chain.VerifyChain(*depth, *level)
+	if err != nil {
+		fmt.Printf("已验证%d个区块，验证失败：%s\n", checked, err.Error())
+		return
+	}
+	fmt.Printf("验证通过，共验证了%d个区块(检查级别%d)\n", checked, *level)
+}
+
+// 删除utxo集合并从创世区块开始重放所有区块，重建后核对钱包中每个地址的余额
+func (client *Client) ReindexChainstate() {
+	reindex := flag.NewFlagSet(REINDEXCHAINSTATE, flag.ExitOnError)
+	dbcache := reindex.Int("dbcache", utxoset.DEFAULTCACHESIZE>>20, "utxo缓存的内存上限(MB)")
+	_ = reindex.Parse(os.Args[2:])
+	if *dbcache <= 0 {
+		fmt.Println("utxo缓存的内存上限必须大于0")
+		return
+	}
+	client.Chain.UTXOSet.Cache.SetLimit(*dbcache << 20)
+	count, err := client.Chain.ReindexChainstate()
+	if err != nil {
+		fmt.Println("重建utxo集合失败：", err.Error())
+		return
+	}
+	fmt.Printf("utxo集合重建完成，共重放了%d个区块\n", count)
+	printCacheStats(client.Chain.UTXOSet.Cache.Stats())
+	addresses, err := client.Chain.GetAddressList()
+	if err != nil {
+		fmt.Println("加载地址失败：", err.Error())
+		return
+	}
+	for _, address := range addresses {
+		balance := client.Chain.GetBalance(address)
+		var searched int64
+		for _, utxo := range client.Chain.SearchUTXO(address) {
+			searched += utxo.Value
+		}
+		if balance != searched {
+			fmt.Printf("地址%s的余额%s与遍历区块得到的%s不一致\n", address, utils.FormatAmount(balance), utils.FormatAmount(searched))
+			continue
+		}
+		fmt.Printf("地址%s的余额%s核对一致\n", address, utils.FormatAmount(balance))
+	}
+}
+
+// 列出交易池中等待打包的交易
+func (client *Client) GetRawMempool() {
+	if len(os.Args[2:]) > 0 {
+		fmt.Println("getrawmempool不接收参数")
+		return
+	}
+	entries, err := client.Chain.Mempool.GetEntries()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("交易池中共有%d笔交易\n", len(entries))
+	for _, entry := range entries {
+		fmt.Printf("%x\n", entry.Tx.TxHash)
+	}
+}
+
+// 查询交易池中某笔交易的详细信息，以及它与池中其他交易的依赖关系
+func (client *Client) GetMempoolEntry() {
+	getMempoolEntry := flag.NewFlagSet(GETMEMPOOLENTRY, flag.ExitOnError)
+	txidStr := getMempoolEntry.String("txid", "", "要查询的交易hash")
+	_ = getMempoolEntry.Parse(os.Args[2:])
+	txid, err := utils.HexToHash(*txidStr)
+	if err != nil {
+		fmt.Println("交易hash格式有误，请重试")
+		return
+	}
+	entry, children, err := client.Chain.Mempool.GetEntry(txid)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("交易hash：%x\n", entry.Tx.TxHash)
+	fmt.Println("进入交易池的时间:", time.Unix(entry.Time, 0).Format("2006-01-02 15:04:05"))
+	fmt.Println("进入交易池时的区块高度:", entry.Height)
+	fmt.Printf("手续费:%s\n", utils.FormatAmount(entry.Fee))
+	for _, depend := range entry.Depends {
+		fmt.Printf("依赖池中的交易：%x\n", depend)
+	}
+	for _, child := range children {
+		fmt.Printf("被池中的交易依赖：%x\n", child)
+	}
+	printTransaction(entry.Tx)
+}
+
+// 挖出指定数量的区块，打包交易池中的交易，出块奖励给矿工地址。按Ctrl-C停止挖矿，已挖出的区块保留
+func (client *Client) Generate() {
+	generate := flag.NewFlagSet(GENERATE, flag.ExitOnError)
+	blocks := generate.Int("blocks", 1, "要挖出的区块数量")
+	dbcache := generate.Int("dbcache", utxoset.DEFAULTCACHESIZE>>20, "utxo缓存的内存上限(MB)")
+	threads := generate.Int("threads", runtime.NumCPU(), "同时搜索nonce的goroutine数量")
+	_ = generate.Parse(os.Args[2:])
+	if *dbcache <= 0 {
+		fmt.Println("utxo缓存的内存上限必须大于0")
+		return
+	}
+	if *threads <= 0 {
+		fmt.Println("goroutine数量必须大于0")
+		return
+	}
+	client.Chain.UTXOSet.Cache.SetLimit(*dbcache << 20)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	hashes, stats, err := client.Chain.Generate(ctx, *blocks, *threads)
+	for _, hash := range hashes {
+		fmt.Printf("挖出区块：%x\n", hash)
+	}
+	switch client.Chain.Params.Consensus {
+	case consensus.POS:
+		fmt.Printf("共计算kernel hash%d次，用时%.2f秒\n", stats.Hashes, stats.Elapsed.Seconds())
+	case consensus.POA:
+		fmt.Printf("共签名%d个区块，用时%.2f秒\n", len(hashes), stats.Elapsed.Seconds())
+	default:
+		fmt.Printf("共计算hash%d次，用时%.2f秒，算力%.0f次/秒(%d个goroutine)\n",
+			stats.Hashes, stats.Elapsed.Seconds(), stats.HashRate(), *threads)
+	}
+	if stats.ExtraNonces > 0 {
+		fmt.Printf("nonce用完%d次，已修改coinbase交易的extra nonce\n", stats.ExtraNonces)
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("收到中断信号，已停止挖矿")
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("当前最新区块高度:%d\n", client.Chain.LastBlock.Height)
+	printCacheStats(client.Chain.UTXOSet.Cache.Stats())
+}
+
+// 查询utxo集合的统计信息，hash相同说明两个db中的utxo集合相同
+func (client *Client) GetTxOutSetInfo() {
+	if len(os.Args[2:]) > 0 {
+		fmt.Println("gettxoutsetinfo不接收参数")
+		return
+	}
+	info, err := client.Chain.UTXOSet.GetTxOutSetInfo()
+	if err != nil {
+		fmt.Println("查询utxo集合失败：", err.Error())
+		return
+	}
+	fmt.Printf("最新区块:%x\n", info.BestBlock)
+	fmt.Printf("区块高度:%d\n", client.Chain.LastBlock.Height)
+	fmt.Printf("utxo数量:%d\n", info.Count)
+	fmt.Printf("总金额:%s\n", utils.FormatAmount(info.Amount))
+	fmt.Printf("序列化大小:%d字节\n", info.Size)
+	fmt.Printf("utxo集合hash:%x\n", info.Hash)
+}
+
+// 查询一个未花费的交易输出，已被花费时不输出任何内容
+func (client *Client) GetTxOut() {
+	getTxOut := flag.NewFlagSet(GETTXOUT, flag.ExitOnError)
+	txidStr := getTxOut.String("txid", "", "交易hash")
+	vout := getTxOut.Int("vout", 0, "交易输出的序号")
+	includeMempool := getTxOut.Bool("include-mempool", false, "是否考虑交易池中的交易")
+	_ = getTxOut.Parse(os.Args[2:])
+	txid, err := utils.HexToHash(*txidStr)
+	if err != nil {
+		fmt.Println("交易hash格式有误，请重试")
+		return
+	}
+	info, err := client.Chain.GetTxOut(txid, *vout, *includeMempool)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if info == nil {
+		return
+	}
+	fmt.Printf("金额:%s\n", utils.FormatAmount(info.UTXO.Value))
+	fmt.Println("所属地址:", info.Owner)
+	fmt.Println("确认数:", info.Confirmations)
+	if info.Height >= 0 {
+		fmt.Println("所在区块高度:", info.Height)
+	}
+	fmt.Println("coinbase输出:", info.Coinbase)
+}
+
+// 扫描utxo集合，找出一组地址或公钥hash的所有utxo，地址不必在钱包中
+func (client *Client) ScanTxOutSet() {
+	scanTxOutSet := flag.NewFlagSet(SCANTXOUTSET, flag.ExitOnError)
+	addresses := scanTxOutSet.String("addresses", "", "要扫描的地址，json数组")
+	pubKeyHashes := scanTxOutSet.String("pubkeyhashes", "", "要扫描的公钥hash(十六进制)，json数组")
+	_ = scanTxOutSet.Parse(os.Args[2:])
+	if len(*addresses) == 0 && len(*pubKeyHashes) == 0 {
+		fmt.Println("请输入要扫描的地址或公钥hash")
+		return
+	}
+	pubHashes := make([][]byte, 0)
+	if len(*addresses) > 0 {
+		addressSlice, err := utils.JsonStringToSlince(*addresses)
+		if err != nil {
+			fmt.Println("地址格式有误，应为json数组")
+			return
+		}
+		for _, address := range addressSlice {
+			if !wallet.IsAddressValid(address) {
+				fmt.Printf("地址%s不合法\n", address)
+				return
+			}
+			pubHashes = append(pubHashes, wallet.GetPubKHashWithAddress(address))
+		}
+	}
+	if len(*pubKeyHashes) > 0 {
+		hashSlice, err := utils.JsonStringToSlince(*pubKeyHashes)
+		if err != nil {
+			fmt.Println("公钥hash格式有误，应为json数组")
+			return
+		}
+		for _, hashStr := range hashSlice {
+			pubHash, err := hex.DecodeString(hashStr)
+			// 不带版本号的20字节公钥hash，补上版本号
+			if err == nil && len(pubHash) == 20 {
+				pubHash = append([]byte{chainparams.Active.AddressVersion}, pubHash...)
+			}
+			if err != nil || len(pubHash) != 21 {
+				fmt.Printf("公钥hash%s有误，应为20或21字节的十六进制\n", hashStr)
+				return
+			}
+			pubHashes = append(pubHashes, pubHash)
+		}
+	}
+	utxos, err := client.Chain.UTXOSet.ScanUTXOs(pubHashes)
+	if err != nil {
+		fmt.Println("扫描utxo集合失败：", err.Error())
+		return
+	}
+	var total int64
+	for _, utxo := range utxos {
+		fmt.Printf("%x:%d\t%s\t%s\n", utxo.TxId, utxo.Vout, wallet.GetAddressWithPubKHash(utxo.PubHash), utils.FormatAmount(utxo.Value))
+		total += utxo.Value
+	}
+	fmt.Printf("共找到%d个utxo，总金额%s\n", len(utxos), utils.FormatAmount(total))
+}
+
+// 查询主链最新区块的难度，以及下一个区块的难度目标
+func (client *Client) GetDifficulty() {
+	if len(os.Args[2:]) > 0 {
+		fmt.Println("getdifficulty不接收参数")
+		return
+	}
+	info, err := client.Chain.GetDifficulty()
+	if err != nil {
+		fmt.Println("查询难度失败：", err.Error())
+		return
+	}
+	fmt.Printf("共识机制:%s\n", client.Chain.Params.Consensus)
+	fmt.Printf("区块高度:%d\n", info.Height)
+	fmt.Printf("难度目标:%08x\n", info.Bits)
+	fmt.Printf("难度:%f\n", info.Difficulty)
+	fmt.Printf("下一个区块的难度目标:%08x\n", info.NextBits)
+	if info.Remaining > 0 {
+		fmt.Printf("再挖出%d个区块后调整难度，期望出块间隔%d秒\n", info.Remaining, chainparams.Active.TargetSpacing)
+	} else {
+		fmt.Printf("%s网络不调整难度\n", chainparams.Active.Name)
+	}
+}
+
+// 打印下一个区块的模板，交易按区块中的顺序给出十六进制的二进制格式，外部矿工在前面加上自己的coinbase交易
+func (client *Client) GetBlockTemplate() {
+	if len(os.Args[2:]) > 0 {
+		fmt.Println("getblocktemplate不接收参数")
+		return
+	}
+	template, err := client.Chain.GetBlockTemplate()
+	if err != nil {
+		fmt.Println("生成区块模板失败：", err.Error())
+		return
+	}
+	fmt.Printf("版本号:%d\n", template.Version)
+	fmt.Printf("区块高度:%d\n", template.Height)
+	fmt.Printf("前一个区块hash:%x\n", template.PreHash)
+	fmt.Printf("难度目标:%08x\n", template.Bits)
+	fmt.Printf("目标值:%064x\n", template.Target)
+	fmt.Printf("当前时间:%d\n", template.CurTime)
+	fmt.Printf("最小时间戳:%d\n", template.MinTime)
+	fmt.Printf("coinbase金额:%s\n", utils.FormatAmount(template.CoinbaseValue))
+	fmt.Printf("手续费:%s\n", utils.FormatAmount(template.Fees))
+	fmt.Printf("交易数量:%d\n", len(template.Txs))
+	for index, tx := range template.Txs {
+		data, err := tx.Serialize()
+		if err != nil {
+			fmt.Println("交易序列化失败：", err.Error())
+			return
+		}
+		fmt.Printf("第%d笔交易:%x\n", index, tx.TxHash)
+		fmt.Printf("%x\n", data)
+	}
+}
+
+// 提交外部矿工挖出的区块，区块为十六进制的二进制格式
+func (client *Client) SubmitBlock() {
+	submitBlock := flag.NewFlagSet(SUBMITBLOCK, flag.ExitOnError)
+	data := submitBlock.String("hex", "", "十六进制格式的区块，区块头 + 区块体")
+	_ = submitBlock.Parse(os.Args[2:])
+	raw, err := hex.DecodeString(*data)
+	if err != nil || len(raw) == 0 {
+		fmt.Println("区块应为十六进制格式")
+		return
+	}
+	block, err := chain.UnSerialize(raw)
+	if err != nil {
+		fmt.Println("区块解析失败：", err.Error())
+		return
+	}
+	isTip, err := client.Chain.SubmitBlock(block)
+	if err != nil {
+		fmt.Println("区块被拒绝：", err.Error())
+		return
+	}
+	if isTip {
+		fmt.Printf("区块%x已连接到主链，当前最新区块高度:%d\n", block.Hash, client.Chain.LastBlock.Height)
+		return
+	}
+	fmt.Printf("区块%x已保存，但不在主链上\n", block.Hash)
+}
+
+// 在当前进程中持续挖矿，直到在另一个窗口执行stopmining或者按下Ctrl+C。挖矿期间其他命令可以正常使用
+func (client *Client) StartMining() {
+	startMining := flag.NewFlagSet(STARTMINING, flag.ExitOnError)
+	threads := startMining.Int("threads", runtime.NumCPU(), "同时搜索nonce的goroutine数量")
+	_ = startMining.Parse(os.Args[2:])
+	if *threads <= 0 {
+		fmt.Println("goroutine数量必须大于0")
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Printf("开始挖矿，进程号%d，出块奖励给%s\n", os.Getpid(), client.Chain.GetCoinbase())
+	err := client.Chain.RunMiner(ctx, *threads, func(block chain.Block, isTip bool) {
+		if isTip {
+			fmt.Printf("挖出区块：%x，高度:%d\n", block.Hash, block.Height)
+		} else {
+			fmt.Printf("挖出的区块%x不在主链上\n", block.Hash)
+		}
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Println("已停止挖矿")
+}
+
+// 通知正在运行的挖矿进程停止挖矿，挖矿进程记录统计信息后退出
+func (client *Client) StopMining() {
+	if len(os.Args[2:]) > 0 {
+		fmt.Println("stopmining不接收参数")
+		return
+	}
+	info, err := client.Chain.GetMiningInfo()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if info == nil || !info.IsAlive() {
+		fmt.Println("没有正在运行的挖矿进程")
+		return
+	}
+	process, err := os.FindProcess(info.Pid)
+	if err == nil {
+		err = process.Signal(os.Interrupt)
+	}
+	if err != nil {
+		fmt.Println("通知挖矿进程失败：", err.Error())
+		return
+	}
+	fmt.Printf("已通知挖矿进程%d停止挖矿\n", info.Pid)
+}
+
+// 查询挖矿进程的状态：挖出的区块数、当前难度、算力和运行时间
+func (client *Client) GetMiningInfo() {
+	if len(os.Args[2:]) > 0 {
+		fmt.Println("getmininginfo不接收参数")
+		return
+	}
+	info, err := client.Chain.GetMiningInfo()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	difficulty, err := client.Chain.GetDifficulty()
+	if err != nil {
+		fmt.Println("查询难度失败：", err.Error())
+		return
+	}
+	fmt.Printf("区块高度:%d\n", difficulty.Height)
+	fmt.Printf("当前难度:%f\n", difficulty.Difficulty)
+	if info == nil {
+		fmt.Println("还没有启动过挖矿进程")
+		return
+	}
+	if info.IsAlive() {
+		fmt.Printf("挖矿进程:运行中，进程号%d，%d个goroutine\n", info.Pid, info.Threads)
+	} else {
+		fmt.Println("挖矿进程:未运行")
+	}
+	fmt.Printf("矿工地址:%s\n", info.Address)
+	fmt.Printf("开始时间:%s\n", time.Unix(info.StartTime, 0).Format("2006-01-02 15:04:05"))
+	fmt.Printf("运行时间:%s\n", info.Uptime())
+	fmt.Printf("挖出区块:%d\n", info.Blocks)
+	if info.Stale > 0 {
+		fmt.Printf("挖出后不在主链上的区块:%d\n", info.Stale)
+	}
+	fmt.Printf("算力:%.0f次/秒\n", info.Stats.HashRate())
+}
+
+// 查询某个高度的出块奖励、到该高度为止的发行量，以及当前网络的减半计划
+func (client *Client) GetBlockSubsidy() {
+	getBlockSubsidy := flag.NewFlagSet(GETBLOCKSUBSIDY, flag.ExitOnError)
+	height := getBlockSubsidy.Int64("height", client.Chain.LastBlock.Height+1, "区块高度，默认为下一个区块")
+	_ = getBlockSubsidy.Parse(os.Args[2:])
+	if *height < 0 {
+		fmt.Println("区块高度不能小于0")
+		return
+	}
+	interval := chainparams.Active.SubsidyHalvingInterval
+	fmt.Printf("区块高度:%d\n", *height)
+	fmt.Printf("出块奖励:%s\n", utils.FormatAmount(consensus.BlockSubsidy(*height)))
+	fmt.Printf("已减半%d次，下一次减半在高度%d\n", *height/interval, (*height/interval+1)*interval)
+	fmt.Printf("到该高度为止共发行:%s\n", utils.FormatAmount(consensus.TotalSubsidy(*height)))
+	fmt.Printf("最大发行量:%s\n", utils.FormatAmount(consensus.MaxSupply()))
+	fmt.Printf("%s网络的减半计划，每%d个区块减半一次：\n", chainparams.Active.Name, interval)
+	for start := int64(0); consensus.BlockSubsidy(start) > 0; start += interval {
+		fmt.Printf("\t高度%d起，出块奖励%s\n", start, utils.FormatAmount(consensus.BlockSubsidy(start)))
+	}
+}
+
+// 由钱包中的验证者发起治理交易，添加或移除一个验证者
+func (client *Client) SendGovernance(command string, action uint8) {
+	sendGovernance := flag.NewFlagSet(command, flag.ExitOnError)
+	validator := sendGovernance.String("validator", "", "要添加或移除的验证者，钱包中的地址或十六进制公钥")
+	from := sendGovernance.String("from", "", "发起治理交易的验证者地址")
+	_ = sendGovernance.Parse(os.Args[2:])
+	transac, submitted, err := client.Chain.SendGovernance(action, *validator, *from)
+	printGovernanceResult(transac, submitted, err)
+}
+
+// 由钱包中的验证者批准一笔治理交易，交易为发起者或上一个批准者输出的十六进制数据
+func (client *Client) ApproveGovernance() {
+	approveGovernance := flag.NewFlagSet(APPROVEGOVERNANCE, flag.ExitOnError)
+	data := approveGovernance.String("hex", "", "十六进制格式的治理交易")
+	from := approveGovernance.String("from", "", "批准治理交易的验证者地址")
+	_ = approveGovernance.Parse(os.Args[2:])
+	raw, err := hex.DecodeString(*data)
+	if err != nil || len(raw) == 0 {
+		fmt.Println("治理交易应为十六进制格式")
+		return
+	}
+	transac, err := transaction.DeserializeTransaction(raw)
+	if err != nil {
+		fmt.Println("治理交易解析失败：", err.Error())
+		return
+	}
+	approved, submitted, err := client.Chain.ApproveGovernance(transac, *from)
+	printGovernanceResult(approved, submitted, err)
+}
+
+// 治理交易放入了交易池时打印交易hash，还需要其他验证者批准时打印交易数据
+func printGovernanceResult(transac *transaction.Transaction, submitted bool, err error) {
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if submitted {
+		fmt.Printf("治理交易%x已获得超过半数的验证者批准，已放入交易池，等待打包\n", transac.TxHash)
+		return
+	}
+	data, err := transac.Serialize()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("治理交易%x已有%d个批准，还需要超过半数的验证者批准，请其他验证者执行%s -hex -from：\n", transac.TxHash, len(transac.Governance.Approvals), APPROVEGOVERNANCE)
+	fmt.Printf("%x\n", data)
+}
+
+// 按轮流出块的顺序列出当前的验证者
+func (client *Client) GetValidators() {
+	if len(os.Args[2:]) > 0 {
+		fmt.Println("getvalidators不接收参数")
+		return
+	}
+	validators, next, err := client.Chain.GetValidators()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("共有%d个验证者\n", len(validators))
+	for index, validator := range validators {
+		address, _ := wallet.NewAddress(validator)
+		fmt.Printf("第%d个验证者：%s，公钥：%x\n", index, address, validator)
+	}
+	nextAddress, _ := wallet.NewAddress(next)
+	fmt.Printf("下一个区块由%s出块\n", nextAddress)
+}
+
+// 打印utxo缓存的命中情况和内存占用
+func printCacheStats(stats utxoset.CacheStats) {
+	fmt.Printf("utxo缓存：命中%d次，未命中%d次，写回db%d次\n", stats.Hits, stats.Misses, stats.Flushes)
+	fmt.Printf("utxo缓存：%d条记录，其中%d条未写回，占用约%d字节，上限%d字节\n", stats.Entries, stats.Dirty, stats.Usage, stats.Limit)
+}
+
+// 打印交易的输入和输出
+func printTransaction(tx transaction.Transaction) {
+	if tx.IsCoinbaseTranaction() {
+		fmt.Println("\t该笔交易是coinbase交易")
+	}
+	if gov := tx.Governance; gov != nil {
+		action := "添加"
+		if gov.Action == transaction.GOVREMOVEVALIDATOR {
+			action = "移除"
+		}
+		validator, _ := wallet.NewAddress(gov.Validator)
+		fmt.Printf("\t该笔交易是治理交易，%s验证者%s\n", action, validator)
+		for _, approval := range gov.Approvals {
+			signer, _ := wallet.NewAddress(approval.Signer)
+			fmt.Printf("\t\t由%s批准\n", signer)
+		}
+	}
+	fmt.Println("\t该笔交易的交易输入")
+	for index, input := range tx.Inputs {
+		fmt.Printf("\t\t第%d个交易输入，金额属于%x的第%d个\n", index, input.Txid, input.Vout)
+	}
+	fmt.Println("\t该笔交易的交易输出")
+	for index, output := range tx.Outputs {
+		address := wallet.GetAddressWithPubKHash(output.PubHash)
+		fmt.Printf("\t\t第%d个交易输出，转给%s面额为%s的金额\n", index, address, utils.FormatAmount(output.Value))
+	}
+}
+
 /*
 *
 
@@ -323,20 +1179,50 @@ func (client *Client) Help() {
 
 	fmt.Println()
 	fmt.Println("使用説明：")
-	fmt.Println("\tgo  run main.go command[arguments]")
+	fmt.Println("\tgo  run main.go [-network mainnet|testnet|regtest] command[arguments]")
+	fmt.Println()
+	fmt.Println("\t-network选择网络，默认为mainnet，testnet和regtest的数据存放在同名目录下")
 	fmt.Println()
 	fmt.Println("現在使用可能な説明:")
 	fmt.Println()
 	fmt.Println("\tThe commands are:")
 	fmt.Println()
-	fmt.Println("\t" + SENDTRASACTION + "\t\t\t 发送一笔交易-from -to -value")
-	fmt.Println("\t" + GENERATEGENESIS + "\t\t\t 创建创世区块")
+	fmt.Println("\t" + SENDTRASACTION + "\t\t\t 发送一笔交易到交易池-from -to -value [-fee 或 -feerate]")
+	fmt.Println("\t" + GENERATEGENESIS + "\t\t\t 创建创世区块 -address [-consensus pow|pos|poa -validators]")
 	fmt.Println("\t" + GETBLOCKCOUNT + "\t\t\t 捕获区块高度")
 	fmt.Println("\t" + GETLASTBLOCK + "\t\t\t 获取最后一个区块")
 	fmt.Println("\t" + GETALLBLOCKS + "\t\t\t 获取所有区块")
 	fmt.Println("\t" + CREATECHAIN + "\t\t\t 创建区块")
 	fmt.Println("\t" + GETNEWADDRESS + "\t\t\t 自动生成地址")
 	fmt.Println("\t" + LISTADDRESS + "\t\t\t 查看所有地址列表")
+	fmt.Println("\t" + GETBLOCKHASH + "\t\t\t 根据高度查询区块hash -height")
+	fmt.Println("\t" + GETBLOCK + "\t\t\t 根据hash查询区块 -hash [-verbose]")
+	fmt.Println("\t" + GETBLOCKHEADER + "\t\t 根据hash只查询区块头 -hash")
+	fmt.Println("\t" + BUILDTXINDEX + "\t\t\t 开启并补建交易索引")
+	fmt.Println("\t" + GETTRANSACTION + "\t\t\t 根据交易hash查询交易 -txid")
+	fmt.Println("\t" + GETADDRESSHISTORY + "\t\t 查询地址的收支记录 -address [-from -to]")
+	fmt.Println("\t" + GETCHAINTIPS + "\t\t\t 查询主链和分叉的末端区块")
+	fmt.Println("\t" + INVALIDATEBLOCK + "\t\t 将区块标记为无效并回退主链 -hash")
+	fmt.Println("\t" + RECONSIDERBLOCK + "\t\t 撤销区块的无效标记 -hash")
+	fmt.Println("\t" + VERIFYCHAIN + "\t\t\t 重新验证主链上的区块 [-depth -level]")
+	fmt.Println("\t" + REINDEXCHAINSTATE + "\t\t 从区块重建utxo集合 -dbcache")
+	fmt.Println("\t" + GETRAWMEMPOOL + "\t\t\t 查询交易池中的交易")
+	fmt.Println("\t" + GETMEMPOOLENTRY + "\t\t 查询交易池中的某笔交易 -txid")
+	fmt.Println("\t" + GENERATE + "\t\t\t 挖出区块，打包交易池中的交易 -blocks -dbcache -threads(只对pow有效)")
+	fmt.Println("\t" + GETTXOUTSETINFO + "\t\t 查询utxo集合的数量、总金额、大小和hash")
+	fmt.Println("\t" + GETTXOUT + "\t\t\t 查询未花费的交易输出 -txid -vout [-include-mempool]")
+	fmt.Println("\t" + SCANTXOUTSET + "\t\t\t 扫描地址或公钥hash的utxo [-addresses -pubkeyhashes]")
+	fmt.Println("\t" + GETDIFFICULTY + "\t\t\t 查询当前难度和下一个区块的难度目标")
+	fmt.Println("\t" + GETBLOCKSUBSIDY + "\t\t 查询出块奖励、发行量和减半计划 [-height]")
+	fmt.Println("\t" + ADDVALIDATOR + "\t\t\t 发起添加验证者的治理交易 -validator -from")
+	fmt.Println("\t" + REMOVEVALIDATOR + "\t\t 发起移除验证者的治理交易 -validator -from")
+	fmt.Println("\t" + APPROVEGOVERNANCE + "\t\t 批准其他验证者发起的治理交易，超过半数批准后放入交易池 -hex -from")
+	fmt.Println("\t" + GETVALIDATORS + "\t\t\t 按出块顺序列出当前的验证者")
+	fmt.Println("\t" + GETBLOCKTEMPLATE + "\t\t 生成供外部矿工挖矿的区块模板")
+	fmt.Println("\t" + SUBMITBLOCK + "\t\t\t 提交外部矿工挖出的区块 -hex")
+	fmt.Println("\t" + STARTMINING + "\t\t\t 持续挖矿，出块奖励给矿工地址，直到stopmining -threads")
+	fmt.Println("\t" + STOPMINING + "\t\t\t 停止正在运行的挖矿进程")
+	fmt.Println("\t" + GETMININGINFO + "\t\t\t 查询挖出的区块数、当前难度、算力和运行时间")
 
 	fmt.Println()
 	fmt.Println("追加のヘルプタイプ")
@@ -14,5 +14,33 @@ const (
 	DUMPPRIVATEKEY = "dumpprivatekey"
 	SETCOINBASE   ="setcoinbase" //设置矿工地址
 	GETCOINBASE = "getcoinbase"
+	GETBLOCKHASH = "getblockhash"
+	GETBLOCK = "getblock"
+	GETBLOCKHEADER = "getblockheader"
+	BUILDTXINDEX = "buildtxindex"
+	GETTRANSACTION = "gettransaction"
+	GETADDRESSHISTORY = "getaddresshistory"
+	GETCHAINTIPS = "getchaintips"
+	INVALIDATEBLOCK = "invalidateblock"
+	RECONSIDERBLOCK = "reconsiderblock"
+	VERIFYCHAIN = "verifychain"
+	REINDEXCHAINSTATE = "reindex-chainstate"
+	GETRAWMEMPOOL = "getrawmempool"
+	GETMEMPOOLENTRY = "getmempoolentry"
+	GENERATE = "generate"
+	GETTXOUTSETINFO = "gettxoutsetinfo"
+	GETTXOUT = "gettxout"
+	SCANTXOUTSET = "scantxoutset"
+	GETDIFFICULTY = "getdifficulty"
+	ADDVALIDATOR = "addvalidator"
+	REMOVEVALIDATOR = "removevalidator"
+	GETVALIDATORS = "getvalidators"
+	APPROVEGOVERNANCE = "approvegovernance"
+	GETBLOCKTEMPLATE = "getblocktemplate"
+	SUBMITBLOCK = "submitblock"
+	STARTMINING = "startmining"
+	STOPMINING = "stopmining"
+	GETMININGINFO = "getmininginfo"
+	GETBLOCKSUBSIDY = "getblocksubsidy"
 	HELP = "help"
 )
@@ -1,15 +1,55 @@
 package consensus
 
 import (
+	"PublicChain/chainparams"
 	"PublicChain/transaction"
+	"context"
+	"errors"
 	"math/big"
+	"runtime"
+	"time"
 )
 
+// 共识机制的名称，创建创世区块时选定，记录在链参数中
+const (
+	POW = "pow" // 工作量证明
+	POS = "pos" // 权益证明，按余额加权选出出块者
+	POA = "poa" // 权威证明，验证者轮流出块
+)
+
+// nonce的取值范围全部尝试过仍未找到满足条件的hash，需要修改区块中的其他内容后重新搜索
+var ErrNonceExhausted = errors.New("nonce已用完")
+
 /**
- * 共识机制的接口标准,用于定义共识方案的接口
+ * 共识机制的接口标准,用于定义共识方案的接口。
+ * 搜索可以通过ctx取消，取消时返回ctx.Err()；出错时返回的结果中只有hash次数和时间有效
  */
 type Consensus interface {
-	SearchNonce() ([32]byte,int64)
+	SearchNonce(ctx context.Context) (*SearchResult, error)
+}
+
+/**
+ * 一次搜索的结果：满足条件的区块hash、nonce和时间戳，以及一共计算了多少次hash、用了多长时间。
+ * 需要签名的共识机制还会给出出块者的公钥和对区块hash的签名
+ */
+type SearchResult struct {
+	Hash      [32]byte
+	Nonce     uint32
+	Timestamp int64
+	Producer  []byte
+	Signature []byte
+	Hashes    uint64
+	Elapsed   time.Duration
+}
+
+/**
+ * 搜索期间的算力，每秒计算的hash次数
+ */
+func (result *SearchResult) HashRate() float64 {
+	if result.Elapsed <= 0 {
+		return 0
+	}
+	return float64(result.Hashes) / result.Elapsed.Seconds()
 }
 
 /**
@@ -23,11 +63,57 @@ type BlockInterface interface {
 	//GetData() []byte
 	GetTxs()  []transaction.Transaction
 	GetMerkleRoot() []byte
+	GetHeader() BlockHeader
+}
+
+/**
+ * 创建工作量证明，threads为同时搜索nonce的goroutine数量，小于等于0时使用全部cpu核数
+ */
+func NewProofWork(block BlockInterface, threads int) Consensus {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	return ProofWork{block,CompactToTarget(block.GetHeader().Bits),threads}
+}
+
+/**
+ * 根据当前网络的初始难度生成工作量证明的目标值，创世区块使用该目标值，之后的区块按实际出块时间调整
+ */
+func NewTarget() *big.Int {
+	difficulty := chainparams.Active.InitialDifficulty
+	init := big.NewInt(int64(difficulty))
+	init.Lsh(init, 255 - difficulty)
+	return init
+}
+
+/**
+ * 初始难度目标值的压缩格式，创世区块的区块头中记录该值
+ */
+func NewBits() uint32 {
+	return TargetToCompact(NewTarget())
 }
 
-func NewProofWork(block BlockInterface) Consensus {
-	init := big.NewInt(DIFFCULT)
-	init.Lsh(init, 255 - DIFFCULT)
-	return ProofWork{block,init}
+/**
+ * 计算某个目标值对应的工作量，即平均需要尝试的hash次数：2^256 / (target+1)
+ */
+func CalculateWork(target *big.Int) *big.Int {
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, denominator)
 }
 
+/**
+ * 校验区块的工作量证明：重新计算区块头的hash，hash需与给定的一致，
+ * 区块头中记录的难度目标需在允许的范围内，并且hash小于该目标值。
+ * 难度目标是否符合调整规则与链上的前序区块有关，由调用方检查
+ */
+func CheckProofOfWork(header BlockHeader, hash [32]byte) bool {
+	if header.BlockHash() != hash {
+		return false
+	}
+	if !CheckBitsRange(header.Bits) {
+		return false
+	}
+	hashBig := new(big.Int).SetBytes(hash[:])
+	return hashBig.Cmp(CompactToTarget(header.Bits)) == -1
+}
@@ -0,0 +1,125 @@
+package consensus
+
+import (
+	"PublicChain/utils"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// 区块头序列化后的固定长度
+const HEADERSIZE = 4 + 32 + 32 + 8 + 4 + 4
+
+// nonce在区块头序列化结果中的偏移量
+const nonceOffset = HEADERSIZE - 4
+
+/**
+ * 区块头：区块hash只对区块头计算，交易通过默克尔根间接提交到区块hash中
+ */
+type BlockHeader struct {
+	Version    int32    // 版本号
+	PreHash    [32]byte // 前一个区块hash
+	MerkleRoot [32]byte // 默克尔根
+	Timestamp  int64    // 时间戳
+	Bits       uint32   // 压缩格式的难度目标值
+	Nonce      uint32   // 随机数
+}
+
+/*
+区块头的二进制格式，整数均为小端序，固定84字节：
+
+	版本号         int32
+	前一个区块hash 32字节
+	默克尔根       32字节
+	时间戳         int64
+	难度目标       uint32
+	nonce          uint32
+*/
+func (header *BlockHeader) Serialize() []byte {
+	buff := bytes.NewBuffer(make([]byte, 0, HEADERSIZE))
+	utils.WriteUint32(buff, uint32(header.Version))
+	buff.Write(header.PreHash[:])
+	buff.Write(header.MerkleRoot[:])
+	utils.WriteInt64(buff, header.Timestamp)
+	utils.WriteUint32(buff, header.Bits)
+	utils.WriteUint32(buff, header.Nonce)
+	return buff.Bytes()
+}
+
+/**
+ * 反序列化区块头，数据必须正好是一个区块头
+ */
+func DeserializeHeader(data []byte) (BlockHeader, error) {
+	var header BlockHeader
+	if len(data) != HEADERSIZE {
+		return header, errors.New("区块头长度有误")
+	}
+	header.Version = int32(binary.LittleEndian.Uint32(data[0:4]))
+	copy(header.PreHash[:], data[4:36])
+	copy(header.MerkleRoot[:], data[36:68])
+	header.Timestamp = int64(binary.LittleEndian.Uint64(data[68:76]))
+	header.Bits = binary.LittleEndian.Uint32(data[76:80])
+	header.Nonce = binary.LittleEndian.Uint32(data[80:84])
+	return header, nil
+}
+
+/**
+ * 区块hash：区块头序列化结果的两次sha256
+ */
+func (header *BlockHeader) BlockHash() [32]byte {
+	return doubleSha256(header.Serialize())
+}
+
+/**
+ * 带签名的区块(权益证明和权威证明)的区块hash：区块头序列化结果之后接上出块者公钥，再计算两次sha256。
+ * 出块者随之提交到区块hash中，更换出块者后区块hash也不同
+ */
+func (header *BlockHeader) SignedBlockHash(producer []byte) [32]byte {
+	return doubleSha256(append(header.Serialize(), producer...))
+}
+
+func doubleSha256(data []byte) [32]byte {
+	first := sha256.Sum256(data)
+	return sha256.Sum256(first[:])
+}
+
+/**
+ * 把压缩格式的难度目标还原为目标值：最高字节为指数，低3字节为尾数，目标值 = 尾数 * 256^(指数-3)。
+ * 尾数的最高位是符号位，目标值不能为负，符号位为1时返回0
+ */
+func CompactToTarget(bits uint32) *big.Int {
+	exponent := uint(bits >> 24)
+	mantissa := int64(bits & 0x007fffff)
+	if bits&0x00800000 != 0 {
+		return big.NewInt(0)
+	}
+	target := big.NewInt(mantissa)
+	if exponent <= 3 {
+		return target.Rsh(target, 8*(3-exponent))
+	}
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+/**
+ * 把目标值压缩为4个字节，只保留最高的3个字节，与CompactToTarget互逆
+ */
+func TargetToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+	exponent := uint((target.BitLen() + 7) / 8)
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64() << (8 * (3 - exponent)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(exponent-3)).Uint64())
+	}
+	// 尾数的最高位是符号位，被占用时尾数右移一个字节，指数加一
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent)<<24 | mantissa
+}
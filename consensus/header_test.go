@@ -0,0 +1,60 @@
+package consensus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	cases := []BlockHeader{
+		{},
+		{
+			Version:    -1,
+			PreHash:    [32]byte{1, 2, 3},
+			MerkleRoot: [32]byte{31: 0xff},
+			Timestamp:  1792281600,
+			Bits:       0x1d00ffff,
+			Nonce:      0xffffffff,
+		},
+	}
+	for _, header := range cases {
+		data := header.Serialize()
+		if len(data) != HEADERSIZE {
+			t.Errorf("区块头序列化后为%d字节，应为%d字节", len(data), HEADERSIZE)
+		}
+		decoded, err := DeserializeHeader(data)
+		if err != nil {
+			t.Errorf("反序列化出错：%v", err)
+			continue
+		}
+		if decoded != header {
+			t.Errorf("读出%+v，应为%+v", decoded, header)
+		}
+		if !bytes.Equal(decoded.Serialize(), data) {
+			t.Error("重新序列化的结果与原来的不一致")
+		}
+	}
+}
+
+func TestDeserializeHeaderLength(t *testing.T) {
+	for _, size := range []int{0, HEADERSIZE - 1, HEADERSIZE + 1} {
+		_, err := DeserializeHeader(make([]byte, size))
+		if err == nil {
+			t.Errorf("%d字节的区块头应反序列化失败", size)
+		}
+	}
+}
+
+// nonce位于区块头的最后4个字节，只改nonce时区块hash随之变化
+func TestHeaderNonceOffset(t *testing.T) {
+	header := BlockHeader{Nonce: 0x01020304}
+	data := header.Serialize()
+	if !bytes.Equal(data[nonceOffset:], []byte{0x04, 0x03, 0x02, 0x01}) {
+		t.Errorf("nonce的位置有误：%x", data[nonceOffset:])
+	}
+	hash := header.BlockHash()
+	header.Nonce++
+	if header.BlockHash() == hash {
+		t.Error("修改nonce后区块hash没有变化")
+	}
+}
@@ -0,0 +1,61 @@
+package consensus
+
+import (
+	"PublicChain/wallet"
+	"context"
+	"time"
+)
+
+/**
+ * 权威证明：验证者按顺序轮流出块，轮到的验证者等到时间戳大于父区块后，用私钥对区块hash签名。
+ * 出块不需要计算hash，出块间隔至少为1秒
+ */
+type ProofAuthority struct {
+	Block      BlockInterface
+	ParentTime int64           // 父区块的时间戳，区块时间戳必须大于该值
+	Key        *wallet.KeyPair // 轮到出块的验证者的密钥对
+}
+
+/**
+ * 创建权威证明，key为轮到出块的验证者的密钥对
+ */
+func NewProofAuthority(block BlockInterface, parentTime int64, key *wallet.KeyPair) Consensus {
+	return ProofAuthority{block, parentTime, key}
+}
+
+/**
+ * 权威证明使用固定的难度目标，不参与难度调整，每个区块的工作量相同
+ */
+func NewAuthorityBits() uint32 {
+	return TargetToCompact(PowLimit())
+}
+
+/**
+ * 实现共识机制接口的方法：时间戳取区块时间戳和父区块时间戳+1中较大的一个，等到该时间后签名
+ */
+func (authority ProofAuthority) SearchNonce(ctx context.Context) (*SearchResult, error) {
+	start := time.Now()
+	result := &SearchResult{}
+	header := authority.Block.GetHeader()
+	if header.Timestamp <= authority.ParentTime {
+		header.Timestamp = authority.ParentTime + 1
+	}
+	err := sleepUntil(ctx, header.Timestamp)
+	if err != nil {
+		result.Elapsed = time.Since(start)
+		return result, err
+	}
+	hash := header.SignedBlockHash(authority.Key.Pub)
+	signature, err := SignBlockHash(authority.Key.Pri, hash)
+	if err != nil {
+		return result, err
+	}
+	result.Hash = hash
+	result.Nonce = header.Nonce
+	result.Timestamp = header.Timestamp
+	result.Producer = authority.Key.Pub
+	result.Signature = signature
+	result.Hashes = 1
+	result.Elapsed = time.Since(start)
+	return result, nil
+}
@@ -0,0 +1,174 @@
+package consensus
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/utils"
+	"PublicChain/wallet"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// 钱包中没有可以质押的余额，无法产出区块
+var ErrNoStake = errors.New("没有可以质押的余额")
+
+/**
+ * 参与出块的质押者：钱包中的密钥对，以及该地址在父区块时已成熟的余额
+ */
+type Staker struct {
+	Key   *wallet.KeyPair
+	Stake int64 // 质押权重，即可质押的余额，单位为1/utils.COIN个币
+}
+
+/**
+ * 权益证明：每一秒每个质押者计算一次kernel hash，kernel hash小于 目标值*质押权重 的质押者
+ * 可以产出该秒的区块，并用私钥对区块hash签名。余额越多，每秒产出区块的概率越大
+ */
+type ProofStake struct {
+	Block      BlockInterface
+	ParentTime int64 // 父区块的时间戳，区块时间戳必须大于该值
+	Stakers    []Staker
+}
+
+/**
+ * 创建权益证明，stakers为钱包中有余额的质押者
+ */
+func NewProofStake(block BlockInterface, parentTime int64, stakers []Staker) Consensus {
+	return ProofStake{block, parentTime, stakers}
+}
+
+/**
+ * 质押的初始难度目标：创世区块奖励全部参与质押时，平均每个出块间隔产出一个区块
+ */
+func NewStakeBits() uint32 {
+	target := new(big.Int).Lsh(big.NewInt(1), 256)
+	params := chainparams.Active
+	target.Div(target, big.NewInt(params.TargetSpacing*params.Reward))
+	return TargetToCompact(target)
+}
+
+/**
+ * 实现共识机制接口的方法：从区块的时间戳开始逐秒尝试，每秒为每个质押者计算一次kernel hash。
+ * 不产出未来时间的区块，尝试的时间超过当前时间时等待到该秒，等待期间可以通过ctx取消。
+ * 找到后把时间戳写入区块头，用质押者的私钥对包含其公钥的区块hash签名
+ */
+func (stake ProofStake) SearchNonce(ctx context.Context) (*SearchResult, error) {
+	start := time.Now()
+	result := &SearchResult{}
+	if len(stake.Stakers) == 0 {
+		return result, ErrNoStake
+	}
+	header := stake.Block.GetHeader()
+	timestamp := header.Timestamp
+	if timestamp <= stake.ParentTime {
+		timestamp = stake.ParentTime + 1
+	}
+	for ; ; timestamp++ {
+		err := sleepUntil(ctx, timestamp)
+		if err != nil {
+			result.Elapsed = time.Since(start)
+			return result, err
+		}
+		for _, staker := range stake.Stakers {
+			kernel := StakeKernel(header.PreHash, staker.Key.Pub, timestamp)
+			result.Hashes++
+			if !CheckStakeKernel(kernel, header.Bits, staker.Stake) {
+				continue
+			}
+			header.Timestamp = timestamp
+			hash := header.SignedBlockHash(staker.Key.Pub)
+			signature, err := SignBlockHash(staker.Key.Pri, hash)
+			if err != nil {
+				return result, err
+			}
+			result.Hash = hash
+			result.Nonce = header.Nonce
+			result.Timestamp = timestamp
+			result.Producer = staker.Key.Pub
+			result.Signature = signature
+			result.Elapsed = time.Since(start)
+			return result, nil
+		}
+	}
+}
+
+// 等待到某一秒，ctx取消时返回ctx.Err()
+func sleepUntil(ctx context.Context, timestamp int64) error {
+	wait := time.Until(time.Unix(timestamp, 0))
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+/**
+ * kernel hash：父区块hash + 质押者公钥 + 时间戳的两次sha256。
+ * 与区块中的交易和nonce无关，质押者无法通过修改区块内容重新抽签；每秒只能抽一次，
+ * 时间戳又不能超前当前时间太多。质押权重只计入已达到成熟深度的utxo，新产生的币和新地址
+ * 要等父区块hash变得无法预知之后才能参与，不能预先为未来的父区块挑选抽中的公钥
+ */
+func StakeKernel(preHash [32]byte, producer []byte, timestamp int64) [32]byte {
+	buff := new(bytes.Buffer)
+	buff.Write(preHash[:])
+	buff.Write(producer)
+	utils.WriteInt64(buff, timestamp)
+	return doubleSha256(buff.Bytes())
+}
+
+/**
+ * kernel hash是否小于 目标值*质押权重，质押权重必须大于0
+ */
+func CheckStakeKernel(kernel [32]byte, bits uint32, stake int64) bool {
+	if stake <= 0 {
+		return false
+	}
+	target := CompactToTarget(bits)
+	target.Mul(target, big.NewInt(stake))
+	return new(big.Int).SetBytes(kernel[:]).Cmp(target) == -1
+}
+
+/**
+ * 用出块者的私钥对区块hash签名，r和s各自补齐为32字节
+ */
+func SignBlockHash(private *ecdsa.PrivateKey, hash [32]byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, private, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), nil
+}
+
+/**
+ * 校验区块的签名：重新计算包含出块者公钥的区块hash，hash需与给定的一致，区块头中记录的难度目标需在允许的范围内，
+ * 并且签名能用出块者的公钥验证。出块者是否有资格产出该区块与父区块时的余额有关，由调用方检查
+ */
+func CheckBlockSignature(header BlockHeader, hash [32]byte, producer []byte, signature []byte) bool {
+	if header.SignedBlockHash(producer) != hash {
+		return false
+	}
+	if !CheckBitsRange(header.Bits) {
+		return false
+	}
+	if len(signature) != 64 {
+		return false
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), producer)
+	if x == nil {
+		return false
+	}
+	pub := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	r, s := wallet.RestoreSignature(signature)
+	return ecdsa.Verify(&pub, hash[:], r, s)
+}
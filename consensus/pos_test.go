@@ -0,0 +1,136 @@
+package consensus
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/wallet"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// 只有区块头的区块，用于测试共识引擎
+type testBlock struct {
+	header BlockHeader
+}
+
+func (block testBlock) GetHeight() int64                  { return 1 }
+func (block testBlock) GetVersion() int64                 { return int64(block.header.Version) }
+func (block testBlock) GetTimeStamp() int64               { return block.header.Timestamp }
+func (block testBlock) GetPreHash() [32]byte              { return block.header.PreHash }
+func (block testBlock) GetTxs() []transaction.Transaction { return nil }
+func (block testBlock) GetMerkleRoot() []byte             { return block.header.MerkleRoot[:] }
+func (block testBlock) GetHeader() BlockHeader            { return block.header }
+
+func newTestKeyPair(t *testing.T) *wallet.KeyPair {
+	t.Helper()
+	keyPair, err := wallet.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyPair
+}
+
+// kernel hash小于 目标值*质押权重 时抽中，质押权重不大于0时永远抽不中
+func TestCheckStakeKernel(t *testing.T) {
+	bits := uint32(0x1d00ffff)
+	target := CompactToTarget(bits)
+	kernelOf := func(value *big.Int) [32]byte {
+		var kernel [32]byte
+		value.FillBytes(kernel[:])
+		return kernel
+	}
+	cases := []struct {
+		name   string
+		kernel [32]byte
+		stake  int64
+		valid  bool
+	}{
+		{"kernel为0", [32]byte{}, 1, true},
+		{"质押权重为0", [32]byte{}, 0, false},
+		{"质押权重为负数", [32]byte{}, -1, false},
+		{"刚好小于目标值", kernelOf(new(big.Int).Sub(target, big.NewInt(1))), 1, true},
+		{"等于目标值", kernelOf(target), 1, false},
+		{"权重加倍后小于目标值", kernelOf(target), 2, true},
+		{"等于加倍后的目标值", kernelOf(new(big.Int).Mul(target, big.NewInt(2))), 2, false},
+	}
+	for _, c := range cases {
+		if got := CheckStakeKernel(c.kernel, bits, c.stake); got != c.valid {
+			t.Errorf("%s：结果为%v，应为%v", c.name, got, c.valid)
+		}
+	}
+}
+
+// kernel hash只与父区块hash、质押者公钥和时间戳有关，任何一项改变都会重新抽签
+func TestStakeKernelInputs(t *testing.T) {
+	producer := newTestKeyPair(t).Pub
+	other := newTestKeyPair(t).Pub
+	kernel := StakeKernel([32]byte{1}, producer, 100)
+	if StakeKernel([32]byte{1}, producer, 100) != kernel {
+		t.Error("相同输入的kernel hash不一致")
+	}
+	if StakeKernel([32]byte{2}, producer, 100) == kernel || StakeKernel([32]byte{1}, other, 100) == kernel || StakeKernel([32]byte{1}, producer, 101) == kernel {
+		t.Error("父区块hash、公钥或时间戳改变后kernel hash应改变")
+	}
+}
+
+// 抽中后用质押者的私钥对包含其公钥的区块hash签名，更换出块者或签名后校验失败
+func TestProofStakeSignature(t *testing.T) {
+	// 使用最低难度，目标值乘以质押权重超过2^256，每秒都能抽中
+	bits := TargetToCompact(PowLimit())
+	stake := new(big.Int).Rsh(new(big.Int).Lsh(big.NewInt(1), 257), uint(PowLimit().BitLen())).Int64()
+	staker := Staker{Key: newTestKeyPair(t), Stake: stake}
+	parentTime := time.Now().Unix() - 100
+	header := BlockHeader{PreHash: [32]byte{1}, Timestamp: parentTime, Bits: bits}
+	result, err := NewProofStake(testBlock{header}, parentTime, []Staker{staker}).SearchNonce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Timestamp != parentTime+1 {
+		t.Errorf("时间戳为%d，应为父区块时间戳+1", result.Timestamp)
+	}
+	header.Timestamp = result.Timestamp
+	if result.Hash != header.SignedBlockHash(staker.Key.Pub) || result.Hash == header.BlockHash() {
+		t.Error("区块hash应包含出块者公钥")
+	}
+	if !CheckBlockSignature(header, result.Hash, result.Producer, result.Signature) {
+		t.Fatal("抽中的区块签名校验失败")
+	}
+
+	other := newTestKeyPair(t)
+	if CheckBlockSignature(header, result.Hash, other.Pub, result.Signature) {
+		t.Error("更换出块者公钥后签名校验应失败")
+	}
+	signature, err := SignBlockHash(other.Pri, result.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CheckBlockSignature(header, result.Hash, other.Pub, signature) {
+		t.Error("其他人对原区块hash重新签名后校验应失败")
+	}
+	resigned, err := SignBlockHash(other.Pri, header.SignedBlockHash(other.Pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckBlockSignature(header, header.SignedBlockHash(other.Pub), other.Pub, resigned) {
+		t.Error("其他人重新签名得到的是另一个区块，hash应不同且签名有效")
+	}
+}
+
+// 没有质押者时直接报错；所有质押者都抽不中时一直尝试到被取消
+func TestProofStakeNoStake(t *testing.T) {
+	header := BlockHeader{Timestamp: time.Now().Unix() - 100, Bits: TargetToCompact(PowLimit())}
+	_, err := NewProofStake(testBlock{header}, header.Timestamp, nil).SearchNonce(context.Background())
+	if !errors.Is(err, ErrNoStake) {
+		t.Errorf("没有质押者时返回%v，应为ErrNoStake", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	staker := Staker{Key: newTestKeyPair(t), Stake: 0}
+	_, err = NewProofStake(testBlock{header}, header.Timestamp, []Staker{staker}).SearchNonce(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("质押权重为0时返回%v，应一直尝试到被取消", err)
+	}
+}
@@ -1,77 +1,88 @@
 package consensus
 
 import (
-	"PublicChain/utils"
-	"bytes"
-	"crypto/sha256"
-	"fmt"
+	"context"
+	"encoding/binary"
 	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 
-const DIFFCULT = 20
+// 每个goroutine每计算多少次hash检查一次是否需要停止
+const checkInterval = 1 << 12
 
 /**
  * 工作量证明
  */
 type ProofWork struct {
-	Block  BlockInterface
-	Target *big.Int
+	Block   BlockInterface
+	Target  *big.Int
+	Threads int // 同时搜索nonce的goroutine数量
 }
 
 /**
- * 实现共识机制接口的方法
+ * 实现共识机制接口的方法：nonce的取值范围按goroutine数量交错划分，
+ * 第i个goroutine依次尝试 i, i+Threads, i+2*Threads ...
+ * 任意一个找到满足条件的nonce后其余的停止；全部尝试完仍未找到时返回ErrNonceExhausted
  */
-func (work ProofWork) SearchNonce() ([32]byte, int64) {
+func (work ProofWork) SearchNonce(ctx context.Context) (*SearchResult, error) {
+	start := time.Now()
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	//1 给定一个non值，计算带有non的区块哈希
-	var nonce int64
-	nonce = 0
-	hashBig :=new(big.Int)
+	//区块头中只有nonce会变化，先把区块头序列化好，每次只改写nonce所在的4个字节
+	header := work.Block.GetHeader()
+	headerBytes := header.Serialize()
 
-	for {
-		hash := CalculateBlockHash(work.Block, nonce)
-		//2 系统给定的值
-		target := work.Target
-		//3 拿1和2比较
-		hashBig =hashBig.SetBytes(hash[:])
-		result :=hashBig.Cmp(target)
-		//result := bytes.Compare(hash[:], target.Bytes())
-		//4 判断结果，区块哈希<给定值，返回non;
-		if result == -1 {
-			return hash, nonce
-		}
-		//否则，non自增
-		nonce++
+	var hashes uint64
+	found := make(chan SearchResult, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < work.Threads; i++ {
+		wg.Add(1)
+		go func(first uint64) {
+			defer wg.Done()
+			data := append([]byte{}, headerBytes...)
+			nonceBytes := data[nonceOffset:]
+			hashBig := new(big.Int)
+			var count uint64
+			defer func() { atomic.AddUint64(&hashes, count) }()
+			for nonce := first; nonce <= 0xffffffff; nonce += uint64(work.Threads) {
+				if count%checkInterval == 0 && searchCtx.Err() != nil {
+					return
+				}
+				//1 给定一个non值，计算带有non的区块哈希
+				binary.LittleEndian.PutUint32(nonceBytes, uint32(nonce))
+				hash := doubleSha256(data)
+				count++
+				//2 拿hash和目标值比较，区块哈希<目标值，返回non
+				hashBig.SetBytes(hash[:])
+				if hashBig.Cmp(work.Target) == -1 {
+					select {
+					case found <- SearchResult{Hash: hash, Nonce: uint32(nonce)}:
+					default:
+					}
+					cancel()
+					return
+				}
+			}
+		}(uint64(i))
 	}
-}
+	wg.Wait()
 
-/**
- * 根据当前的区块和当前的non值，计算区块的哈希值
- */
-func CalculateBlockHash(block BlockInterface, nonce int64) [32]byte {
-	heightByte, _ := utils.IntToByte(block.GetHeight())
-	versionByte, _ := utils.IntToByte(block.GetVersion())
-	timeByte, _ := utils.IntToByte(block.GetTimeStamp())
-	nonceByte, _ := utils.IntToByte(nonce)
-	preHash := block.GetPreHash()
-	txs :=block.GetTxs()
-	txsBytes :=make([]byte,0)
-	for _,tx:=range txs{
-		tyBytes ,err :=utils.GobEncode(tx)
-		if err !=nil{
-			fmt.Println(err.Error())
+	var result SearchResult
+	var err error
+	select {
+	case result = <-found:
+	default:
+		err = ErrNonceExhausted
+		if ctx.Err() != nil {
+			err = ctx.Err()
 		}
-		txsBytes =append(txsBytes,tyBytes...)
 	}
-
-	bk := bytes.Join([][]byte{heightByte,
-		versionByte,
-		preHash[:],
-		timeByte,
-		nonceByte,
-		txsBytes,
-		},
-		[]byte{})
-	return sha256.Sum256(bk)
+	result.Timestamp = header.Timestamp
+	result.Hashes = hashes
+	result.Elapsed = time.Since(start)
+	return &result, err
 }
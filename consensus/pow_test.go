@@ -0,0 +1,62 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// 多个goroutine同时搜索时找到的nonce同样满足目标值，结果中的hash为带该nonce的区块头hash
+func TestProofWorkSearchNonce(t *testing.T) {
+	header := BlockHeader{Version: 1, PreHash: [32]byte{1}, Timestamp: 1792281601, Bits: 0x207fffff}
+	target := new(big.Int).Lsh(big.NewInt(1), 248)
+	for _, threads := range []int{1, 4} {
+		work := ProofWork{Block: testBlock{header}, Target: target, Threads: threads}
+		result, err := work.SearchNonce(context.Background())
+		if err != nil {
+			t.Fatalf("%d个goroutine：%v", threads, err)
+		}
+		found := header
+		found.Nonce = result.Nonce
+		if result.Hash != found.BlockHash() {
+			t.Errorf("%d个goroutine：结果中的hash与nonce%d不一致", threads, result.Nonce)
+		}
+		if new(big.Int).SetBytes(result.Hash[:]).Cmp(target) >= 0 {
+			t.Errorf("%d个goroutine：hash%x不小于目标值", threads, result.Hash)
+		}
+		if result.Hashes == 0 || result.Timestamp != header.Timestamp {
+			t.Errorf("%d个goroutine：统计信息为%+v", threads, result)
+		}
+	}
+}
+
+// 搜索可以通过ctx取消，取消后所有goroutine停止并返回ctx的错误
+func TestProofWorkCancel(t *testing.T) {
+	work := ProofWork{Block: testBlock{BlockHeader{Bits: 0x207fffff}}, Target: big.NewInt(0), Threads: 4}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, err := work.SearchNonce(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("取消后返回%v，应为ctx的错误", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("取消后过了%s才停止", elapsed)
+	}
+	if result.Hashes == 0 {
+		t.Error("取消时应返回已计算的hash次数")
+	}
+}
+
+func TestNewProofWorkThreads(t *testing.T) {
+	block := testBlock{BlockHeader{Bits: 0x207fffff}}
+	if work := NewProofWork(block, 0).(ProofWork); work.Threads != runtime.NumCPU() {
+		t.Errorf("goroutine数量为0时使用%d个，应为CPU核数%d", work.Threads, runtime.NumCPU())
+	}
+	if work := NewProofWork(block, 3).(ProofWork); work.Threads != 3 || work.Target.Cmp(CompactToTarget(0x207fffff)) != 0 {
+		t.Errorf("工作量证明为%+v", work)
+	}
+}
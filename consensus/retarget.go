@@ -0,0 +1,66 @@
+package consensus
+
+import (
+	"PublicChain/chainparams"
+	"math/big"
+)
+
+/**
+ * 目标值允许的最大值，即最低难度，难度为1时的目标值，由当前网络决定
+ */
+func PowLimit() *big.Int {
+	return new(big.Int).Set(chainparams.Active.PowLimit)
+}
+
+/**
+ * 该高度的区块是否需要重新计算难度，创世区块使用初始难度。调整间隔由当前网络决定，回归测试网不调整难度
+ */
+func IsRetargetHeight(height int64) bool {
+	params := chainparams.Active
+	return !params.NoRetargeting && height > 0 && height%params.RetargetInterval == 0
+}
+
+/*
+根据上一个调整周期实际花费的时间计算新的难度目标：
+
+	a.实际时间为周期内第一个区块到最后一个区块的时间戳之差
+	b.实际时间限制在期望时间的1/4到4倍之间，每次调整难度最多变化4倍
+	c.新目标值 = 旧目标值 * 实际时间 / 期望时间，不超过PowLimit
+*/
+func CalculateNextBits(lastBits uint32, firstTime int64, lastTime int64) uint32 {
+	expected := chainparams.Active.TargetTimespan()
+	timespan := lastTime - firstTime
+	if timespan < expected/4 {
+		timespan = expected / 4
+	}
+	if timespan > expected*4 {
+		timespan = expected * 4
+	}
+	target := CompactToTarget(lastBits)
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(expected))
+	if limit := PowLimit(); target.Cmp(limit) > 0 {
+		target.Set(limit)
+	}
+	return TargetToCompact(target)
+}
+
+/**
+ * 难度目标是否在允许的范围内：目标值大于0且不超过PowLimit
+ */
+func CheckBitsRange(bits uint32) bool {
+	target := CompactToTarget(bits)
+	return target.Sign() > 0 && target.Cmp(PowLimit()) <= 0
+}
+
+/**
+ * 难度：PowLimit与目标值的比值，难度越大挖出区块平均需要的hash次数越多
+ */
+func GetDifficulty(bits uint32) float64 {
+	target := CompactToTarget(bits)
+	if target.Sign() <= 0 {
+		return 0
+	}
+	difficulty, _ := new(big.Float).Quo(new(big.Float).SetInt(PowLimit()), new(big.Float).SetInt(target)).Float64()
+	return difficulty
+}
@@ -0,0 +1,146 @@
+package consensus
+
+import (
+	"PublicChain/chainparams"
+	"math/big"
+	"testing"
+)
+
+func useNetwork(t *testing.T, name string) *chainparams.Params {
+	t.Helper()
+	err := chainparams.Select(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chainparams.Active
+}
+
+func TestCompactToTarget(t *testing.T) {
+	cases := []struct {
+		bits   uint32
+		target string // 十六进制
+	}{
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+		{0x05123456, "1234560000"},
+		{0x03123456, "123456"},
+		{0x02008000, "80"},
+		{0x01120000, "12"},
+		{0x01003456, "0"},
+		{0x00000000, "0"},
+		{0x04923456, "0"}, // 符号位为1
+	}
+	for _, c := range cases {
+		expected, _ := new(big.Int).SetString(c.target, 16)
+		if target := CompactToTarget(c.bits); target.Cmp(expected) != 0 {
+			t.Errorf("%08x还原为%x，应为%s", c.bits, target, c.target)
+		}
+	}
+}
+
+// 规范的压缩格式还原后再压缩得到原值，目标值压缩后只保留最高的3个字节
+func TestCompactRoundTrip(t *testing.T) {
+	for _, bits := range []uint32{0x1d00ffff, 0x1b0404cb, 0x207fffff, 0x05123456, 0x03123456, 0x02008000, 0x01120000} {
+		if got := TargetToCompact(CompactToTarget(bits)); got != bits {
+			t.Errorf("%08x还原后再压缩为%08x", bits, got)
+		}
+	}
+	cases := []struct {
+		target string
+		bits   uint32
+	}{
+		{"123456789a", 0x05123456},
+		{"80", 0x02008000},     // 尾数的符号位被占用，指数加一
+		{"800000", 0x04008000}, // 同上
+		{"0", 0},
+	}
+	for _, c := range cases {
+		target, _ := new(big.Int).SetString(c.target, 16)
+		if bits := TargetToCompact(target); bits != c.bits {
+			t.Errorf("%s压缩为%08x，应为%08x", c.target, bits, c.bits)
+		}
+	}
+	if bits := TargetToCompact(big.NewInt(-1)); bits != 0 {
+		t.Errorf("负数压缩为%08x，应为0", bits)
+	}
+}
+
+// 调整幅度限制在1/4到4倍之间，调整后的目标值不超过PowLimit
+func TestCalculateNextBits(t *testing.T) {
+	params := useNetwork(t, chainparams.MAINNET)
+	timespan := params.TargetTimespan()
+	lastBits := uint32(0x1c0fffff)
+	last := CompactToTarget(lastBits)
+	scaled := func(numerator int64, denominator int64) uint32 {
+		target := new(big.Int).Mul(last, big.NewInt(numerator))
+		return TargetToCompact(target.Div(target, big.NewInt(denominator)))
+	}
+	cases := []struct {
+		name   string
+		actual int64
+		bits   uint32
+	}{
+		{"与期望时间相同", timespan, lastBits},
+		{"用了一半的时间", timespan / 2, scaled(1, 2)},
+		{"用了两倍的时间", timespan * 2, scaled(2, 1)},
+		{"刚好4倍", timespan * 4, scaled(4, 1)},
+		{"超过4倍", timespan * 100, scaled(4, 1)},
+		{"刚好1/4", timespan / 4, scaled(1, 4)},
+		{"少于1/4", 1, scaled(1, 4)},
+		{"时间戳倒退", -timespan, scaled(1, 4)},
+	}
+	for _, c := range cases {
+		if bits := CalculateNextBits(lastBits, 1000, 1000+c.actual); bits != c.bits {
+			t.Errorf("%s：调整为%08x，应为%08x", c.name, bits, c.bits)
+		}
+	}
+
+	limitBits := TargetToCompact(PowLimit())
+	if bits := CalculateNextBits(limitBits, 0, timespan*4); bits != limitBits {
+		t.Errorf("调整后的目标值%08x超过了PowLimit，应为%08x", bits, limitBits)
+	}
+}
+
+func TestIsRetargetHeight(t *testing.T) {
+	params := useNetwork(t, chainparams.MAINNET)
+	interval := params.RetargetInterval
+	cases := []struct {
+		height int64
+		expect bool
+	}{
+		{0, false},
+		{1, false},
+		{interval - 1, false},
+		{interval, true},
+		{interval * 3, true},
+		{interval*3 + 1, false},
+	}
+	for _, c := range cases {
+		if got := IsRetargetHeight(c.height); got != c.expect {
+			t.Errorf("高度%d：%v，应为%v", c.height, got, c.expect)
+		}
+	}
+	useNetwork(t, chainparams.REGTEST)
+	if IsRetargetHeight(interval) {
+		t.Error("回归测试网不调整难度")
+	}
+	useNetwork(t, chainparams.MAINNET)
+}
+
+func TestCheckBitsRange(t *testing.T) {
+	useNetwork(t, chainparams.MAINNET)
+	cases := []struct {
+		bits   uint32
+		expect bool
+	}{
+		{NewBits(), true},
+		{TargetToCompact(PowLimit()), true},
+		{0, false},
+		{0x04923456, false},
+		{0x22010000, false}, // 超过PowLimit
+	}
+	for _, c := range cases {
+		if got := CheckBitsRange(c.bits); got != c.expect {
+			t.Errorf("%08x：%v，应为%v", c.bits, got, c.expect)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package consensus
+
+import (
+	"PublicChain/chainparams"
+)
+
+/**
+ * 某个高度的区块奖励(最小单位)：从当前网络的初始奖励开始，每隔SubsidyHalvingInterval个区块减半。
+ * 按最小单位右移计算，减半到不足一个最小单位时为0，总发行量因此有上限
+ */
+func BlockSubsidy(height int64) int64 {
+	params := chainparams.Active
+	halvings := height / params.SubsidyHalvingInterval
+	if height < 0 || halvings >= 63 {
+		return 0
+	}
+	return params.Reward >> uint(halvings)
+}
+
+/**
+ * 从创世区块到height(包含)一共发行的区块奖励(最小单位)
+ */
+func TotalSubsidy(height int64) int64 {
+	interval := chainparams.Active.SubsidyHalvingInterval
+	var total int64
+	for start := int64(0); start <= height; start += interval {
+		subsidy := BlockSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+		blocks := interval
+		if height-start+1 < blocks {
+			blocks = height - start + 1
+		}
+		total += subsidy * blocks
+	}
+	return total
+}
+
+/**
+ * 所有区块奖励发行完之后的总发行量(最小单位)
+ */
+func MaxSupply() int64 {
+	return TotalSubsidy(63 * chainparams.Active.SubsidyHalvingInterval)
+}
@@ -0,0 +1,66 @@
+package consensus
+
+import (
+	"PublicChain/chainparams"
+	"PublicChain/utils"
+	"testing"
+)
+
+// 每个减半周期的第一个和最后一个区块，以及奖励右移到0之后的高度
+func TestBlockSubsidy(t *testing.T) {
+	params := useNetwork(t, chainparams.MAINNET)
+	interval := params.SubsidyHalvingInterval
+	cases := []struct {
+		height  int64
+		subsidy int64
+	}{
+		{-1, 0},
+		{0, 50 * utils.COIN},
+		{interval - 1, 50 * utils.COIN},
+		{interval, 25 * utils.COIN},
+		{interval*2 - 1, 25 * utils.COIN},
+		{interval * 2, 125 * utils.COIN / 10},
+		{interval * 32, 1}, // 50*COIN右移32位
+		{interval * 33, 0}, // 不足一个最小单位
+		{interval * 62, 0},
+		{interval * 63, 0}, // 右移63位及以上按0处理
+		{interval * 100, 0},
+	}
+	for _, c := range cases {
+		if subsidy := BlockSubsidy(c.height); subsidy != c.subsidy {
+			t.Errorf("高度%d的区块奖励为%d，应为%d", c.height, subsidy, c.subsidy)
+		}
+	}
+	useNetwork(t, chainparams.REGTEST)
+	if subsidy := BlockSubsidy(150); subsidy != 25*utils.COIN {
+		t.Errorf("回归测试网高度150的区块奖励为%d，应为%d", subsidy, 25*utils.COIN)
+	}
+	useNetwork(t, chainparams.MAINNET)
+}
+
+// 累计发行量等于逐个区块奖励之和，跨越减半边界时也一样
+func TestTotalSubsidy(t *testing.T) {
+	useNetwork(t, chainparams.REGTEST)
+	defer useNetwork(t, chainparams.MAINNET)
+	var expected int64
+	for height := int64(0); height <= 150*40; height++ {
+		expected += BlockSubsidy(height)
+		if total := TotalSubsidy(height); total != expected {
+			t.Fatalf("到高度%d的累计发行量为%d，应为%d", height, total, expected)
+		}
+	}
+	if total := TotalSubsidy(-1); total != 0 {
+		t.Errorf("负数高度的累计发行量为%d，应为0", total)
+	}
+}
+
+func TestMaxSupply(t *testing.T) {
+	params := useNetwork(t, chainparams.MAINNET)
+	const expected = 2099999997690000
+	if supply := MaxSupply(); supply != expected {
+		t.Errorf("主网总发行量为%d，应为%d", supply, expected)
+	}
+	if supply := TotalSubsidy(params.SubsidyHalvingInterval * 100); supply != expected {
+		t.Errorf("奖励发行完之后累计发行量仍在增加：%d", supply)
+	}
+}
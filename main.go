@@ -2,22 +2,45 @@ package main
 
 import (
 	"PublicChain/chain"
+	"PublicChain/chainparams"
 	"PublicChain/client"
-	"github.com/boltdb/bolt"
+	"flag"
+	"fmt"
+	"os"
 )
 
-const BOLTFILE = "pubchain.db"
-
 func main() {
 
-	db, err := bolt.Open(BOLTFILE, 0600, nil)
+	//命令之前的全局参数：-network选择网络，之后的参数交给client按命令解析
+	network := flag.String("network", chainparams.MAINNET, "使用的网络，mainnet、testnet或regtest")
+	flag.Parse()
+	err := chainparams.Select(*network)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	os.Args = append([]string{os.Args[0]}, flag.Args()...)
+
+	params := chainparams.Active
+	err = os.MkdirAll(params.DataDir, 0700)
 	if err != nil {
-		panic(err.Error())
+		fmt.Println("创建数据目录失败：", err.Error())
+		return
 	}
-	defer db.Close()
 
-	blockChain, err := chain.NewBlockChain(db)
+	//旧版本的db文件在打开时先转换为当前的格式
+	blockChain, err := chain.OpenBlockChain(params.DBFile())
+	if blockChain.DB == nil {
+		fmt.Println("打开db文件失败：", err.Error())
+		return
+	}
 
-	client1 := client.Client{blockChain}
+	client1 := client.Client{Chain: blockChain}
 	client1.Run()
+
+	//程序退出前把utxo缓存中的改动写回db，挖矿期间可能重新打开过db，使用client中的区块链实例
+	err = client1.Chain.Close()
+	if err != nil {
+		fmt.Println("utxo缓存写回db失败：", err.Error())
+	}
 }
@@ -0,0 +1,475 @@
+package mempool
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"PublicChain/utxoset"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"sort"
+	"time"
+)
+
+// 交易池：交易hash -> TxEntry
+const MEMPOOL = "mempool"
+
+// 交易池中已被花费的outpoint：utxo所在交易hash(32字节) + 输出序号(8字节) -> 花费它的交易hash
+const MEMPOOLSPENT = "mempoolspent"
+
+/*
+交易池。命令行每次执行完就退出，所以等待打包的交易保存在db中，而不是内存中
+
+	a.接收验证通过的交易，拒绝与池中交易花费同一个utxo的冲突交易
+	b.记录池中交易之间的依赖关系，以及池中交易花费掉的outpoint
+	c.区块连接到主链后，删除区块中已打包的交易以及与之冲突的交易
+*/
+type Mempool struct {
+	DB    *bolt.DB
+	Coins *utxoset.CoinsCache // 查询交易花费的utxo时经过的utxo缓存
+}
+
+/**
+ * 交易池中的一笔交易
+ */
+type TxEntry struct {
+	Tx       transaction.Transaction
+	Time     int64      // 进入交易池的时间
+	Height   int64      // 进入交易池时主链的高度
+	Sequence uint64     // 进入交易池的顺序，依赖的交易总是排在前面
+	Depends  [][32]byte // 该交易花费了哪些池中交易的输出
+	Fee      int64      // 交易的手续费(最小单位)，即输入金额减去输出金额，由打包该交易的区块的coinbase交易获得
+}
+
+// 实例化交易池
+func LoadMempoolFromDB(db *bolt.DB, coins *utxoset.CoinsCache) Mempool {
+	return Mempool{DB: db, Coins: coins}
+}
+
+/**
+ * 验证交易并放入交易池，多笔交易在同一个事务中提交，任何一笔不通过则全部不放入
+ */
+func (pool *Mempool) AcceptTxs(txs []transaction.Transaction, height int64) error {
+	return pool.DB.Update(func(tx *bolt.Tx) error {
+		for _, transac := range txs {
+			err := AcceptTxInTx(tx, pool.Coins, transac, height)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/**
+ * 在调用方已开启的读写事务中验证交易并放入交易池：
+ * 交易输入花费的utxo必须在utxo集合或者池中交易的输出中，不能已被池中其他交易花费，签名必须正确，
+ * 输出金额必须为正数且之和不能大于输入金额之和，差额作为交易的手续费
+ */
+func AcceptTxInTx(tx *bolt.Tx, coins *utxoset.CoinsCache, transac transaction.Transaction, height int64) error {
+	if transac.IsCoinbaseTranaction() {
+		return errors.New("coinbase交易不能放入交易池")
+	}
+	entries, spents, err := createBuckets(tx)
+	if err != nil {
+		return err
+	}
+	if len(entries.Get(transac.TxHash[:])) != 0 {
+		return errors.New("交易已在交易池中")
+	}
+	spentUTXOs := make([]transaction.UTXO, 0)
+	depends := make([][32]byte, 0)
+	outpoints := make(map[string]bool)
+	for _, input := range transac.Inputs {
+		key, err := utxoset.OutpointKey(input.Txid, input.Vout)
+		if err != nil {
+			return err
+		}
+		if outpoints[string(key)] {
+			return errors.New("交易中存在重复的交易输入")
+		}
+		outpoints[string(key)] = true
+		if spender := spents.Get(key); len(spender) != 0 {
+			return fmt.Errorf("交易输入%x:%d已被交易池中的交易%x花费", input.Txid, input.Vout, spender)
+		}
+		utxo, inPool, err := findSpentUTXO(tx, coins, input)
+		if err != nil {
+			return err
+		}
+		if inPool && !containsHash(depends, input.Txid) {
+			depends = append(depends, input.Txid)
+		}
+		spentUTXOs = append(spentUTXOs, *utxo)
+	}
+	verify, err := transac.VertifySign(spentUTXOs)
+	if err != nil {
+		return err
+	}
+	if !verify {
+		return fmt.Errorf("交易%x签名验证失败", transac.TxHash)
+	}
+	inputAmount, err := transaction.InputAmount(spentUTXOs)
+	if err != nil {
+		return err
+	}
+	outputAmount, err := transac.OutputAmount()
+	if err != nil {
+		return err
+	}
+	if outputAmount > inputAmount {
+		return fmt.Errorf("交易%x的输出金额大于输入金额", transac.TxHash)
+	}
+
+	sequence, err := entries.NextSequence()
+	if err != nil {
+		return err
+	}
+	entry := TxEntry{
+		Tx:       transac,
+		Time:     time.Now().Unix(),
+		Height:   height,
+		Sequence: sequence,
+		Depends:  depends,
+		Fee:      inputAmount - outputAmount,
+	}
+	entryBytes, err := utils.GobEncode(entry)
+	if err != nil {
+		return err
+	}
+	err = entries.Put(transac.TxHash[:], entryBytes)
+	if err != nil {
+		return err
+	}
+	for key := range outpoints {
+		err = spents.Put([]byte(key), transac.TxHash[:])
+		if err != nil {
+			return err
+		}
+	}
+	return linkChildren(tx, transac)
+}
+
+/**
+ * 区块从主链断开时，其中的交易会被放回交易池，此时池中可能已有花费它输出的交易(来自之后断开的区块)，
+ * 把依赖关系补记到这些交易上
+ */
+func linkChildren(tx *bolt.Tx, transac transaction.Transaction) error {
+	entries := tx.Bucket([]byte(MEMPOOL))
+	spents := tx.Bucket([]byte(MEMPOOLSPENT))
+	for index := range transac.Outputs {
+		key, err := utxoset.OutpointKey(transac.TxHash, index)
+		if err != nil {
+			return err
+		}
+		spender := spents.Get(key)
+		if len(spender) == 0 {
+			continue
+		}
+		var child TxEntry
+		_, err = utils.GodDecode(entries.Get(spender), &child)
+		if err != nil {
+			return err
+		}
+		if containsHash(child.Depends, transac.TxHash) {
+			continue
+		}
+		child.Depends = append(child.Depends, transac.TxHash)
+		childBytes, err := utils.GobEncode(child)
+		if err != nil {
+			return err
+		}
+		err = entries.Put(spender, childBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 区块连接到主链时调用：删除区块中已打包的交易，
+ * 以及与区块中交易花费同一个utxo的池中交易(连同依赖它们的交易)
+ */
+func RemoveBlockTxsInTx(tx *bolt.Tx, txs []transaction.Transaction) error {
+	entries := tx.Bucket([]byte(MEMPOOL))
+	spents := tx.Bucket([]byte(MEMPOOLSPENT))
+	if entries == nil || spents == nil {
+		return nil
+	}
+	for _, transac := range txs {
+		err := removeEntry(tx, transac.TxHash)
+		if err != nil {
+			return err
+		}
+		for _, input := range transac.Inputs {
+			key, err := utxoset.OutpointKey(input.Txid, input.Vout)
+			if err != nil {
+				return err
+			}
+			spender := spents.Get(key)
+			if len(spender) == 0 {
+				continue
+			}
+			var conflict [32]byte
+			copy(conflict[:], spender)
+			err = RemoveTxInTx(tx, conflict)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/**
+ * 从交易池中删除一笔交易，以及所有直接或间接依赖它的交易
+ */
+func RemoveTxInTx(tx *bolt.Tx, txid [32]byte) error {
+	entries := tx.Bucket([]byte(MEMPOOL))
+	if entries == nil || len(entries.Get(txid[:])) == 0 {
+		return nil
+	}
+	children, err := getChildren(tx, txid)
+	if err != nil {
+		return err
+	}
+	err = removeEntry(tx, txid)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		err = RemoveTxInTx(tx, child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 在事务中按进入交易池的顺序取出所有交易
+ */
+func GetEntriesInTx(tx *bolt.Tx) ([]TxEntry, error) {
+	result := make([]TxEntry, 0)
+	entries := tx.Bucket([]byte(MEMPOOL))
+	if entries == nil {
+		return result, nil
+	}
+	err := entries.ForEach(func(key, value []byte) error {
+		var entry TxEntry
+		_, err := utils.GodDecode(value, &entry)
+		if err != nil {
+			return err
+		}
+		result = append(result, entry)
+		return nil
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Sequence < result[j].Sequence
+	})
+	return result, err
+}
+
+/**
+ * 按进入交易池的顺序取出所有交易
+ */
+func (pool *Mempool) GetEntries() ([]TxEntry, error) {
+	var result []TxEntry
+	err := pool.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		result, err = GetEntriesInTx(tx)
+		return err
+	})
+	return result, err
+}
+
+/**
+ * 取出交易池中所有的交易
+ */
+func (pool *Mempool) GetTxs() ([]transaction.Transaction, error) {
+	entries, err := pool.GetEntries()
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]transaction.Transaction, 0)
+	for _, entry := range entries {
+		txs = append(txs, entry.Tx)
+	}
+	return txs, nil
+}
+
+/**
+ * 根据交易hash查询交易池中的交易，同时返回池中依赖它的交易
+ */
+func (pool *Mempool) GetEntry(txid [32]byte) (*TxEntry, [][32]byte, error) {
+	var entry TxEntry
+	var children [][32]byte
+	err := pool.DB.View(func(tx *bolt.Tx) error {
+		entries := tx.Bucket([]byte(MEMPOOL))
+		if entries == nil {
+			return errors.New("交易池中没有该交易")
+		}
+		entryBytes := entries.Get(txid[:])
+		if len(entryBytes) == 0 {
+			return errors.New("交易池中没有该交易")
+		}
+		_, err := utils.GodDecode(entryBytes, &entry)
+		if err != nil {
+			return err
+		}
+		children, err = getChildren(tx, txid)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &entry, children, nil
+}
+
+/**
+ * 在事务中查询某个outpoint是否已被池中的交易花费，返回花费它的交易
+ */
+func GetSpenderInTx(tx *bolt.Tx, txid [32]byte, vout int) ([32]byte, bool, error) {
+	var spender [32]byte
+	spents := tx.Bucket([]byte(MEMPOOLSPENT))
+	if spents == nil {
+		return spender, false, nil
+	}
+	key, err := utxoset.OutpointKey(txid, vout)
+	if err != nil {
+		return spender, false, err
+	}
+	spenderBytes := spents.Get(key)
+	if len(spenderBytes) == 0 {
+		return spender, false, nil
+	}
+	copy(spender[:], spenderBytes)
+	return spender, true, nil
+}
+
+/**
+ * 在事务中查询池中交易的某个输出，交易不在池中或没有该输出时返回nil
+ */
+func GetOutputInTx(tx *bolt.Tx, txid [32]byte, vout int) (*transaction.UTXO, error) {
+	entries := tx.Bucket([]byte(MEMPOOL))
+	if entries == nil {
+		return nil, nil
+	}
+	entryBytes := entries.Get(txid[:])
+	if len(entryBytes) == 0 {
+		return nil, nil
+	}
+	var entry TxEntry
+	_, err := utils.GodDecode(entryBytes, &entry)
+	if err != nil {
+		return nil, err
+	}
+	if vout < 0 || vout >= len(entry.Tx.Outputs) {
+		return nil, nil
+	}
+	utxo := transaction.NewUTXO(txid, vout, entry.Tx.Outputs[vout])
+	return &utxo, nil
+}
+
+/**
+ * 找出交易输入花费的utxo：先在交易池中的交易输出里找，再到utxo集合中找。
+ * 第二个返回值表示该utxo是否来自交易池中的交易
+ */
+func findSpentUTXO(tx *bolt.Tx, coins *utxoset.CoinsCache, input transaction.TxInput) (*transaction.UTXO, bool, error) {
+	entries := tx.Bucket([]byte(MEMPOOL))
+	if entryBytes := entries.Get(input.Txid[:]); len(entryBytes) != 0 {
+		var entry TxEntry
+		_, err := utils.GodDecode(entryBytes, &entry)
+		if err != nil {
+			return nil, false, err
+		}
+		if input.Vout >= 0 && input.Vout < len(entry.Tx.Outputs) {
+			utxo := transaction.NewUTXO(entry.Tx.TxHash, input.Vout, entry.Tx.Outputs[input.Vout])
+			if utxo.IsSpent(input) {
+				return &utxo, true, nil
+			}
+		}
+		return nil, false, fmt.Errorf("交易输入%x:%d与交易池中的交易输出不匹配", input.Txid, input.Vout)
+	}
+	utxo, err := coins.GetUTXO(tx, input.Txid, input.Vout)
+	if err != nil {
+		return nil, false, err
+	}
+	if utxo != nil && utxo.IsSpent(input) {
+		return utxo, false, nil
+	}
+	return nil, false, fmt.Errorf("交易输入花费的utxo%x:%d不存在", input.Txid, input.Vout)
+}
+
+/**
+ * 找出池中直接依赖某笔交易的交易
+ */
+func getChildren(tx *bolt.Tx, txid [32]byte) ([][32]byte, error) {
+	children := make([][32]byte, 0)
+	entries, err := GetEntriesInTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if containsHash(entry.Depends, txid) {
+			children = append(children, entry.Tx.TxHash)
+		}
+	}
+	return children, nil
+}
+
+/**
+ * 删除交易池中的一笔交易及其花费的outpoint记录
+ */
+func removeEntry(tx *bolt.Tx, txid [32]byte) error {
+	entries := tx.Bucket([]byte(MEMPOOL))
+	spents := tx.Bucket([]byte(MEMPOOLSPENT))
+	entryBytes := entries.Get(txid[:])
+	if len(entryBytes) == 0 {
+		return nil
+	}
+	var entry TxEntry
+	_, err := utils.GodDecode(entryBytes, &entry)
+	if err != nil {
+		return err
+	}
+	for _, input := range entry.Tx.Inputs {
+		key, err := utxoset.OutpointKey(input.Txid, input.Vout)
+		if err != nil {
+			return err
+		}
+		err = spents.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return entries.Delete(txid[:])
+}
+
+func createBuckets(tx *bolt.Tx) (*bolt.Bucket, *bolt.Bucket, error) {
+	var err error
+	entries := tx.Bucket([]byte(MEMPOOL))
+	if entries == nil {
+		entries, err = tx.CreateBucket([]byte(MEMPOOL))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	spents := tx.Bucket([]byte(MEMPOOLSPENT))
+	if spents == nil {
+		spents, err = tx.CreateBucket([]byte(MEMPOOLSPENT))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return entries, spents, nil
+}
+
+func containsHash(hashes [][32]byte, hash [32]byte) bool {
+	for _, item := range hashes {
+		if item == hash {
+			return true
+		}
+	}
+	return false
+}
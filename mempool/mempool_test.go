@@ -0,0 +1,246 @@
+package mempool
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"PublicChain/utxoset"
+	"PublicChain/wallet"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 临时目录中的交易池，以及一个用于签名的密钥对
+func newTestPool(t *testing.T) (*Mempool, *wallet.KeyPair) {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "mempool.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	keyPair, err := wallet.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := LoadMempoolFromDB(db, utxoset.NewCoinsCache(utxoset.DEFAULTCACHESIZE))
+	return &pool, keyPair
+}
+
+// 在utxo集合中放入一个属于keyPair的utxo
+func fundTestUTXO(t *testing.T, pool *Mempool, keyPair *wallet.KeyPair, seed byte, value int64) transaction.UTXO {
+	t.Helper()
+	utxo := transaction.NewUTXO([32]byte{seed}, 0, transaction.TxOutput{Value: value, PubHash: wallet.NewPubKHash(keyPair.Pub)})
+	err := pool.DB.Update(func(tx *bolt.Tx) error {
+		return utxoset.PutUTXOInTx(tx, utxo)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return utxo
+}
+
+// 用keyPair花费utxos，输出金额为values，都锁定给keyPair自己
+func signTestTx(t *testing.T, keyPair *wallet.KeyPair, utxos []transaction.UTXO, values ...int64) transaction.Transaction {
+	t.Helper()
+	transac := transaction.Transaction{Inputs: []transaction.TxInput{}, Outputs: []transaction.TxOutput{}, LockedTime: 1}
+	for _, utxo := range utxos {
+		transac.Inputs = append(transac.Inputs, transaction.NewTxInput(utxo.TxId, utxo.Vout, keyPair.Pub))
+	}
+	for _, value := range values {
+		transac.Outputs = append(transac.Outputs, transaction.TxOutput{Value: value, PubHash: wallet.NewPubKHash(keyPair.Pub)})
+	}
+	var err error
+	transac.TxHash, err = transac.CalculateTxId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transac.Sign(keyPair.Pri, utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transac
+}
+
+// 交易池中所有交易的hash，按进入交易池的顺序
+func poolTxids(t *testing.T, pool *Mempool) [][32]byte {
+	t.Helper()
+	entries, err := pool.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txids := make([][32]byte, 0)
+	for _, entry := range entries {
+		txids = append(txids, entry.Tx.TxHash)
+	}
+	return txids
+}
+
+// 输出金额超出范围或之和溢出为负数的交易不能进入交易池，否则手续费会被算成一个很大的正数
+func TestAcceptTxRejectsOverflowingOutputs(t *testing.T) {
+	cases := []struct {
+		name   string
+		values []int64
+		valid  bool
+	}{
+		{"正常的交易", []int64{49 * utils.COIN}, true},
+		{"输出之和溢出为负数", []int64{1<<63 - 1, 1<<62 + 1}, false},
+		{"两个输出之和溢出", []int64{1 << 62, 1 << 62}, false},
+		{"单个输出超过上限", []int64{utils.MAXMONEY + 1}, false},
+		{"输出之和超过上限", []int64{utils.MAXMONEY, utils.MAXMONEY}, false},
+		{"输出金额为0", []int64{0}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pool, keyPair := newTestPool(t)
+			utxo := fundTestUTXO(t, pool, keyPair, 1, 50*utils.COIN)
+			err := pool.AcceptTxs([]transaction.Transaction{signTestTx(t, keyPair, []transaction.UTXO{utxo}, c.values...)}, 1)
+			if (err == nil) != c.valid {
+				t.Fatalf("结果为%v，应为%v", err, c.valid)
+			}
+			if txids := poolTxids(t, pool); (len(txids) == 1) != c.valid {
+				t.Errorf("交易池中有%d笔交易", len(txids))
+			}
+		})
+	}
+}
+
+// 与池中交易花费同一个utxo的交易、重复的交易不能进入交易池；花费池中交易输出的交易记录依赖关系和手续费
+func TestAcceptTxConflictsAndDepends(t *testing.T) {
+	pool, keyPair := newTestPool(t)
+	utxo := fundTestUTXO(t, pool, keyPair, 1, 50*utils.COIN)
+	parent := signTestTx(t, keyPair, []transaction.UTXO{utxo}, 30*utils.COIN, 19*utils.COIN)
+	err := pool.AcceptTxs([]transaction.Transaction{parent}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pool.AcceptTxs([]transaction.Transaction{parent}, 1)
+	if err == nil {
+		t.Error("重复的交易不应进入交易池")
+	}
+	conflict := signTestTx(t, keyPair, []transaction.UTXO{utxo}, 40*utils.COIN)
+	err = pool.AcceptTxs([]transaction.Transaction{conflict}, 1)
+	if err == nil {
+		t.Error("与池中交易花费同一个utxo的交易不应进入交易池")
+	}
+
+	child := signTestTx(t, keyPair, []transaction.UTXO{transaction.NewUTXO(parent.TxHash, 0, parent.Outputs[0])}, 29*utils.COIN)
+	err = pool.AcceptTxs([]transaction.Transaction{child}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, children, err := pool.GetEntry(parent.TxHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Fee != utils.COIN || len(entry.Depends) != 0 || len(children) != 1 || children[0] != child.TxHash {
+		t.Errorf("父交易为%+v，依赖它的交易为%x", entry, children)
+	}
+	entry, _, err = pool.GetEntry(child.TxHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Fee != utils.COIN || len(entry.Depends) != 1 || entry.Depends[0] != parent.TxHash || entry.Sequence <= 1 {
+		t.Errorf("子交易为%+v", entry)
+	}
+	err = pool.DB.View(func(tx *bolt.Tx) error {
+		spender, spent, err := GetSpenderInTx(tx, utxo.TxId, utxo.Vout)
+		if err != nil || !spent || spender != parent.TxHash {
+			t.Errorf("utxo的花费者为%x，%v", spender, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 区块连接后删除已打包的交易；与区块中交易冲突的池中交易连同依赖它的交易一起删除
+func TestRemoveBlockTxs(t *testing.T) {
+	pool, keyPair := newTestPool(t)
+	first := fundTestUTXO(t, pool, keyPair, 1, 50*utils.COIN)
+	second := fundTestUTXO(t, pool, keyPair, 2, 50*utils.COIN)
+	parent := signTestTx(t, keyPair, []transaction.UTXO{first}, 49*utils.COIN)
+	child := signTestTx(t, keyPair, []transaction.UTXO{transaction.NewUTXO(parent.TxHash, 0, parent.Outputs[0])}, 48*utils.COIN)
+	other := signTestTx(t, keyPair, []transaction.UTXO{second}, 49*utils.COIN)
+	err := pool.AcceptTxs([]transaction.Transaction{parent, child, other}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 区块中的交易与parent花费同一个utxo
+	mined := signTestTx(t, keyPair, []transaction.UTXO{first}, 45*utils.COIN)
+	err = pool.DB.Update(func(tx *bolt.Tx) error {
+		return RemoveBlockTxsInTx(tx, []transaction.Transaction{mined})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txids := poolTxids(t, pool)
+	if len(txids) != 1 || txids[0] != other.TxHash {
+		t.Fatalf("交易池中为%x，应只剩下不冲突的交易", txids)
+	}
+	err = pool.DB.View(func(tx *bolt.Tx) error {
+		for _, outpoint := range []transaction.UTXO{first, transaction.NewUTXO(parent.TxHash, 0, parent.Outputs[0])} {
+			_, spent, err := GetSpenderInTx(tx, outpoint.TxId, outpoint.Vout)
+			if err != nil || spent {
+				t.Errorf("被删除的交易花费的outpoint%x:%d仍有记录", outpoint.TxId, outpoint.Vout)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pool.DB.Update(func(tx *bolt.Tx) error {
+		return RemoveBlockTxsInTx(tx, []transaction.Transaction{other})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txids := poolTxids(t, pool); len(txids) != 0 {
+		t.Errorf("已打包的交易应从交易池中删除，还剩%x", txids)
+	}
+}
+
+// 交易池保存在db中，重新打开db后交易按进入交易池的顺序保留
+func TestMempoolPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mempool.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPair, err := wallet.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := LoadMempoolFromDB(db, utxoset.NewCoinsCache(utxoset.DEFAULTCACHESIZE))
+	utxo := fundTestUTXO(t, &pool, keyPair, 1, 50*utils.COIN)
+	parent := signTestTx(t, keyPair, []transaction.UTXO{utxo}, 49*utils.COIN)
+	child := signTestTx(t, keyPair, []transaction.UTXO{transaction.NewUTXO(parent.TxHash, 0, parent.Outputs[0])}, 48*utils.COIN)
+	err = pool.AcceptTxs([]transaction.Transaction{parent, child}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	pool = LoadMempoolFromDB(db, utxoset.NewCoinsCache(utxoset.DEFAULTCACHESIZE))
+	txids := poolTxids(t, &pool)
+	if len(txids) != 2 || txids[0] != parent.TxHash || txids[1] != child.TxHash {
+		t.Errorf("重新打开后交易池中为%x", txids)
+	}
+	conflict := signTestTx(t, keyPair, []transaction.UTXO{utxo}, 40*utils.COIN)
+	err = pool.AcceptTxs([]transaction.Transaction{conflict}, 1)
+	if err == nil {
+		t.Error("重新打开后仍应拒绝与池中交易冲突的交易")
+	}
+}
@@ -37,7 +37,7 @@ func creatMerkleTree(leafNode []*TreeNode)(*MerkleTree){
 
 		leavelNodes := make([]*TreeNode, 0)
 		for j := 0; j < len(nowLeveNodes); j += 2 {
-			node := CreateTreeNode(nil, leafNode[j], leafNode[j+1])
+			node := CreateTreeNode(nil, nowLeveNodes[j], nowLeveNodes[j+1])
 			leavelNodes = append(leavelNodes, node)
 		}
 
@@ -66,5 +66,6 @@ func GetLeveCount(num float64)int {
 	if result >=num{
 		return int(count)
 		}
+		count++
 	}
 }
\ No newline at end of file
@@ -0,0 +1,219 @@
+package transaction
+
+import (
+	"PublicChain/utils"
+	"PublicChain/wallet"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 治理交易的操作
+const (
+	GOVADDVALIDATOR    uint8 = 1 // 添加验证者
+	GOVREMOVEVALIDATOR uint8 = 2 // 移除验证者
+)
+
+/**
+ * 治理交易的内容：添加或移除一个验证者，需要超过半数的当前验证者批准。
+ * 治理交易没有输入和输出，不涉及utxo，只在权威证明的链上有效
+ */
+type Governance struct {
+	Action    uint8
+	Validator []byte     // 被添加或移除的验证者公钥
+	Approvals []Approval // 批准该交易的验证者，与签名一样不计入交易hash，收集批准的过程中交易hash不变
+}
+
+/**
+ * 一个验证者对治理交易的批准
+ */
+type Approval struct {
+	Signer []byte // 验证者公钥
+	Sig    []byte // 验证者对交易hash的签名
+}
+
+/**
+ * 构建一笔治理交易，还需要由验证者逐个签名批准
+ */
+func NewGovernanceTx(action uint8, validator []byte) (*Transaction, error) {
+	if action != GOVADDVALIDATOR && action != GOVREMOVEVALIDATOR {
+		return nil, fmt.Errorf("不支持的治理操作%d", action)
+	}
+	tx := Transaction{
+		Inputs:     []TxInput{},
+		Outputs:    []TxOutput{},
+		LockedTime: time.Now().UnixNano(),
+		Governance: &Governance{Action: action, Validator: validator},
+	}
+	txHash, err := tx.CalculateTxId()
+	if err != nil {
+		return nil, err
+	}
+	tx.TxHash = txHash
+	return &tx, nil
+}
+
+/**
+ * 判断某个交易是否是治理交易
+ */
+func (tx *Transaction) IsGovernanceTransaction() bool {
+	return tx.Governance != nil
+}
+
+/**
+ * 验证者signer用私钥对治理交易签名，加入批准列表，签名原文为交易hash。同一个验证者只能批准一次
+ */
+func (tx *Transaction) SignGovernance(private *ecdsa.PrivateKey, signer []byte) error {
+	if tx.Governance == nil {
+		return errors.New("不是治理交易")
+	}
+	for _, approval := range tx.Governance.Approvals {
+		if bytes.Equal(approval.Signer, signer) {
+			return errors.New("该验证者已经批准过这笔治理交易")
+		}
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, private, tx.TxHash[:])
+	if err != nil {
+		return err
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	tx.Governance.Approvals = append(tx.Governance.Approvals, Approval{Signer: signer, Sig: sig})
+	return nil
+}
+
+/**
+ * 验证治理交易中每一个批准的签名，至少要有一个批准，同一个验证者不能重复批准。
+ * 批准者是否为验证者、批准的人数是否超过半数与链上状态有关，由调用方检查
+ */
+func (tx *Transaction) verifyGovernance() (bool, error) {
+	gov := tx.Governance
+	if len(tx.Inputs) != 0 || len(tx.Outputs) != 0 {
+		return false, errors.New("治理交易不能有输入和输出")
+	}
+	x, _ := elliptic.Unmarshal(elliptic.P256(), gov.Validator)
+	if x == nil {
+		return false, errors.New("治理交易中的验证者公钥有误")
+	}
+	if len(gov.Approvals) == 0 {
+		return false, errors.New("治理交易没有验证者批准")
+	}
+	txid, err := tx.CalculateTxId()
+	if err != nil {
+		return false, err
+	}
+	signers := make(map[string]bool)
+	for _, approval := range gov.Approvals {
+		if signers[string(approval.Signer)] {
+			return false, errors.New("同一个验证者重复批准了治理交易")
+		}
+		signers[string(approval.Signer)] = true
+		if len(approval.Sig) != 64 {
+			return false, errors.New("治理交易的签名长度有误")
+		}
+		x, _ = elliptic.Unmarshal(elliptic.P256(), approval.Signer)
+		if x == nil {
+			return false, errors.New("治理交易中的批准者公钥有误")
+		}
+		pub := wallet.GetPublicKeyWithBytes(elliptic.P256(), approval.Signer)
+		r, s := wallet.RestoreSignature(approval.Sig)
+		if !ecdsa.Verify(&pub, txid[:], r, s) {
+			return false, errors.New("治理交易签名验证失败")
+		}
+	}
+	return true, nil
+}
+
+/*
+治理交易的二进制格式，追加在交易的时间戳之后：
+
+	操作        1字节
+	验证者公钥  varint长度 + 字节
+	批准个数    varint
+	  批准者公钥  varint长度 + 字节
+	  签名        varint长度 + 字节
+*/
+func (gov *Governance) encode(buff *bytes.Buffer) {
+	buff.WriteByte(gov.Action)
+	utils.WriteVarBytes(buff, gov.Validator)
+	utils.WriteVarInt(buff, uint64(len(gov.Approvals)))
+	for _, approval := range gov.Approvals {
+		utils.WriteVarBytes(buff, approval.Signer)
+		utils.WriteVarBytes(buff, approval.Sig)
+	}
+}
+
+func decodeGovernance(reader *bytes.Reader) (*Governance, error) {
+	var gov Governance
+	var err error
+	if gov.Action, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+	if gov.Validator, err = utils.ReadVarBytes(reader); err != nil {
+		return nil, err
+	}
+	count, err := utils.ReadVarInt(reader)
+	if err != nil {
+		return nil, err
+	}
+	// 每个批准至少占2个字节，个数不可能超过剩余的字节数
+	if count > uint64(reader.Len()) {
+		return nil, errors.New("治理交易的批准个数超出范围")
+	}
+	gov.Approvals = make([]Approval, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var approval Approval
+		if approval.Signer, err = utils.ReadVarBytes(reader); err != nil {
+			return nil, err
+		}
+		if approval.Sig, err = utils.ReadVarBytes(reader); err != nil {
+			return nil, err
+		}
+		gov.Approvals = append(gov.Approvals, approval)
+	}
+	return &gov, nil
+}
+
+/*
+旧格式的治理交易只有一个发起者，追加在交易的时间戳之后：
+
+	操作        1字节
+	验证者公钥  varint长度 + 字节
+	发起者公钥  varint长度 + 字节
+	签名        varint长度 + 字节
+
+发起者公钥计入交易hash，读取时转换为只有一个批准的治理交易
+*/
+func (gov *Governance) encodeLegacy(buff *bytes.Buffer) {
+	var approval Approval
+	if len(gov.Approvals) > 0 {
+		approval = gov.Approvals[0]
+	}
+	buff.WriteByte(gov.Action)
+	utils.WriteVarBytes(buff, gov.Validator)
+	utils.WriteVarBytes(buff, approval.Signer)
+	utils.WriteVarBytes(buff, approval.Sig)
+}
+
+func decodeLegacyGovernance(reader *bytes.Reader) (*Governance, error) {
+	var gov Governance
+	var approval Approval
+	var err error
+	if gov.Action, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+	if gov.Validator, err = utils.ReadVarBytes(reader); err != nil {
+		return nil, err
+	}
+	if approval.Signer, err = utils.ReadVarBytes(reader); err != nil {
+		return nil, err
+	}
+	if approval.Sig, err = utils.ReadVarBytes(reader); err != nil {
+		return nil, err
+	}
+	gov.Approvals = []Approval{approval}
+	return &gov, nil
+}
@@ -0,0 +1,110 @@
+package transaction
+
+import (
+	"PublicChain/wallet"
+	"bytes"
+	"testing"
+)
+
+func newTestKeyPairs(t *testing.T, count int) []*wallet.KeyPair {
+	t.Helper()
+	keyPairs := make([]*wallet.KeyPair, 0, count)
+	for i := 0; i < count; i++ {
+		keyPair, err := wallet.NewKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyPairs = append(keyPairs, keyPair)
+	}
+	return keyPairs
+}
+
+// 由signers逐个批准的添加验证者的治理交易
+func newTestGovernance(t *testing.T, validator []byte, signers ...*wallet.KeyPair) Transaction {
+	t.Helper()
+	tx, err := NewGovernanceTx(GOVADDVALIDATOR, validator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, signer := range signers {
+		err = tx.SignGovernance(signer.Pri, signer.Pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return *tx
+}
+
+// 每个批准都要验证签名，批准不改变交易hash，序列化后批准原样保留
+func TestGovernanceApprovals(t *testing.T) {
+	keys := newTestKeyPairs(t, 3)
+	tx := newTestGovernance(t, keys[2].Pub, keys[0], keys[1])
+	txid, err := tx.CalculateTxId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txid != tx.TxHash {
+		t.Error("批准后交易hash发生了变化")
+	}
+	if verify, err := tx.VertifySign(nil); !verify || err != nil {
+		t.Fatalf("两个批准的治理交易验证失败：%v", err)
+	}
+	if err := tx.SignGovernance(keys[0].Pri, keys[0].Pub); err == nil {
+		t.Error("同一个验证者不能重复批准")
+	}
+
+	data, err := tx.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeTransaction(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.TxHash != tx.TxHash || len(decoded.Governance.Approvals) != 2 {
+		t.Fatal("反序列化后治理交易没有正确还原")
+	}
+	for i, approval := range decoded.Governance.Approvals {
+		if !bytes.Equal(approval.Signer, keys[i].Pub) || !bytes.Equal(approval.Sig, tx.Governance.Approvals[i].Sig) {
+			t.Errorf("第%d个批准没有正确还原", i)
+		}
+	}
+}
+
+func TestVerifyGovernanceInvalid(t *testing.T) {
+	keys := newTestKeyPairs(t, 3)
+	cases := []struct {
+		name   string
+		tamper func(tx *Transaction)
+	}{
+		{"没有批准", func(tx *Transaction) { tx.Governance.Approvals = nil }},
+		{"重复的批准", func(tx *Transaction) {
+			tx.Governance.Approvals = append(tx.Governance.Approvals, tx.Governance.Approvals[0])
+		}},
+		{"签名被篡改", func(tx *Transaction) { tx.Governance.Approvals[1].Sig[0] ^= 0xff }},
+		{"签名与批准者不符", func(tx *Transaction) { tx.Governance.Approvals[1].Signer = keys[2].Pub }},
+		{"签名长度有误", func(tx *Transaction) { tx.Governance.Approvals[0].Sig = tx.Governance.Approvals[0].Sig[:63] }},
+		{"批准后修改验证者", func(tx *Transaction) { tx.Governance.Validator = keys[0].Pub }},
+		{"验证者公钥有误", func(tx *Transaction) { tx.Governance.Validator = []byte{0x04} }},
+		{"有交易输出", func(tx *Transaction) {
+			tx.Outputs = []TxOutput{{Value: 1, PubHash: bytes.Repeat([]byte{0x01}, 21)}}
+		}},
+	}
+	for _, c := range cases {
+		tx := newTestGovernance(t, keys[2].Pub, keys[0], keys[1])
+		c.tamper(&tx)
+		if verify, err := tx.VertifySign(nil); verify || err == nil {
+			t.Errorf("%s：治理交易应验证失败", c.name)
+		}
+	}
+}
+
+// 只有一个交易输出的治理交易不能被当作coinbase交易而跳过验证
+func TestGovernanceIsNotCoinbase(t *testing.T) {
+	keys := newTestKeyPairs(t, 2)
+	tx := newTestGovernance(t, keys[1].Pub, keys[0])
+	tx.Outputs = []TxOutput{{Value: 1, PubHash: bytes.Repeat([]byte{0x01}, 21)}}
+	if tx.IsCoinbaseTranaction() {
+		t.Error("治理交易不是coinbase交易")
+	}
+}
@@ -0,0 +1,257 @@
+package transaction
+
+import (
+	"PublicChain/utils"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// 交易序列化格式的版本号，写在每笔交易的最前面
+const TXVERSION uint32 = 3
+
+// 治理交易的版本号，在普通交易的格式之后追加治理内容，见Governance.encode。
+// 版本4的治理交易只有一个发起者，已不再支持
+const GOVTXVERSION uint32 = 5
+
+// 旧格式的交易和治理交易的版本号，金额为float64，只在转换旧版本的db文件时读取，见DecodeLegacyTransaction
+const (
+	LEGACYTXVERSION    uint32 = 1
+	LEGACYGOVTXVERSION uint32 = 2
+)
+
+/*
+交易的二进制格式，整数均为小端序，变长整数为compact size：
+
+	版本号       uint32
+	输入个数     varint
+	  交易hash   32字节
+	  输出序号   uint32
+	  签名       varint长度 + 字节
+	  公钥       varint长度 + 字节
+	输出个数     varint
+	  金额       int64，单位为1/utils.COIN个币
+	  公钥hash   varint长度 + 字节
+	时间戳       int64
+	治理内容     只有版本号为GOVTXVERSION的治理交易有，见Governance.encode
+
+交易hash为去掉所有签名之后的序列化结果的sha256
+*/
+func (tx *Transaction) Encode(buff *bytes.Buffer) {
+	tx.encode(buff, TXVERSION, GOVTXVERSION, func(index int) {
+		tx.Outputs[index].Encode(buff)
+	})
+}
+
+// 按给定的版本号写入交易，交易输出由encodeOutput写入，旧格式与当前格式只有版本号和金额不同
+func (tx *Transaction) encode(buff *bytes.Buffer, version uint32, govVersion uint32, encodeOutput func(index int)) {
+	if tx.Governance != nil {
+		utils.WriteUint32(buff, govVersion)
+	} else {
+		utils.WriteUint32(buff, version)
+	}
+	utils.WriteVarInt(buff, uint64(len(tx.Inputs)))
+	for _, input := range tx.Inputs {
+		buff.Write(input.Txid[:])
+		utils.WriteUint32(buff, uint32(input.Vout))
+		utils.WriteVarBytes(buff, input.Sig)
+		utils.WriteVarBytes(buff, input.Pubk)
+	}
+	utils.WriteVarInt(buff, uint64(len(tx.Outputs)))
+	for index := range tx.Outputs {
+		encodeOutput(index)
+	}
+	utils.WriteInt64(buff, tx.LockedTime)
+	if tx.Governance != nil && govVersion == LEGACYGOVTXVERSION {
+		tx.Governance.encodeLegacy(buff)
+	} else if tx.Governance != nil {
+		tx.Governance.encode(buff)
+	}
+}
+
+/**
+ * 从reader中读取一笔交易，并计算交易hash
+ */
+func DecodeTransaction(reader *bytes.Reader) (Transaction, error) {
+	tx, err := decodeTransaction(reader, TXVERSION, GOVTXVERSION, DecodeTxOutput)
+	if err != nil {
+		return tx, err
+	}
+	tx.TxHash, err = tx.CalculateTxId()
+	return tx, err
+}
+
+/**
+ * 读取版本5之前的db文件中的一笔交易：金额为float64，按最小单位取整后转换为int64。
+ * 交易hash按旧格式计算，与旧区块中交易输入引用的交易hash一致，转换db文件时据此替换为新的交易hash
+ */
+func DecodeLegacyTransaction(reader *bytes.Reader) (Transaction, error) {
+	valueBits := make([]uint64, 0)
+	tx, err := decodeTransaction(reader, LEGACYTXVERSION, LEGACYGOVTXVERSION, func(reader *bytes.Reader) (TxOutput, error) {
+		var output TxOutput
+		bits, err := utils.ReadUint64(reader)
+		if err != nil {
+			return output, err
+		}
+		valueBits = append(valueBits, bits)
+		output.Value = LegacyValueToAmount(math.Float64frombits(bits))
+		output.PubHash, err = utils.ReadVarBytes(reader)
+		return output, err
+	})
+	if err != nil {
+		return tx, err
+	}
+	unsigned := CopyTX(tx)
+	//旧格式的治理交易hash包含发起者公钥
+	if tx.Governance != nil {
+		unsigned.Governance.Approvals = []Approval{{Signer: tx.Governance.Approvals[0].Signer}}
+	}
+	buff := new(bytes.Buffer)
+	unsigned.encode(buff, LEGACYTXVERSION, LEGACYGOVTXVERSION, func(index int) {
+		utils.WriteUint64(buff, valueBits[index])
+		utils.WriteVarBytes(buff, unsigned.Outputs[index].PubHash)
+	})
+	tx.TxHash = sha256.Sum256(buff.Bytes())
+	return tx, nil
+}
+
+// 旧格式中float64的金额按最小单位取整
+func LegacyValueToAmount(value float64) int64 {
+	return int64(math.Round(value * float64(utils.COIN)))
+}
+
+// 读取版本号为version或govVersion的交易，交易输出由decodeOutput读取，不计算交易hash
+func decodeTransaction(reader *bytes.Reader, version uint32, govVersion uint32, decodeOutput func(reader *bytes.Reader) (TxOutput, error)) (Transaction, error) {
+	var tx Transaction
+	txVersion, err := utils.ReadUint32(reader)
+	if err != nil {
+		return tx, err
+	}
+	if txVersion != version && txVersion != govVersion {
+		return tx, fmt.Errorf("不支持的交易版本%d", txVersion)
+	}
+	inputCount, err := utils.ReadVarInt(reader)
+	if err != nil {
+		return tx, err
+	}
+	// 每个交易输入至少占38个字节，个数不可能超过剩余的字节数
+	if inputCount > uint64(reader.Len()) {
+		return tx, errors.New("交易输入个数超出范围")
+	}
+	tx.Inputs = make([]TxInput, 0, inputCount)
+	for i := uint64(0); i < inputCount; i++ {
+		var input TxInput
+		input.Txid, err = utils.ReadHash(reader)
+		if err != nil {
+			return tx, err
+		}
+		vout, err := utils.ReadUint32(reader)
+		if err != nil {
+			return tx, err
+		}
+		input.Vout = int(vout)
+		input.Sig, err = utils.ReadVarBytes(reader)
+		if err != nil {
+			return tx, err
+		}
+		input.Pubk, err = utils.ReadVarBytes(reader)
+		if err != nil {
+			return tx, err
+		}
+		tx.Inputs = append(tx.Inputs, input)
+	}
+	outputCount, err := utils.ReadVarInt(reader)
+	if err != nil {
+		return tx, err
+	}
+	if outputCount > uint64(reader.Len()) {
+		return tx, errors.New("交易输出个数超出范围")
+	}
+	tx.Outputs = make([]TxOutput, 0, outputCount)
+	for i := uint64(0); i < outputCount; i++ {
+		output, err := decodeOutput(reader)
+		if err != nil {
+			return tx, err
+		}
+		tx.Outputs = append(tx.Outputs, output)
+	}
+	tx.LockedTime, err = utils.ReadInt64(reader)
+	if err != nil {
+		return tx, err
+	}
+	if txVersion == govVersion && govVersion == LEGACYGOVTXVERSION {
+		tx.Governance, err = decodeLegacyGovernance(reader)
+	} else if txVersion == govVersion {
+		tx.Governance, err = decodeGovernance(reader)
+	}
+	return tx, err
+}
+
+/**
+ * 反序列化一笔交易，数据必须正好是一笔交易
+ */
+func DeserializeTransaction(data []byte) (Transaction, error) {
+	reader := bytes.NewReader(data)
+	tx, err := DecodeTransaction(reader)
+	if err != nil {
+		return tx, err
+	}
+	if reader.Len() != 0 {
+		return tx, errors.New("交易数据末尾有多余的字节")
+	}
+	return tx, nil
+}
+
+/**
+ * 计算交易hash：去掉签名后序列化，再做sha256。签名不影响交易hash
+ */
+func (tx *Transaction) CalculateTxId() ([32]byte, error) {
+	unsigned := CopyTX(*tx)
+	txBytes, err := unsigned.Serialize()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(txBytes), nil
+}
+
+/**
+ * 交易输出的二进制格式：金额(int64，最小单位) + 公钥hash(varint长度 + 字节)
+ */
+func (output *TxOutput) Encode(buff *bytes.Buffer) {
+	utils.WriteInt64(buff, output.Value)
+	utils.WriteVarBytes(buff, output.PubHash)
+}
+
+func (output *TxOutput) Serialize() []byte {
+	buff := new(bytes.Buffer)
+	output.Encode(buff)
+	return buff.Bytes()
+}
+
+func DecodeTxOutput(reader *bytes.Reader) (TxOutput, error) {
+	var output TxOutput
+	var err error
+	output.Value, err = utils.ReadInt64(reader)
+	if err != nil {
+		return output, err
+	}
+	output.PubHash, err = utils.ReadVarBytes(reader)
+	return output, err
+}
+
+/**
+ * 反序列化一个交易输出，数据必须正好是一个交易输出
+ */
+func DeserializeTxOutput(data []byte) (TxOutput, error) {
+	reader := bytes.NewReader(data)
+	output, err := DecodeTxOutput(reader)
+	if err != nil {
+		return output, err
+	}
+	if reader.Len() != 0 {
+		return output, errors.New("交易输出数据末尾有多余的字节")
+	}
+	return output, nil
+}
@@ -0,0 +1,230 @@
+package transaction
+
+import (
+	"PublicChain/utils"
+	"bytes"
+	"crypto/sha256"
+	"math"
+	"testing"
+)
+
+func testHash(seed byte) [32]byte {
+	var hash [32]byte
+	for i := range hash {
+		hash[i] = seed + byte(i)
+	}
+	return hash
+}
+
+func testTransactions() map[string]Transaction {
+	return map[string]Transaction{
+		"coinbase交易": {
+			Inputs:     []TxInput{},
+			Outputs:    []TxOutput{{Value: 50 * 100000000, PubHash: bytes.Repeat([]byte{0x11}, 21)}},
+			LockedTime: 1700000000123456789,
+		},
+		"普通交易": {
+			Inputs: []TxInput{
+				{Txid: testHash(1), Vout: 0, Sig: bytes.Repeat([]byte{0x22}, 64), Pubk: bytes.Repeat([]byte{0x33}, 65)},
+				{Txid: testHash(2), Vout: 300, Sig: bytes.Repeat([]byte{0x44}, 64), Pubk: bytes.Repeat([]byte{0x55}, 65)},
+			},
+			Outputs: []TxOutput{
+				{Value: 1, PubHash: bytes.Repeat([]byte{0x66}, 21)},
+				{Value: 1<<63 - 1, PubHash: bytes.Repeat([]byte{0x77}, 21)},
+			},
+			LockedTime: 1,
+		},
+		"治理交易": {
+			Inputs:     []TxInput{},
+			Outputs:    []TxOutput{},
+			LockedTime: 42,
+			Governance: &Governance{
+				Action:    GOVADDVALIDATOR,
+				Validator: bytes.Repeat([]byte{0x88}, 65),
+				Approvals: []Approval{
+					{Signer: bytes.Repeat([]byte{0x99}, 65), Sig: bytes.Repeat([]byte{0xaa}, 64)},
+					{Signer: bytes.Repeat([]byte{0xbb}, 65), Sig: bytes.Repeat([]byte{0xcc}, 64)},
+				},
+			},
+		},
+	}
+}
+
+// 序列化后再反序列化，重新序列化的结果与原来的一致，交易hash按去掉签名的结果计算
+func TestTransactionRoundTrip(t *testing.T) {
+	for name, tx := range testTransactions() {
+		data, err := tx.Serialize()
+		if err != nil {
+			t.Fatalf("%s：序列化出错：%v", name, err)
+		}
+		decoded, err := DeserializeTransaction(data)
+		if err != nil {
+			t.Errorf("%s：反序列化出错：%v", name, err)
+			continue
+		}
+		again, _ := decoded.Serialize()
+		if !bytes.Equal(data, again) {
+			t.Errorf("%s：重新序列化的结果与原来的不一致", name)
+		}
+		txid, _ := tx.CalculateTxId()
+		if decoded.TxHash != txid {
+			t.Errorf("%s：交易hash为%x，应为%x", name, decoded.TxHash, txid)
+		}
+		if (decoded.Governance != nil) != (tx.Governance != nil) {
+			t.Errorf("%s：治理内容没有正确还原", name)
+		}
+	}
+}
+
+// 签名不计入交易hash，其他字段的改动都会改变交易hash
+func TestTxIdIgnoresSignatures(t *testing.T) {
+	for name, tx := range testTransactions() {
+		txid, _ := tx.CalculateTxId()
+		unsigned := CopyTX(tx)
+		if id, _ := unsigned.CalculateTxId(); id != txid {
+			t.Errorf("%s：去掉签名后交易hash发生了变化", name)
+		}
+		changed := CopyTX(tx)
+		changed.LockedTime++
+		if id, _ := changed.CalculateTxId(); id == txid {
+			t.Errorf("%s：修改时间戳后交易hash没有变化", name)
+		}
+	}
+}
+
+func TestDeserializeTransactionInvalid(t *testing.T) {
+	tx := testTransactions()["普通交易"]
+	data, _ := tx.Serialize()
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"空数据", []byte{}},
+		{"末尾有多余的字节", append(append([]byte{}, data...), 0x00)},
+		{"数据被截断", data[:len(data)-1]},
+		{"不支持的版本号", append([]byte{0x09, 0x00, 0x00, 0x00}, data[4:]...)},
+		{"旧格式的版本号", append([]byte{byte(LEGACYTXVERSION), 0x00, 0x00, 0x00}, data[4:]...)},
+		{"输入个数超出剩余字节数", []byte{byte(TXVERSION), 0x00, 0x00, 0x00, 0xfd, 0xff, 0xff}},
+	}
+	for _, c := range cases {
+		_, err := DeserializeTransaction(c.data)
+		if err == nil {
+			t.Errorf("%s：应反序列化失败", c.name)
+		}
+	}
+}
+
+func TestTxOutputRoundTrip(t *testing.T) {
+	cases := []TxOutput{
+		{Value: 0, PubHash: nil},
+		{Value: 12345678, PubHash: bytes.Repeat([]byte{0x01}, 21)},
+		{Value: -1, PubHash: []byte{0x02}},
+	}
+	for _, output := range cases {
+		decoded, err := DeserializeTxOutput(output.Serialize())
+		if err != nil {
+			t.Errorf("%+v：反序列化出错：%v", output, err)
+			continue
+		}
+		if decoded.Value != output.Value || !bytes.Equal(decoded.PubHash, output.PubHash) {
+			t.Errorf("读出%+v，应为%+v", decoded, output)
+		}
+	}
+}
+
+// 旧格式的float64金额按最小单位四舍五入，浮点数的误差不会少算或多算一个单位
+func TestLegacyValueToAmount(t *testing.T) {
+	cases := []struct {
+		value  float64
+		amount int64
+	}{
+		{0, 0},
+		{0.1, 10000000},
+		{0.3, 30000000},
+		{0.1 + 0.2, 30000000},
+		{1.1 - 0.9, 20000000},
+		{50, 50 * utils.COIN},
+		{0.00000001, 1},
+		{0.000000004, 0},
+		{0.000000006, 1},
+		{21000000, 21000000 * utils.COIN},
+	}
+	for _, c := range cases {
+		if amount := LegacyValueToAmount(c.value); amount != c.amount {
+			t.Errorf("%v转换为%d，应为%d", c.value, amount, c.amount)
+		}
+	}
+}
+
+// 按旧格式手工写出一笔交易，signed为false时不写签名，用于计算旧格式的交易hash
+func legacyTransactionBytes(signed bool, values ...float64) []byte {
+	buff := new(bytes.Buffer)
+	utils.WriteUint32(buff, LEGACYTXVERSION)
+	utils.WriteVarInt(buff, 1)
+	txid := testHash(1)
+	buff.Write(txid[:])
+	utils.WriteUint32(buff, 2)
+	if signed {
+		utils.WriteVarBytes(buff, bytes.Repeat([]byte{0x22}, 64))
+	} else {
+		utils.WriteVarBytes(buff, nil)
+	}
+	utils.WriteVarBytes(buff, bytes.Repeat([]byte{0x33}, 65))
+	utils.WriteVarInt(buff, uint64(len(values)))
+	for _, value := range values {
+		utils.WriteUint64(buff, math.Float64bits(value))
+		utils.WriteVarBytes(buff, bytes.Repeat([]byte{0x44}, 21))
+	}
+	utils.WriteInt64(buff, 7)
+	return buff.Bytes()
+}
+
+// 旧格式的交易hash是去掉签名之后旧格式序列化结果的sha256，与旧区块中交易输入的引用一致
+func TestDecodeLegacyTransaction(t *testing.T) {
+	data := legacyTransactionBytes(true, 0.1, 49.9)
+	reader := bytes.NewReader(data)
+	tx, err := DecodeLegacyTransaction(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.Len() != 0 {
+		t.Errorf("读取后剩余%d字节", reader.Len())
+	}
+	if tx.TxHash != sha256.Sum256(legacyTransactionBytes(false, 0.1, 49.9)) {
+		t.Error("交易hash与旧格式的计算结果不一致")
+	}
+	if len(tx.Outputs) != 2 || tx.Outputs[0].Value != 10000000 || tx.Outputs[1].Value != 4990000000 {
+		t.Errorf("交易输出的金额有误：%+v", tx.Outputs)
+	}
+	if len(tx.Inputs) != 1 || tx.Inputs[0].Txid != testHash(1) || tx.Inputs[0].Vout != 2 || len(tx.Inputs[0].Sig) != 64 {
+		t.Errorf("交易输入有误：%+v", tx.Inputs)
+	}
+	if tx.LockedTime != 7 {
+		t.Errorf("时间戳为%d，应为7", tx.LockedTime)
+	}
+}
+
+func TestDecodeLegacyTransactionInvalid(t *testing.T) {
+	data := legacyTransactionBytes(true, 1)
+	current := testTransactions()["普通交易"]
+	buff := new(bytes.Buffer)
+	current.Encode(buff)
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"当前格式的交易", buff.Bytes()},
+		{"交易被截断", data[:len(data)-1]},
+		{"只有版本号", data[:4]},
+	}
+	for _, c := range cases {
+		_, err := DecodeLegacyTransaction(bytes.NewReader(c.data))
+		if err == nil {
+			t.Errorf("%s：应读取失败", c.name)
+		}
+	}
+	_, err := DeserializeTransaction(data)
+	if err == nil {
+		t.Error("旧格式的交易不应按当前格式读取")
+	}
+}
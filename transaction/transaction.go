@@ -3,52 +3,49 @@ package transaction
 import (
 	"PublicChain/utils"
 	"PublicChain/wallet"
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
 	"errors"
 	"time"
 )
 
-const REWARD =50
-
-
 type Transaction struct {
 	TxHash  [32]byte //交易的唯一标识
 	Inputs  []TxInput
 	Outputs []TxOutput
 	LockedTime  int64 // 时间戳，为使每笔交易保持唯一性
+	Governance  *Governance // 治理交易的内容，普通交易为nil
 }
 
-func NewCoinbaseTx(address string)(*Transaction ,error){
-	txOutput:=Lock2Address(REWARD,address)
+// value为该区块的出块奖励加上区块中交易的手续费，单位为最小单位
+func NewCoinbaseTx(address string, value int64)(*Transaction ,error){
+	txOutput:=Lock2Address(value,address)
 	tx := Transaction{
 		Inputs:  []TxInput{},
 		Outputs: []TxOutput{txOutput},
-		LockedTime:time.Now().Unix(), //
+		LockedTime:time.Now().UnixNano(), // 纳秒，避免同一秒内奖励给同一地址的coinbase交易hash相同
 	}
 
-	//序列化
-	txBytes, err := utils.GobEncode(tx)
+	//交易哈希计算，并赋值给TxHash字段
+	txHash, err := tx.CalculateTxId()
 	if err != nil {
 		return nil, err
 	}
-
-	//交易哈希计算，并赋值给TxHash字段
-	tx.TxHash = sha256.Sum256(txBytes)
+	tx.TxHash = txHash
 
 	return &tx, nil
 }
 
 /**
-   构建一个新的交易
+   构建一个新的交易，输入金额减去转账金额和手续费fee之后的部分找零给转账发起人，金额均为最小单位
  */
-func NewTransaction(spent []UTXO ,from string , pubk []byte,to string,value float64)(*Transaction ,error){
+func NewTransaction(spent []UTXO ,from string , pubk []byte,to string,value int64,fee int64)(*Transaction ,error){
 	//遍历区块 ——>遍历区块中的所有交易
 	// 若找到一笔交易，该交易输出A 数额满足需求则return  Txid
 	txInputs := make([]TxInput, 0)
-	var inputAmount float64
+	var inputAmount int64
 	for _, utxo := range spent {
 		inputAmount += utxo.Value
 		input :=NewTxInput(utxo.TxId, utxo.Vout,pubk)
@@ -64,9 +61,13 @@ func NewTransaction(spent []UTXO ,from string , pubk []byte,to string,value floa
 	txOutput0:=Lock2Address(value,to)
 	txOutputs = append(txOutputs, txOutput0)
 
-	//还有可能产生找零的一个输出：交易发起者给的钱比要转账的钱多
-	if inputAmount-value > 0 { //需要找零给转账发起人
-		txOutput1 := Lock2Address(inputAmount-value,from)
+	//还有可能产生找零的一个输出：交易发起者给的钱比要转账的钱加上手续费多
+	change := inputAmount - value - fee
+	if change < 0 {
+		return nil, errors.New("交易输入的金额不足以支付转账金额和手续费")
+	}
+	if change > 0 { //需要找零给转账发起人
+		txOutput1 := Lock2Address(change,from)
 		txOutputs = append(txOutputs, txOutput1)
 	}
 
@@ -76,12 +77,11 @@ func NewTransaction(spent []UTXO ,from string , pubk []byte,to string,value floa
 		Outputs: txOutputs,
 		LockedTime:time.Now().Unix(),
 	}
-	//序列化
-	txBytes, err := utils.GobEncode(tx)
+	txHash, err := tx.CalculateTxId()
 	if err != nil {
 		return nil, err
 	}
-	tx.TxHash = sha256.Sum256(txBytes)
+	tx.TxHash = txHash
 
 	return &tx, nil
 }
@@ -97,7 +97,7 @@ func (tx *Transaction)Sign(private *ecdsa.PrivateKey,utxos []UTXO)(error){
 	}
 	txcopy :=CopyTX(*tx)
 	for i:=0;i<len(txcopy.Inputs) ;i++{
-		input:=txcopy.Inputs[i] //当前遍历到的第几个交易输入
+		input:=&txcopy.Inputs[i] //当前遍历到的第几个交易输入
 		utxo:=utxos[i]//当前遍历到的第几个utxo
 		//scriptPub:=utxo.PubHash //获得当前遍历到的utxo的锁定脚本中的公钥hash
 		input.Pubk = utxo.PubHash
@@ -113,7 +113,8 @@ func (tx *Transaction)Sign(private *ecdsa.PrivateKey,utxos []UTXO)(error){
 			return err
 		}
 
-		sigbytes:=append(r.Bytes(),s.Bytes()...)
+		// r和s各自补齐为32字节，保证验签时能从中间正确拆分
+		sigbytes:=append(r.FillBytes(make([]byte,32)),s.FillBytes(make([]byte,32))...)
 		tx.Inputs[i].Sig = sigbytes //赋值的是原tx
 
 	}
@@ -129,6 +130,9 @@ func (tx *Transaction) VertifySign(utxos []UTXO) (bool,error){
 	if tx.IsCoinbaseTranaction() {
 		return true ,nil
 	}
+	if tx.IsGovernanceTransaction() {
+		return tx.verifyGovernance()
+	}
 
 	if len(tx.Inputs) !=len(utxos){
 		return false,errors.New("验签遇到错误，请检查")
@@ -140,7 +144,7 @@ func (tx *Transaction) VertifySign(utxos []UTXO) (bool,error){
 
 		// 验签： 公钥，签名，原文 ——> hash
 		pubk := input.Pubk    //公钥
-		signBytes :=input.Sig // 签名
+		signBytes :=tx.Inputs[index].Sig // 签名，副本中的签名已被置空，需从原交易中取
 
 		// 对交易副本中的每一个input进行还原 ，还原签名之前的状态
 		// I. 签名置空
@@ -152,6 +156,8 @@ func (tx *Transaction) VertifySign(utxos []UTXO) (bool,error){
 		if err !=nil{
 			return false,err
 		}
+		//与签名时保持一致，已处理过的input的pubk置空
+		txCopy.Inputs[index].Pubk =nil
 
 		// 还原 PublicKey
 		//根据[]byte 还原PublicKey
@@ -198,6 +204,14 @@ func CopyTX(tx Transaction)(Transaction){
 		outputs = append(outputs,txoutput)
 	}
 	newTx.Outputs = outputs
+	newTx.LockedTime = tx.LockedTime
+
+	//治理交易的批准同样不包含在副本中
+	if tx.Governance != nil {
+		gov := *tx.Governance
+		gov.Approvals = nil
+		newTx.Governance = &gov
+	}
 
 	return newTx
 }
@@ -206,7 +220,7 @@ func CopyTX(tx Transaction)(Transaction){
   *  判断某个交易是否是Coinbase交易
  */
 func (tx *Transaction)IsCoinbaseTranaction() bool {
-	return len(tx.Inputs) ==0 && len(tx.Outputs) == 1
+	return len(tx.Inputs) ==0 && len(tx.Outputs) == 1 && tx.Governance == nil
 }
 
 
@@ -219,9 +233,11 @@ func (tx *Transaction)CalculateTxHash()([]byte,error){
 	return utils.Sha256Hash(txByte) ,nil
 }
 
-// 交易的序列化
+// 交易的序列化，格式见Encode
 func (tx *Transaction)Serialize()([]byte,error){
-	return utils.GobEncode(tx)
+	buff :=new(bytes.Buffer)
+	tx.Encode(buff)
+	return buff.Bytes(),nil
 }
 
 
@@ -3,10 +3,12 @@ package transaction
 import (
 	"PublicChain/utils"
 	"bytes"
+	"errors"
+	"fmt"
 )
 
 type TxOutput struct {
-	Value float64
+	Value int64 //金额，单位为1/utils.COIN个币
 	//ScriptPub  []byte //锁定脚本
 	PubHash []byte  //公钥hash
 }
@@ -14,7 +16,7 @@ type TxOutput struct {
 /**
    构建一个新的交易输出，锁一定数额
  */
-func Lock2Address(value float64,add string)TxOutput{
+func Lock2Address(value int64,add string)TxOutput{
 	reAdd :=utils.Decode(add)
 	pubHash:=reAdd[:len(reAdd)-4]
 	output :=TxOutput{
@@ -31,4 +33,38 @@ func(outPut *TxOutput)VertifyOutputWithAddress(add string)(bool){
 	reAdd :=utils.Decode(add)
 	pubHash:=reAdd[:len(reAdd)-4]
 	return bytes.Compare(outPut.PubHash,pubHash) == 0
-}
\ No newline at end of file
+}
+
+/**
+ * 交易所有输出的金额之和：每个输出的金额必须为正数且不超过utils.MAXMONEY，每加一个输出后的和也不能超过utils.MAXMONEY
+ */
+func (tx *Transaction) OutputAmount() (int64, error) {
+	var amount int64
+	for _, output := range tx.Outputs {
+		if output.Value <= 0 || output.Value > utils.MAXMONEY {
+			return 0, fmt.Errorf("交易%x存在金额不为正数或超出范围的输出", tx.TxHash)
+		}
+		amount += output.Value
+		if !utils.MoneyRange(amount) {
+			return 0, fmt.Errorf("交易%x的输出金额之和超出范围", tx.TxHash)
+		}
+	}
+	return amount, nil
+}
+
+/**
+ * 一组utxo的金额之和，每个utxo的金额和每加一个utxo后的和都不能超出0到utils.MAXMONEY的范围
+ */
+func InputAmount(utxos []UTXO) (int64, error) {
+	var amount int64
+	for _, utxo := range utxos {
+		if !utils.MoneyRange(utxo.Value) {
+			return 0, fmt.Errorf("utxo%x:%d的金额超出范围", utxo.TxId, utxo.Vout)
+		}
+		amount += utxo.Value
+		if !utils.MoneyRange(amount) {
+			return 0, errors.New("交易的输入金额之和超出范围")
+		}
+	}
+	return amount, nil
+}
@@ -0,0 +1,78 @@
+package transaction
+
+import (
+	"PublicChain/utils"
+	"bytes"
+	"testing"
+)
+
+func outputsTx(values ...int64) Transaction {
+	tx := Transaction{Inputs: []TxInput{}, Outputs: []TxOutput{}}
+	for _, value := range values {
+		tx.Outputs = append(tx.Outputs, TxOutput{Value: value, PubHash: bytes.Repeat([]byte{0x01}, 21)})
+	}
+	return tx
+}
+
+// 每个输出和每一步的和都必须在范围内，两个很大的输出相加不能溢出为负数
+func TestOutputAmount(t *testing.T) {
+	cases := []struct {
+		name   string
+		values []int64
+		amount int64
+		valid  bool
+	}{
+		{"没有输出", nil, 0, true},
+		{"普通输出", []int64{1, 2 * utils.COIN}, 2*utils.COIN + 1, true},
+		{"等于上限", []int64{utils.MAXMONEY}, utils.MAXMONEY, true},
+		{"之和等于上限", []int64{utils.MAXMONEY - 1, 1}, utils.MAXMONEY, true},
+		{"金额为0", []int64{1, 0}, 0, false},
+		{"金额为负数", []int64{5, -1}, 0, false},
+		{"单个输出超过上限", []int64{utils.MAXMONEY + 1}, 0, false},
+		{"之和超过上限", []int64{utils.MAXMONEY, 1}, 0, false},
+		{"之和溢出int64", []int64{1 << 62, 1 << 62}, 0, false},
+		{"之和溢出后为正数", []int64{1<<63 - 1, 1<<63 - 1, 2}, 0, false},
+	}
+	for _, c := range cases {
+		tx := outputsTx(c.values...)
+		amount, err := tx.OutputAmount()
+		if (err == nil) != c.valid {
+			t.Errorf("%s：结果为%v，应为%v", c.name, err, c.valid)
+			continue
+		}
+		if c.valid && amount != c.amount {
+			t.Errorf("%s：输出金额之和为%d，应为%d", c.name, amount, c.amount)
+		}
+	}
+}
+
+func TestInputAmount(t *testing.T) {
+	cases := []struct {
+		name   string
+		values []int64
+		amount int64
+		valid  bool
+	}{
+		{"没有输入", nil, 0, true},
+		{"普通输入", []int64{3, 4}, 7, true},
+		{"之和等于上限", []int64{utils.MAXMONEY - 2, 2}, utils.MAXMONEY, true},
+		{"金额为负数", []int64{10, -1}, 0, false},
+		{"单个utxo超过上限", []int64{utils.MAXMONEY + 1}, 0, false},
+		{"之和超过上限", []int64{utils.MAXMONEY, utils.MAXMONEY}, 0, false},
+		{"之和溢出int64", []int64{1<<63 - 1, 1}, 0, false},
+	}
+	for _, c := range cases {
+		utxos := make([]UTXO, 0)
+		for index, value := range c.values {
+			utxos = append(utxos, NewUTXO(testHash(byte(index)), index, TxOutput{Value: value}))
+		}
+		amount, err := InputAmount(utxos)
+		if (err == nil) != c.valid {
+			t.Errorf("%s：结果为%v，应为%v", c.name, err, c.valid)
+			continue
+		}
+		if c.valid && amount != c.amount {
+			t.Errorf("%s：输入金额之和为%d，应为%d", c.name, amount, c.amount)
+		}
+	}
+}
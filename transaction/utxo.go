@@ -1,7 +1,7 @@
 package transaction
 
 import (
-	"PublicChain/utils"
+	"PublicChain/wallet"
 	"bytes"
 )
 
@@ -37,10 +37,7 @@ func(utxo *UTXO)IsSpent(spend TxInput)bool{
 
 	equalVout := utxo.Vout == spend.Vout
 	//把原始公钥 变换 得到 对应公钥hash
-	pubk :=spend.Pubk
-	pubk256 :=utils.Sha256Hash(pubk)
-	ripemd160:= utils.Ripemd160(pubk256)
-	versionPubkHash:=append([]byte{0X00},ripemd160...)
+	versionPubkHash:=wallet.NewPubKHash(spend.Pubk)
 
 	equalLock :=bytes.Compare(versionPubkHash,utxo.PubHash) ==0
 
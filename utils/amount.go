@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 金额的最小单位：1个币 = COIN个单位。交易、utxo和db中的金额都是int64的最小单位
+const COIN int64 = 100000000
+
+// 金额小数点后的位数，与COIN对应
+const AMOUNTDECIMALS = 8
+
+// 单个交易输出、交易的输入或输出之和、区块的手续费之和允许的最大金额(最小单位)，不小于任何网络的总发行量。
+// 限制每一步加法的结果，int64的金额之和不会溢出为负数
+const MAXMONEY int64 = 21000000 * COIN
+
+/**
+ * 金额是否在0到MAXMONEY之间
+ */
+func MoneyRange(amount int64) bool {
+	return amount >= 0 && amount <= MAXMONEY
+}
+
+/**
+ * 把十进制的金额字符串转换为最小单位，例如"0.1"转换为10000000。
+ * 只在命令行的边界解析金额，小数点后最多8位，不经过浮点数，不会产生舍入误差
+ */
+func ParseAmount(data string) (int64, error) {
+	text := strings.TrimSpace(data)
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+	whole, fraction := text, ""
+	if index := strings.IndexByte(text, '.'); index >= 0 {
+		whole, fraction = text[:index], text[index+1:]
+	}
+	if len(whole) == 0 && len(fraction) == 0 {
+		return 0, fmt.Errorf("金额格式有误：%s", data)
+	}
+	if len(fraction) > AMOUNTDECIMALS {
+		return 0, fmt.Errorf("金额%s的小数位数超过了%d位", data, AMOUNTDECIMALS)
+	}
+	if !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("金额格式有误：%s", data)
+	}
+	var coins, units int64
+	var err error
+	if len(whole) > 0 {
+		coins, err = strconv.ParseInt(whole, 10, 64)
+		if err != nil || coins > (1<<63-1)/COIN {
+			return 0, fmt.Errorf("金额%s超出范围", data)
+		}
+	}
+	if len(fraction) > 0 {
+		units, err = strconv.ParseInt(fraction+strings.Repeat("0", AMOUNTDECIMALS-len(fraction)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("金额格式有误：%s", data)
+		}
+	}
+	amount := coins*COIN + units
+	if amount < 0 {
+		return 0, fmt.Errorf("金额%s超出范围", data)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func isDigits(text string) bool {
+	for _, char := range text {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * 把最小单位的金额格式化为正好8位小数的字符串，例如10000000格式化为"0.10000000"
+ */
+func FormatAmount(amount int64) string {
+	sign := ""
+	value := uint64(amount)
+	if amount < 0 {
+		sign = "-"
+		value = uint64(-amount)
+	}
+	return fmt.Sprintf("%s%d.%08d", sign, value/uint64(COIN), value%uint64(COIN))
+}
+
+// JsonArray [10.0,20.1] ---> []int64[1000000000  2010000000]，按原始的十进制文本解析，不经过浮点数
+func JsonAmountToSlice(data string) ([]int64, error) {
+	var numbers []json.Number
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	err := decoder.Decode(&numbers)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("金额数组末尾有多余的内容")
+	}
+	slice := make([]int64, 0, len(numbers))
+	for _, number := range numbers {
+		amount, err := ParseAmount(number.String())
+		if err != nil {
+			return nil, err
+		}
+		slice = append(slice, amount)
+	}
+	return slice, nil
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		text   string
+		amount int64
+		valid  bool
+	}{
+		{"0", 0, true},
+		{"1", COIN, true},
+		{"0.1", 10000000, true},
+		{"0.3", 30000000, true},
+		{"0.00000001", 1, true},
+		{"1.12345678", 112345678, true},
+		{".5", 50000000, true},
+		{"5.", 5 * COIN, true},
+		{" 2.5 ", 250000000, true},
+		{"-1.5", -150000000, true},
+		{"-0", 0, true},
+		{"92233720368.54775807", math.MaxInt64, true},
+		{"-92233720368.54775807", -math.MaxInt64, true},
+		{"", 0, false},
+		{".", 0, false},
+		{"-", 0, false},
+		{"--1", 0, false},
+		{"+1", 0, false},
+		{"1.123456789", 0, false}, // 小数超过8位
+		{"0.000000001", 0, false},
+		{"abc", 0, false},
+		{"1e5", 0, false},
+		{"1,5", 0, false},
+		{"1.2.3", 0, false},
+		{"92233720368.54775808", 0, false}, // 超过int64
+		{"92233720369", 0, false},
+		{"99999999999999999999999", 0, false},
+	}
+	for _, c := range cases {
+		amount, err := ParseAmount(c.text)
+		if (err == nil) != c.valid {
+			t.Errorf("%q：解析结果为%v，应为%v", c.text, err, c.valid)
+			continue
+		}
+		if c.valid && amount != c.amount {
+			t.Errorf("%q解析为%d，应为%d", c.text, amount, c.amount)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	cases := []struct {
+		amount int64
+		text   string
+	}{
+		{0, "0.00000000"},
+		{1, "0.00000001"},
+		{10000000, "0.10000000"},
+		{COIN, "1.00000000"},
+		{-150000000, "-1.50000000"},
+		{-1, "-0.00000001"},
+		{math.MaxInt64, "92233720368.54775807"},
+		{math.MinInt64, "-92233720368.54775808"},
+	}
+	for _, c := range cases {
+		if text := FormatAmount(c.amount); text != c.text {
+			t.Errorf("%d格式化为%s，应为%s", c.amount, text, c.text)
+		}
+	}
+}
+
+// 格式化后再解析得到原值
+func TestAmountRoundTrip(t *testing.T) {
+	for _, amount := range []int64{0, 1, -1, 12345678, COIN, 21000000 * COIN, -math.MaxInt64, math.MaxInt64} {
+		parsed, err := ParseAmount(FormatAmount(amount))
+		if err != nil || parsed != amount {
+			t.Errorf("%d格式化后解析为%d：%v", amount, parsed, err)
+		}
+	}
+}
+
+func TestJsonAmountToSlice(t *testing.T) {
+	cases := []struct {
+		data    string
+		amounts []int64
+		valid   bool
+	}{
+		{"[]", []int64{}, true},
+		{"[10.0,20.1]", []int64{10 * COIN, 2010000000}, true},
+		{"[0.1, 0.2, 0.3]", []int64{10000000, 20000000, 30000000}, true}, // 不经过浮点数，没有舍入误差
+		{"[0.00000001]", []int64{1}, true},
+		{"[1] [2]", nil, false},
+		{"[1]x", nil, false},
+		{"[1e5]", nil, false},
+		{"[0.123456789]", nil, false},
+		{"[\"1.5\"]", []int64{150000000}, true}, // json.Number也接受字符串形式的数字
+		{"[\"abc\"]", nil, false},
+		{"[1,", nil, false},
+		{"1", nil, false},
+	}
+	for _, c := range cases {
+		amounts, err := JsonAmountToSlice(c.data)
+		if (err == nil) != c.valid {
+			t.Errorf("%s：解析结果为%v，应为%v", c.data, err, c.valid)
+			continue
+		}
+		if !c.valid {
+			continue
+		}
+		if len(amounts) != len(c.amounts) {
+			t.Errorf("%s解析出%d个金额，应为%d个", c.data, len(amounts), len(c.amounts))
+			continue
+		}
+		for i := range amounts {
+			if amounts[i] != c.amounts[i] {
+				t.Errorf("%s的第%d个金额为%d，应为%d", c.data, i, amounts[i], c.amounts[i])
+			}
+		}
+	}
+}
+
+func TestMoneyRange(t *testing.T) {
+	cases := []struct {
+		amount int64
+		valid  bool
+	}{
+		{0, true},
+		{1, true},
+		{MAXMONEY, true},
+		{MAXMONEY + 1, false},
+		{-1, false},
+		{math.MinInt64, false},
+		{math.MaxInt64, false},
+	}
+	for _, c := range cases {
+		if got := MoneyRange(c.amount); got != c.valid {
+			t.Errorf("%d：%v，应为%v", c.amount, got, c.valid)
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"golang.org/x/crypto/ripemd160"
 )
 
@@ -48,11 +50,20 @@ func JsonStringToSlince(data string)([]string,error){
 	return slice,err
 }
 
-//JsonArray [10.0,20.1] ---> []float[10.0  20.0]
-func JsonFloatToSlice(data string)([]float64 ,error){
-	var slice []float64
-	err :=json.Unmarshal([]byte(data),&slice)
-	return slice ,err
+/**
+   将16进制字符串转换为32字节的hash
+ */
+func HexToHash(data string)([32]byte,error){
+	var hash [32]byte
+	hashBytes,err :=hex.DecodeString(data)
+	if err !=nil{
+		return hash,err
+	}
+	if len(hashBytes) != 32{
+		return hash,errors.New("hash长度有误")
+	}
+	copy(hash[:],hashBytes)
+	return hash,nil
 }
 
 func Sha256Hash(data []byte) []byte{
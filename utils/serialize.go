@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// 变长字节数组允许的最大长度，防止错误的数据导致分配过大的内存
+const MAXVARBYTES = 32 << 20
+
+/**
+ * 写入compact size变长整数(小端序)：
+ * 小于0xfd时用1个字节，否则先写0xfd/0xfe/0xff，再写2/4/8字节的数值
+ */
+func WriteVarInt(buff *bytes.Buffer, num uint64) {
+	switch {
+	case num < 0xfd:
+		buff.WriteByte(byte(num))
+	case num <= 0xffff:
+		buff.WriteByte(0xfd)
+		_ = binary.Write(buff, binary.LittleEndian, uint16(num))
+	case num <= 0xffffffff:
+		buff.WriteByte(0xfe)
+		_ = binary.Write(buff, binary.LittleEndian, uint32(num))
+	default:
+		buff.WriteByte(0xff)
+		_ = binary.Write(buff, binary.LittleEndian, num)
+	}
+}
+
+/**
+ * 读取compact size变长整数，必须是最短的编码
+ */
+func ReadVarInt(reader *bytes.Reader) (uint64, error) {
+	prefix, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	var num uint64
+	var min uint64
+	switch prefix {
+	case 0xfd:
+		var value uint16
+		err = binary.Read(reader, binary.LittleEndian, &value)
+		num, min = uint64(value), 0xfd
+	case 0xfe:
+		var value uint32
+		err = binary.Read(reader, binary.LittleEndian, &value)
+		num, min = uint64(value), 0x10000
+	case 0xff:
+		err = binary.Read(reader, binary.LittleEndian, &num)
+		min = 0x100000000
+	default:
+		return uint64(prefix), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if num < min {
+		return 0, errors.New("变长整数不是最短编码")
+	}
+	return num, nil
+}
+
+/**
+ * 写入带长度前缀的字节数组
+ */
+func WriteVarBytes(buff *bytes.Buffer, data []byte) {
+	WriteVarInt(buff, uint64(len(data)))
+	buff.Write(data)
+}
+
+/**
+ * 读取带长度前缀的字节数组，长度为0时返回nil
+ */
+func ReadVarBytes(reader *bytes.Reader) ([]byte, error) {
+	length, err := ReadVarInt(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > MAXVARBYTES || length > uint64(reader.Len()) {
+		return nil, errors.New("字节数组长度超出范围")
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	return data, err
+}
+
+func WriteUint32(buff *bytes.Buffer, num uint32) {
+	_ = binary.Write(buff, binary.LittleEndian, num)
+}
+
+func ReadUint32(reader *bytes.Reader) (uint32, error) {
+	var num uint32
+	err := binary.Read(reader, binary.LittleEndian, &num)
+	return num, err
+}
+
+func WriteInt64(buff *bytes.Buffer, num int64) {
+	_ = binary.Write(buff, binary.LittleEndian, num)
+}
+
+func ReadInt64(reader *bytes.Reader) (int64, error) {
+	var num int64
+	err := binary.Read(reader, binary.LittleEndian, &num)
+	return num, err
+}
+
+func WriteUint64(buff *bytes.Buffer, num uint64) {
+	_ = binary.Write(buff, binary.LittleEndian, num)
+}
+
+func ReadUint64(reader *bytes.Reader) (uint64, error) {
+	var num uint64
+	err := binary.Read(reader, binary.LittleEndian, &num)
+	return num, err
+}
+
+/**
+ * 读取定长的hash
+ */
+func ReadHash(reader *bytes.Reader) ([32]byte, error) {
+	var hash [32]byte
+	_, err := io.ReadFull(reader, hash[:])
+	return hash, err
+}
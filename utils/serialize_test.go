@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// 变长整数在每个长度边界两侧的编码长度，以及读出后与写入的值一致
+func TestVarIntRoundTrip(t *testing.T) {
+	cases := []struct {
+		num  uint64
+		size int
+	}{
+		{0, 1},
+		{0xfc, 1},
+		{0xfd, 3},
+		{0xffff, 3},
+		{0x10000, 5},
+		{0xffffffff, 5},
+		{0x100000000, 9},
+		{math.MaxUint64, 9},
+	}
+	for _, c := range cases {
+		buff := new(bytes.Buffer)
+		WriteVarInt(buff, c.num)
+		if buff.Len() != c.size {
+			t.Errorf("%d编码后为%d字节，应为%d字节", c.num, buff.Len(), c.size)
+		}
+		reader := bytes.NewReader(buff.Bytes())
+		num, err := ReadVarInt(reader)
+		if err != nil {
+			t.Errorf("读取%d出错：%v", c.num, err)
+			continue
+		}
+		if num != c.num || reader.Len() != 0 {
+			t.Errorf("读出%d，剩余%d字节，应为%d且没有剩余", num, reader.Len(), c.num)
+		}
+	}
+}
+
+// 不是最短编码或者数据不完整的变长整数读取失败
+func TestReadVarIntInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"空数据", []byte{}},
+		{"0xfd编码的小数值", []byte{0xfd, 0xfc, 0x00}},
+		{"0xfe编码的2字节数值", []byte{0xfe, 0xff, 0xff, 0x00, 0x00}},
+		{"0xff编码的4字节数值", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00}},
+		{"0xfd后数据不足", []byte{0xfd, 0x01}},
+		{"0xff后数据不足", []byte{0xff, 0x01, 0x02, 0x03}},
+	}
+	for _, c := range cases {
+		_, err := ReadVarInt(bytes.NewReader(c.data))
+		if err == nil {
+			t.Errorf("%s：应读取失败", c.name)
+		}
+	}
+}
+
+func TestVarBytesRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"nil", nil},
+		{"1字节", []byte{0x01}},
+		{"252字节", bytes.Repeat([]byte{0xab}, 0xfc)},
+		{"253字节", bytes.Repeat([]byte{0xcd}, 0xfd)},
+		{"70000字节", bytes.Repeat([]byte{0xef}, 70000)},
+	}
+	for _, c := range cases {
+		buff := new(bytes.Buffer)
+		WriteVarBytes(buff, c.data)
+		reader := bytes.NewReader(buff.Bytes())
+		data, err := ReadVarBytes(reader)
+		if err != nil {
+			t.Errorf("%s：读取出错：%v", c.name, err)
+			continue
+		}
+		if !bytes.Equal(data, c.data) || reader.Len() != 0 {
+			t.Errorf("%s：读出的数据与写入的不一致", c.name)
+		}
+	}
+}
+
+// 长度前缀超过剩余的字节数时报错，不按长度前缀分配内存
+func TestReadVarBytesTruncated(t *testing.T) {
+	buff := new(bytes.Buffer)
+	WriteVarInt(buff, 10)
+	buff.Write([]byte{1, 2, 3})
+	_, err := ReadVarBytes(bytes.NewReader(buff.Bytes()))
+	if err == nil {
+		t.Error("长度前缀超过剩余字节数时应读取失败")
+	}
+
+	buff.Reset()
+	WriteVarInt(buff, MAXVARBYTES+1)
+	_, err = ReadVarBytes(bytes.NewReader(buff.Bytes()))
+	if err == nil {
+		t.Error("长度超过MAXVARBYTES时应读取失败")
+	}
+}
+
+func TestFixedIntRoundTrip(t *testing.T) {
+	for _, num := range []int64{0, 1, -1, math.MaxInt64, math.MinInt64} {
+		buff := new(bytes.Buffer)
+		WriteInt64(buff, num)
+		got, err := ReadInt64(bytes.NewReader(buff.Bytes()))
+		if err != nil || got != num || buff.Len() != 8 {
+			t.Errorf("int64 %d读出%d，错误%v", num, got, err)
+		}
+	}
+	for _, num := range []uint32{0, 1, math.MaxUint32} {
+		buff := new(bytes.Buffer)
+		WriteUint32(buff, num)
+		got, err := ReadUint32(bytes.NewReader(buff.Bytes()))
+		if err != nil || got != num || buff.Len() != 4 {
+			t.Errorf("uint32 %d读出%d，错误%v", num, got, err)
+		}
+	}
+	_, err := ReadHash(bytes.NewReader(make([]byte, 31)))
+	if err == nil {
+		t.Error("不足32字节时读取hash应失败")
+	}
+}
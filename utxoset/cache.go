@@ -0,0 +1,364 @@
+package utxoset
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/wallet"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// utxo缓存默认的内存上限：32MB
+const DEFAULTCACHESIZE = 32 << 20
+
+// utxo集合的元数据
+const UTXOMETA = "utxometa"
+
+// utxo集合对应的最新区块hash，缓存写回db时一起更新
+const BESTBLOCK = "bestblock"
+
+// 估算一条缓存记录占用的内存：key、UTXO结构体以及map的开销，不含公钥hash
+const cacheEntryOverhead = 40 + 96 + 64
+
+/**
+ * 缓存中的一条记录
+ */
+type cacheEntry struct {
+	utxo  *transaction.UTXO // nil表示该utxo已被花费
+	dirty bool              // 与db中的不一致，需要写回
+	fresh bool              // db中没有该utxo，在写回之前被花费时直接从缓存中删除即可
+}
+
+/**
+ * 缓存的统计信息
+ */
+type CacheStats struct {
+	Hits    uint64 // 在缓存中找到的次数
+	Misses  uint64 // 需要从db中读取的次数
+	Flushes uint64 // 写回db的次数
+	Entries int    // 缓存中的记录数
+	Dirty   int    // 还未写回db的记录数
+	Usage   int    // 估算占用的内存
+	Limit   int    // 内存上限
+}
+
+/*
+utxo集合前面的写回缓存。连接区块时utxo的新增和花费先记录在内存中：
+
+	a.区块之间检查内存占用，超过上限时把改动批量写回db，然后清空缓存
+	b.程序退出时把剩余的改动写回db
+	c.在写回之前就被花费掉的utxo不会写入db
+
+db中记录了utxo集合对应的最新区块，写回之前程序异常退出时两者不一致，下次启动时会重建utxo集合
+*/
+type CoinsCache struct {
+	entries   map[string]*cacheEntry
+	bestBlock [32]byte
+	usage     int
+	limit     int
+	stats     CacheStats
+
+	// 事务期间被修改的记录原来的状态，事务失败时用于恢复缓存
+	journal        map[string]*cacheEntry
+	journalUsage   int
+	journalBest    [32]byte
+	journalStarted bool
+}
+
+func NewCoinsCache(limit int) *CoinsCache {
+	return &CoinsCache{
+		entries: make(map[string]*cacheEntry),
+		limit:   limit,
+	}
+}
+
+// 设置缓存的内存上限
+func (cache *CoinsCache) SetLimit(limit int) {
+	cache.limit = limit
+}
+
+// 设置缓存中utxo集合对应的最新区块，写回时一起写入db
+func (cache *CoinsCache) SetBestBlock(hash [32]byte) {
+	cache.bestBlock = hash
+}
+
+func (cache *CoinsCache) GetBestBlock() [32]byte {
+	return cache.bestBlock
+}
+
+/**
+ * 按outpoint查询utxo，缓存中没有时从db中读取并放入缓存，不存在或已花费时返回nil
+ */
+func (cache *CoinsCache) GetUTXO(tx *bolt.Tx, txid [32]byte, vout int) (*transaction.UTXO, error) {
+	key, err := OutpointKey(txid, vout)
+	if err != nil {
+		return nil, err
+	}
+	if entry, ok := cache.entries[string(key)]; ok {
+		cache.stats.Hits++
+		return entry.utxo, nil
+	}
+	cache.stats.Misses++
+	utxo, err := GetUTXOInTx(tx, txid, vout)
+	if err != nil || utxo == nil {
+		return nil, err
+	}
+	cache.put(string(key), &cacheEntry{utxo: utxo})
+	return utxo, nil
+}
+
+/**
+ * 新增一个utxo，只记录在缓存中
+ */
+func (cache *CoinsCache) AddUTXO(utxo transaction.UTXO) error {
+	key, err := OutpointKey(utxo.TxId, utxo.Vout)
+	if err != nil {
+		return err
+	}
+	entry := &cacheEntry{utxo: &utxo, dirty: true, fresh: true}
+	if old, ok := cache.entries[string(key)]; ok && !old.fresh {
+		// db中还有该outpoint的旧记录，写回时需要覆盖
+		entry.fresh = false
+	}
+	cache.put(string(key), entry)
+	return nil
+}
+
+/**
+ * 花费一个utxo，要花费的utxo必须存在，返回被花费的utxo
+ */
+func (cache *CoinsCache) SpendUTXO(tx *bolt.Tx, txid [32]byte, vout int) (*transaction.UTXO, error) {
+	utxo, err := cache.GetUTXO(tx, txid, vout)
+	if err != nil {
+		return nil, err
+	}
+	if utxo == nil {
+		return nil, fmt.Errorf("招不到要删除的utxo%x:%d,请检查", txid, vout)
+	}
+	key, _ := OutpointKey(txid, vout)
+	if cache.entries[string(key)].fresh {
+		cache.remove(string(key))
+	} else {
+		cache.put(string(key), &cacheEntry{dirty: true})
+	}
+	return utxo, nil
+}
+
+/**
+ * 查询某个地址所有的utxo：以db中的地址索引为基础，叠加缓存中还未写回的改动
+ */
+func (cache *CoinsCache) QuerryUTXOsByAddress(tx *bolt.Tx, address string) ([]transaction.UTXO, error) {
+	return cache.QuerryUTXOsByPubHash(tx, wallet.GetPubKHashWithAddress(address))
+}
+
+/**
+ * 查询某个公钥hash所有的utxo，同样叠加缓存中还未写回的改动
+ */
+func (cache *CoinsCache) QuerryUTXOsByPubHash(tx *bolt.Tx, pubHash []byte) ([]transaction.UTXO, error) {
+	dbUTXOs, err := QuerryUTXOsByPubHashInTx(tx, pubHash)
+	if err != nil {
+		return nil, err
+	}
+	utxos := make([]transaction.UTXO, 0)
+	found := make(map[string]bool)
+	for _, utxo := range dbUTXOs {
+		key, err := OutpointKey(utxo.TxId, utxo.Vout)
+		if err != nil {
+			return nil, err
+		}
+		found[string(key)] = true
+		if entry, ok := cache.entries[string(key)]; ok {
+			cache.stats.Hits++
+			if entry.utxo == nil {
+				continue
+			}
+			utxo = *entry.utxo
+		}
+		utxos = append(utxos, utxo)
+	}
+	for key, entry := range cache.entries {
+		if found[key] || !entry.dirty || entry.utxo == nil {
+			continue
+		}
+		if bytes.Equal(entry.utxo.PubHash, pubHash) {
+			utxos = append(utxos, *entry.utxo)
+		}
+	}
+	return utxos, nil
+}
+
+/**
+ * 区块之间调用：内存占用超过上限时写回db
+ */
+func (cache *CoinsCache) FlushIfNeeded(tx *bolt.Tx) error {
+	if cache.usage <= cache.limit {
+		return nil
+	}
+	return cache.Flush(tx)
+}
+
+/**
+ * 把缓存中所有的改动以及对应的最新区块写回db，然后清空缓存
+ */
+func (cache *CoinsCache) Flush(tx *bolt.Tx) error {
+	for key, entry := range cache.entries {
+		if !entry.dirty {
+			continue
+		}
+		if len(key) != 40 {
+			return errors.New("utxo缓存数据有误")
+		}
+		if entry.utxo == nil {
+			var txid [32]byte
+			copy(txid[:], key[:32])
+			vout := int(binary.BigEndian.Uint64([]byte(key[32:])))
+			err := DeleteUTXOInTx(tx, txid, vout)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err := PutUTXOInTx(tx, *entry.utxo)
+		if err != nil {
+			return err
+		}
+	}
+	err := SetBestBlockInTx(tx, cache.bestBlock)
+	if err != nil {
+		return err
+	}
+	for key := range cache.entries {
+		cache.remove(key)
+	}
+	cache.stats.Flushes++
+	return nil
+}
+
+/**
+ * 丢弃缓存中所有的记录，包括还未写回的改动
+ */
+func (cache *CoinsCache) Clear() {
+	for key := range cache.entries {
+		cache.remove(key)
+	}
+}
+
+/**
+ * 开始一个db事务：记录之后被修改的缓存记录原来的状态
+ */
+func (cache *CoinsCache) Begin() {
+	cache.journal = make(map[string]*cacheEntry)
+	cache.journalUsage = cache.usage
+	cache.journalBest = cache.bestBlock
+	cache.journalStarted = true
+}
+
+/**
+ * db事务提交成功，缓存中的改动保留
+ */
+func (cache *CoinsCache) Commit() {
+	cache.journal = nil
+	cache.journalStarted = false
+}
+
+/**
+ * db事务失败，把缓存恢复到事务开始之前的状态
+ */
+func (cache *CoinsCache) Rollback() {
+	for key, entry := range cache.journal {
+		if entry == nil {
+			delete(cache.entries, key)
+			continue
+		}
+		cache.entries[key] = entry
+	}
+	cache.usage = cache.journalUsage
+	cache.bestBlock = cache.journalBest
+	cache.Commit()
+}
+
+func (cache *CoinsCache) Stats() CacheStats {
+	stats := cache.stats
+	stats.Entries = len(cache.entries)
+	for _, entry := range cache.entries {
+		if entry.dirty {
+			stats.Dirty++
+		}
+	}
+	stats.Usage = cache.usage
+	stats.Limit = cache.limit
+	return stats
+}
+
+func (cache *CoinsCache) put(key string, entry *cacheEntry) {
+	cache.save(key)
+	if old, ok := cache.entries[key]; ok {
+		cache.usage -= entryUsage(old)
+	}
+	cache.entries[key] = entry
+	cache.usage += entryUsage(entry)
+}
+
+func (cache *CoinsCache) remove(key string) {
+	old, ok := cache.entries[key]
+	if !ok {
+		return
+	}
+	cache.save(key)
+	cache.usage -= entryUsage(old)
+	delete(cache.entries, key)
+}
+
+// 事务期间第一次修改某条记录时，保存它原来的状态
+func (cache *CoinsCache) save(key string) {
+	if !cache.journalStarted {
+		return
+	}
+	if _, saved := cache.journal[key]; saved {
+		return
+	}
+	if old, ok := cache.entries[key]; ok {
+		copied := *old
+		cache.journal[key] = &copied
+		return
+	}
+	cache.journal[key] = nil
+}
+
+func entryUsage(entry *cacheEntry) int {
+	if entry.utxo == nil {
+		return cacheEntryOverhead
+	}
+	return cacheEntryOverhead + len(entry.utxo.PubHash)
+}
+
+/**
+ * 在事务中读取utxo集合对应的最新区块，没有记录时返回false
+ */
+func GetBestBlockInTx(tx *bolt.Tx) ([32]byte, bool) {
+	var hash [32]byte
+	bucket := tx.Bucket([]byte(UTXOMETA))
+	if bucket == nil {
+		return hash, false
+	}
+	hashBytes := bucket.Get([]byte(BESTBLOCK))
+	if len(hashBytes) != 32 {
+		return hash, false
+	}
+	copy(hash[:], hashBytes)
+	return hash, true
+}
+
+func SetBestBlockInTx(tx *bolt.Tx, hash [32]byte) error {
+	bucket := tx.Bucket([]byte(UTXOMETA))
+	if bucket == nil {
+		var err error
+		bucket, err = tx.CreateBucket([]byte(UTXOMETA))
+		if err != nil {
+			return err
+		}
+	}
+	return bucket.Put([]byte(BESTBLOCK), hash[:])
+}
@@ -0,0 +1,289 @@
+package utxoset
+
+import (
+	"PublicChain/transaction"
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func openTestDB(t *testing.T) *bolt.DB {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "utxo.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func testUTXO(seed byte, vout int, value int64) transaction.UTXO {
+	var txid [32]byte
+	txid[0] = seed
+	return transaction.NewUTXO(txid, vout, transaction.TxOutput{Value: value, PubHash: bytes.Repeat([]byte{seed}, 21)})
+}
+
+// 缓存中所有记录的副本，用于比较事务前后的状态
+type cacheSnapshot struct {
+	entries map[string]cacheEntry
+	usage   int
+	best    [32]byte
+}
+
+func snapshotCache(cache *CoinsCache) cacheSnapshot {
+	snapshot := cacheSnapshot{entries: make(map[string]cacheEntry), usage: cache.usage, best: cache.bestBlock}
+	for key, entry := range cache.entries {
+		copied := *entry
+		if entry.utxo != nil {
+			utxo := *entry.utxo
+			copied.utxo = &utxo
+		}
+		snapshot.entries[key] = copied
+	}
+	return snapshot
+}
+
+func (snapshot cacheSnapshot) equal(other cacheSnapshot) bool {
+	if snapshot.usage != other.usage || snapshot.best != other.best || len(snapshot.entries) != len(other.entries) {
+		return false
+	}
+	for key, entry := range snapshot.entries {
+		otherEntry, ok := other.entries[key]
+		if !ok || entry.dirty != otherEntry.dirty || entry.fresh != otherEntry.fresh || (entry.utxo == nil) != (otherEntry.utxo == nil) {
+			return false
+		}
+		if entry.utxo != nil && (entry.utxo.Value != otherEntry.utxo.Value || !bytes.Equal(entry.utxo.PubHash, otherEntry.utxo.PubHash)) {
+			return false
+		}
+	}
+	return true
+}
+
+// 在db中写入utxos，并在缓存中放入一条还未写回的utxo，作为每个用例开始时的状态
+func prepareCache(t *testing.T, db *bolt.DB, utxos ...transaction.UTXO) *CoinsCache {
+	t.Helper()
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, utxo := range utxos {
+			err := PutUTXOInTx(tx, utxo)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewCoinsCache(DEFAULTCACHESIZE)
+	err = cache.AddUTXO(testUTXO(9, 0, 900))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.SetBestBlock([32]byte{1})
+	return cache
+}
+
+// 事务期间对缓存的各种修改，回滚之后缓存恢复到事务开始之前的状态
+func TestCacheRollback(t *testing.T) {
+	inDB := testUTXO(1, 0, 100)
+	cases := []struct {
+		name    string
+		changed bool // 事务结束时缓存是否与开始时不同，新增后立即花费的utxo直接从缓存中删除
+		modify  func(tx *bolt.Tx, cache *CoinsCache) error
+	}{
+		{"新增utxo", true, func(tx *bolt.Tx, cache *CoinsCache) error {
+			return cache.AddUTXO(testUTXO(2, 0, 200))
+		}},
+		{"花费db中的utxo", true, func(tx *bolt.Tx, cache *CoinsCache) error {
+			_, err := cache.SpendUTXO(tx, inDB.TxId, inDB.Vout)
+			return err
+		}},
+		{"花费还未写回的utxo", true, func(tx *bolt.Tx, cache *CoinsCache) error {
+			_, err := cache.SpendUTXO(tx, testUTXO(9, 0, 0).TxId, 0)
+			return err
+		}},
+		{"读取db中的utxo", true, func(tx *bolt.Tx, cache *CoinsCache) error {
+			_, err := cache.GetUTXO(tx, inDB.TxId, inDB.Vout)
+			return err
+		}},
+		{"新增后立即花费", false, func(tx *bolt.Tx, cache *CoinsCache) error {
+			utxo := testUTXO(3, 1, 300)
+			err := cache.AddUTXO(utxo)
+			if err != nil {
+				return err
+			}
+			_, err = cache.SpendUTXO(tx, utxo.TxId, utxo.Vout)
+			return err
+		}},
+		{"花费后在同一outpoint新增", true, func(tx *bolt.Tx, cache *CoinsCache) error {
+			_, err := cache.SpendUTXO(tx, inDB.TxId, inDB.Vout)
+			if err != nil {
+				return err
+			}
+			return cache.AddUTXO(testUTXO(1, 0, 150))
+		}},
+		{"更新最新区块", true, func(tx *bolt.Tx, cache *CoinsCache) error {
+			cache.SetBestBlock([32]byte{2})
+			return nil
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := openTestDB(t)
+			cache := prepareCache(t, db, inDB)
+			before := snapshotCache(cache)
+
+			cache.Begin()
+			err := db.Update(func(tx *bolt.Tx) error {
+				return c.modify(tx, cache)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snapshotCache(cache).equal(before) == c.changed {
+				t.Fatalf("事务结束时缓存发生变化%v，应为%v", !c.changed, c.changed)
+			}
+			cache.Rollback()
+			if !snapshotCache(cache).equal(before) {
+				t.Error("回滚后缓存与事务开始之前不一致")
+			}
+		})
+	}
+}
+
+// 提交之后修改保留，之后的事务回滚只恢复到提交之后的状态
+func TestCacheCommit(t *testing.T) {
+	db := openTestDB(t)
+	inDB := testUTXO(1, 0, 100)
+	cache := prepareCache(t, db, inDB)
+
+	cache.Begin()
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := cache.SpendUTXO(tx, inDB.TxId, inDB.Vout)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Commit()
+	committed := snapshotCache(cache)
+
+	cache.Begin()
+	err = cache.AddUTXO(testUTXO(2, 0, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Rollback()
+	if !snapshotCache(cache).equal(committed) {
+		t.Error("回滚后缓存应恢复到上一次提交之后的状态")
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		utxo, err := cache.GetUTXO(tx, inDB.TxId, inDB.Vout)
+		if utxo != nil {
+			t.Error("提交的花费被回滚了")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 写回db后缓存清空：新增的utxo写入db，花费掉的从db中删除，写回之前就被花费的不写入db
+func TestCacheFlush(t *testing.T) {
+	db := openTestDB(t)
+	inDB := testUTXO(1, 0, 100)
+	cache := prepareCache(t, db, inDB)
+	added := testUTXO(2, 0, 200)
+	transient := testUTXO(3, 0, 300)
+	best := [32]byte{5}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := cache.SpendUTXO(tx, inDB.TxId, inDB.Vout); err != nil {
+			return err
+		}
+		if err := cache.AddUTXO(added); err != nil {
+			return err
+		}
+		if err := cache.AddUTXO(transient); err != nil {
+			return err
+		}
+		if _, err := cache.SpendUTXO(tx, transient.TxId, transient.Vout); err != nil {
+			return err
+		}
+		cache.SetBestBlock(best)
+		return cache.Flush(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Usage != 0 || stats.Flushes != 1 {
+		t.Errorf("写回后缓存应为空：%+v", stats)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		cases := []struct {
+			utxo   transaction.UTXO
+			exists bool
+		}{
+			{inDB, false},
+			{added, true},
+			{transient, false},
+			{testUTXO(9, 0, 900), true},
+		}
+		for _, c := range cases {
+			utxo, err := GetUTXOInTx(tx, c.utxo.TxId, c.utxo.Vout)
+			if err != nil {
+				return err
+			}
+			if (utxo != nil) != c.exists {
+				t.Errorf("utxo%x:%d在db中存在%v，应为%v", c.utxo.TxId, c.utxo.Vout, utxo != nil, c.exists)
+			}
+		}
+		if stored, ok := GetBestBlockInTx(tx); !ok || stored != best {
+			t.Error("最新区块没有写入db")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheSpendMissing(t *testing.T) {
+	db := openTestDB(t)
+	cache := prepareCache(t, db)
+	err := db.View(func(tx *bolt.Tx) error {
+		_, err := cache.SpendUTXO(tx, [32]byte{7}, 0)
+		return err
+	})
+	if err == nil {
+		t.Error("花费不存在的utxo应报错")
+	}
+}
+
+// 第一次从db中读取为未命中，之后在缓存中命中
+func TestCacheHitsAndMisses(t *testing.T) {
+	db := openTestDB(t)
+	inDB := testUTXO(1, 0, 100)
+	cache := prepareCache(t, db, inDB)
+	err := db.View(func(tx *bolt.Tx) error {
+		for i := 0; i < 3; i++ {
+			utxo, err := cache.GetUTXO(tx, inDB.TxId, inDB.Vout)
+			if err != nil {
+				return err
+			}
+			if utxo == nil || utxo.Value != inDB.Value {
+				t.Error("没有读到db中的utxo")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Hits != 2 {
+		t.Errorf("未命中%d次，命中%d次，应为1次和2次", stats.Misses, stats.Hits)
+	}
+}
@@ -0,0 +1,70 @@
+package utxoset
+
+import (
+	"PublicChain/utils"
+	"crypto/sha256"
+	"math/big"
+)
+
+// MuHash使用的3072位素数：2^3072 - 1103717
+var muHashPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 3072), big.NewInt(1103717))
+
+// 每个元素映射到的数字的字节数
+const muHashElementSize = 3072 / 8
+
+/*
+MuHash：集合的滚动hash，与元素加入的顺序无关
+
+	a.每个元素映射为模素数下的一个数，集合的hash为所有元素的乘积
+	b.加入元素时乘到分子上，删除元素时乘到分母上，不需要重新遍历整个集合
+	c.输出时计算 分子/分母 再做一次sha256
+*/
+type MuHash struct {
+	Numerator   *big.Int
+	Denominator *big.Int
+}
+
+func NewMuHash() *MuHash {
+	return &MuHash{
+		Numerator:   big.NewInt(1),
+		Denominator: big.NewInt(1),
+	}
+}
+
+// 把元素加入集合
+func (muhash *MuHash) Insert(data []byte) {
+	muhash.Numerator.Mul(muhash.Numerator, muHashElement(data))
+	muhash.Numerator.Mod(muhash.Numerator, muHashPrime)
+}
+
+// 把元素从集合中删除
+func (muhash *MuHash) Remove(data []byte) {
+	muhash.Denominator.Mul(muhash.Denominator, muHashElement(data))
+	muhash.Denominator.Mod(muhash.Denominator, muHashPrime)
+}
+
+/**
+ * 计算集合的hash
+ */
+func (muhash *MuHash) Finalize() [32]byte {
+	value := new(big.Int).ModInverse(muhash.Denominator, muHashPrime)
+	value.Mul(value, muhash.Numerator)
+	value.Mod(value, muHashPrime)
+	valueBytes := make([]byte, muHashElementSize)
+	value.FillBytes(valueBytes)
+	return sha256.Sum256(valueBytes)
+}
+
+/**
+ * 把元素映射为模素数下的一个数：以元素的sha256为种子，加上计数器反复做sha256，拼接成3072位
+ */
+func muHashElement(data []byte) *big.Int {
+	seed := utils.Sha256Hash(data)
+	expanded := make([]byte, 0, muHashElementSize)
+	for counter := byte(0); len(expanded) < muHashElementSize; counter++ {
+		block := sha256.Sum256(append(seed, counter))
+		expanded = append(expanded, block[:]...)
+	}
+	element := new(big.Int).SetBytes(expanded)
+	return element.Mod(element, muHashPrime)
+}
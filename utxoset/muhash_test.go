@@ -0,0 +1,34 @@
+package utxoset
+
+import "testing"
+
+// 集合的hash与元素加入的顺序无关，删除元素后与从未加入时相同
+func TestMuHash(t *testing.T) {
+	empty := NewMuHash().Finalize()
+	a := NewMuHash()
+	a.Insert([]byte("x"))
+	a.Insert([]byte("y"))
+	b := NewMuHash()
+	b.Insert([]byte("y"))
+	b.Insert([]byte("x"))
+	if a.Finalize() != b.Finalize() {
+		t.Error("加入顺序不同时hash不同")
+	}
+	if a.Finalize() == empty {
+		t.Error("非空集合的hash与空集合相同")
+	}
+
+	c := NewMuHash()
+	c.Insert([]byte("x"))
+	if c.Finalize() == a.Finalize() {
+		t.Error("元素不同的集合hash相同")
+	}
+	a.Remove([]byte("y"))
+	if a.Finalize() != c.Finalize() {
+		t.Error("删除元素后的hash与从未加入时不同")
+	}
+	a.Remove([]byte("x"))
+	if a.Finalize() != empty {
+		t.Error("删除所有元素后的hash与空集合不同")
+	}
+}
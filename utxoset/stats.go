@@ -0,0 +1,170 @@
+package utxoset
+
+import (
+	"PublicChain/transaction"
+	"PublicChain/utils"
+	"bytes"
+	"github.com/boltdb/bolt"
+	"math/big"
+)
+
+// utxo集合的统计信息，与BESTBLOCK一起保存在UTXOMETA中
+const SETSTATS = "setstats"
+
+/**
+ * utxo集合的统计信息：每次新增、删除utxo时增量更新，不需要遍历整个集合
+ */
+type SetStats struct {
+	Count       int64  // utxo数量
+	Amount      int64  // 总金额(最小单位)
+	Size        int64  // utxo集合序列化后的字节数(key+value)
+	Numerator   []byte // MuHash的分子
+	Denominator []byte // MuHash的分母
+}
+
+/**
+ * gettxoutsetinfo的返回结果
+ */
+type TxOutSetInfo struct {
+	BestBlock [32]byte
+	Count     int64
+	Amount    int64
+	Size      int64
+	Hash      [32]byte // 整个utxo集合的MuHash
+}
+
+/**
+ * 查询utxo集合的统计信息，先把缓存中的改动写回db，保证统计的是完整的utxo集合
+ */
+func (utxoset *UTXOSet) GetTxOutSetInfo() (*TxOutSetInfo, error) {
+	err := utxoset.Flush()
+	if err != nil {
+		return nil, err
+	}
+	var info *TxOutSetInfo
+	err = utxoset.DB.View(func(tx *bolt.Tx) error {
+		stats, err := getSetStatsInTx(tx)
+		if err != nil {
+			return err
+		}
+		best, _ := GetBestBlockInTx(tx)
+		muhash := &MuHash{
+			Numerator:   new(big.Int).SetBytes(stats.Numerator),
+			Denominator: new(big.Int).SetBytes(stats.Denominator),
+		}
+		info = &TxOutSetInfo{
+			BestBlock: best,
+			Count:     stats.Count,
+			Amount:    stats.Amount,
+			Size:      stats.Size,
+			Hash:      muhash.Finalize(),
+		}
+		return nil
+	})
+	return info, err
+}
+
+/**
+ * 旧的db文件没有utxo集合的统计信息，遍历一次utxo集合补建
+ */
+func BuildSetStatsInTx(tx *bolt.Tx) error {
+	meta := tx.Bucket([]byte(UTXOMETA))
+	if meta != nil && len(meta.Get([]byte(SETSTATS))) != 0 {
+		return nil
+	}
+	stats := newSetStats()
+	muhash := NewMuHash()
+	bucket := tx.Bucket([]byte(UTXOSET))
+	if bucket != nil {
+		err := bucket.ForEach(func(key, value []byte) error {
+			utxo, err := decodeUTXO(key, value)
+			if err != nil {
+				return err
+			}
+			stats.Count++
+			stats.Amount += utxo.Value
+			stats.Size += int64(len(key) + len(value))
+			muhash.Insert(utxoCommitBytes(*utxo))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	stats.Numerator = muhash.Numerator.Bytes()
+	stats.Denominator = muhash.Denominator.Bytes()
+	return putSetStatsInTx(tx, stats)
+}
+
+/**
+ * 新增或删除一个utxo时更新统计信息，size为该utxo在db中占用的字节数
+ */
+func updateSetStatsInTx(tx *bolt.Tx, utxo transaction.UTXO, size int, insert bool) error {
+	stats, err := getSetStatsInTx(tx)
+	if err != nil {
+		return err
+	}
+	muhash := &MuHash{
+		Numerator:   new(big.Int).SetBytes(stats.Numerator),
+		Denominator: new(big.Int).SetBytes(stats.Denominator),
+	}
+	if insert {
+		stats.Count++
+		stats.Amount += utxo.Value
+		stats.Size += int64(size)
+		muhash.Insert(utxoCommitBytes(utxo))
+	} else {
+		stats.Count--
+		stats.Amount -= utxo.Value
+		stats.Size -= int64(size)
+		muhash.Remove(utxoCommitBytes(utxo))
+	}
+	stats.Numerator = muhash.Numerator.Bytes()
+	stats.Denominator = muhash.Denominator.Bytes()
+	return putSetStatsInTx(tx, stats)
+}
+
+// 读取统计信息，还没有记录时为空集合
+func getSetStatsInTx(tx *bolt.Tx) (SetStats, error) {
+	meta := tx.Bucket([]byte(UTXOMETA))
+	if meta == nil {
+		return newSetStats(), nil
+	}
+	statsBytes := meta.Get([]byte(SETSTATS))
+	if len(statsBytes) == 0 {
+		return newSetStats(), nil
+	}
+	var stats SetStats
+	_, err := utils.GodDecode(statsBytes, &stats)
+	return stats, err
+}
+
+func putSetStatsInTx(tx *bolt.Tx, stats SetStats) error {
+	meta := tx.Bucket([]byte(UTXOMETA))
+	if meta == nil {
+		var err error
+		meta, err = tx.CreateBucket([]byte(UTXOMETA))
+		if err != nil {
+			return err
+		}
+	}
+	statsBytes, err := utils.GobEncode(stats)
+	if err != nil {
+		return err
+	}
+	return meta.Put([]byte(SETSTATS), statsBytes)
+}
+
+func newSetStats() SetStats {
+	return SetStats{Numerator: []byte{1}, Denominator: []byte{1}}
+}
+
+/**
+ * 计算集合hash时utxo的序列化：交易hash + 输出序号(uint32小端序) + 交易输出的二进制格式
+ */
+func utxoCommitBytes(utxo transaction.UTXO) []byte {
+	buff := bytes.NewBuffer(append([]byte{}, utxo.TxId[:]...))
+	utils.WriteUint32(buff, uint32(utxo.Vout))
+	utxo.TxOutput.Encode(buff)
+	return buff.Bytes()
+}
@@ -0,0 +1,94 @@
+package utxoset
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 新增、删除utxo时增量更新的统计信息与遍历整个utxo集合重新计算的相同
+func TestSetStats(t *testing.T) {
+	db := openTestDB(t)
+	utxoset := LoadUTXOSetFromDB(db)
+	empty, err := utxoset.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.Count != 0 || empty.Amount != 0 || empty.Size != 0 {
+		t.Fatalf("空集合的统计信息为%+v", empty)
+	}
+
+	first := testUTXO(1, 0, 100)
+	second := testUTXO(2, 1, 200)
+	err = db.Update(func(tx *bolt.Tx) error {
+		err := PutUTXOInTx(tx, first)
+		if err != nil {
+			return err
+		}
+		err = PutUTXOInTx(tx, second)
+		if err != nil {
+			return err
+		}
+		return DeleteUTXOInTx(tx, first.TxId, first.Vout)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := utxoset.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Count != 1 || info.Amount != 200 || info.Size <= 0 {
+		t.Fatalf("统计信息为%+v", info)
+	}
+
+	// 只含second的集合，无论经过怎样的增删，hash都相同
+	other := LoadUTXOSetFromDB(openTestDB(t))
+	err = other.DB.Update(func(tx *bolt.Tx) error {
+		return PutUTXOInTx(tx, second)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherInfo, err := other.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherInfo.Hash != info.Hash || otherInfo.Size != info.Size {
+		t.Errorf("内容相同的utxo集合统计信息不同：%+v，%+v", otherInfo, info)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(UTXOMETA)).Delete([]byte(SETSTATS))
+		if err != nil {
+			return err
+		}
+		return BuildSetStatsInTx(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := utxoset.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *rebuilt != *info {
+		t.Errorf("重新计算的统计信息为%+v，增量更新的为%+v", rebuilt, info)
+	}
+}
+
+// 统计信息包含缓存中还未写回的改动
+func TestTxOutSetInfoFlushesCache(t *testing.T) {
+	utxoset := LoadUTXOSetFromDB(openTestDB(t))
+	err := utxoset.Cache.AddUTXO(testUTXO(1, 0, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := utxoset.GetTxOutSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Count != 1 || info.Amount != 100 {
+		t.Errorf("统计信息为%+v，没有包含缓存中的utxo", info)
+	}
+}
@@ -3,67 +3,51 @@ package utxoset
 import (
 	"PublicChain/transaction"
 	"PublicChain/utils"
+	"PublicChain/wallet"
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 )
 
-const UTXOSET = "utxoset"
+// utxo集合：outpoint(交易hash 32字节 + 输出序号 8字节) -> TxOutput的二进制格式
+const UTXOSET = "utxos"
+
+// utxo集合按地址的二级索引：公钥hash(21字节) + outpoint -> 空
+const UTXOADDRINDEX = "utxoaddr"
+
+// 旧版本的utxo集合：地址 -> 该地址所有utxo的gob编码，打开旧db文件时迁移到新的结构
+const LEGACYUTXOSET = "utxoset"
 
 /*
 定义一个UTXOSet结构体，该结构体用于管理单个的UtXO
 
-	a.将某个地址新增的utxo的数据存储到文件中
-	b.从UTXOSet整个集合中删除掉某个地址address消费了的utxo
+	a.按outpoint直接查询、新增、删除某个utxo
+	b.通过公钥hash索引查询某个地址所有的utxo
+	c.所有读写都经过前面的写回缓存
 */
 type UTXOSet struct {
 	//UTXO map[string][]transaction.UTXO
-	DB *bolt.DB
+	DB    *bolt.DB
+	Cache *CoinsCache
 }
 
-// 用于找出指定地址在某次交易中所消费的utxo
-func (utxoset *UTXOSet) QuerrySpendUTXOs(address string, spends []SpendRecord) ([]transaction.UTXO, error) {
-	db := utxoset.DB
-	var err error
-	exisutxos := make([]transaction.UTXO, 0)
+// 用于找出某次交易所消费的utxo，要消费的utxo必须都存在
+func (utxoset *UTXOSet) QuerrySpendUTXOs(spends []SpendRecord) ([]transaction.UTXO, error) {
 	spentUTXOs := make([]transaction.UTXO, 0)
-	db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(UTXOSET))
-		if bucket == nil {
-			err = errors.New("UtXO查询失败")
-			return err
-		}
-		utxosBytes := bucket.Get([]byte(address))
-		if len(utxosBytes) == 0 {
-			return nil
-		}
-		//utxo 不为nil 尝试找符合条件utxo
-
-		decoder := gob.NewDecoder(bytes.NewReader(utxosBytes))
-		err = decoder.Decode(&exisutxos)
-		if err != nil {
-			return err
-		}
-
-		isContaines := IsSubsetUtXOs(exisutxos, spends)
-		if !isContaines {
-			err = errors.New("查找消费的utxo有误")
-			return err
-		}
-
-		// 遍历 花费掉了的utxo 记录下来
-		for _, utxo := range exisutxos {
-			for _, record := range spends {
-				if utxo.EqualSpendRecord(record) {
-					// 满足 条件 表示当前找到的utxo在当钱交易中被消费
-					spentUTXOs = append(spentUTXOs, utxo)
-
-				}
+	err := utxoset.DB.View(func(tx *bolt.Tx) error {
+		for _, record := range spends {
+			utxo, err := utxoset.Cache.GetUTXO(tx, record.TxId, record.Vout)
+			if err != nil {
+				return err
+			}
+			if utxo == nil {
+				return errors.New("查找消费的utxo有误")
 			}
+			spentUTXOs = append(spentUTXOs, *utxo)
 		}
-
 		return nil
 	})
 	return spentUTXOs, err
@@ -75,150 +59,312 @@ func (utxoset *UTXOSet) QuerrySpendUTXOs(address string, spends []SpendRecord) (
 	在构建交易之前，先查询到某个地址所拥有的所有可用utxo和总共余额，直接从utxoSet取出
 */
 func (utxoset *UTXOSet) QuerryUTXOByAddress(address string) ([]transaction.UTXO, error) {
-	db := utxoset.DB
-	var err error
-	utxos := make([]transaction.UTXO, 0)
-	fmt.Println("utxoSet:", db)
-
-	err = db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(UTXOSET))
-		if bucket == nil {
-			err = errors.New("UtXO查询失败")
-			return err
-		}
-		utxosBytes := bucket.Get([]byte(address))
-		if len(utxosBytes) == 0 {
-			return nil
-		}
-		decoder := gob.NewDecoder(bytes.NewReader(utxosBytes))
-		err = decoder.Decode(&utxos)
-
+	var utxos []transaction.UTXO
+	err := utxoset.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		utxos, err = utxoset.Cache.QuerryUTXOsByAddress(tx, address)
 		return err
 	})
 	if err != nil {
 		return nil, err
 	}
-
-	return utxos, err
+	return utxos, nil
 }
 
 /*
 *
 
-	将要交易存到区块中，存入交易中产生新的utxo
+	一次查询多个公钥hash所有的utxo，不要求地址在钱包中
 */
-func (utxoset *UTXOSet) AddUTXOWithAddress(address string, utxos []transaction.UTXO) bool {
-	db := utxoset.DB
-	var err error
-	db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(UTXOSET))
-		if bucket == nil {
-			bucket, err = tx.CreateBucket([]byte(UTXOSET))
+func (utxoset *UTXOSet) ScanUTXOs(pubHashes [][]byte) ([]transaction.UTXO, error) {
+	utxos := make([]transaction.UTXO, 0)
+	err := utxoset.DB.View(func(tx *bolt.Tx) error {
+		for _, pubHash := range pubHashes {
+			found, err := utxoset.Cache.QuerryUTXOsByPubHash(tx, pubHash)
 			if err != nil {
 				return err
 			}
+			utxos = append(utxos, found...)
 		}
-		//先取
-		exisUTXOs := make([]transaction.UTXO, 0)
-		utxosBytes := bucket.Get([]byte(address))
-		if len(utxosBytes) != 0 {
-			decoder := gob.NewDecoder(bytes.NewReader(utxosBytes))
-			err = decoder.Decode(&exisUTXOs)
-			if err != nil {
-				return err
-			}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+/*
+*
 
+	把缓存中还未写回的改动写入db，程序退出前调用
+*/
+func (utxoset *UTXOSet) Flush() error {
+	if utxoset.Cache.Stats().Dirty == 0 {
+		var best [32]byte
+		utxoset.DB.View(func(tx *bolt.Tx) error {
+			best, _ = GetBestBlockInTx(tx)
+			return nil
+		})
+		if best == utxoset.Cache.GetBestBlock() {
+			return nil
 		}
-		// 合并已有和新增
-		exisUTXOs = append(exisUTXOs, utxos...)
+	}
+	return utxoset.DB.Update(func(tx *bolt.Tx) error {
+		return utxoset.Cache.Flush(tx)
+	})
+}
 
-		utxosBytes, err := utils.GobEncode(&exisUTXOs)
+/*
+*
+
+	生成utxo在集合中的key：交易hash + 大端序8字节的输出序号
+*/
+func OutpointKey(txid [32]byte, vout int) ([]byte, error) {
+	voutBytes, err := utils.IntToByte(int64(vout))
+	if err != nil {
+		return nil, err
+	}
+	return append(txid[:], voutBytes...), nil
+}
+
+/*
+*
+
+	在调用方已开启的读写事务中新增一个utxo，同时写入地址索引并更新统计信息。
+	该outpoint已有utxo时先删除旧的
+*/
+func PutUTXOInTx(tx *bolt.Tx, utxo transaction.UTXO) error {
+	old, err := GetUTXOInTx(tx, utxo.TxId, utxo.Vout)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		err = DeleteUTXOInTx(tx, utxo.TxId, utxo.Vout)
 		if err != nil {
 			return err
 		}
-		err = bucket.Put([]byte(address), utxosBytes)
+	}
+	bucket, index, err := createBuckets(tx)
+	if err != nil {
 		return err
-	})
-	return err == nil
+	}
+	key, err := OutpointKey(utxo.TxId, utxo.Vout)
+	if err != nil {
+		return err
+	}
+	outputBytes := utxo.TxOutput.Serialize()
+	err = bucket.Put(key, outputBytes)
+	if err != nil {
+		return err
+	}
+	err = index.Put(addrIndexKey(utxo.PubHash, key), []byte{})
+	if err != nil {
+		return err
+	}
+	return updateSetStatsInTx(tx, utxo, len(key)+len(outputBytes), true)
 }
 
 /*
 *
 
-	将某个地址对应已经消费了的utxo数据从utxoSet中删除
+	在调用方已开启的事务中按outpoint查询utxo，不存在时返回nil
 */
-func (utxoset *UTXOSet) Change(address string, records []SpendRecord) bool {
-	db := utxoset.DB
-	var err error
-	db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(UTXOSET))
-		if bucket == nil {
-			return nil
-		}
+func GetUTXOInTx(tx *bolt.Tx, txid [32]byte, vout int) (*transaction.UTXO, error) {
+	bucket := tx.Bucket([]byte(UTXOSET))
+	if bucket == nil {
+		return nil, nil
+	}
+	key, err := OutpointKey(txid, vout)
+	if err != nil {
+		return nil, err
+	}
+	outputBytes := bucket.Get(key)
+	if len(outputBytes) == 0 {
+		return nil, nil
+	}
+	return decodeUTXO(key, outputBytes)
+}
 
-		utxosBytes := bucket.Get([]byte(address))
-		if len(utxosBytes) == 0 {
-			err = errors.New("删除utxo记录失败")
-			return err
+/*
+*
+
+	在调用方已开启的读写事务中删除一个已消费的utxo，要删除的utxo必须存在，同时更新统计信息
+*/
+func DeleteUTXOInTx(tx *bolt.Tx, txid [32]byte, vout int) error {
+	utxo, err := GetUTXOInTx(tx, txid, vout)
+	if err != nil {
+		return err
+	}
+	if utxo == nil {
+		return fmt.Errorf("招不到要删除的utxo%x:%d,请检查", txid, vout)
+	}
+	key, err := OutpointKey(txid, vout)
+	if err != nil {
+		return err
+	}
+	bucket := tx.Bucket([]byte(UTXOSET))
+	size := len(key) + len(bucket.Get(key))
+	err = bucket.Delete(key)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket([]byte(UTXOADDRINDEX)).Delete(addrIndexKey(utxo.PubHash, key))
+	if err != nil {
+		return err
+	}
+	return updateSetStatsInTx(tx, *utxo, size, false)
+}
+
+/*
+*
+
+	在调用方已开启的事务中，通过地址索引查询某个地址所有的utxo
+*/
+func QuerryUTXOsInTx(tx *bolt.Tx, address string) ([]transaction.UTXO, error) {
+	return QuerryUTXOsByPubHashInTx(tx, wallet.GetPubKHashWithAddress(address))
+}
+
+/*
+*
+
+	在调用方已开启的事务中，通过地址索引查询某个公钥hash所有的utxo
+*/
+func QuerryUTXOsByPubHashInTx(tx *bolt.Tx, prefix []byte) ([]transaction.UTXO, error) {
+	utxos := make([]transaction.UTXO, 0)
+	index := tx.Bucket([]byte(UTXOADDRINDEX))
+	if index == nil {
+		return utxos, nil
+	}
+	cursor := index.Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		outpoint := key[len(prefix):]
+		if len(outpoint) != 40 {
+			continue
 		}
-		existUTXOs := make([]transaction.UTXO, 0)
-		decoder := gob.NewDecoder(bytes.NewReader(utxosBytes))
-		err = decoder.Decode(&existUTXOs)
+		var txid [32]byte
+		copy(txid[:], outpoint[:32])
+		vout := int(binary.BigEndian.Uint64(outpoint[32:]))
+		utxo, err := GetUTXOInTx(tx, txid, vout)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if utxo == nil {
+			return nil, errors.New("utxo地址索引与utxo集合不一致")
 		}
+		utxos = append(utxos, *utxo)
+	}
+	return utxos, nil
+}
+
+/*
+*
 
-		// 要删除的utxo都存在
-		isContains := IsSubsetUtXOs(existUTXOs, records)
-		if !isContains {
-			err = errors.New("招不到要删除的utxo,请检查")
+	清空utxo集合及其地址索引(包括旧版本的utxo集合)和统计信息，重建utxo集合时使用
+*/
+func ResetUTXOSetInTx(tx *bolt.Tx) error {
+	if meta := tx.Bucket([]byte(UTXOMETA)); meta != nil {
+		err := meta.Delete([]byte(SETSTATS))
+		if err != nil {
 			return err
 		}
-		//消费剩下的utxo
-		remainUTXOs := make([]transaction.UTXO, 0)
-		for _, utxo := range existUTXOs { // 当前地址目前存在的utxo
-			isSpent := false
-			for _, reccord := range records {
-				if utxo.EqualSpendRecord(reccord) {
-					isSpent = true
-				}
-			}
-			if !isSpent {
-				remainUTXOs = append(remainUTXOs, utxo)
-			}
+	}
+	for _, name := range []string{UTXOSET, UTXOADDRINDEX, LEGACYUTXOSET} {
+		if tx.Bucket([]byte(name)) == nil {
+			continue
 		}
-		// 把剩下存储起来
-		remainBytes, err := utils.GobEncode(remainUTXOs)
+		err := tx.DeleteBucket([]byte(name))
 		if err != nil {
 			return err
 		}
-		bucket.Put([]byte(address), remainBytes)
-		return nil
-	})
-	return err == nil
+	}
+	_, _, err := createBuckets(tx)
+	return err
 }
 
 /*
 *
 
-	判断俩个utxo集合  返回结果为sub是否是all子集
+	把旧版本按地址存储的utxo集合迁移到按outpoint存储的结构，然后删除旧的集合。
+	先解析所有地址的数据，有无法解析的地址时不写入任何utxo，也不删除旧的集合，返回无法解析的地址数量，
+	此时需要从区块重建utxo集合，重建成功后旧的集合随之删除
 */
-func IsSubsetUtXOs(all []transaction.UTXO, sub []SpendRecord) bool {
-	for _, subset_utxo := range sub {
-		isCointains := false
-		for _, super_utxo := range all {
-			if super_utxo.EqualSpendRecord(subset_utxo) {
-				isCointains = true
-			}
+func MigrateLegacyUTXOSet(tx *bolt.Tx) (int, error) {
+	legacy := tx.Bucket([]byte(LEGACYUTXOSET))
+	if legacy == nil {
+		return 0, nil
+	}
+	skipped := 0
+	migrated := make([]transaction.UTXO, 0)
+	err := legacy.ForEach(func(address, utxosBytes []byte) error {
+		utxos := make([]transaction.UTXO, 0)
+		decoder := gob.NewDecoder(bytes.NewReader(utxosBytes))
+		if decoder.Decode(&utxos) != nil {
+			skipped++
+			return nil
 		}
-		if !isCointains {
-			return false
+		migrated = append(migrated, utxos...)
+		return nil
+	})
+	if err != nil || skipped > 0 {
+		return skipped, err
+	}
+	for _, utxo := range migrated {
+		err = PutUTXOInTx(tx, utxo)
+		if err != nil {
+			return 0, err
 		}
 	}
-	return true
+	return 0, tx.DeleteBucket([]byte(LEGACYUTXOSET))
 }
 
-// 实例化 utxoset
+// 由utxo集合中的key和value还原出utxo
+func decodeUTXO(key []byte, outputBytes []byte) (*transaction.UTXO, error) {
+	if len(key) != 40 {
+		return nil, errors.New("utxo集合的key有误")
+	}
+	output, err := transaction.DeserializeTxOutput(outputBytes)
+	if err != nil {
+		return nil, err
+	}
+	var txid [32]byte
+	copy(txid[:], key[:32])
+	utxo := transaction.NewUTXO(txid, int(binary.BigEndian.Uint64(key[32:])), output)
+	return &utxo, nil
+}
+
+func addrIndexKey(pubHash []byte, outpoint []byte) []byte {
+	key := make([]byte, 0, len(pubHash)+len(outpoint))
+	key = append(key, pubHash...)
+	return append(key, outpoint...)
+}
+
+func createBuckets(tx *bolt.Tx) (*bolt.Bucket, *bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket([]byte(UTXOSET))
+	if bucket == nil {
+		bucket, err = tx.CreateBucket([]byte(UTXOSET))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	index := tx.Bucket([]byte(UTXOADDRINDEX))
+	if index == nil {
+		index, err = tx.CreateBucket([]byte(UTXOADDRINDEX))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return bucket, index, nil
+}
+
+// 实例化 utxoset，缓存对应的最新区块为db中记录的最新区块
 func LoadUTXOSetFromDB(db *bolt.DB) UTXOSet {
-	return UTXOSet{db}
+	cache := NewCoinsCache(DEFAULTCACHESIZE)
+	db.View(func(tx *bolt.Tx) error {
+		best, _ := GetBestBlockInTx(tx)
+		cache.SetBestBlock(best)
+		return nil
+	})
+	return UTXOSet{DB: db, Cache: cache}
 }
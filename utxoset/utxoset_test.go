@@ -0,0 +1,66 @@
+package utxoset
+
+import (
+	"PublicChain/transaction"
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func scanTestValues(t *testing.T, utxoset *UTXOSet, pubHashes ...[]byte) []int64 {
+	t.Helper()
+	utxos, err := utxoset.ScanUTXOs(pubHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]int64, 0, len(utxos))
+	for _, utxo := range utxos {
+		values = append(values, utxo.Value)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+// 按公钥hash扫描utxo集合，结果包含缓存中还未写回的新增和花费
+func TestScanUTXOs(t *testing.T) {
+	db := openTestDB(t)
+	utxoset := LoadUTXOSetFromDB(db)
+	spent := testUTXO(1, 1, 50)
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, utxo := range []transaction.UTXO{testUTXO(1, 0, 100), spent, testUTXO(2, 0, 200)} {
+			err := PutUTXOInTx(tx, utxo)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = utxoset.Cache.AddUTXO(testUTXO(1, 2, 70))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		_, err := utxoset.Cache.SpendUTXO(tx, spent.TxId, spent.Vout)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := bytes.Repeat([]byte{1}, 21)
+	second := bytes.Repeat([]byte{2}, 21)
+	if values := scanTestValues(t, &utxoset, first); len(values) != 2 || values[0] != 70 || values[1] != 100 {
+		t.Errorf("扫描到的金额为%v，应为[70 100]", values)
+	}
+	if values := scanTestValues(t, &utxoset, first, second); len(values) != 3 || values[2] != 200 {
+		t.Errorf("扫描到的金额为%v，应为[70 100 200]", values)
+	}
+	if values := scanTestValues(t, &utxoset, bytes.Repeat([]byte{3}, 21)); len(values) != 0 {
+		t.Errorf("没有utxo的公钥hash扫描到%v", values)
+	}
+}
@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"PublicChain/chainparams"
 	"PublicChain/utils"
 	"bytes"
 	"golang.org/x/crypto/ripemd160"
@@ -8,18 +9,23 @@ import (
 
 func NewAddress(pub []byte)(string , error){
 
+	version :=NewPubKHash(pub)
+
+	//fmt.Printf("%x\n",version)
+	address := GetAddressWithPubKHash(version)
+    return address,nil
+
+}
+
+// 根据原始公钥得到带版本号的公钥hash，版本号由当前网络决定
+func NewPubKHash(pub []byte)[]byte{
 	hashpub:=utils.Sha256Hash(pub)
 
 	Ripemd160:=ripemd160.New()
 	Ripemd160.Write(hashpub)
 	rip:=Ripemd160.Sum(nil)
 
-	version :=append([]byte{0X00},rip...)
-
-	//fmt.Printf("%x\n",version)
-	address := GetAddressWithPubKHash(version)
-    return address,nil
-
+	return append([]byte{chainparams.Active.AddressVersion},rip...)
 }
 
 //据 公钥hash 得到 地址
@@ -34,14 +40,20 @@ func GetAddressWithPubKHash(pubkhash []byte)string{
 	return utils.Encode(addressByte)
 }
 
+//据 地址 得到 公钥hash，调用前需先校验地址
+func GetPubKHashWithAddress(addr string)[]byte{
+	reverseAdd:=utils.Decode(addr)
+	return reverseAdd[:len(reverseAdd)-4]
+}
+
 /**
-  用来判断和校验给定的一个字符串是否符合规范
+  用来判断和校验给定的一个字符串是否符合规范，其他网络的地址版本号不同，校验不通过
  */
 func IsAddressValid(addr string)bool{
 	//1. base58反编码
 	reverseAdd:=utils.Decode(addr)
-	//2. 取反编码后四个字节作为反编码作为校验位
-	if len(reverseAdd) < 4 {
+	//2. 长度为 版本号1字节 + 公钥hash 20字节 + 校验位4字节，版本号必须是当前网络的
+	if len(reverseAdd) != 25 || reverseAdd[0] != chainparams.Active.AddressVersion {
 		return false
 	}
 	check := reverseAdd[len(reverseAdd)-4:]
@@ -29,12 +29,27 @@ func NewKeyPair()(*KeyPair,error){
 	return  keyPair,nil
 }
 
+/**
+   根据私钥的D值还原出密钥对，用于从db中加载钱包
+ */
+func RestoreKeyPair(d []byte)*KeyPair{
+	curve:=elliptic.P256()
+	pri :=new(ecdsa.PrivateKey)
+	pri.Curve = curve
+	pri.D = new(big.Int).SetBytes(d)
+	pri.X,pri.Y = curve.ScalarBaseMult(d)
+	return &KeyPair{
+		Pri: pri,
+		Pub: elliptic.Marshal(curve,pri.X,pri.Y),
+	}
+}
+
 /**
  * 使用[]byte类型的数据转换为PublicKey类型公钥
  */
 func GetPublicKeyWithBytes(curve elliptic.Curve, data []byte) ecdsa.PublicKey {
 	x, y := elliptic.Unmarshal(curve, data)
-	return ecdsa.PublicKey{curve, x, y}
+	return ecdsa.PublicKey{Curve: curve, X: x, Y: y}
 }
 
 func RestoreSignature(sign []byte) (r, s *big.Int) {
@@ -3,9 +3,10 @@ package wallet
 import (
 	"PublicChain/utils"
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
+	"fmt"
 	"github.com/boltdb/bolt"
+	"math/big"
 )
 
 const KEYSTORE = "keystore"
@@ -63,9 +64,12 @@ func (wallet *Wallet)SaveMenToDB()error{
 		//		bucket.Put([]byte(key),keyPairBytes)
 		//	}
 		//}
-		gob.Register(elliptic.P256())
-
-		addAndKeyPairbytes,err :=utils.GobEncode(wallet.Address)
+		// 曲线类型无法被gob编码，只保存私钥的D值，加载时再还原
+		addAndPris :=make(map[string][]byte)
+		for address,keyPair :=range wallet.Address{
+			addAndPris[address] = keyPair.Pri.D.Bytes()
+		}
+		addAndKeyPairbytes,err :=utils.GobEncode(addAndPris)
 		if err !=nil{
 			return err
 		}
@@ -95,11 +99,18 @@ func LoadWalletFromDB(db *bolt.DB) (Wallet,error){
 			return nil
 		}
 		//反序列化map
-		gob.Register(elliptic.P256())
+		addAndPris :=make(map[string][]byte)
 		decoder :=gob.NewDecoder(bytes.NewReader(addAndKeyPairBytes))
-		err = decoder.Decode(&adds)
+		err = decoder.Decode(&addAndPris)
 		if err !=nil{
-			return err
+			// 旧版本的钱包格式
+			addAndPris,err = decodeLegacyKeyStore(addAndKeyPairBytes)
+			if err !=nil{
+				return err
+			}
+		}
+		for address,pri :=range addAndPris{
+			adds[address] = RestoreKeyPair(pri)
 		}
 		return nil
 	})
@@ -111,25 +122,60 @@ func LoadWalletFromDB(db *bolt.DB) (Wallet,error){
 	return wallet,err
 }
 
+// 旧版本的钱包直接用gob编码map[string]*KeyPair，私钥中带有曲线类型
+type legacyKeyPair struct {
+	Pri *legacyPrivateKey
+}
+
+// 只读取私钥的D值，曲线和公钥等其余字段由gob跳过
+type legacyPrivateKey struct {
+	D *big.Int
+}
+
+/**
+ * 读取旧版本格式的钱包，取出每个地址私钥的D值。旧格式中的曲线类型在当前的Go版本中无法解码，
+ * 加载后按D值还原密钥对，下一次保存时写为新格式
+ */
+func decodeLegacyKeyStore(data []byte)(map[string][]byte,error){
+	legacy :=make(map[string]*legacyKeyPair)
+	err :=gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy)
+	if err !=nil{
+		return nil,err
+	}
+	addAndPris :=make(map[string][]byte)
+	for address,keyPair :=range legacy{
+		if keyPair == nil || keyPair.Pri == nil || keyPair.Pri.D == nil{
+			return nil,fmt.Errorf("钱包中地址%s的私钥为空",address)
+		}
+		addAndPris[address] = keyPair.Pri.D.Bytes()
+	}
+	return addAndPris,nil
+}
+
 // 根据地址取出 公钥
 func (wallet *Wallet)GetKeyPairByAddress(address string)(*KeyPair){
 	return wallet.Address[address]
 }
 
 func (wallet *Wallet)SetCoinbase(address string) (error){
-	var err error
-	wallet.DB.Update(func(tx *bolt.Tx) error {
-		bucket:=tx.Bucket([]byte(KEYSTORE))
-		if bucket ==nil{
-			bucket,err = tx.CreateBucket([]byte(KEYSTORE))
-			if err !=nil{
-				return err
-			}
-		}
-		bucket.Put([]byte(COINBASE),[]byte(address))
-		return nil
+	return wallet.DB.Update(func(tx *bolt.Tx) error {
+		return SetCoinbaseInTx(tx,address)
 	})
-	return err
+}
+
+/**
+ * 在调用方已开启的读写事务中设置矿工地址，便于和区块数据在同一个事务中提交
+ */
+func SetCoinbaseInTx(tx *bolt.Tx,address string)error{
+	bucket:=tx.Bucket([]byte(KEYSTORE))
+	if bucket ==nil{
+		var err error
+		bucket,err = tx.CreateBucket([]byte(KEYSTORE))
+		if err !=nil{
+			return err
+		}
+	}
+	return bucket.Put([]byte(COINBASE),[]byte(address))
 }
 
 
@@ -0,0 +1,133 @@
+package wallet
+
+import (
+	"PublicChain/utils"
+	"crypto/elliptic"
+	"encoding/gob"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func openTestDB(t *testing.T) *bolt.DB {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "wallet.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// 与旧版本KeyPair中ecdsa.PrivateKey字段结构相同的类型，曲线以接口类型编码
+type oldPublicKey struct {
+	Curve elliptic.Curve
+	X, Y  *big.Int
+}
+
+type oldPrivateKey struct {
+	PublicKey oldPublicKey
+	D         *big.Int
+}
+
+type oldKeyPair struct {
+	Pri *oldPrivateKey
+	Pub []byte
+}
+
+func putKeyStore(t *testing.T, db *bolt.DB, data []byte) {
+	t.Helper()
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(KEYSTORE))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(ADDRESS), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalletSaveAndLoad(t *testing.T) {
+	db := openTestDB(t)
+	wlt, err := LoadWalletFromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := wlt.CreateNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadWalletFromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPair := loaded.GetKeyPairByAddress(address)
+	if keyPair == nil {
+		t.Fatal("加载后找不到新建的地址")
+	}
+	if keyPair.Pri.D.Cmp(wlt.Address[address].Pri.D) != 0 || string(keyPair.Pub) != string(wlt.Address[address].Pub) {
+		t.Error("加载后的密钥对与保存前的不一致")
+	}
+}
+
+// 旧版本用gob直接编码整个密钥对，加载时只取私钥还原，之后保存为新格式
+func TestLoadLegacyWallet(t *testing.T) {
+	gob.Register(elliptic.P256().Params())
+	expected, err := NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := NewAddress(expected.Pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := map[string]*oldKeyPair{
+		address: {
+			Pri: &oldPrivateKey{
+				PublicKey: oldPublicKey{Curve: elliptic.P256().Params(), X: expected.Pri.X, Y: expected.Pri.Y},
+				D:         expected.Pri.D,
+			},
+			Pub: expected.Pub,
+		},
+	}
+	data, err := utils.GobEncode(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := openTestDB(t)
+	putKeyStore(t, db, data)
+
+	wlt, err := LoadWalletFromDB(db)
+	if err != nil {
+		t.Fatalf("加载旧格式的钱包出错：%v", err)
+	}
+	keyPair := wlt.GetKeyPairByAddress(address)
+	if keyPair == nil || keyPair.Pri.D.Cmp(expected.Pri.D) != 0 || string(keyPair.Pub) != string(expected.Pub) {
+		t.Fatal("旧格式钱包中的密钥对没有正确还原")
+	}
+
+	_, err = wlt.CreateNewAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadWalletFromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Address) != 2 || reloaded.GetKeyPairByAddress(address) == nil {
+		t.Error("保存为新格式后旧地址丢失")
+	}
+}
+
+func TestLoadCorruptWallet(t *testing.T) {
+	db := openTestDB(t)
+	putKeyStore(t, db, []byte{0x01, 0x02, 0x03})
+	_, err := LoadWalletFromDB(db)
+	if err == nil {
+		t.Error("无法解析的钱包数据应报错")
+	}
+}